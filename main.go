@@ -2,8 +2,11 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"os"
 
+	"github.com/asecurityteam/logevent"
 	"github.com/asecurityteam/runhttp"
 	"github.com/asecurityteam/settings"
 	digesterd "github.com/asecurityteam/vpcflow-digesterd/pkg"
@@ -11,10 +14,21 @@ import (
 )
 
 func main() {
+	printConfig := flag.Bool("print-config", false, "print the effective configuration, with secrets redacted, and exit")
+	flag.Parse()
+	if *printConfig {
+		if err := digesterd.PrintConfig(context.Background(), os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	router := chi.NewRouter()
 	service := &digesterd.Service{}
 	if err := service.BindRoutes(router); err != nil {
-		panic(err.Error())
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
 	}
 
 	source, err := settings.NewEnvSource(os.Environ())
@@ -28,8 +42,72 @@ func main() {
 		panic(err.Error())
 	}
 
-	// Run the HTTP server.
-	if err := rt.Run(); err != nil {
+	runtimes := []*runhttp.Runtime{rt}
+
+	// Serve the worker endpoint on its own listener, if it is not served by the router.
+	if address, worker := service.Worker(); worker != nil {
+		workerSource := settings.MultiSource{
+			settings.NewMapSource(map[string]interface{}{
+				"runtime": map[string]interface{}{
+					"httpserver": map[string]interface{}{"address": address},
+				},
+			}),
+			source,
+		}
+		workerRT, err := runhttp.New(context.Background(), workerSource, worker)
+		if err != nil {
+			panic(err.Error())
+		}
+		runtimes = append(runtimes, workerRT)
+	}
+
+	// Reconcile late data in the background, until the HTTP servers are shut down.
+	ctx, cancel := context.WithCancel(logevent.NewContext(context.Background(), rt.Logger))
+	reconciled := make(chan struct{})
+	go func() {
+		service.Reconcile(ctx)
+		close(reconciled)
+	}()
+
+	// Run the HTTP servers.
+	err = run(runtimes)
+	cancel()
+	<-reconciled
+	if err != nil {
 		panic(err.Error())
 	}
 }
+
+// run runs each of the runtimes until any one of them exits, either on a signal or an error, then shuts down
+// the others and waits for all of them to return, so that no request in progress is cut short. The first error
+// returned by any runtime, if any, is returned.
+func run(runtimes []*runhttp.Runtime) error {
+	stop := make(chan struct{})
+	stopped := func() chan error {
+		c := make(chan error, 1)
+		go func() {
+			<-stop
+			c <- nil
+		}()
+		return c
+	}
+	results := make(chan error, len(runtimes))
+	for _, rt := range runtimes {
+		rt.Exit = runhttp.MultiSignal([]runhttp.SignalFn{rt.Exit, stopped})
+		go func(rt *runhttp.Runtime) {
+			results <- rt.Run()
+		}(rt)
+	}
+	var err error
+	for range runtimes {
+		if e := <-results; e != nil && err == nil {
+			err = e
+		}
+		select {
+		case <-stop:
+		default:
+			close(stop)
+		}
+	}
+	return err
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/logs"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+)
+
+// Permission is a class of operations which a caller may be allowed to perform
+type Permission string
+
+const (
+	// PermissionRead allows digests, their metadata, jobs and events to be read
+	PermissionRead Permission = "digests:read"
+	// PermissionCreate allows digests to be requested
+	PermissionCreate Permission = "digests:create"
+	// PermissionRegenerate allows digests which already exist to be regenerated, by requesting them with force
+	PermissionRegenerate Permission = "digests:regenerate"
+	// PermissionDelete allows digests to be deleted
+	PermissionDelete Permission = "digests:delete"
+	// PermissionProduce allows digest jobs to be delivered to the worker endpoint
+	PermissionProduce Permission = "digests:produce"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request carries no credentials of the kind it accepts
+var ErrNoCredentials = errors.New("the request has no credentials")
+
+// Principal is an authenticated caller
+type Principal struct {
+	Subject     string
+	Permissions []Permission
+}
+
+// Has reports whether the caller holds the permission
+func (p Principal) Has(permission Permission) bool {
+	for _, held := range p.Permissions {
+		if held == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator identifies the caller of a request. If the request carries no credentials of the kind the
+// Authenticator accepts, ErrNoCredentials is returned. Any other error means the credentials are not valid.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Principal, error)
+}
+
+// Chain is an Authenticator which tries each of its Authenticators in turn, until one finds credentials in
+// the request
+type Chain []Authenticator
+
+// Authenticate returns the Principal identified by the first Authenticator to find credentials in the request
+func (c Chain) Authenticate(r *http.Request) (Principal, error) {
+	for _, authenticator := range c {
+		principal, err := authenticator.Authenticate(r)
+		if err == ErrNoCredentials {
+			continue
+		}
+		return principal, err
+	}
+	return Principal{}, ErrNoCredentials
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of the context which carries the Principal
+func NewContext(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the Principal of an authorized request, if any
+func FromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// ClientOf identifies the caller of a request by the subject of its Principal, if it was authorized, or otherwise
+// by its IP address
+func ClientOf(r *http.Request) string {
+	if principal, ok := FromContext(r.Context()); ok {
+		return principal.Subject
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Authorize returns a check which passes requests whose caller, as added to the context by Authorizer.Require,
+// holds the permission. It is used for operations which require a permission in addition to that of their route.
+func Authorize(permission Permission) func(r *http.Request) error {
+	return func(r *http.Request) error {
+		principal, ok := FromContext(r.Context())
+		if !ok {
+			return errors.New("the request has no authorized caller")
+		}
+		if !principal.Has(permission) {
+			return errors.New(principal.Subject + " does not hold the permission " + string(permission))
+		}
+		return nil
+	}
+}
+
+// Authorizer authenticates requests, and checks that their callers hold the permissions required by each route.
+// The responses to rejected requests are written by Unauthorized and Forbidden, so that each version of the API
+// may describe them in its own format. The reason a request was rejected is logged, rather than returned.
+type Authorizer struct {
+	LogProvider   types.LogFn
+	Authenticator Authenticator
+	// Unauthorized writes the response to a request which does not carry valid credentials
+	Unauthorized func(w http.ResponseWriter, r *http.Request)
+	// Forbidden writes the response to a request whose caller does not hold the required permission
+	Forbidden func(w http.ResponseWriter, r *http.Request)
+}
+
+// Require returns middleware which only passes requests whose caller holds the permission. The Principal of
+// the caller is added to the context of the request.
+func (a *Authorizer) Require(permission Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger := a.LogProvider(r.Context())
+			principal, err := a.Authenticator.Authenticate(r)
+			if err != nil {
+				logger.Info(logs.Unauthorized{Reason: err.Error()})
+				w.Header().Set("WWW-Authenticate", `Bearer realm="vpcflow-digesterd"`)
+				a.Unauthorized(w, r)
+				return
+			}
+			if !principal.Has(permission) {
+				err = errors.New(principal.Subject + " does not hold the permission " + string(permission))
+				logger.Info(logs.Forbidden{Reason: err.Error()})
+				a.Forbidden(w, r)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), principal)))
+		})
+	}
+}
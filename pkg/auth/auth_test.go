@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeAuthenticator struct {
+	principal Principal
+	err       error
+}
+
+func (f fakeAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	return f.principal, f.err
+}
+
+func TestChain(t *testing.T) {
+	alice := Principal{Subject: "alice"}
+	tc := []struct {
+		Name      string
+		Chain     Chain
+		Principal Principal
+		Err       error
+	}{
+		{
+			Name:  "empty",
+			Chain: Chain{},
+			Err:   ErrNoCredentials,
+		},
+		{
+			Name:      "skips authenticators without credentials",
+			Chain:     Chain{fakeAuthenticator{err: ErrNoCredentials}, fakeAuthenticator{principal: alice}},
+			Principal: alice,
+		},
+		{
+			Name:  "stops at invalid credentials",
+			Chain: Chain{fakeAuthenticator{err: errors.New("invalid")}, fakeAuthenticator{principal: alice}},
+			Err:   errors.New("invalid"),
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "/", nil)
+			principal, err := tt.Chain.Authenticate(r)
+			assert.Equal(t, tt.Err, err)
+			assert.Equal(t, tt.Principal, principal)
+		})
+	}
+}
+
+func TestAuthorizerRequire(t *testing.T) {
+	tc := []struct {
+		Name          string
+		Authenticator Authenticator
+		Status        int
+	}{
+		{
+			Name:          "no credentials",
+			Authenticator: fakeAuthenticator{err: ErrNoCredentials},
+			Status:        http.StatusUnauthorized,
+		},
+		{
+			Name:          "invalid credentials",
+			Authenticator: fakeAuthenticator{err: errors.New("invalid")},
+			Status:        http.StatusUnauthorized,
+		},
+		{
+			Name:          "missing permission",
+			Authenticator: fakeAuthenticator{principal: Principal{Subject: "alice", Permissions: []Permission{PermissionRead}}},
+			Status:        http.StatusForbidden,
+		},
+		{
+			Name:          "permitted",
+			Authenticator: fakeAuthenticator{principal: Principal{Subject: "alice", Permissions: []Permission{PermissionRead, PermissionCreate}}},
+			Status:        http.StatusNoContent,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			authorizer := &Authorizer{
+				LogProvider:   types.LoggerFromContext,
+				Authenticator: tt.Authenticator,
+				Unauthorized: func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusUnauthorized)
+				},
+				Forbidden: func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusForbidden)
+				},
+			}
+			handler := authorizer.Require(PermissionCreate)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				principal, ok := FromContext(r.Context())
+				assert.True(t, ok)
+				assert.Equal(t, "alice", principal.Subject)
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			r, _ := http.NewRequest(http.MethodPost, "/", nil)
+			r = r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.Status, w.Code)
+			if tt.Status == http.StatusUnauthorized {
+				assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	authorize := Authorize(PermissionRegenerate)
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	assert.NotNil(t, authorize(r))
+
+	alice := Principal{Subject: "alice", Permissions: []Permission{PermissionCreate}}
+	assert.NotNil(t, authorize(r.WithContext(NewContext(context.Background(), alice))))
+
+	alice.Permissions = append(alice.Permissions, PermissionRegenerate)
+	assert.Nil(t, authorize(r.WithContext(NewContext(context.Background(), alice))))
+}
+
+func TestClientOf(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "192.0.2.10:51234"
+	assert.Equal(t, "192.0.2.10", ClientOf(r))
+
+	r.RemoteAddr = "pipe"
+	assert.Equal(t, "pipe", ClientOf(r))
+
+	r = r.WithContext(NewContext(context.Background(), Principal{Subject: "alice"}))
+	assert.Equal(t, "alice", ClientOf(r))
+}
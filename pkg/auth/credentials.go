@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Credential is a secret shared with a caller, either a bearer token or an HMAC signing key, and the
+// permissions it grants
+type Credential struct {
+	Subject     string       `json:"subject"`
+	Secret      string       `json:"secret"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// LoadCredentials reads a JSON array of credentials from the file at the given path
+func LoadCredentials(path string) ([]Credential, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var credentials []Credential
+	if err := json.Unmarshal(b, &credentials); err != nil {
+		return nil, fmt.Errorf("credentials file %s is not valid: %s", path, err.Error())
+	}
+	for i, credential := range credentials {
+		if credential.Subject == "" || credential.Secret == "" {
+			return nil, fmt.Errorf("credential %d in %s requires a subject and a secret", i, path)
+		}
+		for _, permission := range credential.Permissions {
+			if !permission.valid() {
+				return nil, fmt.Errorf("credential %s in %s has unknown permission %s", credential.Subject, path, permission)
+			}
+		}
+	}
+	return credentials, nil
+}
+
+func (p Permission) valid() bool {
+	switch p {
+	case PermissionRead, PermissionCreate, PermissionRegenerate, PermissionDelete, PermissionProduce:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c Credential) principal() Principal {
+	return Principal{Subject: c.Subject, Permissions: c.Permissions}
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeFile writes the contents to a file in a new temporary directory. It is the caller's responsibility to
+// remove the directory.
+func writeFile(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "auth")
+	assert.Nil(t, err)
+	path := filepath.Join(dir, "file.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(contents), 0600))
+	return path
+}
+
+func TestLoadCredentials(t *testing.T) {
+	tc := []struct {
+		Name        string
+		Contents    string
+		Credentials []Credential
+		Err         bool
+	}{
+		{
+			Name:     "valid",
+			Contents: `[{"subject":"reader","secret":"s3cret","permissions":["digests:read"]}]`,
+			Credentials: []Credential{
+				{Subject: "reader", Secret: "s3cret", Permissions: []Permission{PermissionRead}},
+			},
+		},
+		{
+			Name:     "malformed",
+			Contents: `{`,
+			Err:      true,
+		},
+		{
+			Name:     "missing secret",
+			Contents: `[{"subject":"reader","permissions":["digests:read"]}]`,
+			Err:      true,
+		},
+		{
+			Name:     "unknown permission",
+			Contents: `[{"subject":"reader","secret":"s3cret","permissions":["digests:write"]}]`,
+			Err:      true,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			path := writeFile(t, tt.Contents)
+			defer os.RemoveAll(filepath.Dir(path))
+			credentials, err := LoadCredentials(path)
+			assert.Equal(t, tt.Err, err != nil)
+			assert.Equal(t, tt.Credentials, credentials)
+		})
+	}
+}
+
+func TestLoadCredentialsMissingFile(t *testing.T) {
+	_, err := LoadCredentials(filepath.Join(os.TempDir(), "does-not-exist.json"))
+	assert.NotNil(t, err)
+}
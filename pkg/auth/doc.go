@@ -0,0 +1,5 @@
+// Package auth contains the authentication and authorization of API requests.
+// Callers are identified by an Authenticator, which may accept static bearer
+// tokens, HMAC-signed requests, or JWTs, and each route requires a Permission.
+//
+package auth
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// HMACScheme is the scheme of the Authorization header of HMAC-signed requests
+	HMACScheme = "HMAC-SHA256"
+	// TimestampHeader carries the time at which a request was signed, in seconds since the Unix epoch
+	TimestampHeader = "X-Digest-Timestamp"
+	// DefaultMaxSkew is the default difference allowed between the time a request was signed and the time it is
+	// received
+	DefaultMaxSkew = 5 * time.Minute
+	// maxSignedBodySize is the largest body of a signed request, which is read in full in order to be hashed
+	maxSignedBodySize = 10 << 20
+)
+
+// HMACAuthenticator is an Authenticator which accepts requests signed with a key shared with the caller. Signed
+// requests carry the header
+//
+//	Authorization: HMAC-SHA256 KeyId=<subject>, Signature=<hex encoded signature>
+//
+// The signature is the HMAC-SHA256, under the secret of the key, of the lines of the string to sign: the value
+// of the X-Digest-Timestamp header, the method, the request URI including the query, and the hex encoded
+// SHA-256 of the body.
+type HMACAuthenticator struct {
+	// Keys are the signing keys of callers, identified by their subject
+	Keys []Credential
+	// MaxSkew is the difference allowed between the timestamp of a request and the time it is received, which
+	// limits the window in which a request may be replayed. If zero, DefaultMaxSkew is used.
+	MaxSkew time.Duration
+	// Now returns the current time. If nil, time.Now is used.
+	Now func() time.Time
+}
+
+// Authenticate verifies the signature of the request, returning the Principal of its key
+func (a *HMACAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	keyID, signature, ok := parseHMACHeader(r.Header.Get("Authorization"))
+	if !ok {
+		return Principal{}, ErrNoCredentials
+	}
+	var key *Credential
+	for i := range a.Keys {
+		if a.Keys[i].Subject == keyID {
+			key = &a.Keys[i]
+			break
+		}
+	}
+	if key == nil {
+		return Principal{}, fmt.Errorf("the signing key %s is not recognised", keyID)
+	}
+	timestamp := r.Header.Get(TimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return Principal{}, fmt.Errorf("the %s header is not valid", TimestampHeader)
+	}
+	skew := a.now().Sub(time.Unix(seconds, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > a.maxSkew() {
+		return Principal{}, errors.New("the request timestamp is outside the allowed skew")
+	}
+	expected, err := signature256(key.Secret, timestamp, r)
+	if err != nil {
+		return Principal{}, err
+	}
+	provided, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, provided) {
+		return Principal{}, errors.New("the request signature is not valid")
+	}
+	return key.principal(), nil
+}
+
+func (a *HMACAuthenticator) maxSkew() time.Duration {
+	if a.MaxSkew > 0 {
+		return a.MaxSkew
+	}
+	return DefaultMaxSkew
+}
+
+func (a *HMACAuthenticator) now() time.Time {
+	if a.Now != nil {
+		return a.Now()
+	}
+	return time.Now()
+}
+
+// SignRequest signs a request for an HMACAuthenticator, with the key identified by keyID, at the given time.
+// It sets the Authorization and X-Digest-Timestamp headers of the request.
+func SignRequest(r *http.Request, keyID string, secret string, at time.Time) error {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	signature, err := signature256(secret, timestamp, r)
+	if err != nil {
+		return err
+	}
+	r.Header.Set(TimestampHeader, timestamp)
+	r.Header.Set("Authorization", fmt.Sprintf("%s KeyId=%s, Signature=%s", HMACScheme, keyID, hex.EncodeToString(signature)))
+	return nil
+}
+
+// signature256 returns the HMAC-SHA256 signature of the request. The body of the request is read, and
+// replaced so that it may be read again. Bodies larger than maxSignedBodySize are rejected.
+func signature256(secret string, timestamp string, r *http.Request) ([]byte, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(io.LimitReader(r.Body, maxSignedBodySize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > maxSignedBodySize {
+			return nil, errors.New("the request body is too large to be signed")
+		}
+		_ = r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	bodyHash := sha256.Sum256(body)
+	stringToSign := strings.Join([]string{
+		timestamp,
+		r.Method,
+		r.URL.RequestURI(),
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(stringToSign))
+	return mac.Sum(nil), nil
+}
+
+// parseHMACHeader returns the key ID and signature of an HMAC-SHA256 Authorization header
+func parseHMACHeader(header string) (keyID string, signature string, ok bool) {
+	if !strings.HasPrefix(header, HMACScheme+" ") {
+		return "", "", false
+	}
+	for _, param := range strings.Split(header[len(HMACScheme)+1:], ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "KeyId":
+			keyID = kv[1]
+		case "Signature":
+			signature = kv[1]
+		}
+	}
+	return keyID, signature, keyID != "" && signature != ""
+}
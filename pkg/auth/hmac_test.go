@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHMACAuthenticator(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	authenticator := &HMACAuthenticator{
+		Keys: []Credential{
+			{Subject: "client", Secret: "s3cret", Permissions: []Permission{PermissionCreate}},
+		},
+		Now: func() time.Time { return now },
+	}
+	newRequest := func() *http.Request {
+		r, _ := http.NewRequest(http.MethodPost, "/?start=a&stop=b", bytes.NewBufferString(`{"callback":"x"}`))
+		return r
+	}
+	tc := []struct {
+		Name    string
+		Request func() *http.Request
+		Err     bool
+		NoCreds bool
+	}{
+		{
+			Name: "valid",
+			Request: func() *http.Request {
+				r := newRequest()
+				assert.Nil(t, SignRequest(r, "client", "s3cret", now.Add(-time.Minute)))
+				return r
+			},
+		},
+		{
+			Name:    "unsigned",
+			Request: newRequest,
+			Err:     true,
+			NoCreds: true,
+		},
+		{
+			Name: "unknown key",
+			Request: func() *http.Request {
+				r := newRequest()
+				assert.Nil(t, SignRequest(r, "other", "s3cret", now))
+				return r
+			},
+			Err: true,
+		},
+		{
+			Name: "wrong secret",
+			Request: func() *http.Request {
+				r := newRequest()
+				assert.Nil(t, SignRequest(r, "client", "guess", now))
+				return r
+			},
+			Err: true,
+		},
+		{
+			Name: "modified body",
+			Request: func() *http.Request {
+				r := newRequest()
+				assert.Nil(t, SignRequest(r, "client", "s3cret", now))
+				r.Body = ioutil.NopCloser(bytes.NewBufferString(`{"callback":"y"}`))
+				return r
+			},
+			Err: true,
+		},
+		{
+			Name: "body too large",
+			Request: func() *http.Request {
+				r := newRequest()
+				assert.Nil(t, SignRequest(r, "client", "s3cret", now))
+				r.Body = ioutil.NopCloser(bytes.NewReader(make([]byte, maxSignedBodySize+1)))
+				return r
+			},
+			Err: true,
+		},
+		{
+			Name: "modified query",
+			Request: func() *http.Request {
+				r := newRequest()
+				assert.Nil(t, SignRequest(r, "client", "s3cret", now))
+				r.URL.RawQuery = "start=a&stop=c"
+				return r
+			},
+			Err: true,
+		},
+		{
+			Name: "stale",
+			Request: func() *http.Request {
+				r := newRequest()
+				assert.Nil(t, SignRequest(r, "client", "s3cret", now.Add(-DefaultMaxSkew-time.Second)))
+				return r
+			},
+			Err: true,
+		},
+		{
+			Name: "invalid timestamp",
+			Request: func() *http.Request {
+				r := newRequest()
+				assert.Nil(t, SignRequest(r, "client", "s3cret", now))
+				r.Header.Set(TimestampHeader, "yesterday")
+				return r
+			},
+			Err: true,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			r := tt.Request()
+			principal, err := authenticator.Authenticate(r)
+			assert.Equal(t, tt.Err, err != nil)
+			assert.Equal(t, tt.NoCreds, err == ErrNoCredentials)
+			if !tt.Err {
+				assert.Equal(t, "client", principal.Subject)
+				body, _ := ioutil.ReadAll(r.Body)
+				assert.Equal(t, `{"callback":"x"}`, string(body))
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultLeeway is the default allowance for clock skew when checking the times of a JWT
+const DefaultLeeway = time.Minute
+
+// JWTAuthenticator is an Authenticator which accepts JWTs, signed with RS256 or ES256 by one of the keys of a
+// KeySet, as bearer tokens. The subject of the caller is taken from the sub claim, and their permissions from
+// either the space separated scope claim or the permissions array claim.
+type JWTAuthenticator struct {
+	Keys KeySet
+	// Issuer, if set, must match the iss claim
+	Issuer string
+	// Audience, if set, must be one of the values of the aud claim
+	Audience string
+	// Leeway is the allowance for clock skew when checking the exp and nbf claims. If zero, DefaultLeeway is
+	// used.
+	Leeway time.Duration
+	// Now returns the current time. If nil, time.Now is used.
+	Now func() time.Time
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Subject     string       `json:"sub"`
+	Issuer      string       `json:"iss"`
+	Audience    audience     `json:"aud"`
+	ExpiresAt   *int64       `json:"exp"`
+	NotBefore   *int64       `json:"nbf"`
+	Scope       string       `json:"scope"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// audience is the aud claim, which may be either a single string or an array
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return errors.New("the aud claim must be a string or an array of strings")
+	}
+	*a = multiple
+	return nil
+}
+
+// Authenticate verifies the JWT in the Authorization header, returning the Principal it identifies
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	token, ok := bearerToken(r)
+	if !ok || !isJWT(token) {
+		return Principal{}, ErrNoCredentials
+	}
+	claims, err := a.verify(token)
+	if err != nil {
+		return Principal{}, err
+	}
+	principal := Principal{Subject: claims.Subject, Permissions: claims.Permissions}
+	for _, scope := range strings.Fields(claims.Scope) {
+		principal.Permissions = append(principal.Permissions, Permission(scope))
+	}
+	return principal, nil
+}
+
+// verify checks the signature and claims of a compact JWT, returning its claims
+func (a *JWTAuthenticator) verify(token string) (jwtClaims, error) {
+	var header jwtHeader
+	var claims jwtClaims
+	segments := strings.Split(token, ".")
+	if err := decodeSegment(segments[0], &header); err != nil {
+		return claims, fmt.Errorf("the JWT header is not valid: %s", err.Error())
+	}
+	key, ok := a.Keys[header.KeyID]
+	if !ok {
+		return claims, fmt.Errorf("the JWT signing key %s is not recognised", header.KeyID)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(segments[2])
+	if err != nil {
+		return claims, errors.New("the JWT signature is not valid")
+	}
+	digest := sha256.Sum256([]byte(segments[0] + "." + segments[1]))
+	if err := verifySignature(header.Algorithm, key, digest[:], signature); err != nil {
+		return claims, err
+	}
+	if err := decodeSegment(segments[1], &claims); err != nil {
+		return claims, fmt.Errorf("the JWT claims are not valid: %s", err.Error())
+	}
+	return claims, a.check(claims)
+}
+
+// check validates the registered claims of a JWT whose signature has been verified
+func (a *JWTAuthenticator) check(claims jwtClaims) error {
+	now := a.now()
+	leeway := a.leeway()
+	if claims.ExpiresAt == nil {
+		return errors.New("the JWT has no exp claim")
+	}
+	if now.After(time.Unix(*claims.ExpiresAt, 0).Add(leeway)) {
+		return errors.New("the JWT has expired")
+	}
+	if claims.NotBefore != nil && now.Add(leeway).Before(time.Unix(*claims.NotBefore, 0)) {
+		return errors.New("the JWT is not yet valid")
+	}
+	if a.Issuer != "" && claims.Issuer != a.Issuer {
+		return fmt.Errorf("the JWT issuer %s is not trusted", claims.Issuer)
+	}
+	if a.Audience != "" && !claims.Audience.contains(a.Audience) {
+		return errors.New("the JWT is not intended for this audience")
+	}
+	if claims.Subject == "" {
+		return errors.New("the JWT has no sub claim")
+	}
+	return nil
+}
+
+func (a audience) contains(value string) bool {
+	for _, v := range a {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *JWTAuthenticator) leeway() time.Duration {
+	if a.Leeway > 0 {
+		return a.Leeway
+	}
+	return DefaultLeeway
+}
+
+func (a *JWTAuthenticator) now() time.Time {
+	if a.Now != nil {
+		return a.Now()
+	}
+	return time.Now()
+}
+
+// verifySignature checks the signature of the SHA-256 digest of a JWT with the given algorithm. The algorithm
+// must match the type of the key, so that a token cannot choose how it is verified.
+func verifySignature(algorithm string, key crypto.PublicKey, digest []byte, signature []byte) error {
+	switch algorithm {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("the JWT algorithm does not match its signing key")
+		}
+		if rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest, signature) != nil {
+			return errors.New("the JWT signature is not valid")
+		}
+		return nil
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("the JWT algorithm does not match its signing key")
+		}
+		if len(signature) != 64 {
+			return errors.New("the JWT signature is not valid")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return errors.New("the JWT signature is not valid")
+		}
+		return nil
+	default:
+		return fmt.Errorf("the JWT algorithm %s is not supported", algorithm)
+	}
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
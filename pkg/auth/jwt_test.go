@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	assert.Nil(t, err)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	signingInput := encodeSegment(t, map[string]string{"alg": "RS256", "kid": kid}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	assert.Nil(t, err)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func signES256(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	signingInput := encodeSegment(t, map[string]string{"alg": "ES256", "kid": kid}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	assert.Nil(t, err)
+	signature := make([]byte, 64)
+	rb, sb := r.Bytes(), s.Bytes()
+	copy(signature[32-len(rb):32], rb)
+	copy(signature[64-len(sb):], sb)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func keySetJSON(rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	return fmt.Sprintf(`{"keys":[
+		{"kty":"RSA","kid":"rsa","use":"sig","n":"%s","e":"AQAB"},
+		{"kty":"EC","kid":"ec","crv":"P-256","x":"%s","y":"%s"},
+		{"kty":"RSA","kid":"enc","use":"enc","n":"%s","e":"AQAB"}
+	]}`,
+		b64(rsaKey.N.Bytes()),
+		b64(ecKey.X.Bytes()), b64(ecKey.Y.Bytes()),
+		b64(rsaKey.N.Bytes()))
+}
+
+func TestLoadKeySet(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	path := writeFile(t, keySetJSON(rsaKey, ecKey))
+	defer os.RemoveAll(filepath.Dir(path))
+	keys, err := LoadKeySet(path)
+	assert.Nil(t, err)
+	assert.Len(t, keys, 2)
+	assert.Equal(t, &rsaKey.PublicKey, keys["rsa"])
+	assert.Equal(t, &ecKey.PublicKey, keys["ec"])
+
+	_, err = ParseKeySet([]byte(`{"keys":[{"kty":"oct","kid":"k","k":"c2VjcmV0"}]}`))
+	assert.NotNil(t, err)
+	_, err = ParseKeySet([]byte(`{"keys":[]}`))
+	assert.NotNil(t, err)
+	_, err = ParseKeySet([]byte(`{"keys":[{"kty":"EC","kid":"ec","crv":"P-256","x":"AQ","y":"AQ"}]}`))
+	assert.NotNil(t, err)
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	keys, err := ParseKeySet([]byte(keySetJSON(rsaKey, ecKey)))
+	assert.Nil(t, err)
+
+	now := time.Unix(1500000000, 0)
+	authenticator := &JWTAuthenticator{
+		Keys:     keys,
+		Issuer:   "https://issuer.example.com",
+		Audience: "vpcflow-digesterd",
+		Now:      func() time.Time { return now },
+	}
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":   "client",
+			"iss":   "https://issuer.example.com",
+			"aud":   []string{"other", "vpcflow-digesterd"},
+			"exp":   now.Add(time.Hour).Unix(),
+			"nbf":   now.Add(-time.Hour).Unix(),
+			"scope": "digests:read digests:create",
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+
+	tc := []struct {
+		Name        string
+		Token       string
+		Permissions []Permission
+		Err         bool
+		NoCreds     bool
+	}{
+		{
+			Name:        "rs256 scope",
+			Token:       signRS256(t, rsaKey, "rsa", claims(nil)),
+			Permissions: []Permission{PermissionRead, PermissionCreate},
+		},
+		{
+			Name:        "es256 permissions",
+			Token:       signES256(t, ecKey, "ec", claims(map[string]interface{}{"scope": nil, "permissions": []string{"digests:produce"}, "aud": "vpcflow-digesterd"})),
+			Permissions: []Permission{PermissionProduce},
+		},
+		{
+			Name:        "within leeway",
+			Token:       signRS256(t, rsaKey, "rsa", claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()})),
+			Permissions: []Permission{PermissionRead, PermissionCreate},
+		},
+		{
+			Name:  "expired",
+			Token: signRS256(t, rsaKey, "rsa", claims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()})),
+			Err:   true,
+		},
+		{
+			Name:  "no expiry",
+			Token: signRS256(t, rsaKey, "rsa", claims(map[string]interface{}{"exp": nil})),
+			Err:   true,
+		},
+		{
+			Name:  "not yet valid",
+			Token: signRS256(t, rsaKey, "rsa", claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})),
+			Err:   true,
+		},
+		{
+			Name:  "wrong issuer",
+			Token: signRS256(t, rsaKey, "rsa", claims(map[string]interface{}{"iss": "https://other.example.com"})),
+			Err:   true,
+		},
+		{
+			Name:  "wrong audience",
+			Token: signRS256(t, rsaKey, "rsa", claims(map[string]interface{}{"aud": "other"})),
+			Err:   true,
+		},
+		{
+			Name:  "no subject",
+			Token: signRS256(t, rsaKey, "rsa", claims(map[string]interface{}{"sub": nil})),
+			Err:   true,
+		},
+		{
+			Name:  "untrusted key",
+			Token: signRS256(t, otherKey, "rsa", claims(nil)),
+			Err:   true,
+		},
+		{
+			Name:  "unknown key id",
+			Token: signRS256(t, rsaKey, "other", claims(nil)),
+			Err:   true,
+		},
+		{
+			Name:  "unsigned",
+			Token: encodeSegment(t, map[string]string{"alg": "none", "kid": "rsa"}) + "." + encodeSegment(t, claims(nil)) + ".",
+			Err:   true,
+		},
+		{
+			Name:  "algorithm mismatch",
+			Token: signES256(t, ecKey, "rsa", claims(nil)),
+			Err:   true,
+		},
+		{
+			Name:    "static token",
+			Token:   "static-token",
+			Err:     true,
+			NoCreds: true,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.Token)
+			principal, err := authenticator.Authenticate(r)
+			assert.Equal(t, tt.Err, err != nil, fmt.Sprint(err))
+			assert.Equal(t, tt.NoCreds, err == ErrNoCredentials)
+			if !tt.Err {
+				assert.Equal(t, "client", principal.Subject)
+				assert.ElementsMatch(t, tt.Permissions, principal.Permissions)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// StaticTokens is an Authenticator which accepts a fixed set of bearer tokens, each of which identifies a caller
+type StaticTokens []Credential
+
+// Authenticate returns the Principal of the bearer token in the Authorization header
+func (s StaticTokens) Authenticate(r *http.Request) (Principal, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return Principal{}, ErrNoCredentials
+	}
+	for _, credential := range s {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(credential.Secret)) == 1 {
+			return credential.principal(), nil
+		}
+	}
+	if isJWT(token) {
+		// Unrecognised tokens which look like JWTs are left to the JWT Authenticator
+		return Principal{}, ErrNoCredentials
+	}
+	return Principal{}, errors.New("the bearer token is not recognised")
+}
+
+// isJWT reports whether the token has the three dot separated segments of a compact JWT
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// bearerToken returns the token of a bearer Authorization header
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(prefix):]), true
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticTokens(t *testing.T) {
+	tokens := StaticTokens{
+		{Subject: "reader", Secret: "read-token", Permissions: []Permission{PermissionRead}},
+		{Subject: "worker", Secret: "worker-token", Permissions: []Permission{PermissionProduce}},
+	}
+	tc := []struct {
+		Name      string
+		Header    string
+		Principal Principal
+		Err       bool
+		NoCreds   bool
+	}{
+		{
+			Name:      "known token",
+			Header:    "Bearer worker-token",
+			Principal: Principal{Subject: "worker", Permissions: []Permission{PermissionProduce}},
+		},
+		{
+			Name:      "case insensitive scheme",
+			Header:    "bearer read-token",
+			Principal: Principal{Subject: "reader", Permissions: []Permission{PermissionRead}},
+		},
+		{
+			Name:   "unknown token",
+			Header: "Bearer other-token",
+			Err:    true,
+		},
+		{
+			Name:    "jwt",
+			Header:  "Bearer a.b.c",
+			Err:     true,
+			NoCreds: true,
+		},
+		{
+			Name:    "no header",
+			Err:     true,
+			NoCreds: true,
+		},
+		{
+			Name:    "other scheme",
+			Header:  "Basic dXNlcjpwYXNz",
+			Err:     true,
+			NoCreds: true,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "/", nil)
+			if tt.Header != "" {
+				r.Header.Set("Authorization", tt.Header)
+			}
+			principal, err := tokens.Authenticate(r)
+			assert.Equal(t, tt.Err, err != nil)
+			assert.Equal(t, tt.NoCreds, err == ErrNoCredentials)
+			assert.Equal(t, tt.Principal, principal)
+		})
+	}
+}
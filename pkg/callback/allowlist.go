@@ -0,0 +1,36 @@
+package callback
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Allowlist is a list of hosts to which notifications may be delivered. An entry of the form "*.example.com"
+// allows any subdomain of example.com, but not example.com itself.
+type Allowlist []string
+
+// Validate returns an error if the callback URL is not an absolute HTTPS URL whose host is in the allowlist
+func (a Allowlist) Validate(callback string) error {
+	u, err := url.Parse(callback)
+	if err != nil {
+		return fmt.Errorf("invalid callback URL: %s", err.Error())
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("callback URL must use https")
+	}
+	if u.User != nil {
+		return fmt.Errorf("callback URL must not contain credentials")
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range a {
+		allowed = strings.ToLower(allowed)
+		if host == allowed {
+			return nil
+		}
+		if strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:]) {
+			return nil
+		}
+	}
+	return fmt.Errorf("callback host %s is not allowed", host)
+}
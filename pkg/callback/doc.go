@@ -0,0 +1,3 @@
+// Package callback contains the validation and delivery of notifications sent to callback URLs when digest
+// jobs complete.
+package callback
@@ -0,0 +1,73 @@
+package callback
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+)
+
+const (
+	// SignatureHeader carries the HMAC-SHA256 signature of the notification, as "sha256=<hex>". The signature
+	// is computed over the value of the TimestampHeader, a ".", and the request body.
+	SignatureHeader = "X-Digest-Signature"
+	// TimestampHeader carries the time at which the notification was signed, in seconds since the epoch.
+	// Receivers should reject notifications with old timestamps to prevent replay.
+	TimestampHeader = "X-Digest-Timestamp"
+)
+
+// HTTPNotifier is a Notifier implementation which POSTs signed JSON notifications to callback URLs. Retries are
+// the responsibility of the Client's transport.
+type HTTPNotifier struct {
+	Client *http.Client
+	Secret []byte
+
+	now func() time.Time
+}
+
+// Notify delivers the notification to the callback URL. An error is returned if the notification could not be
+// delivered, or was not acknowledged with a 2XX response.
+func (n *HTTPNotifier) Notify(ctx context.Context, callback string, notification types.Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	now := n.now
+	if now == nil {
+		now = time.Now
+	}
+	timestamp := strconv.FormatInt(now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, callback, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(n.Secret, timestamp, body))
+	res, err := n.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected response from callback: %d", res.StatusCode)
+	}
+	return nil
+}
+
+// Sign computes the value of the SignatureHeader for a notification body signed at the given timestamp
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte(timestamp))
+	_, _ = mac.Write([]byte("."))
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
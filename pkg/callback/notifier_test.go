@@ -0,0 +1,66 @@
+package callback
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotify(t *testing.T) {
+	now := time.Date(2019, time.January, 2, 0, 0, 0, 0, time.UTC)
+	notification := types.Notification{
+		ID:       "id",
+		Start:    now.Add(-2 * time.Hour),
+		Stop:     now.Add(-time.Hour),
+		Status:   types.NotificationCompleted,
+		Location: "/digests/id",
+		Time:     now,
+	}
+	secret := []byte("secret")
+
+	var received types.Notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "1546387200", r.Header.Get(TimestampHeader))
+		assert.Equal(t, Sign(secret, "1546387200", body), r.Header.Get(SignatureHeader))
+		assert.Nil(t, json.Unmarshal(body, &received))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	notifier := &HTTPNotifier{
+		Client: server.Client(),
+		Secret: secret,
+		now:    func() time.Time { return now },
+	}
+	require.Nil(t, notifier.Notify(context.Background(), server.URL, notification))
+	assert.Equal(t, notification, received)
+}
+
+func TestNotifyUnexpectedResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	notifier := &HTTPNotifier{Client: server.Client()}
+	assert.NotNil(t, notifier.Notify(context.Background(), server.URL, types.Notification{}))
+}
+
+func TestSign(t *testing.T) {
+	// signatures must be reproducible by receivers using the documented scheme
+	assert.Equal(t,
+		"sha256=0c7bb6c2e8c8cbd956717855bcfc31cc95f362cb268b3a4c52d72d46930df59e",
+		Sign([]byte("secret"), "1546387200", []byte(`{"id":"id"}`)),
+	)
+}
@@ -0,0 +1,822 @@
+package digesterd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asecurityteam/settings"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/auth"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/ratelimit"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/storage"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// configFileEnv is the environment variable which names an optional YAML or JSON file of settings
+	configFileEnv = "DIGEST_CONFIG_FILE"
+	redacted      = "REDACTED"
+)
+
+// Config is the configuration of the built in modules of the Service. Each field is loaded from the setting named
+// in its comment, which is both an environment variable and a key of the file named by DIGEST_CONFIG_FILE.
+// Durations are given as a number of milliseconds, or as a duration string such as "30s", and lists as comma
+// separated strings, or as arrays in a file.
+type Config struct {
+	// UseIAM is USE_IAM. It is nil if USE_IAM is not set, which is only valid if no S3 client is created.
+	UseIAM *bool
+	// AWSCredentialsFile is AWS_CREDENTIALS_FILE
+	AWSCredentialsFile string
+	// AWSCredentialsProfile is AWS_CREDENTIALS_PROFILE
+	AWSCredentialsProfile string
+
+	// StorageBucket is DIGEST_STORAGE_BUCKET
+	StorageBucket string
+	// StorageBucketRegion is DIGEST_STORAGE_BUCKET_REGION
+	StorageBucketRegion string
+	// StorageBucketRole is DIGEST_STORAGE_BUCKET_ROLE
+	StorageBucketRole string
+	// StorageBucketEndpoint is DIGEST_STORAGE_BUCKET_ENDPOINT
+	StorageBucketEndpoint string
+	// StorageBucketPathStyle is DIGEST_STORAGE_BUCKET_PATH_STYLE
+	StorageBucketPathStyle bool
+	// StorageBucketCAFile is DIGEST_STORAGE_BUCKET_CA_FILE
+	StorageBucketCAFile string
+	// StorageBucketInsecureSkipVerify is DIGEST_STORAGE_BUCKET_INSECURE_SKIP_VERIFY
+	StorageBucketInsecureSkipVerify bool
+	// StorageBucketSSE is DIGEST_STORAGE_BUCKET_SSE
+	StorageBucketSSE string
+	// StorageBucketKMSKeyID is DIGEST_STORAGE_BUCKET_KMS_KEY_ID
+	StorageBucketKMSKeyID string
+	// StorageBucketStorageClass is DIGEST_STORAGE_BUCKET_STORAGE_CLASS
+	StorageBucketStorageClass string
+	// StorageBucketTags is DIGEST_STORAGE_BUCKET_TAGS
+	StorageBucketTags map[string]string
+	// EncryptionKeyFile is DIGEST_ENCRYPTION_KEY_FILE
+	EncryptionKeyFile string
+	// EncryptionKMSKeyID is DIGEST_ENCRYPTION_KMS_KEY_ID
+	EncryptionKMSKeyID string
+
+	// ProgressBucket is DIGEST_PROGRESS_BUCKET
+	ProgressBucket string
+	// ProgressBucketRegion is DIGEST_PROGRESS_BUCKET_REGION
+	ProgressBucketRegion string
+	// ProgressBucketRole is DIGEST_PROGRESS_BUCKET_ROLE
+	ProgressBucketRole string
+	// ProgressBucketEndpoint is DIGEST_PROGRESS_BUCKET_ENDPOINT
+	ProgressBucketEndpoint string
+	// ProgressBucketPathStyle is DIGEST_PROGRESS_BUCKET_PATH_STYLE
+	ProgressBucketPathStyle bool
+	// ProgressBucketCAFile is DIGEST_PROGRESS_BUCKET_CA_FILE
+	ProgressBucketCAFile string
+	// ProgressBucketInsecureSkipVerify is DIGEST_PROGRESS_BUCKET_INSECURE_SKIP_VERIFY
+	ProgressBucketInsecureSkipVerify bool
+	// ProgressBucketSSE is DIGEST_PROGRESS_BUCKET_SSE
+	ProgressBucketSSE string
+	// ProgressBucketKMSKeyID is DIGEST_PROGRESS_BUCKET_KMS_KEY_ID
+	ProgressBucketKMSKeyID string
+	// ProgressBucketStorageClass is DIGEST_PROGRESS_BUCKET_STORAGE_CLASS
+	ProgressBucketStorageClass string
+	// ProgressBucketTags is DIGEST_PROGRESS_BUCKET_TAGS
+	ProgressBucketTags map[string]string
+	// ProgressTimeout is DIGEST_PROGRESS_TIMEOUT
+	ProgressTimeout time.Duration
+	// StreamApplianceEndpoint is STREAM_APPLIANCE_ENDPOINT
+	StreamApplianceEndpoint string
+	// StreamApplianceTopic is STREAM_APPLIANCE_TOPIC
+	StreamApplianceTopic string
+	// StreamApplianceToken is STREAM_APPLIANCE_TOKEN
+	StreamApplianceToken string
+	// WorkerAddress is DIGEST_WORKER_ADDRESS
+	WorkerAddress string
+	// WorkerSecret is DIGEST_WORKER_SECRET
+	WorkerSecret string
+
+	// VPCFlowLogsBucket is VPC_FLOW_LOGS_BUCKET
+	VPCFlowLogsBucket string
+	// VPCFlowLogsBucketRegion is VPC_FLOW_LOGS_BUCKET_REGION
+	VPCFlowLogsBucketRegion string
+	// VPCFlowLogsBucketRole is VPC_FLOW_LOGS_BUCKET_ROLE
+	VPCFlowLogsBucketRole string
+	// VPCFlowLogsBucketEndpoint is VPC_FLOW_LOGS_BUCKET_ENDPOINT
+	VPCFlowLogsBucketEndpoint string
+	// VPCFlowLogsBucketPathStyle is VPC_FLOW_LOGS_BUCKET_PATH_STYLE
+	VPCFlowLogsBucketPathStyle bool
+	// VPCFlowLogsBucketCAFile is VPC_FLOW_LOGS_BUCKET_CA_FILE
+	VPCFlowLogsBucketCAFile string
+	// VPCFlowLogsBucketInsecureSkipVerify is VPC_FLOW_LOGS_BUCKET_INSECURE_SKIP_VERIFY
+	VPCFlowLogsBucketInsecureSkipVerify bool
+	// ScanRegions is VPC_FLOW_LOGS_SCAN_REGIONS
+	ScanRegions []string
+	// ScanAccounts is VPC_FLOW_LOGS_SCAN_ACCOUNTS
+	ScanAccounts []string
+	// MaxBytesPrefetch is VPC_MAX_BYTES_PREFETCH
+	MaxBytesPrefetch int64
+	// MaxConcurrentPrefetch is VPC_MAX_CONCURRENT_PREFETCH
+	MaxConcurrentPrefetch int
+
+	// DownloadRedirect is DIGEST_DOWNLOAD_REDIRECT
+	DownloadRedirect bool
+	// DownloadRedirectTTL is DIGEST_DOWNLOAD_REDIRECT_TTL
+	DownloadRedirectTTL time.Duration
+	// MaxWait is DIGEST_MAX_WAIT
+	MaxWait time.Duration
+	// BatchMax is DIGEST_BATCH_MAX
+	BatchMax int
+	// BatchConcurrency is DIGEST_BATCH_CONCURRENCY
+	BatchConcurrency int
+	// EstimatePerHour is DIGEST_ESTIMATE_PER_HOUR
+	EstimatePerHour time.Duration
+	// LegacyIDLookup is DIGEST_LEGACY_ID_LOOKUP
+	LegacyIDLookup bool
+
+	// WindowMax is DIGEST_WINDOW_MAX
+	WindowMax time.Duration
+	// WindowMaxLookback is DIGEST_WINDOW_MAX_LOOKBACK
+	WindowMaxLookback time.Duration
+	// WindowSettleLag is DIGEST_WINDOW_SETTLE_LAG
+	WindowSettleLag time.Duration
+	// WindowSplit is DIGEST_WINDOW_SPLIT
+	WindowSplit bool
+	// WindowMaxSplit is DIGEST_WINDOW_MAX_SPLIT
+	WindowMaxSplit int
+
+	// ReconcileInterval is DIGEST_RECONCILE_INTERVAL
+	ReconcileInterval time.Duration
+	// ReconcileHorizon is DIGEST_RECONCILE_HORIZON
+	ReconcileHorizon time.Duration
+	// ReconcileMaxAttempts is DIGEST_RECONCILE_MAX_ATTEMPTS
+	ReconcileMaxAttempts int
+
+	// CallbackAllowlist is DIGEST_CALLBACK_ALLOWLIST
+	CallbackAllowlist []string
+	// CallbackSecret is DIGEST_CALLBACK_SECRET
+	CallbackSecret string
+
+	// AuthTokensFile is DIGEST_AUTH_TOKENS_FILE
+	AuthTokensFile string
+	// AuthHMACKeysFile is DIGEST_AUTH_HMAC_KEYS_FILE
+	AuthHMACKeysFile string
+	// AuthHMACMaxSkew is DIGEST_AUTH_HMAC_MAX_SKEW
+	AuthHMACMaxSkew time.Duration
+	// AuthJWKSFile is DIGEST_AUTH_JWKS_FILE
+	AuthJWKSFile string
+	// AuthJWTIssuer is DIGEST_AUTH_JWT_ISSUER
+	AuthJWTIssuer string
+	// AuthJWTAudience is DIGEST_AUTH_JWT_AUDIENCE
+	AuthJWTAudience string
+
+	// RateLimit is DIGEST_RATE_LIMIT
+	RateLimit int
+	// RateLimitBurst is DIGEST_RATE_LIMIT_BURST
+	RateLimitBurst int
+	// RateLimitClients is DIGEST_RATE_LIMIT_CLIENTS
+	RateLimitClients map[string]string
+	// MaxInProgress is DIGEST_MAX_IN_PROGRESS
+	MaxInProgress int
+}
+
+// NewConfig returns a Config with the default value of every setting
+func NewConfig() *Config {
+	return &Config{
+		LegacyIDLookup:   true,
+		EstimatePerHour:  defaultEstimatedDurationPerHour,
+		ReconcileHorizon: defaultReconcileHorizon,
+	}
+}
+
+// ConfigError reports every problem found while loading and validating a Config
+type ConfigError struct {
+	Problems []string
+}
+
+func (e ConfigError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// NewConfigSource returns the source of settings given the environment. Settings are read from environment
+// variables, falling back to the YAML or JSON file named by DIGEST_CONFIG_FILE, if set. Keys in the file are
+// the names of the environment variables, and are not case sensitive. Empty environment variables are ignored.
+func NewConfigSource(environ []string) (settings.Source, error) {
+	env := make(map[string]interface{})
+	for _, pair := range environ {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) == 2 && parts[1] != "" {
+			env[parts[0]] = parts[1]
+		}
+	}
+	path, ok := env[configFileEnv]
+	source := settings.MultiSource{settings.NewMapSource(env)}
+	if ok {
+		file, err := settings.NewFileSource(path.(string))
+		if err != nil {
+			return nil, err
+		}
+		source = append(source, file)
+	}
+	return source, nil
+}
+
+// LoadConfig loads a Config from the source, starting from the defaults. Every setting which cannot be parsed is
+// reported in the returned ConfigError, along with the Config as loaded from the remaining settings. The Config is
+// not otherwise validated, as which settings are required depends on the modules the Service is given.
+func LoadConfig(ctx context.Context, source settings.Source) (*Config, error) {
+	c := NewConfig()
+	if problems := c.load(ctx, source); len(problems) > 0 {
+		return c, ConfigError{Problems: problems}
+	}
+	return c, nil
+}
+
+// PrintConfig loads the Config from the environment, and writes the effective settings to w, one per line in the
+// form NAME=value, with secrets redacted. An error is returned if the configuration would be rejected by a Service
+// using all of the built in modules, but the settings are printed regardless.
+func PrintConfig(ctx context.Context, w io.Writer) error {
+	source, err := NewConfigSource(os.Environ())
+	if err != nil {
+		return err
+	}
+	c := NewConfig()
+	problems := c.load(ctx, source)
+	if err := c.Print(w); err != nil {
+		return err
+	}
+	problems = append(problems, c.validate(&Service{})...)
+	if len(problems) > 0 {
+		return ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// Print writes each setting to w, one per line in the form NAME=value, with secrets redacted
+func (c *Config) Print(w io.Writer) error {
+	for _, s := range c.settings() {
+		value := formatSetting(s.Value())
+		if s.secret && value != "" {
+			value = redacted
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", s.Name(), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load sets each setting found in the source, returning a problem for each which could not be parsed
+func (c *Config) load(ctx context.Context, source settings.Source) []string {
+	var problems []string
+	for _, s := range c.settings() {
+		v, found := source.Get(ctx, s.Name())
+		if !found {
+			continue
+		}
+		if err := s.SetValue(v); err != nil {
+			problems = append(problems, fmt.Sprintf("%s is not valid: %s", s.Name(), err.Error()))
+		}
+	}
+	return problems
+}
+
+// validate returns a problem for each setting which is required by the built in modules the Service will use, but
+// not set, and for each setting whose value is out of range
+func (c *Config) validate(s *Service) []string {
+	var problems []string
+	require := func(name string, value string) {
+		if value == "" {
+			problems = append(problems, name+" is required")
+		}
+	}
+	positive := func(name string, value int64) {
+		if value <= 0 {
+			problems = append(problems, name+" should be greater than zero")
+		}
+	}
+	notNegative := func(name string, value int64) {
+		if value < 0 {
+			problems = append(problems, name+" should not be negative")
+		}
+	}
+
+	// USE_IAM is required by every S3 client, as it always has been
+	if (s.Storage == nil || s.Marker == nil || s.DigesterProvider == nil || s.needsWatermarker(c)) && c.UseIAM == nil {
+		problems = append(problems, "USE_IAM is required")
+	}
+	if s.Queuer == nil {
+		require("STREAM_APPLIANCE_ENDPOINT", c.StreamApplianceEndpoint)
+		if _, err := url.Parse(c.StreamApplianceEndpoint); err != nil {
+			problems = append(problems, "STREAM_APPLIANCE_ENDPOINT is not valid: "+err.Error())
+		}
+	}
+	if s.Storage == nil || s.Marker == nil {
+		require("DIGEST_PROGRESS_BUCKET", c.ProgressBucket)
+		require("DIGEST_PROGRESS_BUCKET_REGION", c.ProgressBucketRegion)
+		positive("DIGEST_PROGRESS_TIMEOUT", int64(c.ProgressTimeout))
+		problems = append(problems, c.progressBucket().validate()...)
+		problems = append(problems, validateObjects("DIGEST_PROGRESS_BUCKET", c.progressObjects())...)
+	}
+	if s.Storage == nil {
+		require("DIGEST_STORAGE_BUCKET", c.StorageBucket)
+		require("DIGEST_STORAGE_BUCKET_REGION", c.StorageBucketRegion)
+		problems = append(problems, c.storageBucket().validate()...)
+		problems = append(problems, validateObjects("DIGEST_STORAGE_BUCKET", c.storageObjects())...)
+		if c.EncryptionKeyFile != "" && c.EncryptionKMSKeyID != "" {
+			problems = append(problems, "only one of DIGEST_ENCRYPTION_KEY_FILE and DIGEST_ENCRYPTION_KMS_KEY_ID should be set")
+		}
+		if c.EncryptionKeyFile != "" {
+			if _, err := storage.NewStaticKeyProvider(c.EncryptionKeyFile); err != nil {
+				problems = append(problems, "DIGEST_ENCRYPTION_KEY_FILE is not valid: "+err.Error())
+			}
+		}
+	}
+	if s.DigesterProvider == nil || s.needsWatermarker(c) {
+		require("VPC_FLOW_LOGS_BUCKET", c.VPCFlowLogsBucket)
+		require("VPC_FLOW_LOGS_BUCKET_REGION", c.VPCFlowLogsBucketRegion)
+		problems = append(problems, c.flowLogsBucket().validate()...)
+	}
+	if s.DigesterProvider == nil {
+		positive("VPC_MAX_BYTES_PREFETCH", c.MaxBytesPrefetch)
+		positive("VPC_MAX_CONCURRENT_PREFETCH", int64(c.MaxConcurrentPrefetch))
+	}
+	if len(c.CallbackAllowlist) > 0 && s.Notifier == nil {
+		require("DIGEST_CALLBACK_SECRET", c.CallbackSecret)
+	}
+	if s.Authenticator == nil {
+		problems = append(problems, c.validateAuth()...)
+	}
+	if _, err := c.rateLimits(); err != nil {
+		problems = append(problems, "DIGEST_RATE_LIMIT_CLIENTS is not valid: "+err.Error())
+	}
+	if c.ReconcileInterval > 0 && s.DigesterProvider != nil && !s.DigestersReportStats {
+		problems = append(problems, "DIGEST_RECONCILE_INTERVAL requires a DigesterProvider whose digesters report the source data they read, as declared by DigestersReportStats")
+	}
+	if _, ok := s.Marker.(types.MarkerCounter); c.MaxInProgress > 0 && s.Marker != nil && !ok {
+		problems = append(problems, "DIGEST_MAX_IN_PROGRESS requires a Marker which can count the digests in progress")
+	}
+
+	notNegative("DIGEST_DOWNLOAD_REDIRECT_TTL", int64(c.DownloadRedirectTTL))
+	notNegative("DIGEST_MAX_WAIT", int64(c.MaxWait))
+	notNegative("DIGEST_BATCH_MAX", int64(c.BatchMax))
+	notNegative("DIGEST_BATCH_CONCURRENCY", int64(c.BatchConcurrency))
+	notNegative("DIGEST_ESTIMATE_PER_HOUR", int64(c.EstimatePerHour))
+	notNegative("DIGEST_WINDOW_MAX", int64(c.WindowMax))
+	notNegative("DIGEST_WINDOW_MAX_LOOKBACK", int64(c.WindowMaxLookback))
+	notNegative("DIGEST_WINDOW_SETTLE_LAG", int64(c.WindowSettleLag))
+	notNegative("DIGEST_WINDOW_MAX_SPLIT", int64(c.WindowMaxSplit))
+	notNegative("DIGEST_RECONCILE_INTERVAL", int64(c.ReconcileInterval))
+	notNegative("DIGEST_RECONCILE_MAX_ATTEMPTS", int64(c.ReconcileMaxAttempts))
+	notNegative("DIGEST_AUTH_HMAC_MAX_SKEW", int64(c.AuthHMACMaxSkew))
+	notNegative("DIGEST_RATE_LIMIT", int64(c.RateLimit))
+	notNegative("DIGEST_RATE_LIMIT_BURST", int64(c.RateLimitBurst))
+	notNegative("DIGEST_MAX_IN_PROGRESS", int64(c.MaxInProgress))
+	positive("DIGEST_RECONCILE_HORIZON", int64(c.ReconcileHorizon))
+	return problems
+}
+
+// useIAM reports whether the credentials of the instance are used to access AWS
+func (c *Config) useIAM() bool {
+	return c.UseIAM != nil && *c.UseIAM
+}
+
+// authEnabled reports whether any of the built in authenticators is configured
+func (c *Config) authEnabled() bool {
+	return c.AuthTokensFile != "" || c.AuthHMACKeysFile != "" || c.AuthJWKSFile != ""
+}
+
+// validateAuth returns a problem for each file of the built in authenticators which cannot be loaded
+func (c *Config) validateAuth() []string {
+	var problems []string
+	if c.AuthTokensFile != "" {
+		if _, err := auth.LoadCredentials(c.AuthTokensFile); err != nil {
+			problems = append(problems, "DIGEST_AUTH_TOKENS_FILE is not valid: "+err.Error())
+		}
+	}
+	if c.AuthHMACKeysFile != "" {
+		if _, err := auth.LoadCredentials(c.AuthHMACKeysFile); err != nil {
+			problems = append(problems, "DIGEST_AUTH_HMAC_KEYS_FILE is not valid: "+err.Error())
+		}
+	}
+	if c.AuthJWKSFile != "" {
+		if _, err := auth.LoadKeySet(c.AuthJWKSFile); err != nil {
+			problems = append(problems, "DIGEST_AUTH_JWKS_FILE is not valid: "+err.Error())
+		}
+	} else if c.AuthJWTIssuer != "" || c.AuthJWTAudience != "" {
+		problems = append(problems, "DIGEST_AUTH_JWT_ISSUER and DIGEST_AUTH_JWT_AUDIENCE are only used with DIGEST_AUTH_JWKS_FILE")
+	}
+	return problems
+}
+
+// s3Bucket holds the settings of the S3 client for a single bucket
+type s3Bucket struct {
+	// name is the prefix of the settings of the bucket, such as DIGEST_STORAGE_BUCKET
+	name               string
+	Region             string
+	Role               string
+	Endpoint           string
+	PathStyle          bool
+	CAFile             string
+	InsecureSkipVerify bool
+}
+
+// rateLimits returns the rate limits of clients creating digests, or nil if there are none
+func (c *Config) rateLimits() (*ratelimit.Limits, error) {
+	if c.RateLimit == 0 && len(c.RateLimitClients) == 0 {
+		return nil, nil
+	}
+	limits := &ratelimit.Limits{
+		Default: ratelimit.Limit{PerMinute: c.RateLimit, Burst: c.RateLimitBurst},
+		Clients: make(map[string]ratelimit.Limit, len(c.RateLimitClients)),
+	}
+	for subject, value := range c.RateLimitClients {
+		parts := strings.SplitN(value, ":", 2)
+		var limit ratelimit.Limit
+		var err error
+		if limit.PerMinute, err = strconv.Atoi(parts[0]); err != nil || limit.PerMinute < 0 {
+			return nil, fmt.Errorf("the rate of %s, %q, is not a non negative integer", subject, parts[0])
+		}
+		if len(parts) == 2 {
+			if limit.Burst, err = strconv.Atoi(parts[1]); err != nil || limit.Burst < 0 {
+				return nil, fmt.Errorf("the burst of %s, %q, is not a non negative integer", subject, parts[1])
+			}
+		}
+		limits.Clients[subject] = limit
+	}
+	return limits, nil
+}
+
+func (c *Config) storageBucket() s3Bucket {
+	return s3Bucket{
+		name:               "DIGEST_STORAGE_BUCKET",
+		Region:             c.StorageBucketRegion,
+		Role:               c.StorageBucketRole,
+		Endpoint:           c.StorageBucketEndpoint,
+		PathStyle:          c.StorageBucketPathStyle,
+		CAFile:             c.StorageBucketCAFile,
+		InsecureSkipVerify: c.StorageBucketInsecureSkipVerify,
+	}
+}
+
+func (c *Config) progressBucket() s3Bucket {
+	return s3Bucket{
+		name:               "DIGEST_PROGRESS_BUCKET",
+		Region:             c.ProgressBucketRegion,
+		Role:               c.ProgressBucketRole,
+		Endpoint:           c.ProgressBucketEndpoint,
+		PathStyle:          c.ProgressBucketPathStyle,
+		CAFile:             c.ProgressBucketCAFile,
+		InsecureSkipVerify: c.ProgressBucketInsecureSkipVerify,
+	}
+}
+
+func (c *Config) flowLogsBucket() s3Bucket {
+	return s3Bucket{
+		name:               "VPC_FLOW_LOGS_BUCKET",
+		Region:             c.VPCFlowLogsBucketRegion,
+		Role:               c.VPCFlowLogsBucketRole,
+		Endpoint:           c.VPCFlowLogsBucketEndpoint,
+		PathStyle:          c.VPCFlowLogsBucketPathStyle,
+		CAFile:             c.VPCFlowLogsBucketCAFile,
+		InsecureSkipVerify: c.VPCFlowLogsBucketInsecureSkipVerify,
+	}
+}
+
+func (c *Config) storageObjects() storage.ObjectOptions {
+	return storage.ObjectOptions{
+		ServerSideEncryption: c.StorageBucketSSE,
+		KMSKeyID:             c.StorageBucketKMSKeyID,
+		StorageClass:         c.StorageBucketStorageClass,
+		Tags:                 c.StorageBucketTags,
+	}
+}
+
+func (c *Config) progressObjects() storage.ObjectOptions {
+	return storage.ObjectOptions{
+		ServerSideEncryption: c.ProgressBucketSSE,
+		KMSKeyID:             c.ProgressBucketKMSKeyID,
+		StorageClass:         c.ProgressBucketStorageClass,
+		Tags:                 c.ProgressBucketTags,
+	}
+}
+
+// validateObjects returns a problem for each of the options of objects written to the bucket which is not valid
+func validateObjects(name string, options storage.ObjectOptions) []string {
+	var problems []string
+	switch options.ServerSideEncryption {
+	case "", s3.ServerSideEncryptionAes256:
+		if options.ServerSideEncryption != "" && options.KMSKeyID != "" {
+			problems = append(problems, name+"_KMS_KEY_ID is only used with "+name+"_SSE of aws:kms")
+		}
+	case s3.ServerSideEncryptionAwsKms:
+	default:
+		problems = append(problems, name+"_SSE should be AES256 or aws:kms")
+	}
+	return problems
+}
+
+// validate returns a problem for each setting of the bucket which is not valid
+func (b s3Bucket) validate() []string {
+	var problems []string
+	if b.Endpoint != "" {
+		u, err := url.Parse(b.Endpoint)
+		if err != nil {
+			problems = append(problems, b.name+"_ENDPOINT is not valid: "+err.Error())
+		} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, b.name+"_ENDPOINT should be an absolute http or https URL")
+		}
+	}
+	if b.CAFile != "" {
+		if _, err := os.Stat(b.CAFile); err != nil {
+			problems = append(problems, b.name+"_CA_FILE is not valid: "+err.Error())
+		}
+	}
+	return problems
+}
+
+// windowPolicy returns the policy constraining digest windows
+func (c *Config) windowPolicy() types.WindowPolicy {
+	return types.WindowPolicy{
+		MaxWindow:   c.WindowMax,
+		MaxLookback: c.WindowMaxLookback,
+		SettleLag:   c.WindowSettleLag,
+		Split:       c.WindowSplit,
+		MaxSplit:    c.WindowMaxSplit,
+	}
+}
+
+// configSetting is a setting bound to a field of a Config
+type configSetting struct {
+	settings.Setting
+	// secret settings are redacted when printed
+	secret bool
+}
+
+// settings returns a setting bound to each field of the Config, in the order in which they are printed
+func (c *Config) settings() []configSetting {
+	return []configSetting{
+		{Setting: optionalBoolSetting("USE_IAM", "Whether to use the IAM role of the instance for AWS credentials", &c.UseIAM)},
+		{Setting: stringSetting("AWS_CREDENTIALS_FILE", "The AWS credentials file to use when not using IAM", &c.AWSCredentialsFile)},
+		{Setting: stringSetting("AWS_CREDENTIALS_PROFILE", "The AWS credentials profile to use when not using IAM", &c.AWSCredentialsProfile)},
+		{Setting: stringSetting("DIGEST_STORAGE_BUCKET", "The S3 bucket used to store digests", &c.StorageBucket)},
+		{Setting: stringSetting("DIGEST_STORAGE_BUCKET_REGION", "The region of the digest storage bucket", &c.StorageBucketRegion)},
+		{Setting: stringSetting("DIGEST_STORAGE_BUCKET_ROLE", "The role to assume to access the digest storage bucket", &c.StorageBucketRole)},
+		{Setting: stringSetting("DIGEST_STORAGE_BUCKET_ENDPOINT", "The S3 compatible endpoint of the digest storage bucket", &c.StorageBucketEndpoint)},
+		{Setting: boolSetting("DIGEST_STORAGE_BUCKET_PATH_STYLE", "Whether to address the digest storage bucket by path rather than by host", &c.StorageBucketPathStyle)},
+		{Setting: stringSetting("DIGEST_STORAGE_BUCKET_CA_FILE", "A PEM file of the certificate authorities trusted by the endpoint of the digest storage bucket", &c.StorageBucketCAFile)},
+		{Setting: boolSetting("DIGEST_STORAGE_BUCKET_INSECURE_SKIP_VERIFY", "Whether to skip verification of the certificate of the endpoint of the digest storage bucket", &c.StorageBucketInsecureSkipVerify)},
+		{Setting: stringSetting("DIGEST_STORAGE_BUCKET_SSE", "The server side encryption of digests, either AES256 or aws:kms", &c.StorageBucketSSE)},
+		{Setting: stringSetting("DIGEST_STORAGE_BUCKET_KMS_KEY_ID", "The ID or ARN of the KMS key used to encrypt digests", &c.StorageBucketKMSKeyID)},
+		{Setting: stringSetting("DIGEST_STORAGE_BUCKET_STORAGE_CLASS", "The S3 storage class of digests", &c.StorageBucketStorageClass)},
+		{Setting: tagsSetting("DIGEST_STORAGE_BUCKET_TAGS", "The tags of digests, as comma separated key=value pairs", &c.StorageBucketTags)},
+		{Setting: stringSetting("DIGEST_ENCRYPTION_KEY_FILE", "A file holding the AES-256 master key with which digests are encrypted", &c.EncryptionKeyFile)},
+		{Setting: stringSetting("DIGEST_ENCRYPTION_KMS_KEY_ID", "The KMS key under which the data keys of encrypted digests are generated", &c.EncryptionKMSKeyID)},
+		{Setting: stringSetting("DIGEST_PROGRESS_BUCKET", "The S3 bucket used to store digest progress states", &c.ProgressBucket)},
+		{Setting: stringSetting("DIGEST_PROGRESS_BUCKET_REGION", "The region of the digest progress bucket", &c.ProgressBucketRegion)},
+		{Setting: stringSetting("DIGEST_PROGRESS_BUCKET_ROLE", "The role to assume to access the digest progress bucket", &c.ProgressBucketRole)},
+		{Setting: stringSetting("DIGEST_PROGRESS_BUCKET_ENDPOINT", "The S3 compatible endpoint of the digest progress bucket", &c.ProgressBucketEndpoint)},
+		{Setting: boolSetting("DIGEST_PROGRESS_BUCKET_PATH_STYLE", "Whether to address the digest progress bucket by path rather than by host", &c.ProgressBucketPathStyle)},
+		{Setting: stringSetting("DIGEST_PROGRESS_BUCKET_CA_FILE", "A PEM file of the certificate authorities trusted by the endpoint of the digest progress bucket", &c.ProgressBucketCAFile)},
+		{Setting: boolSetting("DIGEST_PROGRESS_BUCKET_INSECURE_SKIP_VERIFY", "Whether to skip verification of the certificate of the endpoint of the digest progress bucket", &c.ProgressBucketInsecureSkipVerify)},
+		{Setting: stringSetting("DIGEST_PROGRESS_BUCKET_SSE", "The server side encryption of progress markers, either AES256 or aws:kms", &c.ProgressBucketSSE)},
+		{Setting: stringSetting("DIGEST_PROGRESS_BUCKET_KMS_KEY_ID", "The ID or ARN of the KMS key used to encrypt progress markers", &c.ProgressBucketKMSKeyID)},
+		{Setting: stringSetting("DIGEST_PROGRESS_BUCKET_STORAGE_CLASS", "The S3 storage class of progress markers", &c.ProgressBucketStorageClass)},
+		{Setting: tagsSetting("DIGEST_PROGRESS_BUCKET_TAGS", "The tags of progress markers, as comma separated key=value pairs", &c.ProgressBucketTags)},
+		{Setting: durationSetting("DIGEST_PROGRESS_TIMEOUT", "The time after which an in progress marker is considered invalid", &c.ProgressTimeout)},
+		{Setting: stringSetting("STREAM_APPLIANCE_ENDPOINT", "The endpoint to which digest jobs are queued", &c.StreamApplianceEndpoint)},
+		{Setting: stringSetting("STREAM_APPLIANCE_TOPIC", "The only topic from which the worker endpoint accepts jobs", &c.StreamApplianceTopic)},
+		{Setting: stringSetting("STREAM_APPLIANCE_TOKEN", "The bearer token the built-in Queuer presents to the stream appliance", &c.StreamApplianceToken), secret: true},
+		{Setting: stringSetting("DIGEST_WORKER_ADDRESS", "The listening address of the worker endpoint, if it is served apart from the API", &c.WorkerAddress)},
+		{Setting: stringSetting("DIGEST_WORKER_SECRET", "The secret the stream appliance presents to the worker endpoint", &c.WorkerSecret), secret: true},
+		{Setting: stringSetting("VPC_FLOW_LOGS_BUCKET", "The S3 bucket which holds VPC flow logs", &c.VPCFlowLogsBucket)},
+		{Setting: stringSetting("VPC_FLOW_LOGS_BUCKET_REGION", "The region of the VPC flow logs bucket", &c.VPCFlowLogsBucketRegion)},
+		{Setting: stringSetting("VPC_FLOW_LOGS_BUCKET_ROLE", "The role to assume to access the VPC flow logs bucket", &c.VPCFlowLogsBucketRole)},
+		{Setting: stringSetting("VPC_FLOW_LOGS_BUCKET_ENDPOINT", "The S3 compatible endpoint of the VPC flow logs bucket", &c.VPCFlowLogsBucketEndpoint)},
+		{Setting: boolSetting("VPC_FLOW_LOGS_BUCKET_PATH_STYLE", "Whether to address the VPC flow logs bucket by path rather than by host", &c.VPCFlowLogsBucketPathStyle)},
+		{Setting: stringSetting("VPC_FLOW_LOGS_BUCKET_CA_FILE", "A PEM file of the certificate authorities trusted by the endpoint of the VPC flow logs bucket", &c.VPCFlowLogsBucketCAFile)},
+		{Setting: boolSetting("VPC_FLOW_LOGS_BUCKET_INSECURE_SKIP_VERIFY", "Whether to skip verification of the certificate of the endpoint of the VPC flow logs bucket", &c.VPCFlowLogsBucketInsecureSkipVerify)},
+		{Setting: listSetting("VPC_FLOW_LOGS_SCAN_REGIONS", "The regions to scan for VPC flow logs", &c.ScanRegions)},
+		{Setting: listSetting("VPC_FLOW_LOGS_SCAN_ACCOUNTS", "The accounts to scan for VPC flow logs", &c.ScanAccounts)},
+		{Setting: int64Setting("VPC_MAX_BYTES_PREFETCH", "The maximum number of bytes of flow logs to prefetch", &c.MaxBytesPrefetch)},
+		{Setting: intSetting("VPC_MAX_CONCURRENT_PREFETCH", "The maximum number of flow log objects to prefetch", &c.MaxConcurrentPrefetch)},
+		{Setting: boolSetting("DIGEST_DOWNLOAD_REDIRECT", "Whether to redirect downloads to pre-signed URLs", &c.DownloadRedirect)},
+		{Setting: durationSetting("DIGEST_DOWNLOAD_REDIRECT_TTL", "The time for which pre-signed URLs are valid", &c.DownloadRedirectTTL)},
+		{Setting: durationSetting("DIGEST_MAX_WAIT", "The maximum time for which requests wait for a digest", &c.MaxWait)},
+		{Setting: intSetting("DIGEST_BATCH_MAX", "The maximum number of windows in a batch", &c.BatchMax)},
+		{Setting: intSetting("DIGEST_BATCH_CONCURRENCY", "The number of windows of a batch queued at once", &c.BatchConcurrency)},
+		{Setting: durationSetting("DIGEST_ESTIMATE_PER_HOUR", "The estimated time to digest an hour of flow logs", &c.EstimatePerHour)},
+		{Setting: boolSetting("DIGEST_LEGACY_ID_LOOKUP", "Whether to look up digests under their legacy IDs", &c.LegacyIDLookup)},
+		{Setting: durationSetting("DIGEST_WINDOW_MAX", "The maximum length of a digest window", &c.WindowMax)},
+		{Setting: durationSetting("DIGEST_WINDOW_MAX_LOOKBACK", "The maximum age of the start of a digest window", &c.WindowMaxLookback)},
+		{Setting: durationSetting("DIGEST_WINDOW_SETTLE_LAG", "The minimum age of the end of a digest window", &c.WindowSettleLag)},
+		{Setting: boolSetting("DIGEST_WINDOW_SPLIT", "Whether to split windows longer than the maximum", &c.WindowSplit)},
+		{Setting: intSetting("DIGEST_WINDOW_MAX_SPLIT", "The maximum number of digests a window is split into", &c.WindowMaxSplit)},
+		{Setting: durationSetting("DIGEST_RECONCILE_INTERVAL", "The time between checks for late flow logs", &c.ReconcileInterval)},
+		{Setting: durationSetting("DIGEST_RECONCILE_HORIZON", "The age of the windows checked for late flow logs", &c.ReconcileHorizon)},
+		{Setting: intSetting("DIGEST_RECONCILE_MAX_ATTEMPTS", "The number of times a stale digest is requeued before it is given up on", &c.ReconcileMaxAttempts)},
+		{Setting: listSetting("DIGEST_CALLBACK_ALLOWLIST", "The hosts to which notifications may be sent", &c.CallbackAllowlist)},
+		{Setting: stringSetting("DIGEST_CALLBACK_SECRET", "The secret used to sign notifications", &c.CallbackSecret), secret: true},
+		{Setting: stringSetting("DIGEST_AUTH_TOKENS_FILE", "A JSON file of the bearer tokens accepted by the API", &c.AuthTokensFile)},
+		{Setting: stringSetting("DIGEST_AUTH_HMAC_KEYS_FILE", "A JSON file of the keys with which API requests may be signed", &c.AuthHMACKeysFile)},
+		{Setting: durationSetting("DIGEST_AUTH_HMAC_MAX_SKEW", "The maximum age of a signed API request", &c.AuthHMACMaxSkew)},
+		{Setting: stringSetting("DIGEST_AUTH_JWKS_FILE", "A JSON Web Key Set file of the keys which sign the JWTs accepted by the API", &c.AuthJWKSFile)},
+		{Setting: stringSetting("DIGEST_AUTH_JWT_ISSUER", "The issuer of the JWTs accepted by the API", &c.AuthJWTIssuer)},
+		{Setting: stringSetting("DIGEST_AUTH_JWT_AUDIENCE", "The audience of the JWTs accepted by the API", &c.AuthJWTAudience)},
+		{Setting: intSetting("DIGEST_RATE_LIMIT", "The number of digests each client may request a minute", &c.RateLimit)},
+		{Setting: intSetting("DIGEST_RATE_LIMIT_BURST", "The number of digests each client may request at once", &c.RateLimitBurst)},
+		{Setting: tagsSetting("DIGEST_RATE_LIMIT_CLIENTS", "The rate limits of individual clients, as comma separated subject=rate or subject=rate:burst pairs", &c.RateLimitClients)},
+		{Setting: intSetting("DIGEST_MAX_IN_PROGRESS", "The maximum number of digests in progress at once", &c.MaxInProgress)},
+	}
+}
+
+func baseSetting(name string, description string) *settings.BaseSetting {
+	return &settings.BaseSetting{NameValue: name, DescriptionValue: description}
+}
+
+func stringSetting(name string, description string, value *string) settings.Setting {
+	return &settings.StringSetting{BaseSetting: baseSetting(name, description), StringValue: value}
+}
+
+func boolSetting(name string, description string, value *bool) settings.Setting {
+	return &settings.BoolSetting{BaseSetting: baseSetting(name, description), BoolValue: value}
+}
+
+func optionalBoolSetting(name string, description string, value **bool) settings.Setting {
+	return &unsetBoolSetting{BaseSetting: baseSetting(name, description), BoolValue: value}
+}
+
+func intSetting(name string, description string, value *int) settings.Setting {
+	return &settings.IntSetting{BaseSetting: baseSetting(name, description), IntValue: value}
+}
+
+func int64Setting(name string, description string, value *int64) settings.Setting {
+	return &settings.Int64Setting{BaseSetting: baseSetting(name, description), Int64Value: value}
+}
+
+func durationSetting(name string, description string, value *time.Duration) settings.Setting {
+	return &millisecondSetting{BaseSetting: baseSetting(name, description), DurationValue: value}
+}
+
+func listSetting(name string, description string, value *[]string) settings.Setting {
+	return &commaListSetting{BaseSetting: baseSetting(name, description), ListValue: value}
+}
+
+func tagsSetting(name string, description string, value *map[string]string) settings.Setting {
+	return &keyValueSetting{BaseSetting: baseSetting(name, description), MapValue: value}
+}
+
+// millisecondSetting manages a time.Duration which is given as a whole number of milliseconds, as the environment
+// variables of the service always have been, or as a duration string such as "30s"
+type millisecondSetting struct {
+	*settings.BaseSetting
+	DurationValue *time.Duration
+}
+
+// Value returns the underlying time.Duration
+func (s *millisecondSetting) Value() interface{} {
+	return *s.DurationValue
+}
+
+// SetValue changes the underlying time.Duration
+func (s *millisecondSetting) SetValue(v interface{}) error {
+	switch value := v.(type) {
+	case string:
+		if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+			*s.DurationValue = time.Duration(ms) * time.Millisecond
+			return nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*s.DurationValue = d
+	case int:
+		*s.DurationValue = time.Duration(value) * time.Millisecond
+	case int64:
+		*s.DurationValue = time.Duration(value) * time.Millisecond
+	case float64:
+		*s.DurationValue = time.Duration(value * float64(time.Millisecond))
+	default:
+		return fmt.Errorf("%v is not a number of milliseconds or a duration", v)
+	}
+	return nil
+}
+
+// commaListSetting manages a []string which is given as a comma separated string, or as an array. Empty elements
+// are ignored.
+type commaListSetting struct {
+	*settings.BaseSetting
+	ListValue *[]string
+}
+
+// Value returns the underlying []string
+func (s *commaListSetting) Value() interface{} {
+	return *s.ListValue
+}
+
+// SetValue changes the underlying []string
+func (s *commaListSetting) SetValue(v interface{}) error {
+	var values []string
+	switch value := v.(type) {
+	case string:
+		values = strings.Split(value, ",")
+	case []interface{}:
+		for _, element := range value {
+			str, ok := element.(string)
+			if !ok {
+				return fmt.Errorf("%v is not a string", element)
+			}
+			values = append(values, str)
+		}
+	default:
+		return fmt.Errorf("%v is not a comma separated list", v)
+	}
+	for i, value := range values {
+		values[i] = strings.TrimSpace(value)
+	}
+	*s.ListValue = filterSlice(values)
+	return nil
+}
+
+// unsetBoolSetting manages a *bool which is nil until the setting is given, so that a required bool may be told
+// apart from one set to false
+type unsetBoolSetting struct {
+	*settings.BaseSetting
+	BoolValue **bool
+}
+
+// Value returns the underlying bool, or an empty string if it is not set
+func (s *unsetBoolSetting) Value() interface{} {
+	if *s.BoolValue == nil {
+		return ""
+	}
+	return **s.BoolValue
+}
+
+// SetValue changes the underlying bool
+func (s *unsetBoolSetting) SetValue(v interface{}) error {
+	var value bool
+	if err := boolSetting(s.Name(), s.Description(), &value).SetValue(v); err != nil {
+		return err
+	}
+	*s.BoolValue = &value
+	return nil
+}
+
+// keyValueSetting manages a map[string]string which is given as comma separated key=value pairs, or as a map
+type keyValueSetting struct {
+	*settings.BaseSetting
+	MapValue *map[string]string
+}
+
+// Value returns the underlying map[string]string
+func (s *keyValueSetting) Value() interface{} {
+	return *s.MapValue
+}
+
+// SetValue changes the underlying map[string]string
+func (s *keyValueSetting) SetValue(v interface{}) error {
+	tags := make(map[string]string)
+	switch value := v.(type) {
+	case string:
+		for _, pair := range filterSlice(strings.Split(value, ",")) {
+			parts := strings.SplitN(pair, "=", 2)
+			key := strings.TrimSpace(parts[0])
+			if len(parts) != 2 || key == "" {
+				return fmt.Errorf("%q is not a key=value pair", pair)
+			}
+			tags[key] = strings.TrimSpace(parts[1])
+		}
+	case map[string]interface{}:
+		for k, element := range value {
+			tags[k] = fmt.Sprint(element)
+		}
+	default:
+		return fmt.Errorf("%v is not a comma separated list of key=value pairs", v)
+	}
+	*s.MapValue = tags
+	return nil
+}
+
+// formatSetting renders the value of a setting in a form from which it can be loaded
+func formatSetting(v interface{}) string {
+	switch value := v.(type) {
+	case time.Duration:
+		return strconv.FormatInt(int64(value/time.Millisecond), 10)
+	case []string:
+		return strings.Join(value, ",")
+	case map[string]string:
+		pairs := make([]string, 0, len(value))
+		for k, v := range value {
+			pairs = append(pairs, k+"="+v)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ",")
+	default:
+		return fmt.Sprint(value)
+	}
+}
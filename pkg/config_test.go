@@ -0,0 +1,360 @@
+package digesterd
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/go-vpcflow"
+	"github.com/asecurityteam/settings"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/auth"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/callback"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/storage"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/stream"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := LoadConfig(context.Background(), settings.NewMapSource(map[string]interface{}{}))
+	require.Nil(t, err)
+	assert.Equal(t, NewConfig(), cfg)
+	assert.True(t, cfg.LegacyIDLookup)
+	assert.Nil(t, cfg.UseIAM)
+	assert.Equal(t, time.Hour, cfg.ReconcileHorizon)
+	assert.Equal(t, types.WindowPolicy{}, cfg.windowPolicy())
+}
+
+func TestLoadConfigFromEnvironment(t *testing.T) {
+	source, err := NewConfigSource([]string{
+		"USE_IAM=true",
+		"DIGEST_STORAGE_BUCKET=storage",
+		"DIGEST_STORAGE_BUCKET_REGION=us-west-2",
+		"DIGEST_PROGRESS_TIMEOUT=10000",
+		"DIGEST_MAX_WAIT=30s",
+		"DIGEST_LEGACY_ID_LOOKUP=",
+		"VPC_MAX_BYTES_PREFETCH=1024",
+		"VPC_FLOW_LOGS_SCAN_REGIONS=us-west-2, us-east-1,",
+		"DIGEST_WINDOW_MAX=3600000",
+		"DIGEST_WINDOW_MAX_LOOKBACK=86400000",
+		"DIGEST_WINDOW_SETTLE_LAG=600000",
+		"DIGEST_WINDOW_SPLIT=true",
+		"DIGEST_WINDOW_MAX_SPLIT=24",
+		"DIGEST_STORAGE_BUCKET_KMS_KEY_ID=alias/digests",
+		"DIGEST_STORAGE_BUCKET_TAGS=team=security, cost-center=42",
+	})
+	require.Nil(t, err)
+	cfg, err := LoadConfig(context.Background(), source)
+	require.Nil(t, err)
+	require.NotNil(t, cfg.UseIAM)
+	assert.True(t, *cfg.UseIAM)
+	assert.Equal(t, "storage", cfg.StorageBucket)
+	assert.Equal(t, "us-west-2", cfg.StorageBucketRegion)
+	assert.Equal(t, 10*time.Second, cfg.ProgressTimeout)
+	assert.Equal(t, 30*time.Second, cfg.MaxWait)
+	assert.True(t, cfg.LegacyIDLookup, "empty variables should be ignored")
+	assert.Equal(t, int64(1024), cfg.MaxBytesPrefetch)
+	assert.Equal(t, []string{"us-west-2", "us-east-1"}, cfg.ScanRegions)
+	assert.Equal(t, storage.ObjectOptions{
+		KMSKeyID: "alias/digests",
+		Tags:     map[string]string{"team": "security", "cost-center": "42"},
+	}, cfg.storageObjects())
+	assert.Equal(t, types.WindowPolicy{
+		MaxWindow:   time.Hour,
+		MaxLookback: 24 * time.Hour,
+		SettleLag:   10 * time.Minute,
+		Split:       true,
+		MaxSplit:    24,
+	}, cfg.windowPolicy())
+}
+
+func TestLoadConfigFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	contents := []byte(`
+digest_storage_bucket: storage
+digest_storage_bucket_region: us-west-2
+digest_progress_timeout: 10000
+digest_max_wait: 30s
+vpc_flow_logs_scan_accounts:
+  - "123456789012"
+digest_progress_bucket_tags:
+  team: security
+`)
+	require.Nil(t, ioutil.WriteFile(path, contents, 0600))
+
+	source, err := NewConfigSource([]string{
+		"DIGEST_CONFIG_FILE=" + path,
+		"DIGEST_STORAGE_BUCKET_REGION=us-east-1",
+	})
+	require.Nil(t, err)
+	cfg, err := LoadConfig(context.Background(), source)
+	require.Nil(t, err)
+	assert.Equal(t, "storage", cfg.StorageBucket)
+	assert.Equal(t, "us-east-1", cfg.StorageBucketRegion, "the environment should take precedence over the file")
+	assert.Equal(t, 10*time.Second, cfg.ProgressTimeout)
+	assert.Equal(t, 30*time.Second, cfg.MaxWait)
+	assert.Equal(t, []string{"123456789012"}, cfg.ScanAccounts)
+	assert.Equal(t, map[string]string{"team": "security"}, cfg.ProgressBucketTags)
+
+	_, err = NewConfigSource([]string{"DIGEST_CONFIG_FILE=" + filepath.Join(dir, "missing.yaml")})
+	assert.NotNil(t, err)
+}
+
+func TestLoadConfigReportsAllProblems(t *testing.T) {
+	source := settings.NewMapSource(map[string]interface{}{
+		"USE_IAM":                    "sometimes",
+		"DIGEST_PROGRESS_TIMEOUT":    "soon",
+		"VPC_MAX_BYTES_PREFETCH":     "lots",
+		"DIGEST_STORAGE_BUCKET":      "storage",
+		"VPC_FLOW_LOGS_SCAN_REGIONS": 1,
+		"DIGEST_STORAGE_BUCKET_TAGS": "team",
+	})
+	cfg, err := LoadConfig(context.Background(), source)
+	require.NotNil(t, err)
+	configErr, ok := err.(ConfigError)
+	require.True(t, ok)
+	assert.Len(t, configErr.Problems, 5)
+	assert.Equal(t, "storage", cfg.StorageBucket, "valid settings should still be loaded")
+}
+
+// countlessMarker is a Marker which cannot count the digests in progress
+type countlessMarker struct {
+	types.Marker
+}
+
+// statslessDigester is a Digester which does not report the source data it read
+type statslessDigester struct {
+	vpcflow.Digester
+}
+
+func TestConfigValidate(t *testing.T) {
+	tc := []struct {
+		Name     string
+		Service  *Service
+		Config   func(*Config)
+		Problems []string
+	}{
+		{
+			Name:    "valid",
+			Service: &Service{},
+			Config:  func(*Config) {},
+		},
+		{
+			Name:    "missing",
+			Service: &Service{},
+			Config: func(c *Config) {
+				c.UseIAM = nil
+				c.StorageBucket = ""
+				c.ProgressTimeout = 0
+				c.CallbackAllowlist = []string{"example.com"}
+			},
+			Problems: []string{
+				"USE_IAM is required",
+				"DIGEST_PROGRESS_TIMEOUT should be greater than zero",
+				"DIGEST_STORAGE_BUCKET is required",
+				"DIGEST_CALLBACK_SECRET is required",
+			},
+		},
+		{
+			Name:    "provided modules",
+			Service: &Service{Queuer: &stream.DigestQueuer{}, Storage: &storage.S3{}, Marker: &storage.ProgressMarker{}, Notifier: &callback.HTTPNotifier{}},
+			Config: func(c *Config) {
+				c.StorageBucket = ""
+				c.ProgressBucket = ""
+				c.ProgressTimeout = 0
+				c.StreamApplianceEndpoint = ""
+				c.CallbackAllowlist = []string{"example.com"}
+			},
+		},
+		{
+			Name:    "provided flow logs",
+			Service: &Service{DigesterProvider: newDigester("", nil, 0, 0, nil, nil)},
+			Config: func(c *Config) {
+				c.VPCFlowLogsBucket = ""
+				c.VPCFlowLogsBucketRegion = ""
+				c.MaxBytesPrefetch = 0
+				c.MaxConcurrentPrefetch = 0
+			},
+		},
+		{
+			Name:    "reconciled flow logs",
+			Service: &Service{DigesterProvider: newDigester("", nil, 0, 0, nil, nil), DigestersReportStats: true},
+			Config: func(c *Config) {
+				c.VPCFlowLogsBucket = ""
+				c.ReconcileInterval = time.Minute
+			},
+			Problems: []string{"VPC_FLOW_LOGS_BUCKET is required"},
+		},
+		{
+			Name:    "bucket endpoints",
+			Service: &Service{},
+			Config: func(c *Config) {
+				c.StorageBucketEndpoint = "http://localhost:9000"
+				c.StorageBucketPathStyle = true
+				c.ProgressBucketEndpoint = "localhost:9000"
+				c.VPCFlowLogsBucketCAFile = "missing.pem"
+			},
+			Problems: []string{
+				"DIGEST_PROGRESS_BUCKET_ENDPOINT should be an absolute http or https URL",
+				"VPC_FLOW_LOGS_BUCKET_CA_FILE is not valid: stat missing.pem: no such file or directory",
+			},
+		},
+		{
+			Name:    "object options",
+			Service: &Service{},
+			Config: func(c *Config) {
+				c.StorageBucketSSE = "aws:kms"
+				c.StorageBucketKMSKeyID = "alias/digests"
+				c.ProgressBucketSSE = "AES256"
+				c.ProgressBucketKMSKeyID = "alias/progress"
+			},
+			Problems: []string{"DIGEST_PROGRESS_BUCKET_KMS_KEY_ID is only used with DIGEST_PROGRESS_BUCKET_SSE of aws:kms"},
+		},
+		{
+			Name:    "unknown encryption",
+			Service: &Service{},
+			Config: func(c *Config) {
+				c.StorageBucketSSE = "rot13"
+			},
+			Problems: []string{"DIGEST_STORAGE_BUCKET_SSE should be AES256 or aws:kms"},
+		},
+		{
+			Name:    "encryption",
+			Service: &Service{},
+			Config: func(c *Config) {
+				c.EncryptionKMSKeyID = "alias/digests"
+				c.EncryptionKeyFile = "missing.key"
+			},
+			Problems: []string{
+				"only one of DIGEST_ENCRYPTION_KEY_FILE and DIGEST_ENCRYPTION_KMS_KEY_ID should be set",
+				"DIGEST_ENCRYPTION_KEY_FILE is not valid: open missing.key: no such file or directory",
+			},
+		},
+		{
+			Name:    "authentication",
+			Service: &Service{},
+			Config: func(c *Config) {
+				c.AuthTokensFile = "/does/not/exist.json"
+				c.AuthJWTAudience = "vpcflow-digesterd"
+				c.AuthHMACMaxSkew = -time.Second
+			},
+			Problems: []string{
+				"DIGEST_AUTH_TOKENS_FILE is not valid: open /does/not/exist.json: no such file or directory",
+				"DIGEST_AUTH_JWT_ISSUER and DIGEST_AUTH_JWT_AUDIENCE are only used with DIGEST_AUTH_JWKS_FILE",
+				"DIGEST_AUTH_HMAC_MAX_SKEW should not be negative",
+			},
+		},
+		{
+			Name:    "provided authenticator",
+			Service: &Service{Authenticator: auth.StaticTokens{}},
+			Config: func(c *Config) {
+				c.AuthTokensFile = "/does/not/exist.json"
+			},
+		},
+		{
+			Name:    "rate limits",
+			Service: &Service{},
+			Config: func(c *Config) {
+				c.RateLimit = 10
+				c.RateLimitBurst = -1
+				c.RateLimitClients = map[string]string{"batch": "60:many"}
+				c.MaxInProgress = 100
+			},
+			Problems: []string{
+				"DIGEST_RATE_LIMIT_CLIENTS is not valid: the burst of batch, \"many\", is not a non negative integer",
+				"DIGEST_RATE_LIMIT_BURST should not be negative",
+			},
+		},
+		{
+			Name:    "provided marker cannot count",
+			Service: &Service{Marker: countlessMarker{}},
+			Config: func(c *Config) {
+				c.MaxInProgress = 100
+			},
+			Problems: []string{"DIGEST_MAX_IN_PROGRESS requires a Marker which can count the digests in progress"},
+		},
+		{
+			Name: "provided digesters cannot report stats",
+			Service: &Service{
+				DigesterProvider:  func(start, stop time.Time) vpcflow.Digester { return statslessDigester{} },
+				WatermarkProvider: func(context.Context, time.Time, time.Time) (types.Watermark, error) { return types.Watermark{}, nil },
+			},
+			Config: func(c *Config) {
+				c.ReconcileInterval = time.Minute
+			},
+			Problems: []string{"DIGEST_RECONCILE_INTERVAL requires a DigesterProvider whose digesters report the source data they read, as declared by DigestersReportStats"},
+		},
+		{
+			Name:    "out of range",
+			Service: &Service{},
+			Config: func(c *Config) {
+				c.MaxWait = -time.Second
+				c.BatchMax = -1
+				c.ReconcileHorizon = 0
+			},
+			Problems: []string{
+				"DIGEST_MAX_WAIT should not be negative",
+				"DIGEST_BATCH_MAX should not be negative",
+				"DIGEST_RECONCILE_HORIZON should be greater than zero",
+			},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.UseIAM = aws.Bool(false)
+			cfg.StorageBucket = "storage"
+			cfg.StorageBucketRegion = "us-west-2"
+			cfg.ProgressBucket = "progress"
+			cfg.ProgressBucketRegion = "us-west-2"
+			cfg.ProgressTimeout = time.Second
+			cfg.StreamApplianceEndpoint = "http://localhost"
+			cfg.VPCFlowLogsBucket = "flowlogs"
+			cfg.VPCFlowLogsBucketRegion = "us-west-2"
+			cfg.MaxBytesPrefetch = 1
+			cfg.MaxConcurrentPrefetch = 1
+			tt.Config(cfg)
+			assert.Equal(t, tt.Problems, cfg.validate(tt.Service))
+		})
+	}
+}
+
+func TestConfigPrint(t *testing.T) {
+	cfg := NewConfig()
+	cfg.StorageBucket = "storage"
+	cfg.ProgressTimeout = 10 * time.Second
+	cfg.CallbackAllowlist = []string{"a.example.com", "b.example.com"}
+	cfg.CallbackSecret = "hunter2"
+	cfg.StorageBucketTags = map[string]string{"team": "security", "cost-center": "42"}
+	var buf bytes.Buffer
+	require.Nil(t, cfg.Print(&buf))
+	out := buf.String()
+	assert.Contains(t, out, "DIGEST_STORAGE_BUCKET=storage\n")
+	assert.Contains(t, out, "DIGEST_PROGRESS_TIMEOUT=10000\n")
+	assert.Contains(t, out, "DIGEST_CALLBACK_ALLOWLIST=a.example.com,b.example.com\n")
+	assert.Contains(t, out, "DIGEST_CALLBACK_SECRET=REDACTED\n")
+	assert.Contains(t, out, "DIGEST_STORAGE_BUCKET_TAGS=cost-center=42,team=security\n")
+	assert.NotContains(t, out, "hunter2")
+	assert.Contains(t, out, "DIGEST_LEGACY_ID_LOOKUP=true\n")
+
+	// the printed settings can be loaded again
+	var environ []string
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		environ = append(environ, string(line))
+	}
+	source, err := NewConfigSource(environ)
+	require.Nil(t, err)
+	loaded, err := LoadConfig(context.Background(), source)
+	require.Nil(t, err)
+	cfg.CallbackSecret = redacted
+	assert.Equal(t, cfg, loaded)
+}
@@ -0,0 +1,123 @@
+package digesterd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/asecurityteam/go-vpcflow"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// watermarkClient decorates the S3 client used to list flow log objects and records the most recent
+// modification time of the objects listed
+type watermarkClient struct {
+	s3iface.S3API
+	lock         sync.Mutex
+	lastModified time.Time
+}
+
+func (c *watermarkClient) ListObjectsV2(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	res, err := c.S3API.ListObjectsV2(input)
+	if err != nil {
+		return res, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var watermark types.Watermark
+	observe(&watermark, res.Contents)
+	if watermark.LastModified.After(c.lastModified) {
+		c.lastModified = watermark.LastModified
+	}
+	return res, nil
+}
+
+func (c *watermarkClient) LastModified() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lastModified
+}
+
+// observe updates the watermark with the given objects. Empty objects are ignored, since they are
+// also skipped when creating the digest.
+func observe(watermark *types.Watermark, objects []*s3.Object) {
+	for _, object := range objects {
+		if aws.Int64Value(object.Size) == 0 {
+			continue
+		}
+		watermark.Objects++
+		if lastModified := aws.TimeValue(object.LastModified); lastModified.After(watermark.LastModified) {
+			watermark.LastModified = lastModified
+		}
+	}
+}
+
+// newWatermarker returns a WatermarkProvider which lists the same flow log objects that a digest for the
+// window would be created from
+func newWatermarker(bucket string, client s3iface.S3API, regions []string, accounts []string) types.WatermarkProvider {
+	return func(ctx context.Context, start, _ time.Time) (types.Watermark, error) {
+		var watermark types.Watermark
+		input := &s3.ListObjectsV2Input{
+			Bucket: aws.String(bucket),
+			Prefix: aws.String(makePrefix(regions, accounts, start)),
+		}
+		err := client.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, _ bool) bool {
+			observe(&watermark, page.Contents)
+			return true
+		})
+		return watermark, err
+	}
+}
+
+// countingIterator decorates a BucketIterator and counts the log files it yields. The prefetch policy
+// iterates from a separate goroutine, so counts are updated atomically.
+type countingIterator struct {
+	vpcflow.BucketIterator
+	objects int64
+	bytes   int64
+}
+
+func (i *countingIterator) Iterate() bool {
+	if !i.BucketIterator.Iterate() {
+		return false
+	}
+	atomic.AddInt64(&i.objects, 1)
+	atomic.AddInt64(&i.bytes, i.BucketIterator.Current().Size)
+	return true
+}
+
+// countingReader decorates a ReadCloser and counts the newline delimited records read through it
+type countingReader struct {
+	io.ReadCloser
+	records int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	atomic.AddInt64(&r.records, int64(bytes.Count(b[:n], []byte{'\n'})))
+	return n, err
+}
+
+// statsDigester decorates a Digester with statistics about the source data it consumed
+type statsDigester struct {
+	vpcflow.Digester
+	client   *watermarkClient
+	iterator *countingIterator
+	reader   *countingReader
+}
+
+// Stats reports on the source data consumed by the digester
+func (d *statsDigester) Stats() types.DigestStats {
+	return types.DigestStats{
+		SourceObjects: atomic.LoadInt64(&d.iterator.objects),
+		SourceBytes:   atomic.LoadInt64(&d.iterator.bytes),
+		Records:       atomic.LoadInt64(&d.reader.records),
+		LastModified:  d.client.LastModified(),
+	}
+}
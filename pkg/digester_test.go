@@ -0,0 +1,110 @@
+package digesterd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/go-vpcflow"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+type sliceIterator struct {
+	files []vpcflow.LogFile
+	pos   int
+}
+
+func (i *sliceIterator) Iterate() bool {
+	i.pos++
+	return i.pos <= len(i.files)
+}
+
+func (i *sliceIterator) Current() vpcflow.LogFile {
+	return i.files[i.pos-1]
+}
+
+func (i *sliceIterator) Close() error {
+	return nil
+}
+
+func TestStatsDigester(t *testing.T) {
+	iterator := &countingIterator{
+		BucketIterator: &sliceIterator{files: []vpcflow.LogFile{{Size: 10}, {Size: 20}}},
+	}
+	for iterator.Iterate() {
+	}
+	reader := &countingReader{
+		ReadCloser: ioutil.NopCloser(bytes.NewReader([]byte("one\ntwo\nthree\n"))),
+	}
+	_, _ = ioutil.ReadAll(reader)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lastModified := time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC)
+	mockS3Client := NewMockS3API(ctrl)
+	mockS3Client.EXPECT().ListObjectsV2(gomock.Any()).Return(&s3.ListObjectsV2Output{
+		Contents: []*s3.Object{
+			{Size: aws.Int64(10), LastModified: aws.Time(lastModified.Add(-time.Minute))},
+			{Size: aws.Int64(20), LastModified: aws.Time(lastModified)},
+			{Size: aws.Int64(0), LastModified: aws.Time(lastModified.Add(time.Minute))},
+		},
+	}, nil)
+	client := &watermarkClient{S3API: mockS3Client}
+	_, _ = client.ListObjectsV2(&s3.ListObjectsV2Input{})
+
+	digester := &statsDigester{client: client, iterator: iterator, reader: reader}
+	expected := types.DigestStats{SourceObjects: 2, SourceBytes: 30, Records: 3, LastModified: lastModified}
+	assert.Equal(t, expected, digester.Stats())
+}
+
+func TestWatermarker(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	start := time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC)
+	lastModified := start.Add(time.Hour)
+	pages := []*s3.ListObjectsV2Output{
+		{Contents: []*s3.Object{{Size: aws.Int64(10), LastModified: aws.Time(start)}}},
+		{Contents: []*s3.Object{
+			{Size: aws.Int64(10), LastModified: aws.Time(lastModified)},
+			{Size: aws.Int64(0), LastModified: aws.Time(lastModified.Add(time.Minute))},
+		}},
+	}
+	mockS3Client := NewMockS3API(ctrl)
+	mockS3Client.EXPECT().ListObjectsV2PagesWithContext(gomock.Any(), &s3.ListObjectsV2Input{
+		Bucket: aws.String("bucket"),
+		Prefix: aws.String(makePrefix([]string{"us-west-2"}, []string{"123"}, start)),
+	}, gomock.Any()).DoAndReturn(func(_ context.Context, _ *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+		for i, page := range pages {
+			if !fn(page, i == len(pages)-1) {
+				break
+			}
+		}
+		return nil
+	})
+
+	watermarker := newWatermarker("bucket", mockS3Client, []string{"us-west-2"}, []string{"123"})
+	watermark, err := watermarker(context.Background(), start, start.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, types.Watermark{Objects: 2, LastModified: lastModified}, watermark)
+}
+
+func TestWatermarkerError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3Client := NewMockS3API(ctrl)
+	mockS3Client.EXPECT().ListObjectsV2PagesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("oops"))
+
+	watermarker := newWatermarker("bucket", mockS3Client, nil, nil)
+	_, err := watermarker(context.Background(), time.Now(), time.Now())
+	assert.NotNil(t, err)
+}
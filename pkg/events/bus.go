@@ -0,0 +1,123 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+)
+
+const (
+	defaultHistory = 1000
+	defaultBuffer  = 64
+)
+
+// Bus is an in-process implementation of types.Publisher and types.Subscriber. Only events published by this
+// instance of the service are delivered to its subscribers.
+//
+// Event IDs are of the form <epoch>-<sequence>, where the epoch identifies the Bus. Subscribers reconnecting
+// with the ID of an event from another Bus, such as one from before the service restarted, are sent all
+// retained events.
+type Bus struct {
+	// History is the number of recent events retained for subscribers which reconnect. Defaults to 1000.
+	History int
+	// Buffer is the number of events which may be waiting to be delivered to a subscriber before it is
+	// considered too far behind and its channel is closed. Defaults to 64.
+	Buffer int
+
+	lock        sync.Mutex
+	epoch       string
+	seq         uint64
+	history     []types.Event
+	subscribers map[chan types.Event]struct{}
+}
+
+// Publish assigns the event an ID, retains it, and delivers it to all subscribers
+func (b *Bus) Publish(ctx context.Context, e types.Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.init()
+	b.seq++
+	e.ID = b.epoch + "-" + strconv.FormatUint(b.seq, 10)
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	b.history = append(b.history, e)
+	if len(b.history) > b.historySize() {
+		b.history = append([]types.Event(nil), b.history[len(b.history)-b.historySize():]...)
+	}
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- e:
+		default:
+			close(subscriber)
+			delete(b.subscribers, subscriber)
+		}
+	}
+}
+
+// Subscribe returns the retained events published after the event with lastEventID, followed by a channel
+// of events published from now on
+func (b *Bus) Subscribe(lastEventID string) ([]types.Event, <-chan types.Event, func()) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.init()
+	replay := b.replay(lastEventID)
+	buffer := b.Buffer
+	if buffer == 0 {
+		buffer = defaultBuffer
+	}
+	subscriber := make(chan types.Event, buffer)
+	b.subscribers[subscriber] = struct{}{}
+	cancel := func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		if _, ok := b.subscribers[subscriber]; ok {
+			close(subscriber)
+			delete(b.subscribers, subscriber)
+		}
+	}
+	return replay, subscriber, cancel
+}
+
+func (b *Bus) replay(lastEventID string) []types.Event {
+	if lastEventID == "" {
+		return nil
+	}
+	parts := strings.SplitN(lastEventID, "-", 2)
+	if len(parts) == 2 && parts[0] == b.epoch {
+		if seq, err := strconv.ParseUint(parts[1], 10, 64); err == nil {
+			var replay []types.Event
+			for _, e := range b.history {
+				if eventSeq(e) > seq {
+					replay = append(replay, e)
+				}
+			}
+			return replay
+		}
+	}
+	return append([]types.Event(nil), b.history...)
+}
+
+func (b *Bus) init() {
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan types.Event]struct{})
+		b.epoch = fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+}
+
+func (b *Bus) historySize() int {
+	if b.History == 0 {
+		return defaultHistory
+	}
+	return b.History
+}
+
+func eventSeq(e types.Event) uint64 {
+	seq, _ := strconv.ParseUint(e.ID[strings.Index(e.ID, "-")+1:], 10, 64)
+	return seq
+}
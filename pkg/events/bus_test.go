@@ -0,0 +1,76 @@
+package events
+
+import (
+	"context"
+	"testing"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBusDeliversToSubscribers(t *testing.T) {
+	b := &Bus{}
+	replay, events, cancel := b.Subscribe("")
+	defer cancel()
+	assert.Empty(t, replay)
+
+	b.Publish(context.Background(), types.Event{Type: types.EventQueued, DigestID: "digest"})
+	e := <-events
+	assert.Equal(t, types.EventQueued, e.Type)
+	assert.Equal(t, "digest", e.DigestID)
+	assert.NotEmpty(t, e.ID)
+	assert.False(t, e.Time.IsZero())
+}
+
+func TestBusReplaysMissedEvents(t *testing.T) {
+	b := &Bus{}
+	_, events, cancel := b.Subscribe("")
+	b.Publish(context.Background(), types.Event{Type: types.EventQueued, DigestID: "1"})
+	b.Publish(context.Background(), types.Event{Type: types.EventQueued, DigestID: "2"})
+	b.Publish(context.Background(), types.Event{Type: types.EventQueued, DigestID: "3"})
+	first := <-events
+	cancel()
+
+	replay, _, cancel := b.Subscribe(first.ID)
+	defer cancel()
+	assert.Len(t, replay, 2)
+	assert.Equal(t, "2", replay[0].DigestID)
+	assert.Equal(t, "3", replay[1].DigestID)
+}
+
+func TestBusReplaysAllForUnknownID(t *testing.T) {
+	b := &Bus{History: 2}
+	b.Publish(context.Background(), types.Event{Type: types.EventQueued, DigestID: "1"})
+	b.Publish(context.Background(), types.Event{Type: types.EventQueued, DigestID: "2"})
+	b.Publish(context.Background(), types.Event{Type: types.EventQueued, DigestID: "3"})
+
+	replay, _, cancel := b.Subscribe("0-42")
+	defer cancel()
+	assert.Len(t, replay, 2)
+	assert.Equal(t, "2", replay[0].DigestID)
+	assert.Equal(t, "3", replay[1].DigestID)
+}
+
+func TestBusDropsSlowSubscribers(t *testing.T) {
+	b := &Bus{Buffer: 1}
+	_, events, cancel := b.Subscribe("")
+	defer cancel()
+
+	b.Publish(context.Background(), types.Event{Type: types.EventQueued, DigestID: "1"})
+	b.Publish(context.Background(), types.Event{Type: types.EventQueued, DigestID: "2"})
+	e, ok := <-events
+	assert.True(t, ok)
+	assert.Equal(t, "1", e.DigestID)
+	_, ok = <-events
+	assert.False(t, ok)
+}
+
+func TestBusCancel(t *testing.T) {
+	b := &Bus{}
+	_, events, cancel := b.Subscribe("")
+	cancel()
+	cancel()
+	_, ok := <-events
+	assert.False(t, ok)
+	b.Publish(context.Background(), types.Event{Type: types.EventQueued, DigestID: "1"})
+}
@@ -0,0 +1,3 @@
+// Package events contains the in-process event bus which carries changes to the state of digest jobs to
+// subscribers, such as the Server-Sent Events stream.
+package events
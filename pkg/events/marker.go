@@ -0,0 +1,42 @@
+package events
+
+import (
+	"context"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+)
+
+// Marker is an implementation of types.Marker which decorates another Marker, publishing an event whenever a
+// digest is successfully marked or unmarked
+type Marker struct {
+	types.Marker
+	Publisher types.Publisher
+}
+
+// Mark flags the digest identified by key as being "in progress", and publishes a types.EventMarked event
+func (m *Marker) Mark(ctx context.Context, key string) error {
+	if err := m.Marker.Mark(ctx, key); err != nil {
+		return err
+	}
+	m.Publisher.Publish(ctx, types.Event{Type: types.EventMarked, DigestID: key})
+	return nil
+}
+
+// Unmark flags the digest identified by key as not being "in progress", and publishes a types.EventUnmarked
+// event
+func (m *Marker) Unmark(ctx context.Context, key string) error {
+	if err := m.Marker.Unmark(ctx, key); err != nil {
+		return err
+	}
+	m.Publisher.Publish(ctx, types.Event{Type: types.EventUnmarked, DigestID: key})
+	return nil
+}
+
+// WaitUnmarked waits for the digest identified by key to be unmarked, if the decorated Marker supports it.
+// Otherwise it returns immediately.
+func (m *Marker) WaitUnmarked(ctx context.Context, key string) error {
+	if watcher, ok := m.Marker.(types.MarkerWatcher); ok {
+		return watcher.WaitUnmarked(ctx, key)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package events
+
+import (
+	"context"
+	"testing"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// setMarker is a Marker which records the digests in progress
+type setMarker map[string]bool
+
+func (s setMarker) Mark(ctx context.Context, key string) error {
+	s[key] = true
+	return nil
+}
+
+func (s setMarker) Unmark(ctx context.Context, key string) error {
+	delete(s, key)
+	return nil
+}
+
+func TestMarkerPublishes(t *testing.T) {
+	b := &Bus{}
+	_, events, cancel := b.Subscribe("")
+	defer cancel()
+	marked := setMarker{}
+	m := &Marker{Marker: marked, Publisher: b}
+
+	assert.Nil(t, m.Mark(context.Background(), "digest"))
+	assert.True(t, marked["digest"])
+	e := <-events
+	assert.Equal(t, types.EventMarked, e.Type)
+	assert.Equal(t, "digest", e.DigestID)
+
+	assert.Nil(t, m.Unmark(context.Background(), "digest"))
+	assert.False(t, marked["digest"])
+	e = <-events
+	assert.Equal(t, types.EventUnmarked, e.Type)
+
+	assert.Nil(t, m.WaitUnmarked(context.Background(), "digest"))
+}
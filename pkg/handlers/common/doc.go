@@ -0,0 +1,3 @@
+// Package common contains the parts of the HTTP API which are the same in every version: problem details
+// responses, the parsing of shared query parameters, and the queuing of digest jobs.
+package common
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+)
+
+const (
+	// DefaultListLimit is the number of digests listed on a page if no limit is given
+	DefaultListLimit = 100
+	// MaxListLimit is the largest number of digests which may be listed on a page
+	MaxListLimit = 1000
+)
+
+// ExtractListOptions extracts the optional start, stop, limit, and token query parameters used to
+// filter and paginate the digest listing
+func ExtractListOptions(r *http.Request) (types.ListOptions, error) {
+	q := r.URL.Query()
+	options := types.ListOptions{
+		Limit: DefaultListLimit,
+		Token: q.Get("token"),
+	}
+	var err error
+	if start := q.Get("start"); start != "" {
+		if options.Start, err = time.Parse(time.RFC3339Nano, start); err != nil {
+			return types.ListOptions{}, err
+		}
+	}
+	if stop := q.Get("stop"); stop != "" {
+		if options.Stop, err = time.Parse(time.RFC3339Nano, stop); err != nil {
+			return types.ListOptions{}, err
+		}
+	}
+	if !options.Start.IsZero() && !options.Stop.IsZero() && options.Start.After(options.Stop) {
+		return types.ListOptions{}, errors.New("start should be before stop")
+	}
+	if limit := q.Get("limit"); limit != "" {
+		if options.Limit, err = strconv.Atoi(limit); err != nil {
+			return types.ListOptions{}, err
+		}
+		if options.Limit < 1 || options.Limit > MaxListLimit {
+			return types.ListOptions{}, fmt.Errorf("limit should be between 1 and %d", MaxListLimit)
+		}
+	}
+	return options, nil
+}
+
+// FormatTime renders t as an RFC3339Nano timestamp in UTC, or an empty string if t is not set
+func FormatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// DurationMS renders d in whole milliseconds, as recorded in the audit log
+func DurationMS(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
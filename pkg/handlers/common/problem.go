@@ -0,0 +1,109 @@
+package common
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/ratelimit"
+	"github.com/go-chi/chi/middleware"
+)
+
+const (
+	// ProblemContentType is the content type of problem details responses
+	ProblemContentType = "application/problem+json"
+	requestIDHeader    = "X-Request-Id"
+)
+
+// Machine readable error codes, returned in the code field of a problem. Each version of the API documents the
+// codes which it returns.
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeWindowRejected   = "window_rejected"
+	CodeCallbackRejected = "callback_rejected"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeRateLimited      = "rate_limited"
+	CodeJobNotFound      = "job_not_found"
+	CodeDigestNotFound   = "digest_not_found"
+	CodeDigestExists     = "digest_exists"
+	CodeDigestInProgress = "digest_in_progress"
+	CodeTopicNotFound    = "topic_not_found"
+	CodeInternalError    = "internal_error"
+)
+
+// Problem is an RFC 7807 problem details response body, extended with an error code, the ID of the
+// digest concerned, if any, and the ID of the request for correlation with logs
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Code      string `json:"code"`
+	DigestID  string `json:"digestId,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+	// Conflict is set on 409 responses to the status of the existing digest, either "exists" or "in_progress".
+	// Only version 1 of the API returns conflicts.
+	Conflict string `json:"conflict,omitempty"`
+}
+
+// NewProblem describes a problem with the given status code and error code. The detail is a human readable
+// explanation of this occurrence of the problem, and should not expose internal errors.
+func NewProblem(r *http.Request, statusCode int, code string, detail string, digestID string) Problem {
+	requestID := middleware.GetReqID(r.Context())
+	if requestID == "" {
+		requestID = r.Header.Get(requestIDHeader)
+	}
+	return Problem{
+		Type:      "urn:vpcflow-digesterd:problem:" + code,
+		Title:     http.StatusText(statusCode),
+		Status:    statusCode,
+		Detail:    detail,
+		Code:      code,
+		DigestID:  digestID,
+		RequestID: requestID,
+	}
+}
+
+// Write writes the problem as the response
+func (p Problem) Write(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// WriteProblem writes a problem details response
+func WriteProblem(w http.ResponseWriter, r *http.Request, statusCode int, code string, detail string, digestID string) {
+	NewProblem(r, statusCode, code, detail, digestID).Write(w)
+}
+
+// WriteConflict writes a 409 problem details response, including the status of the conflicting digest
+func WriteConflict(w http.ResponseWriter, r *http.Request, code string, detail string, digestID string, status string) {
+	p := NewProblem(r, http.StatusConflict, code, detail, digestID)
+	p.Conflict = status
+	p.Write(w)
+}
+
+// InvalidRequest writes the problem details response to a request which does not match the OpenAPI document
+func InvalidRequest(w http.ResponseWriter, r *http.Request, err error) {
+	WriteProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error(), "")
+}
+
+// Unauthorized writes a 401 problem details response, for requests which do not carry valid credentials
+func Unauthorized(w http.ResponseWriter, r *http.Request) {
+	WriteProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "valid credentials are required", "")
+}
+
+// Forbidden writes a 403 problem details response, for requests whose caller is not permitted to perform the
+// operation
+func Forbidden(w http.ResponseWriter, r *http.Request) {
+	WriteProblem(w, r, http.StatusForbidden, CodeForbidden, "the caller is not permitted to perform this operation", "")
+}
+
+// TooManyRequests writes a 429 problem details response, for requests rejected because the caller, or the
+// service as a whole, is over a limit on digest creation. The Retry-After header is set from the error.
+func TooManyRequests(w http.ResponseWriter, r *http.Request, err ratelimit.ErrLimited) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(err.RetryAfter.Seconds()))))
+	WriteProblem(w, r, http.StatusTooManyRequests, CodeRateLimited, "too many digests have been requested, retry later", "")
+}
@@ -0,0 +1,100 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteProblem(t *testing.T) {
+	tc := []struct {
+		Name      string
+		Header    string
+		Context   string
+		RequestID string
+	}{
+		{
+			Name:      "middleware",
+			Header:    "header-id",
+			Context:   "context-id",
+			RequestID: "context-id",
+		},
+		{
+			Name:      "header",
+			Header:    "header-id",
+			RequestID: "header-id",
+		},
+		{
+			Name: "none",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "/", nil)
+			if tt.Header != "" {
+				r.Header.Set(requestIDHeader, tt.Header)
+			}
+			if tt.Context != "" {
+				r = r.WithContext(context.WithValue(r.Context(), middleware.RequestIDKey, tt.Context))
+			}
+			w := httptest.NewRecorder()
+			WriteProblem(w, r, http.StatusConflict, CodeDigestExists, "digest exists", "id")
+
+			assert.Equal(t, http.StatusConflict, w.Result().StatusCode)
+			assert.Equal(t, ProblemContentType, w.Result().Header.Get("Content-Type"))
+			var p Problem
+			assert.Nil(t, json.NewDecoder(w.Result().Body).Decode(&p))
+			assert.Equal(t, Problem{
+				Type:      "urn:vpcflow-digesterd:problem:digest_exists",
+				Title:     "Conflict",
+				Status:    http.StatusConflict,
+				Detail:    "digest exists",
+				Code:      CodeDigestExists,
+				DigestID:  "id",
+				RequestID: tt.RequestID,
+			}, p)
+		})
+	}
+}
+
+func TestAuthProblems(t *testing.T) {
+	tc := []struct {
+		Name   string
+		Write  func(http.ResponseWriter, *http.Request)
+		Status int
+		Code   string
+	}{
+		{
+			Name:   "unauthorized",
+			Write:  Unauthorized,
+			Status: http.StatusUnauthorized,
+			Code:   CodeUnauthorized,
+		},
+		{
+			Name:   "forbidden",
+			Write:  Forbidden,
+			Status: http.StatusForbidden,
+			Code:   CodeForbidden,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "/", nil)
+			w := httptest.NewRecorder()
+			tt.Write(w, r)
+
+			assert.Equal(t, tt.Status, w.Result().StatusCode)
+			assert.Equal(t, ProblemContentType, w.Result().Header.Get("Content-Type"))
+			var p Problem
+			assert.Nil(t, json.NewDecoder(w.Result().Body).Decode(&p))
+			assert.Equal(t, tt.Code, p.Code)
+		})
+	}
+}
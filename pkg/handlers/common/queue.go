@@ -0,0 +1,145 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/logs"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/metrics"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/ratelimit"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+)
+
+// The outcomes of a request to create the digest for a window. Each version of the API may describe them
+// differently.
+const (
+	// StatusQueued is the status of a digest whose job was queued
+	StatusQueued = "queued"
+	// StatusExists is the status of a digest which already exists, and was not regenerated
+	StatusExists = "exists"
+	// StatusInProgress is the status of a digest which is already being created
+	StatusInProgress = "in_progress"
+	// StatusRateLimited is the status of a digest which was not queued because the client, or the service, is
+	// over a limit. It is only reported for requests which queue more than one digest.
+	StatusRateLimited = "rate_limited"
+)
+
+// JobOptions are the options given by a request to create digests, which apply to each job it queues
+type JobOptions struct {
+	// Force requests that the digest be regenerated if it already exists
+	Force bool
+	// Callback, if set, is a URL to notify when the digest is complete
+	Callback string
+	// Client identifies the caller, as by auth.ClientOf, and is charged for each job queued
+	Client string
+}
+
+// Queue queues the jobs which create digests
+type Queue struct {
+	LogProvider  types.LogFn
+	StatProvider types.StatFn
+	Storage      types.Storage
+	Marker       types.Marker
+	Queuer       types.Queuer
+	// Events, if set, is published to whenever a job is queued
+	Events types.Publisher
+	// Limiter, if set, limits the creation of digests. The client is charged for each job, just before it is
+	// queued, and the charge is refunded if the job cannot be queued.
+	Limiter *ratelimit.Limiter
+}
+
+// Queue queues a job to create the digest for the window, and marks it as in progress, unless the digest is
+// already being created or, when not forcing regeneration, already exists. If the client, or the service, is
+// over a limit, ratelimit.ErrLimited is returned. Errors are logged before returning, and every outcome is counted.
+func (q *Queue) Queue(ctx context.Context, id string, window types.Window, opts JobOptions) (status string, err error) {
+	logger := q.LogProvider(ctx)
+	stat := q.StatProvider(ctx)
+	defer func() {
+		switch err.(type) {
+		case nil:
+		case ratelimit.ErrLimited:
+			status = metrics.StatusRateLimited
+		default:
+			status = metrics.StatusError
+		}
+		stat.Count(metrics.DigestRequested, 1, metrics.Status(status))
+	}()
+	exists, err := q.Storage.Exists(ctx, id)
+	switch err.(type) {
+	case nil:
+	case types.ErrInProgress:
+		return StatusInProgress, nil
+	default:
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		return "", err
+	}
+	// if data is returned, a digest already exists, unless the caller has asked for it to be regenerated
+	if exists && !opts.Force {
+		return StatusExists, nil
+	}
+	if q.Limiter != nil {
+		refund, err := q.Limiter.Take(ctx, opts.Client)
+		switch err.(type) {
+		case nil:
+		case ratelimit.ErrLimited:
+			logger.Info(logs.RateLimited{Client: opts.Client, Reason: err.Error()})
+			return "", err
+		default:
+			logger.Error(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
+			return "", err
+		}
+		defer func() {
+			if status != StatusQueued {
+				refund()
+			}
+		}()
+	}
+
+	// the client is carried with the job, so that the worker may record who requested the digest
+	ctx = types.NewClientContext(ctx, opts.Client)
+	started := time.Now()
+	if opts.Callback != "" {
+		err = q.Queuer.(types.CallbackQueuer).QueueWithCallback(ctx, id, window.Start.UTC(), window.Stop.UTC(), opts.Callback)
+	} else {
+		err = q.Queuer.Queue(ctx, id, window.Start.UTC(), window.Stop.UTC())
+	}
+	metrics.TimeDependency(stat, logs.DependencyQueuer, "queue", started, err)
+	if err != nil {
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyQueuer, Reason: err.Error()})
+		return "", err
+	}
+
+	started = time.Now()
+	markErr := q.Marker.Mark(ctx, id)
+	metrics.TimeDependency(stat, logs.DependencyMarker, "mark", started, markErr)
+	if markErr != nil {
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: markErr.Error()})
+	}
+	if q.Events != nil {
+		q.Events.Publish(ctx, types.Event{Type: types.EventQueued, DigestID: id, Start: window.Start.UTC(), Stop: window.Stop.UTC()})
+	}
+	return StatusQueued, nil
+}
+
+// CheckCallback returns an error if a callback was given, but callbacks are not enabled or the URL is not
+// allowed. Callbacks are enabled if the Queuer implements types.CallbackQueuer, and there is a validate function.
+func CheckCallback(queuer types.Queuer, validate func(callback string) error, callback string) error {
+	if callback == "" {
+		return nil
+	}
+	if _, ok := queuer.(types.CallbackQueuer); !ok || validate == nil {
+		return errors.New("callbacks are not enabled")
+	}
+	return validate(callback)
+}
+
+// EstimateCompletion estimates when the digest for a window queued at the given time will be complete, given
+// the time taken to digest an hour of flow logs. The length of the window is rounded up to the hour.
+func EstimateCompletion(window types.Window, queuedAt time.Time, perHour time.Duration) time.Time {
+	hours := (window.Stop.Sub(window.Start) + time.Hour - 1) / time.Hour
+	if hours < 1 {
+		hours = 1
+	}
+	return queuedAt.Add(time.Duration(hours) * perHour)
+}
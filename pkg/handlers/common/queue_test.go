@@ -0,0 +1,76 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// callbackQueuer is a types.CallbackQueuer which queues nothing
+type callbackQueuer struct{}
+
+func (callbackQueuer) Queue(ctx context.Context, id string, start, stop time.Time) error {
+	return nil
+}
+
+func (callbackQueuer) QueueWithCallback(ctx context.Context, id string, start, stop time.Time, callback string) error {
+	return nil
+}
+
+// plainQueuer is a types.Queuer which cannot notify callbacks
+type plainQueuer struct{}
+
+func (plainQueuer) Queue(ctx context.Context, id string, start, stop time.Time) error {
+	return nil
+}
+
+func TestCheckCallback(t *testing.T) {
+	allow := func(string) error { return nil }
+	deny := func(string) error { return errors.New("not allowed") }
+
+	tc := []struct {
+		Name     string
+		Queuer   types.Queuer
+		Validate func(string) error
+		Callback string
+		Err      bool
+	}{
+		{Name: "none", Queuer: plainQueuer{}},
+		{Name: "allowed", Queuer: callbackQueuer{}, Validate: allow, Callback: "https://example.com"},
+		{Name: "denied", Queuer: callbackQueuer{}, Validate: deny, Callback: "https://example.com", Err: true},
+		{Name: "not validated", Queuer: callbackQueuer{}, Callback: "https://example.com", Err: true},
+		{Name: "not supported", Queuer: plainQueuer{}, Validate: allow, Callback: "https://example.com", Err: true},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := CheckCallback(tt.Queuer, tt.Validate, tt.Callback)
+			assert.Equal(t, tt.Err, err != nil)
+		})
+	}
+}
+
+func TestEstimateCompletion(t *testing.T) {
+	queuedAt := time.Date(2019, time.January, 2, 0, 0, 0, 0, time.UTC)
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	tc := []struct {
+		Name     string
+		Length   time.Duration
+		Expected time.Duration
+	}{
+		{Name: "empty", Expected: time.Minute},
+		{Name: "partial hour", Length: 10 * time.Minute, Expected: time.Minute},
+		{Name: "hours", Length: 3 * time.Hour, Expected: 3 * time.Minute},
+		{Name: "rounded up", Length: 3*time.Hour + time.Minute, Expected: 4 * time.Minute},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			window := types.Window{Start: start, Stop: start.Add(tt.Length)}
+			assert.Equal(t, queuedAt.Add(tt.Expected), EstimateCompletion(window, queuedAt, time.Minute))
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package common
+
+import (
+	"context"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+)
+
+// Stat returns the metadata for the digest identified by key. If the Storage cannot read metadata, an error
+// will be returned of type types.ErrUnsupported.
+func Stat(ctx context.Context, storage types.Storage, key string) (types.DigestMetadata, error) {
+	stater, ok := storage.(types.Stater)
+	if !ok {
+		return types.DigestMetadata{}, types.ErrUnsupported{Operation: "stat"}
+	}
+	return stater.Stat(ctx, key)
+}
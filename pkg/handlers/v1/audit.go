@@ -0,0 +1,47 @@
+package v1
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/auth"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/logs"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+)
+
+// logRequested records who requested the digest for a window, and the outcome
+func (h *DigesterHandler) logRequested(r *http.Request, id string, window types.Window, status string, opts common.JobOptions) {
+	h.LogProvider(r.Context()).Info(logs.DigestRequested{
+		Client: auth.ClientOf(r),
+		ID:     id,
+		Start:  common.FormatTime(window.Start),
+		Stop:   common.FormatTime(window.Stop),
+		Scope:  h.Scope.String(),
+		Force:  opts.Force,
+		Status: status,
+	})
+}
+
+// logServed records who was served a digest. The window is zero if the digest was requested by ID.
+func (h *DigesterHandler) logServed(r *http.Request, id string, window types.Window, redirect bool, bytes int64, started time.Time) {
+	h.LogProvider(r.Context()).Info(logs.DigestServed{
+		Client:     auth.ClientOf(r),
+		ID:         id,
+		Start:      common.FormatTime(window.Start),
+		Stop:       common.FormatTime(window.Stop),
+		Scope:      h.Scope.String(),
+		Redirect:   redirect,
+		Bytes:      bytes,
+		DurationMS: common.DurationMS(time.Since(started)),
+	})
+}
+
+// logDeleted records who deleted a digest
+func (h *DigesterHandler) logDeleted(r *http.Request, id string) {
+	h.LogProvider(r.Context()).Info(logs.DigestDeleted{
+		Client: auth.ClientOf(r),
+		ID:     id,
+		Scope:  h.Scope.String(),
+	})
+}
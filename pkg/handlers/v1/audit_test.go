@@ -0,0 +1,179 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/go-vpcflow"
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/auth"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/go-chi/chi"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/xstats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const auditID = "8d5d9ab4-4d6b-4b8a-9c39-3a7b1f1b8f1e"
+
+// auditRequest returns a request made by the given subject, whose logs are written to buf
+func auditRequest(method string, target string, body io.Reader, subject string, buf *bytes.Buffer) *http.Request {
+	r := httptest.NewRequest(method, target, body)
+	ctx := logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: buf}))
+	ctx = auth.NewContext(ctx, auth.Principal{Subject: subject})
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", auditID)
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+	return r.WithContext(ctx)
+}
+
+// auditEvent returns the fields of the event with the given message in the logs
+func auditEvent(t *testing.T, buf *bytes.Buffer, message string) map[string]interface{} {
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var event map[string]interface{}
+		require.Nil(t, json.Unmarshal([]byte(line), &event))
+		if event["message"] == message {
+			return event
+		}
+	}
+	t.Fatalf("no %s event was logged", message)
+	return nil
+}
+
+func TestAuditRequested(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	stop := start.Add(time.Hour)
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, nil)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any(), start, stop).Return(nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(nil)
+
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		Queuer:       queuerMock,
+		Marker:       markerMock,
+		Scope:        types.Scope{Accounts: []string{"123456789012"}},
+	}
+	var buf bytes.Buffer
+	w := httptest.NewRecorder()
+	h.Post(w, auditRequest(http.MethodPost, "/?start=2019-01-01T00:00:00Z&stop=2019-01-01T01:00:00Z", nil, "alice", &buf))
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	event := auditEvent(t, &buf, "digest-requested")
+	assert.Equal(t, "alice", event["client"])
+	assert.Equal(t, "2019-01-01T00:00:00Z", event["start"])
+	assert.Equal(t, "2019-01-01T01:00:00Z", event["stop"])
+	assert.Equal(t, "123456789012/*", event["scope"])
+	assert.Equal(t, common.StatusQueued, event["status"])
+	assert.Equal(t, false, event["force"])
+	assert.NotEmpty(t, event["id"])
+}
+
+func TestAuditServed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	data := "this is the digest you're looking for"
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Get(gomock.Any(), auditID).Return(ioutil.NopCloser(strings.NewReader(data)), nil)
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	storageMock.EXPECT().Stat(gomock.Any(), auditID).Return(types.DigestMetadata{Start: start, Stop: start.Add(time.Hour)}, nil)
+
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+	}
+	var buf bytes.Buffer
+	w := httptest.NewRecorder()
+	h.GetByID(w, auditRequest(http.MethodGet, "/digests/"+auditID, nil, "alice", &buf))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	event := auditEvent(t, &buf, "digest-served")
+	assert.Equal(t, "alice", event["client"])
+	assert.Equal(t, auditID, event["id"])
+	assert.Equal(t, float64(len(data)), event["bytes"])
+	assert.Equal(t, false, event["redirect"])
+	assert.Equal(t, "2019-01-01T00:00:00Z", event["start"], "the window of a digest served by ID is read from its metadata")
+	assert.Equal(t, "2019-01-01T01:00:00Z", event["stop"])
+}
+
+func TestAuditDeleted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Delete(gomock.Any(), auditID).Return(nil)
+
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+	}
+	var buf bytes.Buffer
+	w := httptest.NewRecorder()
+	h.DeleteByID(w, auditRequest(http.MethodDelete, "/digests/"+auditID, nil, "alice", &buf))
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	event := auditEvent(t, &buf, "digest-deleted")
+	assert.Equal(t, "alice", event["client"])
+	assert.Equal(t, auditID, event["id"])
+}
+
+func TestAuditProduced(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	data := "digest"
+	digesterMock := NewMockDigester(ctrl)
+	digesterMock.EXPECT().Digest().Return(ioutil.NopCloser(strings.NewReader(data)), nil)
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Store(gomock.Any(), key, gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ string, body io.ReadCloser, _ types.DigestMetadata) error {
+			_, err := ioutil.ReadAll(body)
+			return err
+		})
+	storageMock.EXPECT().Stat(gomock.Any(), key).Return(types.DigestMetadata{ID: key}, nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Unmark(gomock.Any(), key).Return(nil)
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute).UTC()
+	stop := start.Add(time.Minute)
+	payload := fmt.Sprintf(`{"id":"%s","start":"%s","stop":"%s","client":"alice"}`,
+		key, start.Format(time.RFC3339Nano), stop.Format(time.RFC3339Nano))
+	handler := &Produce{
+		LogProvider:      logevent.FromContext,
+		StatProvider:     xstats.FromContext,
+		Storage:          storageMock,
+		Marker:           markerMock,
+		DigesterProvider: func(_, _ time.Time) vpcflow.Digester { return digesterMock },
+	}
+	var buf bytes.Buffer
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, auditRequest(http.MethodPost, "/topic/event", strings.NewReader(payload), "stream-appliance", &buf))
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	event := auditEvent(t, &buf, "digest-produced")
+	assert.Equal(t, "alice", event["client"])
+	assert.Equal(t, key, event["id"])
+	assert.Equal(t, common.FormatTime(start), event["start"])
+	assert.Equal(t, float64(len(data)), event["uncompressed_bytes"])
+}
@@ -0,0 +1,205 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/auth"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/logs"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/ratelimit"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+)
+
+const (
+	defaultMaxBatch         = 1000
+	defaultBatchConcurrency = 8
+
+	statusInvalid = "invalid"
+	statusFailed  = "failed"
+
+	// errRateLimited explains the status of a window which was rate limited
+	errRateLimited = "too many digests have been requested, retry later"
+)
+
+// batchItem is a window for which a digest is requested as part of a batch
+type batchItem struct {
+	Start string `json:"start"`
+	Stop  string `json:"stop"`
+	// Scope, if given, must match the accounts and regions from which this service creates digests
+	Scope *batchScope `json:"scope,omitempty"`
+}
+
+type batchScope struct {
+	Accounts []string `json:"accounts"`
+	Regions  []string `json:"regions"`
+}
+
+// batchResult is the outcome of a single item of a batch. Items which were rejected, could not be queued, or were
+// rate limited, have the status "invalid", "failed" or "rate_limited", and an error, but no ID.
+type batchResult struct {
+	Index               int    `json:"index"`
+	ID                  string `json:"id,omitempty"`
+	Start               string `json:"start"`
+	Stop                string `json:"stop"`
+	Status              string `json:"status"`
+	Location            string `json:"location,omitempty"`
+	EstimatedCompletion string `json:"estimatedCompletion,omitempty"`
+	Error               string `json:"error,omitempty"`
+}
+
+// batchResults is the response to a batch request, with a result for each item in the order they were given
+type batchResults struct {
+	Results []batchResult `json:"results"`
+}
+
+// Batch creates the digests for many windows at once. The body is a JSON array of windows, each of which is
+// checked against the Policy, but never split. Items for the same digest are queued once, and the others report
+// the digest as in progress or existing. Digests are queued concurrently, up to BatchConcurrency at a time, and
+// the outcome of each item is reported individually. The "force" and "callback" query parameters apply to every
+// item.
+func (h *DigesterHandler) Batch(w http.ResponseWriter, r *http.Request) {
+	logger := h.LogProvider(r.Context())
+	var items []batchItem
+	if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
+		return
+	}
+	maxBatch := h.MaxBatch
+	if maxBatch == 0 {
+		maxBatch = defaultMaxBatch
+	}
+	if len(items) == 0 || len(items) > maxBatch {
+		msg := fmt.Sprintf("a batch should contain between 1 and %d windows", maxBatch)
+		logger.Info(logs.InvalidInput{Reason: msg})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, msg, "")
+		return
+	}
+	force, err := extractForce(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
+		return
+	}
+	if force && h.AuthorizeForce != nil {
+		if err = h.AuthorizeForce(r); err != nil {
+			logger.Info(logs.Forbidden{Reason: err.Error()})
+			common.WriteProblem(w, r, http.StatusForbidden, common.CodeForbidden, err.Error(), "")
+			return
+		}
+	}
+	callback, err := h.extractCallback(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeCallbackRejected, err.Error(), "")
+		return
+	}
+	opts := common.JobOptions{Force: force, Callback: callback, Client: auth.ClientOf(r)}
+
+	concurrency := h.BatchConcurrency
+	if concurrency == 0 {
+		concurrency = defaultBatchConcurrency
+	}
+	res := batchResults{Results: make([]batchResult, len(items))}
+	now := time.Now()
+	// the indexes of the valid items, grouped by the ID of their digest in the order the digests were first given
+	windows := make([]types.Window, len(items))
+	groups := make(map[string][]int)
+	var ids []string
+	for i, item := range items {
+		window, err := h.checkBatchItem(item, now)
+		if err != nil {
+			logger.Info(logs.InvalidInput{Reason: err.Error()})
+			res.Results[i] = batchResult{Index: i, Start: item.Start, Stop: item.Stop, Status: statusInvalid, Error: err.Error()}
+			continue
+		}
+		windows[i] = window
+		id := types.DigestKey{Start: window.Start, Stop: window.Stop, Scope: h.Scope}.ID()
+		if _, ok := groups[id]; !ok {
+			ids = append(ids, id)
+		}
+		groups[id] = append(groups[id], i)
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(indexes []int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			queued := h.batchItem(r, indexes[0], windows[indexes[0]], opts, now)
+			res.Results[indexes[0]] = queued
+			for _, i := range indexes[1:] {
+				res.Results[i] = duplicateResult(queued, i)
+			}
+		}(groups[id])
+	}
+	wg.Wait()
+	writeJob(w, http.StatusOK, res)
+}
+
+// duplicateResult returns the result of an item for the same digest as an item which has already been queued. The
+// digest is reported as in progress if it was queued, and the outcome is otherwise the same.
+func duplicateResult(queued batchResult, index int) batchResult {
+	res := queued
+	res.Index = index
+	if res.Status == common.StatusQueued {
+		res.Status = common.StatusInProgress
+	}
+	return res
+}
+
+// batchItem queues the job for the window of a single item of a batch
+func (h *DigesterHandler) batchItem(r *http.Request, index int, window types.Window, opts common.JobOptions, now time.Time) batchResult {
+	id := h.resolveID(r.Context(), window.Start, window.Stop, opts.Force)
+	status, err := h.jobs().Queue(r.Context(), id, window, opts)
+	if err != nil {
+		res := batchResult{
+			Index:  index,
+			Start:  common.FormatTime(window.Start),
+			Stop:   common.FormatTime(window.Stop),
+			Status: statusFailed,
+			Error:  "the digest could not be queued",
+		}
+		if _, ok := err.(ratelimit.ErrLimited); ok {
+			res.Status = common.StatusRateLimited
+			res.Error = errRateLimited
+		}
+		return res
+	}
+	h.logRequested(r, id, window, status, opts)
+	j := h.newJob(id, window, status, now)
+	return batchResult{
+		Index:               index,
+		ID:                  j.ID,
+		Start:               j.Start,
+		Stop:                j.Stop,
+		Status:              j.Status,
+		Location:            j.Location,
+		EstimatedCompletion: j.EstimatedCompletion,
+	}
+}
+
+// checkBatchItem parses the window of a batch item, truncated to minute precision, and checks it against the
+// Policy and the scope of the service
+func (h *DigesterHandler) checkBatchItem(item batchItem, now time.Time) (types.Window, error) {
+	window, err := types.ParseWindow(item.Start, item.Stop)
+	if err != nil {
+		return types.Window{}, err
+	}
+	if !window.Stop.After(window.Start) {
+		return types.Window{}, types.ErrEmptyWindow
+	}
+	if item.Scope != nil && !h.Scope.Equal(types.Scope{Accounts: item.Scope.Accounts, Regions: item.Scope.Regions}) {
+		return types.Window{}, fmt.Errorf("digests are only created for the scope %s", h.Scope.String())
+	}
+	if err := h.Policy.Check(window.Start, window.Stop, now); err != nil {
+		return types.Window{}, err
+	}
+	return window, nil
+}
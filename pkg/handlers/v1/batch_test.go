@@ -0,0 +1,250 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/ratelimit"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/xstats"
+	"github.com/stretchr/testify/assert"
+)
+
+func newBatchRequest(body string) *http.Request {
+	r, _ := http.NewRequest(http.MethodPost, "/batch", bytes.NewBufferString(body))
+	return r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+}
+
+func TestBatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	queued := types.DigestKey{Start: start, Stop: start.Add(time.Hour)}.ID()
+	exists := types.DigestKey{Start: start.Add(time.Hour), Stop: start.Add(2 * time.Hour)}.ID()
+	inProgress := types.DigestKey{Start: start.Add(2 * time.Hour), Stop: start.Add(3 * time.Hour)}.ID()
+	failed := types.DigestKey{Start: start.Add(3 * time.Hour), Stop: start.Add(4 * time.Hour)}.ID()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), queued).Return(false, nil)
+	storageMock.EXPECT().Exists(gomock.Any(), exists).Return(true, nil)
+	storageMock.EXPECT().Exists(gomock.Any(), inProgress).Return(false, types.ErrInProgress{Key: inProgress})
+	storageMock.EXPECT().Exists(gomock.Any(), failed).Return(false, errors.New("oops"))
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), queued, gomock.Any(), gomock.Any()).Return(nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), queued).Return(nil)
+
+	body := fmt.Sprintf(`[
+		{"start":"%[1]s","stop":"%[2]s"},
+		{"start":"%[2]s","stop":"%[3]s"},
+		{"start":"%[3]s","stop":"%[4]s","scope":{"accounts":[],"regions":[]}},
+		{"start":"%[4]s","stop":"%[5]s"},
+		{"start":"yesterday","stop":"%[5]s"},
+		{"start":"%[1]s","stop":"%[2]s","scope":{"accounts":["123456789012"]}},
+		{"start":"%[1]s","stop":"%[1]s"}
+	]`,
+		start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339), start.Add(2*time.Hour).Format(time.RFC3339),
+		start.Add(3*time.Hour).Format(time.RFC3339), start.Add(4*time.Hour).Format(time.RFC3339))
+
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		Queuer:       queuerMock,
+		Marker:       markerMock,
+	}
+	w := httptest.NewRecorder()
+	h.Batch(w, newBatchRequest(body))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var res batchResults
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.Len(t, res.Results, 7)
+	for i, r := range res.Results {
+		assert.Equal(t, i, r.Index)
+	}
+	assert.Equal(t, common.StatusQueued, res.Results[0].Status)
+	assert.Equal(t, queued, res.Results[0].ID)
+	assert.Equal(t, "/digests/"+queued, res.Results[0].Location)
+	assert.Equal(t, common.StatusExists, res.Results[1].Status)
+	assert.Equal(t, common.StatusInProgress, res.Results[2].Status)
+	assert.Equal(t, statusFailed, res.Results[3].Status)
+	assert.Empty(t, res.Results[3].ID)
+	assert.NotEmpty(t, res.Results[3].Error)
+	assert.Equal(t, statusInvalid, res.Results[4].Status)
+	assert.Equal(t, "yesterday", res.Results[4].Start)
+	assert.Equal(t, statusInvalid, res.Results[5].Status)
+	assert.Equal(t, statusInvalid, res.Results[6].Status)
+	assert.Equal(t, types.ErrEmptyWindow.Error(), res.Results[6].Error)
+}
+
+func TestBatchDuplicates(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	queued := types.DigestKey{Start: start, Stop: start.Add(time.Hour)}.ID()
+	exists := types.DigestKey{Start: start.Add(time.Hour), Stop: start.Add(2 * time.Hour)}.ID()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), queued).Return(false, nil)
+	storageMock.EXPECT().Exists(gomock.Any(), exists).Return(true, nil)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), queued, gomock.Any(), gomock.Any()).Return(nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), queued).Return(nil)
+
+	// the same windows, expressed in different time zones and precisions
+	body := `[
+		{"start":"2019-01-01T00:00:00Z","stop":"2019-01-01T01:00:00Z"},
+		{"start":"2019-01-01T01:00:00Z","stop":"2019-01-01T02:00:00Z"},
+		{"start":"2019-01-01T02:00:00+02:00","stop":"2019-01-01T03:00:30+02:00"},
+		{"start":"2019-01-01T01:00:00Z","stop":"2019-01-01T02:00:00Z"}
+	]`
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		Queuer:       queuerMock,
+		Marker:       markerMock,
+	}
+	w := httptest.NewRecorder()
+	h.Batch(w, newBatchRequest(body))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var res batchResults
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.Len(t, res.Results, 4)
+	for i, r := range res.Results {
+		assert.Equal(t, i, r.Index)
+	}
+	assert.Equal(t, common.StatusQueued, res.Results[0].Status)
+	assert.Equal(t, common.StatusInProgress, res.Results[2].Status)
+	assert.Equal(t, queued, res.Results[2].ID)
+	assert.Equal(t, "/digests/"+queued, res.Results[2].Location)
+	assert.Equal(t, common.StatusExists, res.Results[1].Status)
+	assert.Equal(t, common.StatusExists, res.Results[3].Status)
+	assert.Equal(t, exists, res.Results[3].ID)
+}
+
+func TestBatchRateLimited(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, nil).Times(3)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+
+	now := time.Unix(1500000000, 0)
+	h := DigesterHandler{
+		LogProvider:      logevent.FromContext,
+		StatProvider:     xstats.FromContext,
+		Storage:          storageMock,
+		Queuer:           queuerMock,
+		Marker:           markerMock,
+		BatchConcurrency: 1,
+		Limiter: &ratelimit.Limiter{Limits: &ratelimit.Limits{
+			Default: ratelimit.Limit{PerMinute: 2},
+			Now:     func() time.Time { return now },
+		}},
+	}
+	body := `[
+		{"start":"2019-01-01T00:00:00Z","stop":"2019-01-01T01:00:00Z"},
+		{"start":"2019-01-01T01:00:00Z","stop":"2019-01-01T02:00:00Z"},
+		{"start":"2019-01-01T02:00:00Z","stop":"2019-01-01T03:00:00Z"}
+	]`
+	w := httptest.NewRecorder()
+	h.Batch(w, newBatchRequest(body))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var res batchResults
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.Len(t, res.Results, 3)
+	assert.Equal(t, common.StatusQueued, res.Results[0].Status)
+	assert.Equal(t, common.StatusQueued, res.Results[1].Status)
+	assert.Equal(t, common.StatusRateLimited, res.Results[2].Status)
+	assert.Empty(t, res.Results[2].ID)
+	assert.Equal(t, errRateLimited, res.Results[2].Error)
+}
+
+func TestBatchBoundedConcurrency(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var current, peak int32
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).DoAndReturn(func(context.Context, string) (bool, error) {
+		n := atomic.AddInt32(&current, 1)
+		defer atomic.AddInt32(&current, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return true, nil
+	}).Times(20)
+
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	items := make([]batchItem, 20)
+	for i := range items {
+		items[i] = batchItem{
+			Start: start.Add(time.Duration(i) * time.Hour).Format(time.RFC3339),
+			Stop:  start.Add(time.Duration(i+1) * time.Hour).Format(time.RFC3339),
+		}
+	}
+	body, _ := json.Marshal(items)
+
+	h := DigesterHandler{
+		LogProvider:      logevent.FromContext,
+		StatProvider:     xstats.FromContext,
+		Storage:          storageMock,
+		BatchConcurrency: 3,
+	}
+	w := httptest.NewRecorder()
+	h.Batch(w, newBatchRequest(string(body)))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, atomic.LoadInt32(&peak) <= 3, "peak concurrency was %d", peak)
+}
+
+func TestBatchBadRequest(t *testing.T) {
+	tc := []struct {
+		Name string
+		Body string
+	}{
+		{Name: "not_json", Body: "windows"},
+		{Name: "empty", Body: "[]"},
+		{Name: "too_many", Body: `[{"start":"a","stop":"b"},{"start":"a","stop":"b"},{"start":"a","stop":"b"}]`},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			h := DigesterHandler{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+				MaxBatch:     2,
+			}
+			w := httptest.NewRecorder()
+			h.Batch(w, newBatchRequest(tt.Body))
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, common.ProblemContentType, w.Header().Get("Content-Type"))
+		})
+	}
+}
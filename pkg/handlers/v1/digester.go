@@ -1,19 +1,30 @@
 package v1
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/auth"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
 	"github.com/asecurityteam/vpcflow-digesterd/pkg/logs"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/metrics"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/ratelimit"
 	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/go-chi/chi"
 	"github.com/google/uuid"
 )
 
-var digestNamespace = uuid.NewSHA1(uuid.Nil, []byte("digest"))
+const (
+	defaultRedirectTTL = 5 * time.Minute
+	defaultMaxWait     = 30 * time.Second
+	staleHeader        = "X-Digest-Stale"
+)
 
 // DigesterHandler handles incoming HTTP requests for starting and retrieving new digests
 type DigesterHandler struct {
@@ -22,6 +33,43 @@ type DigesterHandler struct {
 	Storage      types.Storage
 	Marker       types.Marker
 	Queuer       types.Queuer
+	// Redirect, when true, causes Get to respond with a redirect to a short-lived pre-signed URL
+	// instead of proxying the digest, provided the Storage implements types.Presigner. Clients may
+	// override this on a per request basis with the "redirect" query parameter.
+	Redirect bool
+	// RedirectTTL is the length of time for which pre-signed URLs are valid. Defaults to 5 minutes.
+	RedirectTTL time.Duration
+	// AuthorizeForce, if set, is consulted before honoring a request to regenerate a digest which
+	// already exists. Returning an error rejects the request with a 403. If not set, all requests
+	// to regenerate a digest are allowed.
+	AuthorizeForce func(r *http.Request) error
+	// Policy constrains the windows for which digests may be created. Requests which violate it are
+	// rejected with a 422, or, if the policy allows it, oversized windows are split into multiple jobs.
+	Policy types.WindowPolicy
+	// Scope describes the accounts and regions which digests are created from. It is part of the digest ID.
+	Scope types.Scope
+	// LegacyLookup, when true, causes digests which are not found under their canonical ID to be looked up
+	// under the ID they would have been stored with before canonical IDs were introduced.
+	LegacyLookup bool
+	// EstimatedDurationPerHour is the estimated time taken to digest an hour of flow logs. It is used to estimate
+	// when queued digests will be complete. If zero, no estimate is given.
+	EstimatedDurationPerHour time.Duration
+	// ValidateCallback, if set, enables the "callback" query parameter of Post. It returns an error if the
+	// callback URL is not allowed. The Queuer must implement types.CallbackQueuer.
+	ValidateCallback func(callback string) error
+	// MaxWait caps the "wait" query parameter of Get and GetByID, which holds the request open while the digest
+	// is in progress. Longer waits are shortened to MaxWait. Defaults to 30 seconds. Waiting requires the Marker
+	// to implement types.MarkerWatcher, and otherwise the parameter is ignored.
+	MaxWait time.Duration
+	// Events, if set, is published to whenever a digest job is queued
+	Events types.Publisher
+	// MaxBatch is the maximum number of windows which may be requested at once with Batch. Defaults to 1000.
+	MaxBatch int
+	// BatchConcurrency is the maximum number of windows of a batch which are queued at once. Defaults to 8.
+	BatchConcurrency int
+	// Limiter, if set, limits the creation of digests. Clients are charged for each digest queued, whether
+	// requested alone, as part of a split window, or in a batch.
+	Limiter *ratelimit.Limiter
 }
 
 // Post creates a new digest
@@ -30,42 +78,123 @@ func (h *DigesterHandler) Post(w http.ResponseWriter, r *http.Request) {
 	start, stop, err := extractInput(r)
 	if err != nil {
 		logger.Info(logs.InvalidInput{Reason: err.Error()})
-		writeJSONResponse(w, http.StatusBadRequest, err.Error())
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
 		return
 	}
-	id := computeID(start, stop)
-	exists, err := h.Storage.Exists(r.Context(), id)
-	switch err.(type) {
-	case nil:
-	case types.ErrInProgress:
-		logger.Info(logs.Conflict{Reason: err.Error()})
-		writeJSONResponse(w, http.StatusConflict, err.Error())
+	force, err := extractForce(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
 		return
-	default:
-		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
-		writeJSONResponse(w, http.StatusInternalServerError, "Internal Server Error")
+	}
+	if force && h.AuthorizeForce != nil {
+		if err = h.AuthorizeForce(r); err != nil {
+			logger.Info(logs.Forbidden{Reason: err.Error()})
+			common.WriteProblem(w, r, http.StatusForbidden, common.CodeForbidden, err.Error(), "")
+			return
+		}
+	}
+	callback, err := h.extractCallback(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeCallbackRejected, err.Error(), "")
 		return
 	}
-	// if data is returned, a digest already exists. return 409 and exit
-	if exists {
-		msg := fmt.Sprintf("digest %s already exists", id)
-		logger.Info(logs.Conflict{Reason: msg})
-		writeJSONResponse(w, http.StatusConflict, msg)
+	opts := common.JobOptions{Force: force, Callback: callback, Client: auth.ClientOf(r)}
+	windows, err := h.Policy.Windows(start, stop, time.Now())
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusUnprocessableEntity, common.CodeWindowRejected, err.Error(), "")
 		return
 	}
-
-	if err = h.Queuer.Queue(r.Context(), id, start, stop); err != nil {
-		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyQueuer, Reason: err.Error()})
-		writeJSONResponse(w, http.StatusInternalServerError, "Internal Server Error")
+	if len(windows) > 1 {
+		h.postSplit(w, r, windows, opts)
 		return
 	}
 
-	err = h.Marker.Mark(r.Context(), id)
+	id := h.resolveID(r.Context(), start, stop, opts.Force)
+	status, err := h.jobs().Queue(r.Context(), id, windows[0], opts)
+	if limited, ok := err.(ratelimit.ErrLimited); ok {
+		common.TooManyRequests(w, r, limited)
+		return
+	}
 	if err != nil {
-		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusInternalServerError, common.CodeInternalError, "", id)
+		return
+	}
+	h.logRequested(r, id, windows[0], status, opts)
+	switch status {
+	case common.StatusInProgress:
+		msg := types.ErrInProgress{Key: id}.Error()
+		logger.Info(logs.Conflict{Reason: msg})
+		common.WriteConflict(w, r, common.CodeDigestInProgress, msg, id, status)
+	case common.StatusExists:
+		msg := fmt.Sprintf("digest %s already exists", id)
+		logger.Info(logs.Conflict{Reason: msg})
+		common.WriteConflict(w, r, common.CodeDigestExists, msg, id, status)
+	default:
+		w.Header().Set("Location", digestLocation(id))
+		writeJob(w, http.StatusAccepted, h.newJob(id, windows[0], status, time.Now()))
+	}
+}
+
+// postSplit queues a job for each of the windows that an oversized request was split into. Windows whose digest
+// already exists or is being created are skipped, and windows which could not be queued are reported as failed,
+// or rate limited, as in a batch. The response lists the outcome for each window, and is a 202 if any jobs were
+// queued. Otherwise, it is a 429 if any windows were rate limited, a 500 if any failed, so that the request may
+// be retried as a whole, or a 409.
+func (h *DigesterHandler) postSplit(w http.ResponseWriter, r *http.Request, windows []types.Window, opts common.JobOptions) {
+	res := jobList{Digests: make([]job, 0, len(windows))}
+	queued := false
+	failed := false
+	var limited *ratelimit.ErrLimited
+	now := time.Now()
+	for _, window := range windows {
+		id := h.resolveID(r.Context(), window.Start, window.Stop, opts.Force)
+		status, err := h.jobs().Queue(r.Context(), id, window, opts)
+		if err != nil {
+			j := h.newJob(id, window, statusFailed, now)
+			j.Error = "the digest could not be queued"
+			if e, ok := err.(ratelimit.ErrLimited); ok {
+				if limited == nil || e.RetryAfter > limited.RetryAfter {
+					limited = &e
+				}
+				j.Status = common.StatusRateLimited
+				j.Error = errRateLimited
+			} else {
+				failed = true
+			}
+			res.Digests = append(res.Digests, j)
+			continue
+		}
+		h.logRequested(r, id, window, status, opts)
+		queued = queued || status == common.StatusQueued
+		res.Digests = append(res.Digests, h.newJob(id, window, status, now))
+	}
+	switch {
+	case queued:
+		writeJob(w, http.StatusAccepted, res)
+	case limited != nil:
+		common.TooManyRequests(w, r, *limited)
+	case failed:
+		common.WriteProblem(w, r, http.StatusInternalServerError, common.CodeInternalError, "", "")
+	default:
+		h.LogProvider(r.Context()).Info(logs.Conflict{Reason: "all digests for the window already exist or are being created"})
+		writeJob(w, http.StatusConflict, res)
 	}
+}
 
-	w.WriteHeader(http.StatusAccepted)
+// jobs returns the Queue of the jobs which create digests
+func (h *DigesterHandler) jobs() *common.Queue {
+	return &common.Queue{
+		LogProvider:  h.LogProvider,
+		StatProvider: h.StatProvider,
+		Storage:      h.Storage,
+		Marker:       h.Marker,
+		Queuer:       h.Queuer,
+		Events:       h.Events,
+		Limiter:      h.Limiter,
+	}
 }
 
 // Get retrieves a digest
@@ -74,69 +203,302 @@ func (h *DigesterHandler) Get(w http.ResponseWriter, r *http.Request) {
 	start, stop, err := extractInput(r)
 	if err != nil {
 		logger.Info(logs.InvalidInput{Reason: err.Error()})
-		writeJSONResponse(w, http.StatusBadRequest, err.Error())
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
 		return
 	}
-	id := computeID(start, stop)
-	body, err := h.Storage.Get(r.Context(), id)
+	redirect, err := h.shouldRedirect(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
+		return
+	}
+	wait, err := h.extractWait(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
+		return
+	}
+
+	id := h.resolveID(r.Context(), start, stop, false)
+	if !h.waitForDigest(r.Context(), id, wait) {
+		return
+	}
+	h.serveDigest(w, r, id, types.Window{Start: start, Stop: stop}, redirect)
+}
+
+// GetByID retrieves a digest by the ID returned when it was created
+func (h *DigesterHandler) GetByID(w http.ResponseWriter, r *http.Request) {
+	logger := h.LogProvider(r.Context())
+	id, err := extractID(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
+		return
+	}
+	redirect, err := h.shouldRedirect(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
+		return
+	}
+	wait, err := h.extractWait(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
+		return
+	}
+	if !h.waitForDigest(r.Context(), id, wait) {
+		return
+	}
+	h.serveDigest(w, r, id, types.Window{}, redirect)
+}
+
+// HeadByID reports whether a digest exists, without returning the digest body
+func (h *DigesterHandler) HeadByID(w http.ResponseWriter, r *http.Request) {
+	logger := h.LogProvider(r.Context())
+	id, err := extractID(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	exists, err := h.Storage.Exists(r.Context(), id)
 	switch err.(type) {
 	case nil:
-		defer body.Close()
 	case types.ErrInProgress:
 		w.WriteHeader(http.StatusNoContent)
 		return
-	case types.ErrNotFound:
-		logger.Info(logs.NotFound{Reason: err.Error()})
+	default:
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
 		w.WriteHeader(http.StatusNotFound)
 		return
-	default:
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteByID removes a digest, including any in progress state, so that it may be regenerated
+func (h *DigesterHandler) DeleteByID(w http.ResponseWriter, r *http.Request) {
+	logger := h.LogProvider(r.Context())
+	id, err := extractID(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
+		return
+	}
+	if err := h.Storage.Delete(r.Context(), id); err != nil {
 		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
-		writeJSONResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		common.WriteProblem(w, r, http.StatusInternalServerError, common.CodeInternalError, "", id)
+		return
+	}
+	h.logDeleted(r, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resolveID returns the ID of the digest for the window. This is the canonical ID unless LegacyLookup is enabled
+// and the digest only exists under its legacy ID. Regenerating a digest always uses the canonical ID, which
+// migrates it. Errors are left for the caller to encounter when using the canonical ID.
+func (h *DigesterHandler) resolveID(ctx context.Context, start, stop time.Time, force bool) string {
+	key := types.DigestKey{Start: start, Stop: stop, Scope: h.Scope}
+	id := key.ID()
+	if !h.LegacyLookup || force {
+		return id
+	}
+	if exists, err := h.Storage.Exists(ctx, id); err != nil || exists {
+		return id
+	}
+	legacyID := key.LegacyID()
+	if exists, err := h.Storage.Exists(ctx, legacyID); err == nil && exists {
+		return legacyID
+	}
+	return id
+}
+
+// serveDigest writes the digest identified by id to the response, either directly or as a redirect. The window
+// of the digest is recorded in the audit log; if it is not known, as for digests requested by ID, it is read from
+// the digest metadata.
+func (h *DigesterHandler) serveDigest(w http.ResponseWriter, r *http.Request, id string, window types.Window, redirect bool) {
+	started := time.Now()
+	if redirect && h.redirect(w, r, id, window, started) {
+		return
+	}
+	stat := h.StatProvider(r.Context())
+	body, err := h.Storage.Get(r.Context(), id)
+	metrics.TimeDependency(stat, logs.DependencyStorage, "get", started, err)
+	if err != nil {
+		stat.Count(metrics.DigestServed, 1, metrics.Status(metrics.StorageStatus(err)))
+		writeStorageError(w, r, h.LogProvider(r.Context()), id, err)
 		return
 	}
+	defer body.Close()
+	if known := h.setStaleHeader(w, r, id); window.Start.IsZero() {
+		window = known
+	}
 
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.WriteHeader(http.StatusOK)
-	_, _ = io.Copy(w, body)
+	n, _ := io.Copy(w, body)
+	stat.Count(metrics.DigestServed, 1, metrics.Status(metrics.StatusServed))
+	h.logServed(r, id, window, false, n, started)
 }
 
-// extractInput attempts to extract the start/stop query parameters required by GET and POST.
-// If either value is not a valid RFC3339Nano, an error is returned. Otherwise, start and stop
-// times are returned in the respective order. Additionally, it truncates the time values to the
-// nearest minute since anything with more precision doesn't really fit the digest filter use case
-func extractInput(r *http.Request) (time.Time, time.Time, error) {
-	startString := r.URL.Query().Get("start")
-	stopString := r.URL.Query().Get("stop")
-	start, err := time.Parse(time.RFC3339Nano, startString)
+// setStaleHeader reports, via the X-Digest-Stale header, whether the source data for the digest has changed
+// since it was created, in which case the digest is being regenerated. The window of the digest, as recorded in
+// its metadata, is returned so that digests requested by ID may be audited by window. The header is omitted, and
+// the window is zero, if the digest metadata is unavailable or the Storage cannot read metadata.
+func (h *DigesterHandler) setStaleHeader(w http.ResponseWriter, r *http.Request, id string) types.Window {
+	meta, err := common.Stat(r.Context(), h.Storage, id)
+	switch err.(type) {
+	case nil:
+		w.Header().Set(staleHeader, strconv.FormatBool(meta.Stale))
+		return types.Window{Start: meta.Start, Stop: meta.Stop}
+	case types.ErrNotFound, types.ErrUnsupported:
+	default:
+		h.LogProvider(r.Context()).Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+	}
+	return types.Window{}
+}
+
+// waitForDigest blocks for up to the given duration while the digest is in progress, so that clients need not
+// poll. The digest is then served as usual, which results in a 204 if it is still in progress. False is returned
+// if the request was cancelled while waiting, in which case no response should be written.
+func (h *DigesterHandler) waitForDigest(ctx context.Context, id string, wait time.Duration) bool {
+	watcher, ok := h.Marker.(types.MarkerWatcher)
+	if wait <= 0 || !ok {
+		return true
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	err := watcher.WaitUnmarked(waitCtx, id)
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil && err != context.DeadlineExceeded {
+		h.LogProvider(ctx).Error(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
+	}
+	return true
+}
+
+// extractWait extracts the optional "wait" query parameter, a duration such as "30s" for which to wait for an
+// in progress digest, capped at MaxWait
+func (h *DigesterHandler) extractWait(r *http.Request) (time.Duration, error) {
+	waitString := r.URL.Query().Get("wait")
+	if waitString == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(waitString)
 	if err != nil {
-		return time.Time{}, time.Time{}, err
+		return 0, err
+	}
+	if wait < 0 {
+		return 0, errors.New("wait should not be negative")
+	}
+	maxWait := h.MaxWait
+	if maxWait == 0 {
+		maxWait = defaultMaxWait
+	}
+	if wait > maxWait {
+		wait = maxWait
+	}
+	return wait, nil
+}
+
+// shouldRedirect determines whether the digest should be served as a redirect to a pre-signed URL. The
+// "redirect" query parameter, if present, takes precedence over the handler's configuration.
+func (h *DigesterHandler) shouldRedirect(r *http.Request) (bool, error) {
+	redirect := r.URL.Query().Get("redirect")
+	if redirect == "" {
+		return h.Redirect, nil
+	}
+	return strconv.ParseBool(redirect)
+}
+
+// redirect attempts to respond with a redirect to a pre-signed URL for the digest. If the Storage is not
+// capable of presigning URLs, no response is written and false is returned so that the caller may fall back
+// to proxying the digest.
+func (h *DigesterHandler) redirect(w http.ResponseWriter, r *http.Request, id string, window types.Window, started time.Time) bool {
+	presigner, ok := h.Storage.(types.Presigner)
+	if !ok {
+		return false
+	}
+	ttl := h.RedirectTTL
+	if ttl == 0 {
+		ttl = defaultRedirectTTL
+	}
+	location, err := presigner.Presign(r.Context(), id, ttl)
+	if _, ok := err.(types.ErrUnsupported); ok {
+		return false
 	}
-	stop, err := time.Parse(time.RFC3339Nano, stopString)
 	if err != nil {
-		return time.Time{}, time.Time{}, err
+		h.StatProvider(r.Context()).Count(metrics.DigestServed, 1, metrics.Status(metrics.StorageStatus(err)))
+		writeStorageError(w, r, h.LogProvider(r.Context()), id, err)
+		return true
 	}
-	if start.After(stop) {
-		return time.Time{}, time.Time{}, errors.New("start should be before stop")
+	if known := h.setStaleHeader(w, r, id); window.Start.IsZero() {
+		window = known
+	}
+	http.Redirect(w, r, location, http.StatusFound)
+	h.StatProvider(r.Context()).Count(metrics.DigestServed, 1, metrics.Status(metrics.StatusRedirected))
+	h.logServed(r, id, window, true, 0, started)
+	return true
+}
+
+// extractInput extracts the window from the start/stop query parameters required by GET and POST. The times
+// are parsed and truncated by types.ParseWindow.
+func extractInput(r *http.Request) (time.Time, time.Time, error) {
+	window, err := types.ParseWindow(r.URL.Query().Get("start"), r.URL.Query().Get("stop"))
+	return window.Start, window.Stop, err
+}
+
+// extractCallback extracts the optional "callback" query parameter, which is a URL to notify when the digest is
+// complete. An error is returned if callbacks are not enabled, or the URL is not allowed.
+func (h *DigesterHandler) extractCallback(r *http.Request) (string, error) {
+	callback := r.URL.Query().Get("callback")
+	if err := common.CheckCallback(h.Queuer, h.ValidateCallback, callback); err != nil {
+		return "", err
 	}
-	return start.Truncate(time.Minute), stop.Truncate(time.Minute), nil
+	return callback, nil
 }
 
-// computeID generates a UUID v5 from a name composed by appending start and stop time strings
-// in that order
-func computeID(start, stop time.Time) string {
-	name := start.String() + stop.String()
-	u := uuid.NewSHA1(digestNamespace, []byte(name))
-	return u.String()
+// extractForce extracts the optional "force" query parameter, which requests that an existing digest be regenerated
+func extractForce(r *http.Request) (bool, error) {
+	force := r.URL.Query().Get("force")
+	if force == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(force)
 }
 
-// write the http response with the given status code and message
-func writeJSONResponse(w http.ResponseWriter, statusCode int, message string) {
-	msg := struct {
-		Message string `json:"message"`
-	}{
-		Message: message,
+// extractID extracts the digest ID from the request path. An error is returned if the ID is not a valid UUID.
+func extractID(r *http.Request) (string, error) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return "", fmt.Errorf("invalid digest ID: %s", err.Error())
 	}
-	w.Header().Add("Content-type", "application/json")
+	return id.String(), nil
+}
+
+// writeJob writes a JSON response describing one or more jobs
+func writeJob(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	_ = json.NewEncoder(w).Encode(msg)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// writeStorageError translates an error returned from a Storage lookup into the appropriate response
+func writeStorageError(w http.ResponseWriter, r *http.Request, logger types.Logger, id string, err error) {
+	switch err.(type) {
+	case types.ErrInProgress:
+		w.WriteHeader(http.StatusNoContent)
+	case types.ErrNotFound:
+		logger.Info(logs.NotFound{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusNotFound, common.CodeDigestNotFound, err.Error(), id)
+	default:
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusInternalServerError, common.CodeInternalError, "", id)
+	}
 }
@@ -3,6 +3,7 @@ package v1
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
@@ -11,8 +12,12 @@ import (
 	"time"
 
 	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/ratelimit"
 	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/go-chi/chi"
 	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
 	"github.com/rs/xstats"
 	"github.com/stretchr/testify/assert"
 )
@@ -169,7 +174,7 @@ func TestGetHappyPath(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	start := time.Now().Format(time.RFC3339Nano)
+	start := time.Now().Add(-time.Hour).Format(time.RFC3339Nano)
 	stop := time.Now().Format(time.RFC3339Nano)
 	r, _ := http.NewRequest(http.MethodGet, "/", nil)
 	w := httptest.NewRecorder()
@@ -185,6 +190,7 @@ func TestGetHappyPath(t *testing.T) {
 
 	storageMock := NewMockStorage(ctrl)
 	storageMock.EXPECT().Get(gomock.Any(), gomock.Any()).Return(readCloser, nil)
+	storageMock.EXPECT().Stat(gomock.Any(), gomock.Any()).Return(types.DigestMetadata{}, nil)
 
 	h := DigesterHandler{
 		LogProvider:  logevent.FromContext,
@@ -206,7 +212,7 @@ func TestPostConflictInProgress(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	start := time.Now().Format(time.RFC3339Nano)
+	start := time.Now().Add(-time.Hour).Format(time.RFC3339Nano)
 	stop := time.Now().Format(time.RFC3339Nano)
 	r, _ := http.NewRequest(http.MethodPost, "/", nil)
 	w := httptest.NewRecorder()
@@ -228,13 +234,19 @@ func TestPostConflictInProgress(t *testing.T) {
 	h.Post(w, r)
 
 	assert.Equal(t, http.StatusConflict, w.Result().StatusCode)
+
+	var p common.Problem
+	assert.Nil(t, json.NewDecoder(w.Result().Body).Decode(&p))
+	assert.Equal(t, common.CodeDigestInProgress, p.Code)
+	assert.NotEmpty(t, p.DigestID)
+	assert.Equal(t, common.StatusInProgress, p.Conflict)
 }
 
 func TestPostConflictDigestCreated(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	start := time.Now().Format(time.RFC3339Nano)
+	start := time.Now().Add(-time.Hour).Format(time.RFC3339Nano)
 	stop := time.Now().Format(time.RFC3339Nano)
 	r, _ := http.NewRequest(http.MethodPost, "/", nil)
 	w := httptest.NewRecorder()
@@ -256,13 +268,19 @@ func TestPostConflictDigestCreated(t *testing.T) {
 	h.Post(w, r)
 
 	assert.Equal(t, http.StatusConflict, w.Result().StatusCode)
+
+	var p common.Problem
+	assert.Nil(t, json.NewDecoder(w.Result().Body).Decode(&p))
+	assert.Equal(t, common.CodeDigestExists, p.Code)
+	assert.NotEmpty(t, p.DigestID)
+	assert.Equal(t, common.StatusExists, p.Conflict)
 }
 
 func TestPostStorageError(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	start := time.Now().Format(time.RFC3339Nano)
+	start := time.Now().Add(-time.Hour).Format(time.RFC3339Nano)
 	stop := time.Now().Format(time.RFC3339Nano)
 	r, _ := http.NewRequest(http.MethodPost, "/", nil)
 	w := httptest.NewRecorder()
@@ -290,7 +308,7 @@ func TestPostQueueError(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	start := time.Now()
+	start := time.Now().Add(-time.Hour)
 	stop := time.Now()
 	r, _ := http.NewRequest(http.MethodPost, "/", nil)
 	w := httptest.NewRecorder()
@@ -324,7 +342,7 @@ func TestPostHappyPath(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	start := time.Now()
+	start := time.Now().Add(-time.Hour)
 	stop := time.Now()
 	r, _ := http.NewRequest(http.MethodPost, "/", nil)
 	w := httptest.NewRecorder()
@@ -361,7 +379,7 @@ func TestPostUnsuccessfulMark(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	start := time.Now()
+	start := time.Now().Add(-time.Hour)
 	stop := time.Now()
 	r, _ := http.NewRequest(http.MethodPost, "/", nil)
 	w := httptest.NewRecorder()
@@ -394,3 +412,1137 @@ func TestPostUnsuccessfulMark(t *testing.T) {
 	// Shouldn't blow up
 	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
 }
+
+type presignStorage struct {
+	*MockStorage
+	Location string
+	Err      error
+}
+
+func (s *presignStorage) Presign(_ context.Context, _ string, _ time.Duration) (string, error) {
+	return s.Location, s.Err
+}
+
+func newGetRequest(start, stop time.Time, redirect string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	q := r.URL.Query()
+	q.Set("start", start.Format(time.RFC3339Nano))
+	q.Set("stop", stop.Format(time.RFC3339Nano))
+	if redirect != "" {
+		q.Set("redirect", redirect)
+	}
+	r.URL.RawQuery = q.Encode()
+	return r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+}
+
+func TestGetRedirect(t *testing.T) {
+	tc := []struct {
+		Name             string
+		Configured       bool
+		Param            string
+		ExpectedRedirect bool
+	}{
+		{
+			Name:             "configured",
+			Configured:       true,
+			ExpectedRedirect: true,
+		},
+		{
+			Name:             "query_param",
+			Param:            "true",
+			ExpectedRedirect: true,
+		},
+		{
+			Name:             "query_param_override",
+			Configured:       true,
+			Param:            "false",
+			ExpectedRedirect: false,
+		},
+		{
+			Name:             "default",
+			ExpectedRedirect: false,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			location := "https://bucket.s3.amazonaws.com/digest?X-Amz-Signature=abc"
+			storageMock := &presignStorage{MockStorage: NewMockStorage(ctrl), Location: location}
+			storageMock.EXPECT().Stat(gomock.Any(), gomock.Any()).Return(types.DigestMetadata{}, nil)
+			if !tt.ExpectedRedirect {
+				storageMock.EXPECT().Get(gomock.Any(), gomock.Any()).Return(ioutil.NopCloser(bytes.NewReader([]byte("digest"))), nil)
+			}
+			h := DigesterHandler{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+				Storage:      storageMock,
+				Redirect:     tt.Configured,
+			}
+			w := httptest.NewRecorder()
+			h.Get(w, newGetRequest(time.Now().Add(-time.Hour), time.Now(), tt.Param))
+			if !tt.ExpectedRedirect {
+				assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+				return
+			}
+			assert.Equal(t, http.StatusFound, w.Result().StatusCode)
+			assert.Equal(t, location, w.Result().Header.Get("Location"))
+		})
+	}
+}
+
+func TestGetRedirectBadParam(t *testing.T) {
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+	}
+	w := httptest.NewRecorder()
+	h.Get(w, newGetRequest(time.Now().Add(-time.Hour), time.Now(), "maybe"))
+	assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+}
+
+func TestGetRedirectFallsBackToProxy(t *testing.T) {
+	tc := []struct {
+		Name    string
+		Storage func(*MockStorage) types.Storage
+	}{
+		{
+			Name:    "not_a_presigner",
+			Storage: func(m *MockStorage) types.Storage { return m },
+		},
+		{
+			Name: "unsupported",
+			Storage: func(m *MockStorage) types.Storage {
+				return &presignStorage{MockStorage: m, Err: types.ErrUnsupported{Operation: "presign"}}
+			},
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			data := "this is the digest you're looking for"
+			storageMock := NewMockStorage(ctrl)
+			storageMock.EXPECT().Get(gomock.Any(), gomock.Any()).Return(ioutil.NopCloser(bytes.NewReader([]byte(data))), nil)
+			storageMock.EXPECT().Stat(gomock.Any(), gomock.Any()).Return(types.DigestMetadata{}, nil)
+			h := DigesterHandler{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+				Storage:      tt.Storage(storageMock),
+				Redirect:     true,
+			}
+			w := httptest.NewRecorder()
+			h.Get(w, newGetRequest(time.Now().Add(-time.Hour), time.Now(), ""))
+			assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+			result, _ := ioutil.ReadAll(w.Result().Body)
+			assert.Equal(t, data, string(result))
+		})
+	}
+}
+
+func TestGetRedirectStorageErrors(t *testing.T) {
+	tc := []struct {
+		Name               string
+		Error              error
+		ExpectedStatusCode int
+	}{
+		{
+			Name:               "in_progress",
+			Error:              types.ErrInProgress{},
+			ExpectedStatusCode: http.StatusNoContent,
+		},
+		{
+			Name:               "not_found",
+			Error:              types.ErrNotFound{},
+			ExpectedStatusCode: http.StatusNotFound,
+		},
+		{
+			Name:               "unknown",
+			Error:              errors.New("oops"),
+			ExpectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			h := DigesterHandler{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+				Storage:      &presignStorage{MockStorage: NewMockStorage(ctrl), Err: tt.Error},
+				Redirect:     true,
+			}
+			w := httptest.NewRecorder()
+			h.Get(w, newGetRequest(time.Now().Add(-time.Hour), time.Now(), ""))
+			assert.Equal(t, tt.ExpectedStatusCode, w.Result().StatusCode)
+		})
+	}
+}
+
+func newIDRequest(method string, id string) *http.Request {
+	r, _ := http.NewRequest(method, "/digests/"+id, nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	ctx := context.WithValue(context.Background(), chi.RouteCtxKey, rctx)
+	return r.WithContext(logevent.NewContext(ctx, logevent.New(logevent.Config{Output: ioutil.Discard})))
+}
+
+func TestByIDBadRequest(t *testing.T) {
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+	}
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodDelete} {
+		t.Run(method, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := newIDRequest(method, "not-a-uuid")
+			switch method {
+			case http.MethodGet:
+				h.GetByID(w, r)
+			case http.MethodHead:
+				h.HeadByID(w, r)
+			case http.MethodDelete:
+				h.DeleteByID(w, r)
+			}
+			assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+		})
+	}
+}
+
+func TestGetByID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	id := uuid.New().String()
+	data := "this is the digest you're looking for"
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Get(gomock.Any(), id).Return(ioutil.NopCloser(bytes.NewReader([]byte(data))), nil)
+	storageMock.EXPECT().Stat(gomock.Any(), id).Return(types.DigestMetadata{ID: id, Stale: true}, nil)
+
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+	}
+	w := httptest.NewRecorder()
+	h.GetByID(w, newIDRequest(http.MethodGet, id))
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, "true", w.Result().Header.Get("X-Digest-Stale"))
+	result, _ := ioutil.ReadAll(w.Result().Body)
+	assert.Equal(t, data, string(result))
+}
+
+func TestGetByIDStorageErrors(t *testing.T) {
+	tc := []struct {
+		Name               string
+		Error              error
+		ExpectedStatusCode int
+	}{
+		{
+			Name:               "in_progress",
+			Error:              types.ErrInProgress{},
+			ExpectedStatusCode: http.StatusNoContent,
+		},
+		{
+			Name:               "not_found",
+			Error:              types.ErrNotFound{},
+			ExpectedStatusCode: http.StatusNotFound,
+		},
+		{
+			Name:               "unknown",
+			Error:              errors.New("oops"),
+			ExpectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			id := uuid.New().String()
+			storageMock := NewMockStorage(ctrl)
+			storageMock.EXPECT().Get(gomock.Any(), id).Return(nil, tt.Error)
+
+			h := DigesterHandler{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+				Storage:      storageMock,
+			}
+			w := httptest.NewRecorder()
+			h.GetByID(w, newIDRequest(http.MethodGet, id))
+			assert.Equal(t, tt.ExpectedStatusCode, w.Result().StatusCode)
+		})
+	}
+}
+
+func TestHeadByID(t *testing.T) {
+	tc := []struct {
+		Name               string
+		Exists             bool
+		Error              error
+		ExpectedStatusCode int
+	}{
+		{
+			Name:               "exists",
+			Exists:             true,
+			ExpectedStatusCode: http.StatusOK,
+		},
+		{
+			Name:               "not_exists",
+			ExpectedStatusCode: http.StatusNotFound,
+		},
+		{
+			Name:               "in_progress",
+			Error:              types.ErrInProgress{},
+			ExpectedStatusCode: http.StatusNoContent,
+		},
+		{
+			Name:               "unknown",
+			Error:              errors.New("oops"),
+			ExpectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			id := uuid.New().String()
+			storageMock := NewMockStorage(ctrl)
+			storageMock.EXPECT().Exists(gomock.Any(), id).Return(tt.Exists, tt.Error)
+
+			h := DigesterHandler{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+				Storage:      storageMock,
+			}
+			w := httptest.NewRecorder()
+			h.HeadByID(w, newIDRequest(http.MethodHead, id))
+			assert.Equal(t, tt.ExpectedStatusCode, w.Result().StatusCode)
+			assert.Empty(t, w.Body.Bytes())
+		})
+	}
+}
+
+func TestDeleteByID(t *testing.T) {
+	tc := []struct {
+		Name               string
+		Error              error
+		ExpectedStatusCode int
+	}{
+		{
+			Name:               "success",
+			ExpectedStatusCode: http.StatusNoContent,
+		},
+		{
+			Name:               "error",
+			Error:              errors.New("oops"),
+			ExpectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			id := uuid.New().String()
+			storageMock := NewMockStorage(ctrl)
+			storageMock.EXPECT().Delete(gomock.Any(), id).Return(tt.Error)
+
+			h := DigesterHandler{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+				Storage:      storageMock,
+			}
+			w := httptest.NewRecorder()
+			h.DeleteByID(w, newIDRequest(http.MethodDelete, id))
+			assert.Equal(t, tt.ExpectedStatusCode, w.Result().StatusCode)
+		})
+	}
+}
+
+func newPostRequest(start, stop time.Time, force string) *http.Request {
+	r, _ := http.NewRequest(http.MethodPost, "/", nil)
+	q := r.URL.Query()
+	q.Set("start", start.Format(time.RFC3339Nano))
+	q.Set("stop", stop.Format(time.RFC3339Nano))
+	if force != "" {
+		q.Set("force", force)
+	}
+	r.URL.RawQuery = q.Encode()
+	return r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+}
+
+func TestPostRateLimited(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, nil).Times(3)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(true, nil)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("oops"))
+	queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(nil)
+
+	now := time.Unix(1500000000, 0)
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		Queuer:       queuerMock,
+		Marker:       markerMock,
+		Limiter: &ratelimit.Limiter{Limits: &ratelimit.Limits{
+			Default: ratelimit.Limit{PerMinute: 1},
+			Now:     func() time.Time { return now },
+		}},
+	}
+
+	// the charge for a job which could not be queued is refunded
+	w := httptest.NewRecorder()
+	h.Post(w, newPostRequest(start, start.Add(time.Hour), ""))
+	assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+	w = httptest.NewRecorder()
+	h.Post(w, newPostRequest(start, start.Add(time.Hour), ""))
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+
+	w = httptest.NewRecorder()
+	h.Post(w, newPostRequest(start, start.Add(time.Hour), ""))
+	assert.Equal(t, http.StatusTooManyRequests, w.Result().StatusCode)
+	assert.Equal(t, "60", w.Result().Header.Get("Retry-After"))
+	var p common.Problem
+	assert.Nil(t, json.NewDecoder(w.Result().Body).Decode(&p))
+	assert.Equal(t, common.CodeRateLimited, p.Code)
+
+	// digests which already exist are not charged
+	w = httptest.NewRecorder()
+	h.Post(w, newPostRequest(start, start.Add(time.Hour), ""))
+	assert.Equal(t, http.StatusConflict, w.Result().StatusCode)
+}
+
+func TestPostSplitRateLimited(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, nil).Times(5)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+
+	now := time.Unix(1500000000, 0)
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		Queuer:       queuerMock,
+		Marker:       markerMock,
+		Policy:       types.WindowPolicy{MaxWindow: time.Hour, Split: true},
+		Limiter: &ratelimit.Limiter{Limits: &ratelimit.Limits{
+			Default: ratelimit.Limit{PerMinute: 2},
+			Now:     func() time.Time { return now },
+		}},
+	}
+
+	// each window of a split request is charged
+	w := httptest.NewRecorder()
+	h.Post(w, newPostRequest(start, start.Add(3*time.Hour), ""))
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+	var res jobList
+	assert.Nil(t, json.NewDecoder(w.Result().Body).Decode(&res))
+	statuses := make([]string, 0, len(res.Digests))
+	for _, j := range res.Digests {
+		statuses = append(statuses, j.Status)
+	}
+	assert.Equal(t, []string{common.StatusQueued, common.StatusQueued, common.StatusRateLimited}, statuses)
+
+	w = httptest.NewRecorder()
+	h.Post(w, newPostRequest(start, start.Add(2*time.Hour), ""))
+	assert.Equal(t, http.StatusTooManyRequests, w.Result().StatusCode)
+	assert.Equal(t, "30", w.Result().Header.Get("Retry-After"))
+}
+
+func TestPostForceRegeneratesExisting(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(true, nil)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(nil)
+
+	authorized := false
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		Queuer:       queuerMock,
+		Marker:       markerMock,
+		AuthorizeForce: func(*http.Request) error {
+			authorized = true
+			return nil
+		},
+	}
+	w := httptest.NewRecorder()
+	h.Post(w, newPostRequest(time.Now().Add(-time.Hour), time.Now(), "true"))
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+	assert.True(t, authorized)
+}
+
+func TestPostForceInProgress(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, types.ErrInProgress{})
+
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+	}
+	w := httptest.NewRecorder()
+	h.Post(w, newPostRequest(time.Now().Add(-time.Hour), time.Now(), "true"))
+	assert.Equal(t, http.StatusConflict, w.Result().StatusCode)
+}
+
+func TestPostForceForbidden(t *testing.T) {
+	h := DigesterHandler{
+		LogProvider:    logevent.FromContext,
+		StatProvider:   xstats.FromContext,
+		AuthorizeForce: func(*http.Request) error { return errors.New("nope") },
+	}
+	w := httptest.NewRecorder()
+	h.Post(w, newPostRequest(time.Now().Add(-time.Hour), time.Now(), "true"))
+	assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+}
+
+func TestPostForceBadParam(t *testing.T) {
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+	}
+	w := httptest.NewRecorder()
+	h.Post(w, newPostRequest(time.Now().Add(-time.Hour), time.Now(), "please"))
+	assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+}
+
+func TestGetStaleHeaderStatError(t *testing.T) {
+	tc := []struct {
+		Name  string
+		Error error
+	}{
+		{
+			Name:  "not_found",
+			Error: types.ErrNotFound{},
+		},
+		{
+			Name:  "unknown",
+			Error: errors.New("oops"),
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			storageMock := NewMockStorage(ctrl)
+			storageMock.EXPECT().Get(gomock.Any(), gomock.Any()).Return(ioutil.NopCloser(bytes.NewReader([]byte("digest"))), nil)
+			storageMock.EXPECT().Stat(gomock.Any(), gomock.Any()).Return(types.DigestMetadata{}, tt.Error)
+
+			h := DigesterHandler{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+				Storage:      storageMock,
+			}
+			w := httptest.NewRecorder()
+			h.Get(w, newGetRequest(time.Now().Add(-time.Hour), time.Now(), ""))
+			assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+			assert.Empty(t, w.Result().Header.Get("X-Digest-Stale"))
+		})
+	}
+}
+
+func TestPostWindowRejected(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	tc := []struct {
+		Name   string
+		Start  time.Time
+		Stop   time.Time
+		Policy types.WindowPolicy
+	}{
+		{
+			Name:  "future",
+			Start: now.Add(-time.Hour),
+			Stop:  now.Add(7 * 24 * time.Hour),
+		},
+		{
+			Name:   "unsettled",
+			Start:  now.Add(-time.Hour),
+			Stop:   now.Add(-time.Minute),
+			Policy: types.WindowPolicy{SettleLag: 10 * time.Minute},
+		},
+		{
+			Name:   "lookback",
+			Start:  now.Add(-48 * time.Hour),
+			Stop:   now.Add(-47 * time.Hour),
+			Policy: types.WindowPolicy{MaxLookback: 24 * time.Hour},
+		},
+		{
+			Name:   "too_large",
+			Start:  now.Add(-48 * time.Hour),
+			Stop:   now.Add(-time.Hour),
+			Policy: types.WindowPolicy{MaxWindow: time.Hour},
+		},
+		{
+			Name:   "too_many_splits",
+			Start:  now.Add(-48 * time.Hour),
+			Stop:   now.Add(-time.Hour),
+			Policy: types.WindowPolicy{MaxWindow: time.Hour, Split: true, MaxSplit: 10},
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			h := DigesterHandler{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+				Policy:       tt.Policy,
+			}
+			w := httptest.NewRecorder()
+			h.Post(w, newPostRequest(tt.Start, tt.Stop, ""))
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Result().StatusCode)
+		})
+	}
+}
+
+func TestPostSplit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	stop := start.Add(150 * time.Minute)
+	windows := []types.Window{
+		{Start: start, Stop: start.Add(time.Hour)},
+		{Start: start.Add(time.Hour), Stop: start.Add(2 * time.Hour)},
+		{Start: start.Add(2 * time.Hour), Stop: stop},
+	}
+
+	storageMock := NewMockStorage(ctrl)
+	queuerMock := NewMockQueuer(ctrl)
+	markerMock := NewMockMarker(ctrl)
+	ids := make([]string, 0, len(windows))
+	for _, window := range windows {
+		ids = append(ids, types.DigestKey{Start: window.Start, Stop: window.Stop}.ID())
+	}
+	storageMock.EXPECT().Exists(gomock.Any(), ids[0]).Return(true, nil)
+	storageMock.EXPECT().Exists(gomock.Any(), ids[1]).Return(false, types.ErrInProgress{})
+	storageMock.EXPECT().Exists(gomock.Any(), ids[2]).Return(false, nil)
+	queuerMock.EXPECT().Queue(gomock.Any(), ids[2], &timeMatcher{windows[2].Start}, &timeMatcher{windows[2].Stop}).Return(nil)
+	markerMock.EXPECT().Mark(gomock.Any(), ids[2]).Return(nil)
+
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		Queuer:       queuerMock,
+		Marker:       markerMock,
+		Policy:       types.WindowPolicy{MaxWindow: time.Hour, Split: true},
+	}
+	w := httptest.NewRecorder()
+	h.Post(w, newPostRequest(start, stop, ""))
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+
+	var res jobList
+	assert.Nil(t, json.NewDecoder(w.Result().Body).Decode(&res))
+	expected := make([]job, 0, len(windows))
+	for i, status := range []string{common.StatusExists, common.StatusInProgress, common.StatusQueued} {
+		expected = append(expected, job{
+			ID:       ids[i],
+			Start:    common.FormatTime(windows[i].Start),
+			Stop:     common.FormatTime(windows[i].Stop),
+			Status:   status,
+			Location: "/digests/" + ids[i],
+		})
+	}
+	assert.Equal(t, expected, res.Digests)
+}
+
+func TestPostSplitConflict(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(true, nil).Times(2)
+
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		Policy:       types.WindowPolicy{MaxWindow: time.Hour, Split: true},
+	}
+	w := httptest.NewRecorder()
+	h.Post(w, newPostRequest(start, start.Add(2*time.Hour), ""))
+	assert.Equal(t, http.StatusConflict, w.Result().StatusCode)
+}
+
+func TestPostSplitQueueError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	windows := []types.Window{
+		{Start: start, Stop: start.Add(time.Hour)},
+		{Start: start.Add(time.Hour), Stop: start.Add(2 * time.Hour)},
+	}
+	ids := []string{
+		types.DigestKey{Start: windows[0].Start, Stop: windows[0].Stop}.ID(),
+		types.DigestKey{Start: windows[1].Start, Stop: windows[1].Stop}.ID(),
+	}
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, nil).Times(2)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), ids[0], gomock.Any(), gomock.Any()).Return(errors.New("oops"))
+	queuerMock.EXPECT().Queue(gomock.Any(), ids[1], gomock.Any(), gomock.Any()).Return(nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), ids[1]).Return(nil)
+
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		Queuer:       queuerMock,
+		Marker:       markerMock,
+		Policy:       types.WindowPolicy{MaxWindow: time.Hour, Split: true},
+	}
+	w := httptest.NewRecorder()
+	h.Post(w, newPostRequest(start, start.Add(2*time.Hour), ""))
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+
+	var res jobList
+	assert.Nil(t, json.NewDecoder(w.Result().Body).Decode(&res))
+	assert.Equal(t, []job{
+		{
+			ID:       ids[0],
+			Start:    common.FormatTime(windows[0].Start),
+			Stop:     common.FormatTime(windows[0].Stop),
+			Status:   statusFailed,
+			Location: "/digests/" + ids[0],
+			Error:    "the digest could not be queued",
+		},
+		{
+			ID:       ids[1],
+			Start:    common.FormatTime(windows[1].Start),
+			Stop:     common.FormatTime(windows[1].Stop),
+			Status:   common.StatusQueued,
+			Location: "/digests/" + ids[1],
+		},
+	}, res.Digests)
+}
+
+func TestPostSplitAllFailed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(true, nil)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, nil)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("oops"))
+
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		Queuer:       queuerMock,
+		Policy:       types.WindowPolicy{MaxWindow: time.Hour, Split: true},
+	}
+	w := httptest.NewRecorder()
+	h.Post(w, newPostRequest(start, start.Add(2*time.Hour), ""))
+	assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+}
+
+func TestGetLegacyLookup(t *testing.T) {
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	stop := start.Add(time.Hour)
+	scope := types.Scope{Accounts: []string{"123"}, Regions: []string{"us-west-2"}}
+	key := types.DigestKey{Start: start, Stop: stop, Scope: scope}
+
+	tc := []struct {
+		Name           string
+		CanonicalFound bool
+		LegacyFound    bool
+		ExpectedID     string
+	}{
+		{
+			Name:           "canonical",
+			CanonicalFound: true,
+			ExpectedID:     key.ID(),
+		},
+		{
+			Name:        "legacy",
+			LegacyFound: true,
+			ExpectedID:  key.LegacyID(),
+		},
+		{
+			Name:       "neither",
+			ExpectedID: key.ID(),
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			storageMock := NewMockStorage(ctrl)
+			storageMock.EXPECT().Exists(gomock.Any(), key.ID()).Return(tt.CanonicalFound, nil)
+			if !tt.CanonicalFound {
+				storageMock.EXPECT().Exists(gomock.Any(), key.LegacyID()).Return(tt.LegacyFound, nil)
+			}
+			storageMock.EXPECT().Get(gomock.Any(), tt.ExpectedID).Return(nil, types.ErrNotFound{ID: tt.ExpectedID})
+
+			h := DigesterHandler{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+				Storage:      storageMock,
+				Scope:        scope,
+				LegacyLookup: true,
+			}
+			w := httptest.NewRecorder()
+			h.Get(w, newGetRequest(start, stop, ""))
+			assert.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+		})
+	}
+}
+
+func TestPostLegacyLookup(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	key := types.DigestKey{Start: start, Stop: start.Add(time.Hour)}
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), key.ID()).Return(false, nil)
+	storageMock.EXPECT().Exists(gomock.Any(), key.LegacyID()).Return(true, nil).Times(2)
+
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		LegacyLookup: true,
+	}
+	w := httptest.NewRecorder()
+	h.Post(w, newPostRequest(key.Start, key.Stop, ""))
+	assert.Equal(t, http.StatusConflict, w.Result().StatusCode)
+}
+
+func TestPostForceUsesCanonicalID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	zone := time.FixedZone("", 60*60)
+	start := time.Date(2019, time.January, 1, 1, 0, 0, 0, zone)
+	key := types.DigestKey{Start: start, Stop: start.Add(time.Hour)}
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), key.ID()).Return(true, nil)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), key.ID(), start.UTC(), start.Add(time.Hour).UTC()).Return(nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), key.ID()).Return(nil)
+
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		Queuer:       queuerMock,
+		Marker:       markerMock,
+		LegacyLookup: true,
+	}
+	w := httptest.NewRecorder()
+	h.Post(w, newPostRequest(key.Start, key.Stop, "true"))
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+}
+
+func TestPostJobMetadata(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	zone := time.FixedZone("", -7*60*60)
+	start := time.Date(2019, time.January, 1, 0, 0, 30, 0, zone)
+	stop := time.Date(2019, time.January, 1, 1, 30, 30, 0, zone)
+	id := types.DigestKey{Start: start.Truncate(time.Minute), Stop: stop.Truncate(time.Minute)}.ID()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), id).Return(false, nil)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), id, gomock.Any(), gomock.Any()).Return(nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), id).Return(nil)
+	publisher := &recordingPublisher{}
+
+	h := DigesterHandler{
+		LogProvider:              logevent.FromContext,
+		StatProvider:             xstats.FromContext,
+		Storage:                  storageMock,
+		Queuer:                   queuerMock,
+		Marker:                   markerMock,
+		EstimatedDurationPerHour: time.Minute,
+		Events:                   publisher,
+	}
+	w := httptest.NewRecorder()
+	before := time.Now()
+	h.Post(w, newPostRequest(start, stop, ""))
+
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+	assert.Len(t, publisher.Events, 1)
+	assert.Equal(t, types.EventQueued, publisher.Events[0].Type)
+	assert.Equal(t, id, publisher.Events[0].DigestID)
+	assert.Equal(t, "/digests/"+id, w.Result().Header.Get("Location"))
+	assert.Equal(t, "application/json", w.Result().Header.Get("Content-Type"))
+	var res job
+	assert.Nil(t, json.NewDecoder(w.Result().Body).Decode(&res))
+	assert.Equal(t, id, res.ID)
+	assert.Equal(t, "2019-01-01T07:00:00Z", res.Start)
+	assert.Equal(t, "2019-01-01T08:30:00Z", res.Stop)
+	assert.Equal(t, common.StatusQueued, res.Status)
+	assert.Equal(t, "/digests/"+id, res.Location)
+	estimate, err := time.Parse(time.RFC3339Nano, res.EstimatedCompletion)
+	assert.Nil(t, err)
+	assert.False(t, estimate.Before(before.Add(2*time.Minute)))
+	assert.False(t, estimate.After(time.Now().Add(2*time.Minute)))
+}
+
+type callbackQueuer struct {
+	*MockQueuer
+	Callback string
+}
+
+func (q *callbackQueuer) QueueWithCallback(ctx context.Context, id string, start, stop time.Time, callback string) error {
+	q.Callback = callback
+	return q.MockQueuer.Queue(ctx, id, start, stop)
+}
+
+func newCallbackPostRequest(start, stop time.Time, callback string) *http.Request {
+	r := newPostRequest(start, stop, "")
+	q := r.URL.Query()
+	q.Set("callback", callback)
+	r.URL.RawQuery = q.Encode()
+	return r
+}
+
+func TestPostCallback(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, nil)
+	queuer := &callbackQueuer{MockQueuer: NewMockQueuer(ctrl)}
+	queuer.EXPECT().Queue(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(nil)
+
+	h := DigesterHandler{
+		LogProvider:      logevent.FromContext,
+		StatProvider:     xstats.FromContext,
+		Storage:          storageMock,
+		Queuer:           queuer,
+		Marker:           markerMock,
+		ValidateCallback: func(string) error { return nil },
+	}
+	w := httptest.NewRecorder()
+	h.Post(w, newCallbackPostRequest(time.Now().Add(-time.Hour), time.Now(), "https://hooks.example.com"))
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+	assert.Equal(t, "https://hooks.example.com", queuer.Callback)
+}
+
+func TestPostCallbackRejected(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tc := []struct {
+		Name     string
+		Queuer   types.Queuer
+		Validate func(string) error
+	}{
+		{
+			Name:   "not_enabled",
+			Queuer: &callbackQueuer{MockQueuer: NewMockQueuer(ctrl)},
+		},
+		{
+			Name:     "not_supported",
+			Queuer:   NewMockQueuer(ctrl),
+			Validate: func(string) error { return nil },
+		},
+		{
+			Name:     "not_allowed",
+			Queuer:   &callbackQueuer{MockQueuer: NewMockQueuer(ctrl)},
+			Validate: func(string) error { return errors.New("not allowed") },
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			h := DigesterHandler{
+				LogProvider:      logevent.FromContext,
+				StatProvider:     xstats.FromContext,
+				Queuer:           tt.Queuer,
+				ValidateCallback: tt.Validate,
+			}
+			w := httptest.NewRecorder()
+			h.Post(w, newCallbackPostRequest(time.Now().Add(-time.Hour), time.Now(), "https://hooks.example.com"))
+			assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+
+			var p common.Problem
+			assert.Nil(t, json.NewDecoder(w.Result().Body).Decode(&p))
+			assert.Equal(t, common.CodeCallbackRejected, p.Code)
+		})
+	}
+}
+
+type watchingMarker struct {
+	*MockMarker
+	waitUnmarked func(ctx context.Context, key string) error
+}
+
+func (m *watchingMarker) WaitUnmarked(ctx context.Context, key string) error {
+	return m.waitUnmarked(ctx, key)
+}
+
+func newWaitRequest(id string, wait string) *http.Request {
+	r := newIDRequest(http.MethodGet, id)
+	q := r.URL.Query()
+	q.Set("wait", wait)
+	r.URL.RawQuery = q.Encode()
+	return r
+}
+
+func TestGetByIDWait(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	id := uuid.New().String()
+	data := "this is the digest you're looking for"
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Get(gomock.Any(), id).Return(ioutil.NopCloser(bytes.NewReader([]byte(data))), nil)
+	storageMock.EXPECT().Stat(gomock.Any(), id).Return(types.DigestMetadata{ID: id}, nil)
+
+	var waited time.Duration
+	marker := &watchingMarker{
+		MockMarker: NewMockMarker(ctrl),
+		waitUnmarked: func(ctx context.Context, key string) error {
+			assert.Equal(t, id, key)
+			deadline, _ := ctx.Deadline()
+			waited = time.Until(deadline)
+			return nil
+		},
+	}
+
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		Marker:       marker,
+	}
+	w := httptest.NewRecorder()
+	h.GetByID(w, newWaitRequest(id, "1m"))
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.True(t, waited > 0 && waited <= defaultMaxWait, "wait was not capped: %s", waited)
+	result, _ := ioutil.ReadAll(w.Result().Body)
+	assert.Equal(t, data, string(result))
+}
+
+func TestGetByIDWaitExpired(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	id := uuid.New().String()
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Get(gomock.Any(), id).Return(nil, types.ErrInProgress{Key: id})
+
+	marker := &watchingMarker{
+		MockMarker: NewMockMarker(ctrl),
+		waitUnmarked: func(ctx context.Context, key string) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		Marker:       marker,
+	}
+	w := httptest.NewRecorder()
+	h.GetByID(w, newWaitRequest(id, "10ms"))
+	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+}
+
+func TestGetByIDWaitCancelled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	id := uuid.New().String()
+	marker := &watchingMarker{
+		MockMarker: NewMockMarker(ctrl),
+		waitUnmarked: func(ctx context.Context, key string) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      NewMockStorage(ctrl),
+		Marker:       marker,
+	}
+	r := newWaitRequest(id, "30s")
+	ctx, cancel := context.WithCancel(r.Context())
+	cancel()
+	w := httptest.NewRecorder()
+	h.GetByID(w, r.WithContext(ctx))
+	assert.False(t, w.Flushed)
+	assert.Empty(t, w.Body.Bytes())
+}
+
+func TestGetWaitBadParam(t *testing.T) {
+	for _, wait := range []string{"soon", "-1s"} {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		q := r.URL.Query()
+		q.Set("start", time.Now().Add(-time.Hour).Format(time.RFC3339Nano))
+		q.Set("stop", time.Now().Format(time.RFC3339Nano))
+		q.Set("wait", wait)
+		r.URL.RawQuery = q.Encode()
+		r = r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+
+		h := DigesterHandler{
+			LogProvider:  logevent.FromContext,
+			StatProvider: xstats.FromContext,
+		}
+		w := httptest.NewRecorder()
+		h.Get(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode, wait)
+	}
+}
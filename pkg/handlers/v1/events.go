@@ -0,0 +1,106 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+)
+
+const (
+	defaultHeartbeat  = 15 * time.Second
+	lastEventIDHeader = "Last-Event-ID"
+)
+
+// event is the data of a Server-Sent Event describing a change to the state of a digest job
+type event struct {
+	Type     string `json:"type"`
+	DigestID string `json:"digestId"`
+	Start    string `json:"start,omitempty"`
+	Stop     string `json:"stop,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Time     string `json:"time"`
+}
+
+// Events is a handler which streams changes to the state of digest jobs as Server-Sent Events
+type Events struct {
+	Subscriber types.Subscriber
+	// Heartbeat is the interval at which comments are sent to keep idle connections open. Defaults to 15 seconds.
+	Heartbeat time.Duration
+}
+
+// ServeHTTP streams events until the client disconnects. The stream may be limited to particular digests with
+// one or more "id" query parameters. Clients which reconnect with the Last-Event-ID header, or the lastEventId
+// query parameter, are first sent the retained events they missed. If the client falls too far behind, the
+// stream ends and the client should reconnect.
+func (h *Events) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		common.WriteProblem(w, r, http.StatusInternalServerError, common.CodeInternalError, "streaming is not supported", "")
+		return
+	}
+	ids := make(map[string]bool)
+	for _, id := range r.URL.Query()["id"] {
+		ids[id] = true
+	}
+	lastEventID := r.Header.Get(lastEventIDHeader)
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+
+	replay, events, cancel := h.Subscriber.Subscribe(lastEventID)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	for _, e := range replay {
+		if len(ids) == 0 || ids[e.DigestID] {
+			writeEvent(w, e)
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := h.Heartbeat
+	if heartbeat == 0 {
+		heartbeat = defaultHeartbeat
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			_, _ = fmt.Fprint(w, ": heartbeat\n\n")
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if len(ids) > 0 && !ids[e.DigestID] {
+				continue
+			}
+			writeEvent(w, e)
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e types.Event) {
+	data := event{
+		Type:     e.Type,
+		DigestID: e.DigestID,
+		Error:    e.Error,
+		Time:     common.FormatTime(e.Time),
+	}
+	if !e.Start.IsZero() {
+		data.Start = common.FormatTime(e.Start)
+		data.Stop = common.FormatTime(e.Stop)
+	}
+	b, _ := json.Marshal(data)
+	_, _ = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, b)
+}
@@ -0,0 +1,71 @@
+package v1
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/events"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventsReplayFiltered(t *testing.T) {
+	bus := &events.Bus{}
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	bus.Publish(context.Background(), types.Event{Type: types.EventQueued, DigestID: "a", Start: start, Stop: start.Add(time.Hour)})
+	bus.Publish(context.Background(), types.Event{Type: types.EventQueued, DigestID: "b"})
+	bus.Publish(context.Background(), types.Event{Type: types.EventFailed, DigestID: "a", Error: "oops"})
+
+	r, _ := http.NewRequest(http.MethodGet, "/events?id=a", nil)
+	r.Header.Set("Last-Event-ID", "0-0")
+	ctx, cancel := context.WithCancel(context.Background())
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		(&Events{Subscriber: bus}).ServeHTTP(w, r.WithContext(ctx))
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	assert.Equal(t, 2, strings.Count(body, "event: "))
+	assert.Contains(t, body, "event: queued\ndata: {\"type\":\"queued\",\"digestId\":\"a\",\"start\":\"2019-01-01T00:00:00Z\",\"stop\":\"2019-01-01T01:00:00Z\"")
+	assert.Contains(t, body, "event: failed\ndata: {\"type\":\"failed\",\"digestId\":\"a\",\"error\":\"oops\"")
+	assert.NotContains(t, body, "\"digestId\":\"b\"")
+}
+
+func TestEventsLive(t *testing.T) {
+	bus := &events.Bus{}
+	server := httptest.NewServer(&Events{Subscriber: bus, Heartbeat: time.Millisecond})
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	reader := bufio.NewReader(res.Body)
+
+	// the first heartbeat shows that the subscription is in place
+	line, err := reader.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, ": heartbeat\n", line)
+
+	bus.Publish(context.Background(), types.Event{Type: types.EventCompleted, DigestID: "a"})
+	for {
+		line, err = reader.ReadString('\n')
+		assert.Nil(t, err)
+		if strings.HasPrefix(line, "id: ") {
+			break
+		}
+	}
+	line, _ = reader.ReadString('\n')
+	assert.Equal(t, "event: completed\n", line)
+}
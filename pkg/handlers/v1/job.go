@@ -0,0 +1,48 @@
+package v1
+
+import (
+	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+)
+
+// job describes the outcome of a request to create the digest for a window
+type job struct {
+	ID string `json:"id"`
+	// Start and Stop are the window of the digest, in UTC, after truncation
+	Start  string `json:"start"`
+	Stop   string `json:"stop"`
+	Status string `json:"status"`
+	// Location is the URL from which the digest may be fetched, and its progress checked
+	Location string `json:"location"`
+	// EstimatedCompletion is only set for jobs which were queued
+	EstimatedCompletion string `json:"estimatedCompletion,omitempty"`
+	// Error is only set for jobs which could not be queued, which are only listed when a request is split
+	Error string `json:"error,omitempty"`
+}
+
+// jobList is the response to a request which was split into multiple jobs
+type jobList struct {
+	Digests []job `json:"digests"`
+}
+
+// newJob describes the job with the given status for the digest of the window
+func (h *DigesterHandler) newJob(id string, window types.Window, status string, now time.Time) job {
+	j := job{
+		ID:       id,
+		Start:    common.FormatTime(window.Start),
+		Stop:     common.FormatTime(window.Stop),
+		Status:   status,
+		Location: digestLocation(id),
+	}
+	if status == common.StatusQueued && h.EstimatedDurationPerHour > 0 {
+		j.EstimatedCompletion = common.FormatTime(common.EstimateCompletion(window, now, h.EstimatedDurationPerHour))
+	}
+	return j
+}
+
+// digestLocation returns the URL of the digest with the given ID
+func digestLocation(id string) string {
+	return "/digests/" + id
+}
@@ -0,0 +1,71 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/logs"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+)
+
+type digestResponse struct {
+	ID                 string `json:"id"`
+	Start              string `json:"start"`
+	Stop               string `json:"stop"`
+	Scope              string `json:"scope"`
+	CreatedAt          string `json:"createdAt"`
+	Size               int64  `json:"size"`
+	Records            int64  `json:"records"`
+	SourceObjects      int64  `json:"sourceObjects"`
+	SourceLastModified string `json:"sourceLastModified"`
+	Stale              bool   `json:"stale"`
+}
+
+type listResponse struct {
+	Digests   []digestResponse `json:"digests"`
+	NextToken string           `json:"nextToken,omitempty"`
+}
+
+// List returns a page of the digests which have been stored, optionally filtered to those whose
+// window overlaps with the start/stop query parameters
+func (h *DigesterHandler) List(w http.ResponseWriter, r *http.Request) {
+	logger := h.LogProvider(r.Context())
+	options, err := common.ExtractListOptions(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
+		return
+	}
+	list, err := h.Storage.List(r.Context(), options)
+	if err != nil {
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusInternalServerError, common.CodeInternalError, "", "")
+		return
+	}
+	response := listResponse{
+		Digests:   make([]digestResponse, 0, len(list.Digests)),
+		NextToken: list.NextToken,
+	}
+	for _, meta := range list.Digests {
+		response.Digests = append(response.Digests, newDigestResponse(meta))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func newDigestResponse(meta types.DigestMetadata) digestResponse {
+	return digestResponse{
+		ID:                 meta.ID,
+		Start:              common.FormatTime(meta.Start),
+		Stop:               common.FormatTime(meta.Stop),
+		Scope:              meta.Scope,
+		CreatedAt:          common.FormatTime(meta.CreatedAt),
+		Size:               meta.Size,
+		Records:            meta.Records,
+		SourceObjects:      meta.SourceObjects,
+		SourceLastModified: common.FormatTime(meta.SourceLastModified),
+		Stale:              meta.Stale,
+	}
+}
@@ -0,0 +1,151 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/xstats"
+	"github.com/stretchr/testify/assert"
+)
+
+func newListRequest(query map[string]string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, "/digests", nil)
+	q := r.URL.Query()
+	for k, v := range query {
+		q.Set(k, v)
+	}
+	r.URL.RawQuery = q.Encode()
+	return r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+}
+
+func TestListBadRequest(t *testing.T) {
+	tc := []struct {
+		Name  string
+		Query map[string]string
+	}{
+		{
+			Name:  "bad_start",
+			Query: map[string]string{"start": "invalid ts"},
+		},
+		{
+			Name:  "bad_stop",
+			Query: map[string]string{"stop": "invalid ts"},
+		},
+		{
+			Name: "bad_range",
+			Query: map[string]string{
+				"start": time.Now().Format(time.RFC3339Nano),
+				"stop":  time.Now().Add(-1 * time.Minute).Format(time.RFC3339Nano),
+			},
+		},
+		{
+			Name:  "bad_limit",
+			Query: map[string]string{"limit": "lots"},
+		},
+		{
+			Name:  "limit_too_large",
+			Query: map[string]string{"limit": "1001"},
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			h := DigesterHandler{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+			}
+			w := httptest.NewRecorder()
+			h.List(w, newListRequest(tt.Query))
+			assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+		})
+	}
+}
+
+func TestListStorageError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().List(gomock.Any(), gomock.Any()).Return(types.DigestList{}, errors.New("oops"))
+
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+	}
+	w := httptest.NewRecorder()
+	h.List(w, newListRequest(nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+}
+
+func TestListHappyPath(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	stop := time.Date(2019, time.January, 2, 0, 0, 0, 0, time.UTC)
+	expectedOptions := types.ListOptions{
+		Start: start,
+		Stop:  stop,
+		Limit: 5,
+		Token: "token",
+	}
+	list := types.DigestList{
+		Digests: []types.DigestMetadata{
+			{
+				ID:            "id",
+				Start:         start,
+				Stop:          start.Add(time.Hour),
+				Scope:         "a/r",
+				CreatedAt:     stop,
+				Size:          100,
+				Records:       10,
+				SourceObjects: 2,
+			},
+		},
+		NextToken: "next",
+	}
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().List(gomock.Any(), expectedOptions).Return(list, nil)
+
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+	}
+	w := httptest.NewRecorder()
+	h.List(w, newListRequest(map[string]string{
+		"start": start.Format(time.RFC3339Nano),
+		"stop":  stop.Format(time.RFC3339Nano),
+		"limit": "5",
+		"token": "token",
+	}))
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	var response listResponse
+	assert.Nil(t, json.NewDecoder(w.Result().Body).Decode(&response))
+	assert.Equal(t, listResponse{
+		Digests: []digestResponse{
+			{
+				ID:            "id",
+				Start:         "2019-01-01T00:00:00Z",
+				Stop:          "2019-01-01T01:00:00Z",
+				Scope:         "a/r",
+				CreatedAt:     "2019-01-02T00:00:00Z",
+				Size:          100,
+				Records:       10,
+				SourceObjects: 2,
+			},
+		},
+		NextToken: "next",
+	}, response)
+}
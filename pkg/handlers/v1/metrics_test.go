@@ -0,0 +1,242 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/go-vpcflow"
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/metrics"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/xstats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordedStat is a stat sent to recordingStats
+type recordedStat struct {
+	Name  string
+	Value float64
+	Tags  []string
+}
+
+// recordingStats is an xstats.XStater which records the stats sent to it
+type recordingStats struct {
+	lock  sync.Mutex
+	stats []recordedStat
+}
+
+func (s *recordingStats) record(name string, value float64, tags []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stats = append(s.stats, recordedStat{Name: name, Value: value, Tags: tags})
+}
+
+func (s *recordingStats) Gauge(stat string, value float64, tags ...string) {
+	s.record(stat, value, tags)
+}
+
+func (s *recordingStats) Count(stat string, count float64, tags ...string) {
+	s.record(stat, count, tags)
+}
+
+func (s *recordingStats) Histogram(stat string, value float64, tags ...string) {
+	s.record(stat, value, tags)
+}
+
+func (s *recordingStats) Timing(stat string, duration time.Duration, tags ...string) {
+	s.record(stat, float64(duration), tags)
+}
+
+func (s *recordingStats) AddTags(tags ...string) {}
+
+func (s *recordingStats) GetTags() []string { return nil }
+
+// find returns the stats with the given name
+func (s *recordingStats) find(name string) []recordedStat {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var found []recordedStat
+	for _, stat := range s.stats {
+		if stat.Name == name {
+			found = append(found, stat)
+		}
+	}
+	return found
+}
+
+// reportingDigester is a Digester which reports the source data it consumed
+type reportingDigester struct {
+	*MockDigester
+	stats types.DigestStats
+}
+
+func (d *reportingDigester) Stats() types.DigestStats {
+	return d.stats
+}
+
+// metricsRequest returns a request whose stats are sent to stats
+func metricsRequest(method string, target string, body io.Reader, stats *recordingStats) *http.Request {
+	r := auditRequest(method, target, body, "alice", &bytes.Buffer{})
+	return r.WithContext(xstats.NewContext(r.Context(), stats))
+}
+
+func TestMetricsRequested(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, nil)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(errors.New("oops"))
+
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		Queuer:       queuerMock,
+		Marker:       markerMock,
+	}
+	stats := &recordingStats{}
+	w := httptest.NewRecorder()
+	h.Post(w, metricsRequest(http.MethodPost, "/?start=2019-01-01T00:00:00Z&stop=2019-01-01T01:00:00Z", nil, stats))
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	requested := stats.find(metrics.DigestRequested)
+	require.Len(t, requested, 1)
+	assert.Equal(t, []string{"status:queued"}, requested[0].Tags)
+	dependencies := stats.find(metrics.DependencyDuration)
+	require.Len(t, dependencies, 2)
+	assert.Equal(t, []string{"dependency:queuer", "operation:queue", "status:success"}, dependencies[0].Tags)
+	assert.Equal(t, []string{"dependency:marker", "operation:mark", "status:error"}, dependencies[1].Tags)
+}
+
+func TestMetricsRequestedError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, errors.New("oops"))
+
+	h := DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+	}
+	stats := &recordingStats{}
+	w := httptest.NewRecorder()
+	h.Post(w, metricsRequest(http.MethodPost, "/?start=2019-01-01T00:00:00Z&stop=2019-01-01T01:00:00Z", nil, stats))
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+
+	requested := stats.find(metrics.DigestRequested)
+	require.Len(t, requested, 1)
+	assert.Equal(t, []string{"status:error"}, requested[0].Tags)
+}
+
+func TestMetricsServed(t *testing.T) {
+	tc := []struct {
+		Name   string
+		Err    error
+		Status string
+	}{
+		{Name: "served", Status: "status:served"},
+		{Name: "in progress", Err: types.ErrInProgress{}, Status: "status:in_progress"},
+		{Name: "not found", Err: types.ErrNotFound{}, Status: "status:not_found"},
+		{Name: "error", Err: errors.New("oops"), Status: "status:error"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			storageMock := NewMockStorage(ctrl)
+			if tt.Err != nil {
+				storageMock.EXPECT().Get(gomock.Any(), auditID).Return(nil, tt.Err)
+			} else {
+				storageMock.EXPECT().Get(gomock.Any(), auditID).Return(ioutil.NopCloser(strings.NewReader("digest")), nil)
+				storageMock.EXPECT().Stat(gomock.Any(), auditID).Return(types.DigestMetadata{}, nil)
+			}
+			h := DigesterHandler{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+				Storage:      storageMock,
+			}
+			stats := &recordingStats{}
+			h.GetByID(httptest.NewRecorder(), metricsRequest(http.MethodGet, "/digests/"+auditID, nil, stats))
+
+			served := stats.find(metrics.DigestServed)
+			require.Len(t, served, 1)
+			assert.Equal(t, []string{tt.Status}, served[0].Tags)
+		})
+	}
+}
+
+func TestMetricsProduced(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	data := "digest"
+	digesterMock := NewMockDigester(ctrl)
+	digesterMock.EXPECT().Digest().Return(ioutil.NopCloser(strings.NewReader(data)), nil)
+	digester := &reportingDigester{
+		MockDigester: digesterMock,
+		stats:        types.DigestStats{SourceObjects: 3, SourceBytes: 300, Records: 30},
+	}
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Store(gomock.Any(), key, gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ string, body io.ReadCloser, _ types.DigestMetadata) error {
+			_, err := ioutil.ReadAll(body)
+			return err
+		})
+	storageMock.EXPECT().Stat(gomock.Any(), key).Return(types.DigestMetadata{ID: key, Size: 20}, nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Unmark(gomock.Any(), key).Return(nil)
+
+	start := time.Now().Add(-time.Hour)
+	stop := start.Add(time.Minute)
+	queuedAt := time.Now().Add(-time.Minute)
+	payload := fmt.Sprintf(`{"id":"%s","start":"%s","stop":"%s","queuedAt":"%s"}`,
+		key, start.Format(time.RFC3339Nano), stop.Format(time.RFC3339Nano), queuedAt.Format(time.RFC3339Nano))
+	handler := &Produce{
+		LogProvider:      logevent.FromContext,
+		StatProvider:     xstats.FromContext,
+		Storage:          storageMock,
+		Marker:           markerMock,
+		DigesterProvider: func(_, _ time.Time) vpcflow.Digester { return digester },
+	}
+	stats := &recordingStats{}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, metricsRequest(http.MethodPost, "/topic/event", strings.NewReader(payload), stats))
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	latency := stats.find(metrics.QueueLatency)
+	require.Len(t, latency, 1)
+	assert.True(t, latency[0].Value >= float64(time.Minute))
+	duration := stats.find(metrics.DigestDuration)
+	require.Len(t, duration, 1)
+	assert.Equal(t, []string{"status:success"}, duration[0].Tags)
+	assert.Equal(t, float64(len(data)), stats.find(metrics.DigestUncompressedSize)[0].Value)
+	assert.Equal(t, float64(20), stats.find(metrics.DigestSize)[0].Value)
+	assert.Equal(t, float64(3), stats.find(metrics.DigestSourceObjects)[0].Value)
+	assert.Equal(t, float64(300), stats.find(metrics.DigestSourceBytes)[0].Value)
+	assert.Equal(t, float64(30), stats.find(metrics.DigestRecords)[0].Value)
+	dependencies := stats.find(metrics.DependencyDuration)
+	require.Len(t, dependencies, 4)
+	assert.Equal(t, []string{"dependency:digester", "operation:digest", "status:success"}, dependencies[0].Tags)
+	assert.Equal(t, []string{"dependency:storage", "operation:store", "status:success"}, dependencies[1].Tags)
+	assert.Equal(t, []string{"dependency:marker", "operation:unmark", "status:success"}, dependencies[2].Tags)
+	assert.Equal(t, []string{"dependency:storage", "operation:stat", "status:success"}, dependencies[3].Tags)
+}
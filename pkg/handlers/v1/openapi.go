@@ -0,0 +1,520 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/openapi"
+)
+
+const staleDescription = "Whether flow logs for the window were delivered after the digest was created, in which case it is being regenerated."
+
+// OpenAPI returns the OpenAPI 3 document describing the routes served by the handlers of this package
+func OpenAPI() *openapi.Document {
+	doc := &openapi.Document{
+		OpenAPI: "3.0.2",
+		Info: openapi.Info{
+			Title:       "VPC Digester",
+			Description: "VPC Flow Log Digester API.",
+			Version:     "1.0.0",
+		},
+		Paths: map[string]*openapi.PathItem{
+			"/": {
+				Post: &openapi.Operation{
+					OperationID: "createDigest",
+					Summary:     "Generate a digest.",
+					Parameters: []*openapi.Parameter{
+						windowParameter("start", "The start time of the digest."),
+						windowParameter("stop", "The stop time of the digest."),
+						forceParameter("If true, regenerate the digest even if it already exists. The existing digest continues to be served until it is replaced, and is preserved as a prior version. Requires the digests:regenerate permission."),
+						callbackParameter("An HTTPS URL to which a Notification is POSTed when the digest is complete, or has failed. The host must be allowed by the service. Notifications are signed with the X-Digest-Signature and X-Digest-Timestamp headers."),
+					},
+					Responses: map[string]*openapi.Response{
+						"202": {
+							Description: "The digest will be created, as described by a Job. If the range was longer than the service allows, and the service is configured to split such ranges, the body is instead a SplitDigests listing the job for each part of the range, and there is no Location header. Parts which could not be queued have the status failed, or rate_limited if the caller or the service is over a limit, and may be requested again.",
+							Headers: map[string]*openapi.Header{
+								"Location": {
+									Description: "The URL from which the digest may be fetched, and its progress checked.",
+									Schema:      &openapi.Schema{Type: "string"},
+								},
+							},
+							Content: map[string]*openapi.MediaType{
+								"application/json": {Schema: &openapi.Schema{OneOf: []*openapi.Schema{openapi.Ref("Job"), openapi.Ref("SplitDigests")}}},
+							},
+						},
+						"400": problemResponse("The start or stop time is not valid, or the callback URL is not allowed (code callback_rejected)."),
+						"403": problemResponse("The caller does not hold the required permission, or does not hold digests:regenerate and force is set."),
+						"409": {
+							Description: "The digest for this range already exists (code digest_exists), or is in progress (code digest_in_progress), and is described by a Problem. If the range was split into multiple digests, none of them were queued, and the body is instead a SplitDigests listing each of them.",
+							Content: map[string]*openapi.MediaType{
+								common.ProblemContentType: {Schema: openapi.Ref("Problem")},
+								"application/json":        {Schema: openapi.Ref("SplitDigests")},
+							},
+						},
+						"422": problemResponse("The range ends in the future, is too recent for all flow logs to have been delivered, starts too far in the past, or is longer than the service allows."),
+						"429": rateLimitedResponse(),
+						"500": problemResponse("An internal error occurred. The error is logged with the request ID. If the range was split into multiple digests, none of them were queued, and at least one could not be."),
+					},
+				},
+				Get: &openapi.Operation{
+					OperationID: "getDigest",
+					Summary:     "Fetch a complete digest.",
+					Parameters: []*openapi.Parameter{
+						windowParameter("start", "The start time of the digest."),
+						windowParameter("stop", "The stop time of the digest."),
+						redirectParameter(),
+						waitParameter(),
+					},
+					Responses: digestResponses("The digest for this range does not exist yet.", "The start, stop, or wait is not valid."),
+				},
+			},
+			"/batch": {
+				Post: &openapi.Operation{
+					OperationID: "createDigests",
+					Summary:     "Generate the digests for many windows at once.",
+					Description: "Each window is checked and queued individually, as for POST /, but is never split. Windows for the same digest are queued once, and the others report the digest as in progress or existing. The outcome for each window is reported in the order they were given.",
+					Parameters: []*openapi.Parameter{
+						forceParameter("If true, regenerate each digest even if it already exists. Requires the digests:regenerate permission."),
+						callbackParameter("An HTTPS URL to which a Notification is POSTed as each digest is complete, or has failed."),
+					},
+					RequestBody: &openapi.RequestBody{
+						Required: true,
+						Content: map[string]*openapi.MediaType{
+							"application/json": {Schema: &openapi.Schema{Type: "array", Items: openapi.Ref("BatchWindow")}},
+						},
+					},
+					Responses: map[string]*openapi.Response{
+						"200": jsonResponse("The outcome for each window.", openapi.Ref("BatchResults")),
+						"400": problemResponse("The body is not a JSON array of windows, has too few or too many windows, or the force or callback parameters are not valid."),
+						"403": problemResponse("The caller does not hold the required permission, or does not hold digests:regenerate and force is set."),
+						"413": problemResponse("The body is larger than 10 MiB."),
+						"500": internalErrorResponse(),
+					},
+				},
+			},
+			"/events": {
+				Get: &openapi.Operation{
+					OperationID: "streamEvents",
+					Summary:     "Stream changes to the state of digest jobs.",
+					Description: "A stream of Server-Sent Events. Each event has an id, an event type, and JSON data described by Event. Comments are sent periodically to keep the connection open. If the client falls too far behind, the stream ends and the client should reconnect.",
+					Parameters: []*openapi.Parameter{
+						{
+							Name:        "id",
+							In:          "query",
+							Description: "Only stream events for digests with these IDs.",
+							Schema:      &openapi.Schema{Type: "array", Items: &openapi.Schema{Type: "string", Format: openapi.FormatUUID}},
+						},
+						{
+							Name:        lastEventIDHeader,
+							In:          "header",
+							Description: "The ID of the last event received. Retained events published since are sent first. Events from before the service restarted cannot be identified, so all retained events are sent.",
+							Schema:      &openapi.Schema{Type: "string"},
+						},
+						{
+							Name:        "lastEventId",
+							In:          "query",
+							Description: "An alternative to the Last-Event-ID header, for clients which cannot set it.",
+							Schema:      &openapi.Schema{Type: "string"},
+						},
+					},
+					Responses: map[string]*openapi.Response{
+						"200": {
+							Description: "A stream of events, whose data is described by Event.",
+							Content: map[string]*openapi.MediaType{
+								"text/event-stream": {Schema: openapi.Ref("Event")},
+							},
+						},
+						"500": problemResponse("The server does not support streaming."),
+					},
+				},
+			},
+			"/digests": {
+				Get: &openapi.Operation{
+					OperationID: "listDigests",
+					Summary:     "List stored digests.",
+					Description: "A page contains limit digests unless it is the last, but the last page may be empty. Continue paging until nextToken is absent.",
+					Parameters: []*openapi.Parameter{
+						{
+							Name:        "start",
+							In:          "query",
+							Description: "If set, only digests whose window ends after this time are returned.",
+							Schema:      &openapi.Schema{Type: "string", Format: openapi.FormatDateTime},
+						},
+						{
+							Name:        "stop",
+							In:          "query",
+							Description: "If set, only digests whose window begins before this time are returned.",
+							Schema:      &openapi.Schema{Type: "string", Format: openapi.FormatDateTime},
+						},
+						{
+							Name:        "limit",
+							In:          "query",
+							Description: "The maximum number of digests to return in this page. Only the last page has fewer.",
+							Schema:      &openapi.Schema{Type: "integer", Minimum: float(1), Maximum: float(common.MaxListLimit), Default: common.DefaultListLimit},
+						},
+						{
+							Name:        "token",
+							In:          "query",
+							Description: "The nextToken value from the previous page.",
+							Schema:      &openapi.Schema{Type: "string"},
+						},
+					},
+					Responses: map[string]*openapi.Response{
+						"200": jsonResponse("A page of digests.", openapi.Ref("DigestList")),
+						"400": problemResponse("The query parameters are not valid."),
+						"500": internalErrorResponse(),
+					},
+				},
+			},
+			"/digests/{id}": {
+				Parameters: []*openapi.Parameter{
+					{
+						Name:        "id",
+						In:          "path",
+						Description: "The ID of the digest, as returned when it was requested.",
+						Required:    true,
+						Schema:      &openapi.Schema{Type: "string", Format: openapi.FormatUUID},
+					},
+				},
+				Get: &openapi.Operation{
+					OperationID: "getDigestByID",
+					Summary:     "Fetch a complete digest by ID.",
+					Parameters:  []*openapi.Parameter{redirectParameter(), waitParameter()},
+					Responses:   digestResponses("The digest does not exist.", "The ID or wait is not valid."),
+				},
+				Head: &openapi.Operation{
+					OperationID: "checkDigest",
+					Summary:     "Check whether a digest exists.",
+					Responses: map[string]*openapi.Response{
+						"200": {Description: "The digest exists."},
+						"204": {Description: "The digest is created but not yet complete."},
+						"400": {Description: "The ID is not valid."},
+						"404": {Description: "The digest does not exist."},
+						"500": {Description: "An internal error occurred. HEAD responses have no body."},
+					},
+				},
+				Delete: &openapi.Operation{
+					OperationID: "deleteDigest",
+					Summary:     "Delete a digest, along with any prior versions and in progress state, so that it may be regenerated.",
+					Responses: map[string]*openapi.Response{
+						"204": {Description: "The digest was deleted, or did not exist."},
+						"400": problemResponse("The ID is not valid."),
+						"500": internalErrorResponse(),
+					},
+				},
+			},
+			"/{topic}/{event}": {
+				Parameters: []*openapi.Parameter{
+					{Name: "topic", In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}},
+					{Name: "event", In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}},
+				},
+				Post: &openapi.Operation{
+					OperationID: "produceDigest",
+					Summary:     "Create and store a queued digest.",
+					Description: "The worker endpoint, to which the Queuer's event bus delivers digest jobs. The event is not used, and the topic must match STREAM_APPLIANCE_TOPIC, if set. The endpoint may instead be served on its own listener, set by DIGEST_WORKER_ADDRESS.",
+					RequestBody: &openapi.RequestBody{
+						Required: true,
+						Content: map[string]*openapi.MediaType{
+							"application/json": {Schema: openapi.Ref("DigestJob")},
+						},
+					},
+					Responses: map[string]*openapi.Response{
+						"204": {Description: "The digest was created and stored."},
+						"400": problemResponse("The job is not valid."),
+						"404": problemResponse("The topic is not the one from which jobs are accepted."),
+						"413": problemResponse("The body is larger than 10 MiB."),
+						"422": problemResponse("The window of the job is not allowed by the service."),
+						"500": problemResponse("The digest could not be created, stored, or marked as complete, and the job should be retried."),
+					},
+				},
+			},
+			"/openapi.json": {
+				Get: &openapi.Operation{
+					OperationID: "getOpenAPI",
+					Summary:     "Fetch this document.",
+					Responses: map[string]*openapi.Response{
+						"200": jsonResponse("The OpenAPI 3 document describing the service.", &openapi.Schema{Type: "object"}),
+					},
+				},
+			},
+		},
+		Components: openapi.Components{Schemas: schemas()},
+	}
+	addAuthResponses(doc)
+	return doc
+}
+
+// InvalidRequest writes the problem details response to a request which does not match the OpenAPI document
+func InvalidRequest(w http.ResponseWriter, r *http.Request, err error) {
+	common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
+}
+
+func schemas() map[string]*openapi.Schema {
+	dateTime := func(description string) *openapi.Schema {
+		return &openapi.Schema{Type: "string", Format: openapi.FormatDateTime, Description: description}
+	}
+	uuid := &openapi.Schema{Type: "string", Format: openapi.FormatUUID}
+	str := func(description string) *openapi.Schema {
+		return &openapi.Schema{Type: "string", Description: description}
+	}
+	return map[string]*openapi.Schema{
+		"Problem": {
+			Type:        "object",
+			Description: "An RFC 7807 problem details object, returned with the application/problem+json content type for all errors.",
+			Required:    []string{"type", "title", "status", "code"},
+			Properties: map[string]*openapi.Schema{
+				"type":   str("A URI identifying the problem type, of the form urn:vpcflow-digesterd:problem:<code>."),
+				"title":  str("The HTTP status text."),
+				"status": {Type: "integer"},
+				"detail": str("A human readable explanation of this occurrence of the problem. Omitted for internal errors."),
+				"code": {
+					Type:        "string",
+					Description: "A machine readable error code.",
+					Enum: []string{common.CodeInvalidRequest, common.CodeWindowRejected, common.CodeCallbackRejected, common.CodeUnauthorized, common.CodeForbidden,
+						common.CodeDigestNotFound, common.CodeDigestExists, common.CodeDigestInProgress, common.CodeTopicNotFound, common.CodeRateLimited, common.CodeInternalError},
+				},
+				"digestId":  str("The ID of the digest concerned, if any."),
+				"requestId": str("The ID of the request, taken from the X-Request-Id header if provided, for correlation with logs."),
+				"conflict": {
+					Type:        "string",
+					Description: "Set on 409 responses to the status of the conflicting digest.",
+					Enum:        []string{common.StatusExists, common.StatusInProgress},
+				},
+			},
+		},
+		"Digest": {
+			Type: "object",
+			Properties: map[string]*openapi.Schema{
+				"id":                 uuid,
+				"start":              str("The start of the digest window. Empty if unknown."),
+				"stop":               str("The stop of the digest window. Empty if unknown."),
+				"scope":              str("The accounts and regions the digest was created from, as <accounts>/<regions>, where * means all."),
+				"createdAt":          str("When the digest was created. Empty if unknown."),
+				"size":               {Type: "integer", Description: "The size, in bytes, of the stored (gzipped) digest."},
+				"records":            {Type: "integer", Description: "The number of flow log records read to create the digest."},
+				"sourceObjects":      {Type: "integer", Description: "The number of flow log objects read to create the digest."},
+				"sourceLastModified": str("The most recent modification time of the flow log objects read to create the digest. Empty if unknown."),
+				"stale":              {Type: "boolean", Description: staleDescription},
+			},
+		},
+		"DigestList": {
+			Type: "object",
+			Properties: map[string]*openapi.Schema{
+				"digests":   {Type: "array", Items: openapi.Ref("Digest")},
+				"nextToken": str("Present if there may be more digests."),
+			},
+		},
+		"Job": {
+			Type:     "object",
+			Required: []string{"id", "start", "stop", "status", "location"},
+			Properties: map[string]*openapi.Schema{
+				"id":    uuid,
+				"start": dateTime("The start of the digest window, in UTC, after truncation to minute precision."),
+				"stop":  dateTime("The stop of the digest window, in UTC, after truncation to minute precision."),
+				"status": {
+					Type: "string",
+					Enum: []string{common.StatusQueued, common.StatusExists, common.StatusInProgress, statusFailed, common.StatusRateLimited},
+				},
+				"location":            str("The URL from which the digest may be fetched, and its progress checked."),
+				"estimatedCompletion": dateTime("An estimate of when the digest will be complete. Only present for queued jobs."),
+				"error":               str("Why the job could not be queued. Only present for failed or rate limited jobs, which are only listed in a SplitDigests."),
+			},
+		},
+		"SplitDigests": {
+			Type:     "object",
+			Required: []string{"digests"},
+			Properties: map[string]*openapi.Schema{
+				"digests": {Type: "array", Items: openapi.Ref("Job")},
+			},
+		},
+		"BatchWindow": {
+			Type:     "object",
+			Required: []string{"start", "stop"},
+			Properties: map[string]*openapi.Schema{
+				"start": str("Parsed as an RFC3339Nano timestamp, and truncated to minute precision. Windows which are not valid are reported as invalid, rather than rejecting the batch."),
+				"stop":  str("Parsed as an RFC3339Nano timestamp, and truncated to minute precision."),
+				"scope": {
+					Type:        "object",
+					Description: "If given, must match the accounts and regions from which the service creates digests.",
+					Properties: map[string]*openapi.Schema{
+						"accounts": {Type: "array", Items: &openapi.Schema{Type: "string"}},
+						"regions":  {Type: "array", Items: &openapi.Schema{Type: "string"}},
+					},
+				},
+			},
+		},
+		"BatchResults": {
+			Type:     "object",
+			Required: []string{"results"},
+			Properties: map[string]*openapi.Schema{
+				"results": {
+					Type: "array",
+					Items: &openapi.Schema{
+						Type:     "object",
+						Required: []string{"index", "start", "stop", "status"},
+						Properties: map[string]*openapi.Schema{
+							"index": {Type: "integer", Description: "The position of the window in the request."},
+							"id":    {Type: "string", Format: openapi.FormatUUID, Description: "Not present for invalid or failed windows."},
+							"start": str(""),
+							"stop":  str(""),
+							"status": {
+								Type: "string",
+								Enum: []string{common.StatusQueued, common.StatusExists, common.StatusInProgress, statusInvalid, statusFailed, common.StatusRateLimited},
+							},
+							"location":            str(""),
+							"estimatedCompletion": dateTime(""),
+							"error":               str("Why the window was invalid, could not be queued, or was rate limited."),
+						},
+					},
+				},
+			},
+		},
+		"DigestJob": {
+			Type:        "object",
+			Description: "A digest job, as queued by the Queuer.",
+			Required:    []string{"id", "start", "stop"},
+			Properties: map[string]*openapi.Schema{
+				"id":       str("The ID under which to store the digest."),
+				"start":    dateTime(""),
+				"stop":     dateTime(""),
+				"callback": str("The URL to notify when the job is complete, if any."),
+				"queuedAt": dateTime("The time at which the job was queued, if known. Used to measure the latency of the queue."),
+				"client":   str("The client which requested the job, if known. Recorded in the audit log when the digest is produced."),
+			},
+		},
+		"Notification": {
+			Type:        "object",
+			Description: "POSTed to the callback URL of a digest request when the digest is complete, or has failed.",
+			Properties: map[string]*openapi.Schema{
+				"id":       uuid,
+				"start":    dateTime(""),
+				"stop":     dateTime(""),
+				"status":   {Type: "string", Enum: []string{"completed", "failed"}},
+				"location": str("The URL from which the digest may be fetched. Only present for completed digests."),
+				"error":    str("Why the digest could not be created. Only present for failed digests."),
+				"time":     dateTime(""),
+			},
+		},
+		"Event": {
+			Type: "object",
+			Properties: map[string]*openapi.Schema{
+				"type":     {Type: "string", Enum: []string{"queued", "started", "completed", "failed", "marked", "unmarked"}},
+				"digestId": uuid,
+				"start":    dateTime("The start of the digest window. Not present for marked and unmarked events."),
+				"stop":     dateTime("The stop of the digest window. Not present for marked and unmarked events."),
+				"error":    str("Why the digest could not be created. Only present for failed events."),
+				"time":     dateTime(""),
+			},
+		},
+	}
+}
+
+func windowParameter(name string, description string) *openapi.Parameter {
+	return &openapi.Parameter{
+		Name:        name,
+		In:          "query",
+		Description: description + " Input will be parsed as an RFC3339Nano timestamp, and will be truncated to minute precision.",
+		Required:    true,
+		Schema:      &openapi.Schema{Type: "string", Format: openapi.FormatDateTime},
+	}
+}
+
+func forceParameter(description string) *openapi.Parameter {
+	return &openapi.Parameter{Name: "force", In: "query", Description: description, Schema: &openapi.Schema{Type: "boolean"}}
+}
+
+func callbackParameter(description string) *openapi.Parameter {
+	return &openapi.Parameter{Name: "callback", In: "query", Description: description, Schema: &openapi.Schema{Type: "string"}}
+}
+
+func redirectParameter() *openapi.Parameter {
+	return &openapi.Parameter{
+		Name:        "redirect",
+		In:          "query",
+		Description: "If true, respond with a redirect to a short-lived pre-signed URL for the digest rather than the digest itself. Overrides the service default. Storage backends which cannot presign URLs always return the digest.",
+		Schema:      &openapi.Schema{Type: "boolean"},
+	}
+}
+
+func waitParameter() *openapi.Parameter {
+	return &openapi.Parameter{
+		Name:        "wait",
+		In:          "query",
+		Description: "A duration, such as 30s, for which to hold the request open while the digest is in progress. Capped by the service, which defaults to 30s. If the digest is still in progress when the wait expires, a 204 is returned.",
+		Schema:      &openapi.Schema{Type: "string", Format: openapi.FormatDuration},
+	}
+}
+
+// digestResponses are the responses of the operations which fetch a digest
+func digestResponses(notFound string, badRequest string) map[string]*openapi.Response {
+	stale := map[string]*openapi.Header{
+		staleHeader: {Description: staleDescription, Schema: &openapi.Schema{Type: "boolean"}},
+	}
+	return map[string]*openapi.Response{
+		"200": {
+			Description: "The digest.",
+			Headers:     stale,
+			Content:     map[string]*openapi.MediaType{"application/octet-stream": {}},
+		},
+		"204": {Description: "The digest is created but not yet complete."},
+		"302": {
+			Description: "The digest may be downloaded from the URL in the Location header.",
+			Headers:     stale,
+			Content:     map[string]*openapi.MediaType{"text/html": {}},
+		},
+		"400": problemResponse(badRequest),
+		"404": problemResponse(notFound),
+		"500": internalErrorResponse(),
+	}
+}
+
+// addAuthResponses documents the responses to unauthenticated and unauthorized requests on every operation except
+// the retrieval of this document, which is public
+func addAuthResponses(doc *openapi.Document) {
+	for path, item := range doc.Paths {
+		if path == "/openapi.json" {
+			continue
+		}
+		for _, operation := range item.Operations() {
+			operation.Responses["401"] = problemResponse("The request does not carry valid credentials. Only returned if authentication is enabled.")
+			if _, ok := operation.Responses["403"]; !ok {
+				operation.Responses["403"] = problemResponse("The caller does not hold the required permission.")
+			}
+		}
+	}
+}
+
+func jsonResponse(description string, schema *openapi.Schema) *openapi.Response {
+	return &openapi.Response{
+		Description: description,
+		Content:     map[string]*openapi.MediaType{"application/json": {Schema: schema}},
+	}
+}
+
+func problemResponse(description string) *openapi.Response {
+	return &openapi.Response{
+		Description: description,
+		Content:     map[string]*openapi.MediaType{common.ProblemContentType: {Schema: openapi.Ref("Problem")}},
+	}
+}
+
+func internalErrorResponse() *openapi.Response {
+	return problemResponse("An internal error occurred. The error is logged with the request ID.")
+}
+
+// rateLimitedResponse documents the response to a request for a digest rejected because the caller has requested
+// too many recently, or too many digests are already in progress
+func rateLimitedResponse() *openapi.Response {
+	response := problemResponse("The caller has requested too many digests recently, or too many digests are in progress (code rate_limited), and no digests were queued. Each digest queued counts towards the limits, so a range which is split counts once for each part. Only returned if limits are configured.")
+	response.Headers = map[string]*openapi.Header{
+		"Retry-After": {
+			Description: "The number of seconds after which the request may be retried.",
+			Schema:      &openapi.Schema{Type: "integer"},
+		},
+	}
+	return response
+}
+
+func float(f float64) *float64 {
+	return &f
+}
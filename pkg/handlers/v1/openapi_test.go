@@ -0,0 +1,213 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/openapi"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/go-chi/chi"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/rs/xstats"
+	"github.com/stretchr/testify/assert"
+)
+
+// newValidatedRouter serves the handler through the OpenAPI validator, failing the test for any response which
+// does not match the document
+func newValidatedRouter(t *testing.T, h *DigesterHandler) http.Handler {
+	validator := &openapi.Validator{
+		Document:       OpenAPI(),
+		OnRequestError: InvalidRequest,
+		OnResponseError: func(r *http.Request, err error) {
+			t.Errorf("%s %s: %s", r.Method, r.URL.Path, err.Error())
+		},
+		ValidateResponseBodies: true,
+	}
+	router := chi.NewRouter()
+	router.Use(validator.Middleware)
+	router.Post("/", h.Post)
+	router.Post("/batch", h.Batch)
+	router.Get("/", h.Get)
+	router.Get("/digests", h.List)
+	router.Get("/digests/{id}", h.GetByID)
+	router.Head("/digests/{id}", h.HeadByID)
+	router.Delete("/digests/{id}", h.DeleteByID)
+	return router
+}
+
+func newValidatedRequest(method string, target string, body string) *http.Request {
+	r := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	return r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	doc := OpenAPI()
+	_, err := json.Marshal(doc)
+	assert.Nil(t, err)
+	for path, item := range doc.Paths {
+		for method, operation := range item.Operations() {
+			assert.NotEmpty(t, operation.OperationID, "%s %s", method, path)
+			assert.NotEmpty(t, operation.Responses, "%s %s", method, path)
+		}
+	}
+}
+
+func TestOpenAPIRejectsInvalidRequests(t *testing.T) {
+	tc := []struct {
+		Name   string
+		Method string
+		Target string
+		Body   string
+	}{
+		{Name: "bad_start", Method: http.MethodGet, Target: "/?start=yesterday&stop=2019-01-01T00:00:00Z"},
+		{Name: "missing_stop", Method: http.MethodPost, Target: "/?start=2019-01-01T00:00:00Z"},
+		{Name: "bad_limit", Method: http.MethodGet, Target: "/digests?limit=lots"},
+		{Name: "bad_id", Method: http.MethodGet, Target: "/digests/not-a-uuid"},
+		{Name: "bad_batch", Method: http.MethodPost, Target: "/batch", Body: `[{"start":1}]`},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			// the handler has no dependencies, so reaching it would panic
+			router := newValidatedRouter(t, &DigesterHandler{})
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, newValidatedRequest(tt.Method, tt.Target, tt.Body))
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, common.ProblemContentType, w.Header().Get("Content-Type"))
+			var p common.Problem
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &p))
+			assert.Equal(t, common.CodeInvalidRequest, p.Code)
+		})
+	}
+}
+
+func TestOpenAPIResponsesMatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	id := uuid.New().String()
+	start := time.Now().Add(-2 * time.Hour).Truncate(time.Minute)
+	stop := start.Add(time.Hour)
+	window := "start=" + start.Format(time.RFC3339) + "&stop=" + stop.Format(time.RFC3339)
+
+	storageMock := NewMockStorage(ctrl)
+	queuerMock := NewMockQueuer(ctrl)
+	markerMock := NewMockMarker(ctrl)
+	h := &DigesterHandler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		Queuer:       queuerMock,
+		Marker:       markerMock,
+	}
+	router := newValidatedRouter(t, h)
+
+	tc := []struct {
+		Name   string
+		Method string
+		Target string
+		Body   string
+		Setup  func()
+		Status int
+	}{
+		{
+			Name:   "post_queued",
+			Method: http.MethodPost,
+			Target: "/?" + window,
+			Setup: func() {
+				storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, nil)
+				markerMock.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(nil)
+				queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+			},
+			Status: http.StatusAccepted,
+		},
+		{
+			Name:   "post_exists",
+			Method: http.MethodPost,
+			Target: "/?" + window,
+			Setup: func() {
+				storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(true, nil)
+			},
+			Status: http.StatusConflict,
+		},
+		{
+			Name:   "post_error",
+			Method: http.MethodPost,
+			Target: "/?" + window,
+			Setup: func() {
+				storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, errors.New("oops"))
+			},
+			Status: http.StatusInternalServerError,
+		},
+		{
+			Name:   "get_in_progress",
+			Method: http.MethodGet,
+			Target: "/?" + window,
+			Setup: func() {
+				storageMock.EXPECT().Get(gomock.Any(), gomock.Any()).Return(nil, types.ErrInProgress{})
+			},
+			Status: http.StatusNoContent,
+		},
+		{
+			Name:   "get_by_id",
+			Method: http.MethodGet,
+			Target: "/digests/" + id,
+			Setup: func() {
+				storageMock.EXPECT().Get(gomock.Any(), id).Return(ioutil.NopCloser(bytes.NewBufferString("digest")), nil)
+				storageMock.EXPECT().Stat(gomock.Any(), id).Return(types.DigestMetadata{ID: id}, nil)
+			},
+			Status: http.StatusOK,
+		},
+		{
+			Name:   "get_by_id_not_found",
+			Method: http.MethodGet,
+			Target: "/digests/" + id,
+			Setup: func() {
+				storageMock.EXPECT().Get(gomock.Any(), id).Return(nil, types.ErrNotFound{})
+			},
+			Status: http.StatusNotFound,
+		},
+		{
+			Name:   "list",
+			Method: http.MethodGet,
+			Target: "/digests?limit=10",
+			Setup: func() {
+				storageMock.EXPECT().List(gomock.Any(), gomock.Any()).Return(types.DigestList{
+					Digests:   []types.DigestMetadata{{ID: id, Start: start, Stop: stop, Scope: "*/*", CreatedAt: stop, Size: 6}},
+					NextToken: "next",
+				}, nil)
+			},
+			Status: http.StatusOK,
+		},
+		{
+			Name:   "batch",
+			Method: http.MethodPost,
+			Target: "/batch",
+			Body:   `[{"start":"` + start.Format(time.RFC3339) + `","stop":"` + stop.Format(time.RFC3339) + `"},{"start":"` + stop.Format(time.RFC3339) + `","stop":"` + start.Format(time.RFC3339) + `"}]`,
+			Setup: func() {
+				storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(true, nil)
+			},
+			Status: http.StatusOK,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			tt.Setup()
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, newValidatedRequest(tt.Method, tt.Target, tt.Body))
+			assert.Equal(t, tt.Status, w.Code, w.Body.String())
+		})
+	}
+}
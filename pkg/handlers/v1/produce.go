@@ -1,18 +1,29 @@
 package v1
 
 import (
+	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 	"time"
 
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
 	"github.com/asecurityteam/vpcflow-digesterd/pkg/logs"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/metrics"
 	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/go-chi/chi"
 )
 
 type payload struct {
 	ID    string `json:"id"`
 	Start string `json:"start"`
 	Stop  string `json:"stop"`
+	// Callback is the URL to notify when the job is complete, if any
+	Callback string `json:"callback,omitempty"`
+	// QueuedAt is the time at which the job was queued, if known
+	QueuedAt string `json:"queuedAt,omitempty"`
+	// Client identifies the client which requested the job, if known
+	Client string `json:"client,omitempty"`
 }
 
 // Produce is a handler which performs the digest job, and stores the digest
@@ -22,73 +33,237 @@ type Produce struct {
 	Storage          types.Storage
 	Marker           types.Marker
 	DigesterProvider types.DigesterProvider
+	// Scope describes the accounts and regions which digests are created from. It is recorded in the
+	// metadata of each stored digest.
+	Scope types.Scope
+	// Policy constrains the windows for which digests may be created. Jobs which violate it, including
+	// those larger than the maximum window, are rejected with a 422, and their digest is unmarked.
+	Policy types.WindowPolicy
+	// Notifier delivers notifications to the callback URL of jobs which have one, once they have completed or
+	// failed. If not set, callbacks are ignored.
+	Notifier types.Notifier
+	// Events, if set, is published to when a job is started, and when it completes or fails
+	Events types.Publisher
+	// Topic, if set, is the only topic of the stream appliance from which jobs are accepted. Jobs POSTed to any
+	// other topic are rejected with a 404.
+	Topic string
 }
 
 // ServeHTTP handles incoming HTTP requests, and creates a vpc flow digest
 func (h *Produce) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	logger := h.LogProvider(r.Context())
+	if h.Topic != "" && chi.URLParam(r, "topic") != h.Topic {
+		msg := "unknown topic " + chi.URLParam(r, "topic")
+		logger.Info(logs.InvalidInput{Reason: msg})
+		common.WriteProblem(w, r, http.StatusNotFound, common.CodeTopicNotFound, msg, "")
+		return
+	}
 	var body payload
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		logger.Info(logs.InvalidInput{Reason: err.Error()})
-		writeTextResponse(w, http.StatusBadRequest, err.Error())
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
 		return
 	}
 
 	if body.ID == "" {
 		msg := "missing ID field"
 		logger.Info(logs.InvalidInput{Reason: msg})
-		writeTextResponse(w, http.StatusBadRequest, msg)
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, msg, "")
 		return
 	}
 
 	start, err := time.Parse(time.RFC3339Nano, body.Start)
 	if err != nil {
 		logger.Info(logs.InvalidInput{Reason: err.Error()})
-		writeTextResponse(w, http.StatusBadRequest, err.Error())
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), body.ID)
 		return
 	}
 
 	stop, err := time.Parse(time.RFC3339Nano, body.Stop)
 	if err != nil {
 		logger.Info(logs.InvalidInput{Reason: err.Error()})
-		writeTextResponse(w, http.StatusBadRequest, err.Error())
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), body.ID)
 		return
 	}
 
 	if !stop.After(start) {
 		msg := "invalid time range"
 		logger.Info(logs.InvalidInput{Reason: msg})
-		writeTextResponse(w, http.StatusBadRequest, msg)
+		h.unmarkRejected(r.Context(), body.ID)
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, msg, body.ID)
+		h.finish(r.Context(), body, start, stop, msg)
+		return
+	}
+
+	if err := h.Policy.Check(start, stop, time.Now()); err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		h.unmarkRejected(r.Context(), body.ID)
+		common.WriteProblem(w, r, http.StatusUnprocessableEntity, common.CodeWindowRejected, err.Error(), body.ID)
+		h.finish(r.Context(), body, start, stop, err.Error())
 		return
 	}
 
+	h.publish(r.Context(), types.Event{Type: types.EventStarted, DigestID: body.ID, Start: start.UTC(), Stop: stop.UTC()})
+	stat := h.StatProvider(r.Context())
+	started := time.Now()
+	if queuedAt, err := time.Parse(time.RFC3339Nano, body.QueuedAt); err == nil {
+		stat.Timing(metrics.QueueLatency, started.Sub(queuedAt))
+	}
 	digester := h.DigesterProvider(start, stop)
 	digest, err := digester.Digest()
+	metrics.TimeDependency(stat, logs.DependencyDigester, "digest", started, err)
 	if err != nil {
+		stat.Timing(metrics.DigestDuration, time.Since(started), metrics.Status(metrics.StatusError))
 		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyDigester, Reason: err.Error()})
-		writeTextResponse(w, http.StatusInternalServerError, err.Error())
+		common.WriteProblem(w, r, http.StatusInternalServerError, common.CodeInternalError, "", body.ID)
+		h.finish(r.Context(), body, start, stop, "the digest could not be created")
 		return
 	}
 	defer digest.Close()
-	if err := h.Storage.Store(r.Context(), body.ID, digest); err != nil {
+	counter := &countingReader{ReadCloser: digest}
+	meta := types.DigestMetadata{
+		ID:        body.ID,
+		Start:     start,
+		Stop:      stop,
+		Scope:     h.Scope.String(),
+		CreatedAt: time.Now(),
+	}
+	reporter, reported := digester.(types.StatsReporter)
+	var stats types.DigestStats
+	if reported {
+		stats = reporter.Stats()
+		meta.Records = stats.Records
+		meta.SourceObjects = stats.SourceObjects
+		meta.SourceLastModified = stats.LastModified
+	}
+	storeStarted := time.Now()
+	err = h.Storage.Store(r.Context(), body.ID, counter, meta)
+	metrics.TimeDependency(stat, logs.DependencyStorage, "store", storeStarted, err)
+	if err != nil {
+		stat.Timing(metrics.DigestDuration, time.Since(started), metrics.Status(metrics.StatusError))
 		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
-		writeTextResponse(w, http.StatusInternalServerError, err.Error())
+		common.WriteProblem(w, r, http.StatusInternalServerError, common.CodeInternalError, "", body.ID)
+		h.finish(r.Context(), body, start, stop, "the digest could not be stored")
 		return
 	}
 	// We may want to improve this in the future to be a non-fatal error. Today if unmark fails,
 	// fetching the digest will result in a perpetual "in progress" state. To mitigate this, we
 	// report a failure to the caller signifying that the operation should be retried. This will
 	// hopefully mitigate the amount of invalid state occurrence we may incur
-	if err := h.Marker.Unmark(r.Context(), body.ID); err != nil {
+	unmarkStarted := time.Now()
+	err = h.Marker.Unmark(r.Context(), body.ID)
+	metrics.TimeDependency(stat, logs.DependencyMarker, "unmark", unmarkStarted, err)
+	if err != nil {
+		stat.Timing(metrics.DigestDuration, time.Since(started), metrics.Status(metrics.StatusError))
 		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
-		writeTextResponse(w, http.StatusInternalServerError, err.Error())
+		common.WriteProblem(w, r, http.StatusInternalServerError, common.CodeInternalError, "", body.ID)
+		h.finish(r.Context(), body, start, stop, "the digest could not be marked as complete")
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
+	stat.Timing(metrics.DigestDuration, time.Since(started), metrics.Status(metrics.StatusSuccess))
+	stat.Histogram(metrics.DigestUncompressedSize, float64(counter.n))
+	h.statSize(r.Context(), body.ID)
+	if reported {
+		stat.Histogram(metrics.DigestSourceObjects, float64(stats.SourceObjects))
+		stat.Histogram(metrics.DigestSourceBytes, float64(stats.SourceBytes))
+		stat.Histogram(metrics.DigestRecords, float64(stats.Records))
+	}
+	logger.Info(logs.DigestProduced{
+		Client:            body.Client,
+		ID:                body.ID,
+		Start:             common.FormatTime(start),
+		Stop:              common.FormatTime(stop),
+		Scope:             meta.Scope,
+		Records:           meta.Records,
+		UncompressedBytes: counter.n,
+		DurationMS:        common.DurationMS(time.Since(started)),
+	})
+	h.finish(r.Context(), body, start, stop, "")
+}
+
+// unmarkRejected unmarks the digest of a job which was rejected. The job will never succeed, so the digest must
+// not be left in progress.
+func (h *Produce) unmarkRejected(ctx context.Context, id string) {
+	if err := h.Marker.Unmark(ctx, id); err != nil {
+		h.LogProvider(ctx).Error(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
+	}
+}
+
+// statSize emits the size of the digest as stored, which is only known to Storage once it has been compressed
+// or encrypted. The size is read from the metadata of the digest, and is not emitted if it cannot be read or the
+// Storage cannot read metadata.
+func (h *Produce) statSize(ctx context.Context, id string) {
+	stat := h.StatProvider(ctx)
+	started := time.Now()
+	meta, err := common.Stat(ctx, h.Storage, id)
+	if _, ok := err.(types.ErrUnsupported); ok {
+		return
+	}
+	metrics.TimeDependency(stat, logs.DependencyStorage, "stat", started, err)
+	if err != nil {
+		h.LogProvider(ctx).Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		return
+	}
+	stat.Histogram(metrics.DigestSize, float64(meta.Size))
+}
+
+// countingReader counts the bytes of the digest as they are read by Storage, before they are compressed
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
 }
 
-func writeTextResponse(w http.ResponseWriter, statusCode int, msg string) {
-	w.WriteHeader(statusCode)
-	w.Header().Set("Content-Type", "application/octet-stream")
-	_, _ = w.Write([]byte(msg))
+// finish reports the outcome of the job to subscribers and to its callback URL, if it has one. The job failed
+// if reason is not empty.
+func (h *Produce) finish(ctx context.Context, body payload, start, stop time.Time, reason string) {
+	e := types.Event{Type: types.EventCompleted, DigestID: body.ID, Start: start.UTC(), Stop: stop.UTC()}
+	if reason != "" {
+		e.Type = types.EventFailed
+		e.Error = reason
+	}
+	h.publish(ctx, e)
+	h.notify(ctx, body, start, stop, reason)
+}
+
+func (h *Produce) publish(ctx context.Context, e types.Event) {
+	if h.Events != nil {
+		h.Events.Publish(ctx, e)
+	}
+}
+
+// notify delivers a notification of the outcome of the job to its callback URL, if it has one. The job failed
+// if reason is not empty. Notifications which cannot be delivered are logged as dead letters. Failed jobs may be
+// retried, so a failed notification may be followed by another for the same job.
+func (h *Produce) notify(ctx context.Context, body payload, start, stop time.Time, reason string) {
+	if body.Callback == "" || h.Notifier == nil {
+		return
+	}
+	n := types.Notification{
+		ID:     body.ID,
+		Start:  start.UTC(),
+		Stop:   stop.UTC(),
+		Status: types.NotificationCompleted,
+		Time:   time.Now().UTC(),
+	}
+	if reason != "" {
+		n.Status = types.NotificationFailed
+		n.Error = reason
+	} else {
+		n.Location = digestLocation(body.ID)
+	}
+	if err := h.Notifier.Notify(ctx, body.Callback, n); err != nil {
+		h.LogProvider(ctx).Error(logs.CallbackDeadLetter{
+			ID:       body.ID,
+			Callback: body.Callback,
+			Status:   n.Status,
+			Reason:   err.Error(),
+		})
+	}
 }
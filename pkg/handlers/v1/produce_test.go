@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -13,6 +14,9 @@ import (
 
 	"github.com/asecurityteam/go-vpcflow"
 	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/go-chi/chi"
 	"github.com/golang/mock/gomock"
 	"github.com/rs/xstats"
 	"github.com/stretchr/testify/assert"
@@ -44,10 +48,6 @@ func TestProduceBadRequst(t *testing.T) {
 			Name:    "invalid_stop",
 			Payload: fmt.Sprintf(payloadTpl, key, time.Now().Format(time.RFC3339Nano), ""),
 		},
-		{
-			Name:    "invalid_range",
-			Payload: fmt.Sprintf(payloadTpl, key, time.Now().Format(time.RFC3339Nano), time.Now().Add(-1*time.Minute).Format(time.RFC3339Nano)),
-		},
 	}
 
 	for _, tt := range tc {
@@ -61,6 +61,106 @@ func TestProduceBadRequst(t *testing.T) {
 			}
 			handler.ServeHTTP(w, r)
 			assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+			assert.Equal(t, common.ProblemContentType, w.Result().Header.Get("Content-Type"))
+		})
+	}
+}
+
+func TestProduceInvalidRange(t *testing.T) {
+	for _, unmarkErr := range []error{nil, errors.New("oops")} {
+		payload := fmt.Sprintf(payloadTpl, key, time.Now().Format(time.RFC3339Nano), time.Now().Add(-1*time.Minute).Format(time.RFC3339Nano))
+		r, _ := http.NewRequest(http.MethodPost, "/", ioutil.NopCloser(bytes.NewReader([]byte(payload))))
+		r = r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+		ctrl := gomock.NewController(t)
+		markerMock := NewMockMarker(ctrl)
+		markerMock.EXPECT().Unmark(gomock.Any(), key).Return(unmarkErr)
+		publisher := &recordingPublisher{}
+		w := httptest.NewRecorder()
+		handler := &Produce{
+			LogProvider:  logevent.FromContext,
+			StatProvider: xstats.FromContext,
+			Marker:       markerMock,
+			Events:       publisher,
+		}
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+		assert.Equal(t, common.ProblemContentType, w.Result().Header.Get("Content-Type"))
+		assert.Len(t, publisher.Events, 1)
+		assert.Equal(t, types.EventFailed, publisher.Events[0].Type)
+		assert.Equal(t, key, publisher.Events[0].DigestID)
+		ctrl.Finish()
+	}
+}
+
+func TestProduceUnknownTopic(t *testing.T) {
+	payload := fmt.Sprintf(payloadTpl, key, time.Now().Format(time.RFC3339Nano), time.Now().Format(time.RFC3339Nano))
+	r, _ := http.NewRequest(http.MethodPost, "/other-queue/event", bytes.NewReader([]byte(payload)))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("topic", "other-queue")
+	rctx.URLParams.Add("event", "event")
+	ctx := context.WithValue(context.Background(), chi.RouteCtxKey, rctx)
+	r = r.WithContext(logevent.NewContext(ctx, logevent.New(logevent.Config{Output: ioutil.Discard})))
+	w := httptest.NewRecorder()
+	handler := &Produce{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Topic:        "digest-queue",
+	}
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+	assert.Equal(t, common.ProblemContentType, w.Result().Header.Get("Content-Type"))
+}
+
+func TestProduceWindowRejected(t *testing.T) {
+	tc := []struct {
+		Name      string
+		Start     time.Time
+		Stop      time.Time
+		Policy    types.WindowPolicy
+		UnmarkErr error
+	}{
+		{
+			Name:  "future",
+			Start: time.Now(),
+			Stop:  time.Now().Add(time.Hour),
+		},
+		{
+			Name:   "too_large",
+			Start:  time.Now().Add(-3 * time.Hour),
+			Stop:   time.Now(),
+			Policy: types.WindowPolicy{MaxWindow: time.Hour, Split: true},
+		},
+		{
+			Name:      "unmark_error",
+			Start:     time.Now(),
+			Stop:      time.Now().Add(time.Hour),
+			UnmarkErr: errors.New("oops"),
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			payload := fmt.Sprintf(payloadTpl, key, tt.Start.Format(time.RFC3339Nano), tt.Stop.Format(time.RFC3339Nano))
+			r, _ := http.NewRequest(http.MethodPost, "/", ioutil.NopCloser(bytes.NewReader([]byte(payload))))
+			r = r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			markerMock := NewMockMarker(ctrl)
+			markerMock.EXPECT().Unmark(gomock.Any(), key).Return(tt.UnmarkErr)
+			publisher := &recordingPublisher{}
+			w := httptest.NewRecorder()
+			handler := &Produce{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+				Marker:       markerMock,
+				Policy:       tt.Policy,
+				Events:       publisher,
+			}
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Result().StatusCode)
+			assert.Len(t, publisher.Events, 1)
+			assert.Equal(t, types.EventFailed, publisher.Events[0].Type)
+			assert.Equal(t, key, publisher.Events[0].DigestID)
 		})
 	}
 }
@@ -95,7 +195,7 @@ func TestStorageError(t *testing.T) {
 	digesterMock.EXPECT().Digest().Return(ioutil.NopCloser(bytes.NewReader([]byte(""))), nil)
 
 	storageMock := NewMockStorage(ctrl)
-	storageMock.EXPECT().Store(gomock.Any(), key, gomock.Any()).Return(errors.New("oops"))
+	storageMock.EXPECT().Store(gomock.Any(), key, gomock.Any(), gomock.Any()).Return(errors.New("oops"))
 
 	start := time.Now().Add(-1 * time.Minute)
 	stop := time.Now()
@@ -121,7 +221,7 @@ func TestMarkerError(t *testing.T) {
 	digesterMock.EXPECT().Digest().Return(ioutil.NopCloser(bytes.NewReader([]byte(""))), nil)
 
 	storageMock := NewMockStorage(ctrl)
-	storageMock.EXPECT().Store(gomock.Any(), key, gomock.Any()).Return(nil)
+	storageMock.EXPECT().Store(gomock.Any(), key, gomock.Any(), gomock.Any()).Return(nil)
 
 	markerMock := NewMockMarker(ctrl)
 	markerMock.EXPECT().Unmark(gomock.Any(), key).Return(errors.New("oops"))
@@ -151,7 +251,8 @@ func TestHappyPath(t *testing.T) {
 	digesterMock.EXPECT().Digest().Return(ioutil.NopCloser(bytes.NewReader([]byte(""))), nil)
 
 	storageMock := NewMockStorage(ctrl)
-	storageMock.EXPECT().Store(gomock.Any(), key, gomock.Any()).Return(nil)
+	storageMock.EXPECT().Store(gomock.Any(), key, gomock.Any(), gomock.Any()).Return(nil)
+	storageMock.EXPECT().Stat(gomock.Any(), key).Return(types.DigestMetadata{ID: key}, nil)
 
 	markerMock := NewMockMarker(ctrl)
 	markerMock.EXPECT().Unmark(gomock.Any(), key).Return(nil)
@@ -172,3 +273,228 @@ func TestHappyPath(t *testing.T) {
 	handler.ServeHTTP(w, r)
 	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
 }
+
+type statsDigester struct {
+	*MockDigester
+	stats types.DigestStats
+}
+
+func (d *statsDigester) Stats() types.DigestStats {
+	return d.stats
+}
+
+func TestHappyPathRecordsMetadata(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	digesterMock := &statsDigester{
+		MockDigester: NewMockDigester(ctrl),
+		stats:        types.DigestStats{SourceObjects: 2, SourceBytes: 100, Records: 10},
+	}
+	digesterMock.EXPECT().Digest().Return(ioutil.NopCloser(bytes.NewReader([]byte(""))), nil)
+
+	start := time.Now().Add(-1 * time.Minute).Truncate(time.Minute)
+	stop := time.Now().Truncate(time.Minute)
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Store(gomock.Any(), key, gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ string, _ io.ReadCloser, meta types.DigestMetadata) error {
+			assert.Equal(t, key, meta.ID)
+			assert.True(t, start.Equal(meta.Start))
+			assert.True(t, stop.Equal(meta.Stop))
+			assert.Equal(t, "a/r", meta.Scope)
+			assert.False(t, meta.CreatedAt.IsZero())
+			assert.Equal(t, int64(10), meta.Records)
+			assert.Equal(t, int64(2), meta.SourceObjects)
+			return nil
+		})
+	storageMock.EXPECT().Stat(gomock.Any(), key).Return(types.DigestMetadata{ID: key}, nil)
+
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Unmark(gomock.Any(), key).Return(nil)
+
+	payload := []byte(fmt.Sprintf(payloadTpl, key, start.Format(time.RFC3339Nano), stop.Format(time.RFC3339Nano)))
+	r, _ := http.NewRequest(http.MethodPost, "/", ioutil.NopCloser(bytes.NewReader(payload)))
+	r = r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+	w := httptest.NewRecorder()
+	handler := &Produce{
+		LogProvider:      logevent.FromContext,
+		StatProvider:     xstats.FromContext,
+		Storage:          storageMock,
+		Marker:           markerMock,
+		DigesterProvider: func(_, _ time.Time) vpcflow.Digester { return digesterMock },
+		Scope:            types.Scope{Accounts: []string{"a"}, Regions: []string{"r"}},
+	}
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+}
+
+type recordingNotifier struct {
+	Callback      string
+	Notifications []types.Notification
+	Err           error
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, callback string, notification types.Notification) error {
+	n.Callback = callback
+	n.Notifications = append(n.Notifications, notification)
+	return n.Err
+}
+
+const callbackPayloadTpl = `{"id":"%s","start":"%s","stop":"%s","callback":"%s"}`
+
+func newCallbackRequest(start, stop time.Time, callback string) *http.Request {
+	payload := []byte(fmt.Sprintf(callbackPayloadTpl, key, start.Format(time.RFC3339Nano), stop.Format(time.RFC3339Nano), callback))
+	r, _ := http.NewRequest(http.MethodPost, "/", ioutil.NopCloser(bytes.NewReader(payload)))
+	return r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+}
+
+func TestProduceNotifiesCompletion(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	digesterMock := NewMockDigester(ctrl)
+	digesterMock.EXPECT().Digest().Return(ioutil.NopCloser(bytes.NewReader([]byte(""))), nil)
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Store(gomock.Any(), key, gomock.Any(), gomock.Any()).Return(nil)
+	storageMock.EXPECT().Stat(gomock.Any(), key).Return(types.DigestMetadata{ID: key}, nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Unmark(gomock.Any(), key).Return(nil)
+
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	stop := start.Add(time.Hour)
+	notifier := &recordingNotifier{}
+	handler := &Produce{
+		LogProvider:      logevent.FromContext,
+		StatProvider:     xstats.FromContext,
+		Storage:          storageMock,
+		Marker:           markerMock,
+		DigesterProvider: func(_, _ time.Time) vpcflow.Digester { return digesterMock },
+		Notifier:         notifier,
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newCallbackRequest(start, stop, "https://hooks.example.com"))
+
+	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+	assert.Equal(t, "https://hooks.example.com", notifier.Callback)
+	assert.Len(t, notifier.Notifications, 1)
+	n := notifier.Notifications[0]
+	assert.Equal(t, key, n.ID)
+	assert.Equal(t, start, n.Start)
+	assert.Equal(t, stop, n.Stop)
+	assert.Equal(t, types.NotificationCompleted, n.Status)
+	assert.Equal(t, "/digests/"+key, n.Location)
+	assert.Empty(t, n.Error)
+}
+
+func TestProduceNotifiesFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	digesterMock := NewMockDigester(ctrl)
+	digesterMock.EXPECT().Digest().Return(nil, errors.New("oops"))
+
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	notifier := &recordingNotifier{Err: errors.New("unreachable")}
+	handler := &Produce{
+		LogProvider:      logevent.FromContext,
+		StatProvider:     xstats.FromContext,
+		DigesterProvider: func(_, _ time.Time) vpcflow.Digester { return digesterMock },
+		Notifier:         notifier,
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newCallbackRequest(start, start.Add(time.Hour), "https://hooks.example.com"))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+	assert.Len(t, notifier.Notifications, 1)
+	assert.Equal(t, types.NotificationFailed, notifier.Notifications[0].Status)
+	assert.NotEmpty(t, notifier.Notifications[0].Error)
+	assert.Empty(t, notifier.Notifications[0].Location)
+}
+
+func TestProduceWithoutCallback(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	digesterMock := NewMockDigester(ctrl)
+	digesterMock.EXPECT().Digest().Return(nil, errors.New("oops"))
+
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	notifier := &recordingNotifier{}
+	handler := &Produce{
+		LogProvider:      logevent.FromContext,
+		StatProvider:     xstats.FromContext,
+		DigesterProvider: func(_, _ time.Time) vpcflow.Digester { return digesterMock },
+		Notifier:         notifier,
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newCallbackRequest(start, start.Add(time.Hour), ""))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+	assert.Empty(t, notifier.Notifications)
+}
+
+type recordingPublisher struct {
+	Events []types.Event
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, e types.Event) {
+	p.Events = append(p.Events, e)
+}
+
+func TestProducePublishesEvents(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	digesterMock := NewMockDigester(ctrl)
+	digesterMock.EXPECT().Digest().Return(ioutil.NopCloser(bytes.NewReader([]byte(""))), nil)
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Store(gomock.Any(), key, gomock.Any(), gomock.Any()).Return(nil)
+	storageMock.EXPECT().Stat(gomock.Any(), key).Return(types.DigestMetadata{ID: key}, nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Unmark(gomock.Any(), key).Return(nil)
+
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	publisher := &recordingPublisher{}
+	handler := &Produce{
+		LogProvider:      logevent.FromContext,
+		StatProvider:     xstats.FromContext,
+		Storage:          storageMock,
+		Marker:           markerMock,
+		DigesterProvider: func(_, _ time.Time) vpcflow.Digester { return digesterMock },
+		Events:           publisher,
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newCallbackRequest(start, start.Add(time.Hour), ""))
+
+	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+	assert.Len(t, publisher.Events, 2)
+	assert.Equal(t, types.EventStarted, publisher.Events[0].Type)
+	assert.Equal(t, types.EventCompleted, publisher.Events[1].Type)
+	assert.Equal(t, key, publisher.Events[1].DigestID)
+	assert.Equal(t, start, publisher.Events[1].Start)
+}
+
+func TestProducePublishesFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	digesterMock := NewMockDigester(ctrl)
+	digesterMock.EXPECT().Digest().Return(nil, errors.New("oops"))
+
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	publisher := &recordingPublisher{}
+	handler := &Produce{
+		LogProvider:      logevent.FromContext,
+		StatProvider:     xstats.FromContext,
+		DigesterProvider: func(_, _ time.Time) vpcflow.Digester { return digesterMock },
+		Events:           publisher,
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newCallbackRequest(start, start.Add(time.Hour), ""))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+	assert.Len(t, publisher.Events, 2)
+	assert.Equal(t, types.EventFailed, publisher.Events[1].Type)
+	assert.Equal(t, "the digest could not be created", publisher.Events[1].Error)
+}
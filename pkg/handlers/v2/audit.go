@@ -0,0 +1,58 @@
+package v2
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/auth"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/logs"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+)
+
+// logRequested records who requested the digest for a window, and the outcome
+func (h *Handler) logRequested(r *http.Request, id string, window types.Window, status string, opts common.JobOptions) {
+	h.LogProvider(r.Context()).Info(logs.DigestRequested{
+		Client: auth.ClientOf(r),
+		ID:     id,
+		Start:  common.FormatTime(window.Start),
+		Stop:   common.FormatTime(window.Stop),
+		Scope:  h.Scope.String(),
+		Force:  opts.Force,
+		Status: status,
+	})
+}
+
+// logServed records who was served the content of a digest. The window of the digest is read from its metadata,
+// and is omitted if the metadata is unavailable or the Storage cannot read metadata.
+func (h *Handler) logServed(r *http.Request, id string, redirect bool, bytes int64, started time.Time) {
+	logger := h.LogProvider(r.Context())
+	var window types.Window
+	meta, err := common.Stat(r.Context(), h.Storage, id)
+	switch err.(type) {
+	case nil:
+		window = types.Window{Start: meta.Start, Stop: meta.Stop}
+	case types.ErrNotFound, types.ErrUnsupported:
+	default:
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+	}
+	logger.Info(logs.DigestServed{
+		Client:     auth.ClientOf(r),
+		ID:         id,
+		Start:      common.FormatTime(window.Start),
+		Stop:       common.FormatTime(window.Stop),
+		Scope:      h.Scope.String(),
+		Redirect:   redirect,
+		Bytes:      bytes,
+		DurationMS: common.DurationMS(time.Since(started)),
+	})
+}
+
+// logDeleted records who deleted a digest
+func (h *Handler) logDeleted(r *http.Request, id string) {
+	h.LogProvider(r.Context()).Info(logs.DigestDeleted{
+		Client: auth.ClientOf(r),
+		ID:     id,
+		Scope:  h.Scope.String(),
+	})
+}
@@ -0,0 +1,97 @@
+package v2
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/auth"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/go-chi/chi"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/rs/xstats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// auditEvent returns the fields of the event with the given message in the logs
+func auditEvent(t *testing.T, buf *bytes.Buffer, message string) map[string]interface{} {
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var event map[string]interface{}
+		require.Nil(t, json.Unmarshal([]byte(line), &event))
+		if event["message"] == message {
+			return event
+		}
+	}
+	t.Fatalf("no %s event was logged", message)
+	return nil
+}
+
+func TestAuditRequested(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	stop := start.Add(time.Hour)
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(true, nil)
+
+	h := Handler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+	}
+	var buf bytes.Buffer
+	r := httptest.NewRequest(http.MethodPost, Prefix+"/jobs", strings.NewReader(windowBody(start, stop, "")))
+	r = r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: &buf})))
+	w := httptest.NewRecorder()
+	h.CreateJob(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// the client is identified by its IP address when the API does not require authentication
+	event := auditEvent(t, &buf, "digest-requested")
+	assert.Equal(t, "192.0.2.1", event["client"])
+	assert.Equal(t, "2019-01-01T00:00:00Z", event["start"])
+	assert.Equal(t, statusComplete, event["status"])
+}
+
+func TestAuditServedRedirect(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	id := uuid.New().String()
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Stat(gomock.Any(), id).Return(types.DigestMetadata{ID: id, Start: start, Stop: start.Add(time.Hour)}, nil)
+	h := Handler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      &presignStorage{MockStorage: storageMock},
+		Redirect:     true,
+		Scope:        types.Scope{Regions: []string{"us-west-2"}},
+	}
+	var buf bytes.Buffer
+	r := httptest.NewRequest(http.MethodGet, digestLocation(id)+"/content", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	ctx := logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: &buf}))
+	ctx = auth.NewContext(ctx, auth.Principal{Subject: "alice"})
+	w := httptest.NewRecorder()
+	h.GetDigestContent(w, r.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx)))
+	require.Equal(t, http.StatusFound, w.Code)
+
+	event := auditEvent(t, &buf, "digest-served")
+	assert.Equal(t, "alice", event["client"])
+	assert.Equal(t, id, event["id"])
+	assert.Equal(t, "2019-01-01T00:00:00Z", event["start"])
+	assert.Equal(t, "2019-01-01T01:00:00Z", event["stop"])
+	assert.Equal(t, "*/us-west-2", event["scope"])
+	assert.Equal(t, true, event["redirect"])
+}
@@ -0,0 +1,210 @@
+package v2
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/logs"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/metrics"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+)
+
+// digest describes a stored digest. Its content is served separately.
+type digest struct {
+	ID                 string      `json:"id"`
+	Start              string      `json:"start"`
+	Stop               string      `json:"stop"`
+	Scope              string      `json:"scope"`
+	CreatedAt          string      `json:"createdAt"`
+	Size               int64       `json:"size"`
+	Records            int64       `json:"records"`
+	SourceObjects      int64       `json:"sourceObjects"`
+	SourceLastModified string      `json:"sourceLastModified"`
+	Stale              bool        `json:"stale"`
+	Links              digestLinks `json:"links"`
+}
+
+type digestLinks struct {
+	Self    string `json:"self"`
+	Content string `json:"content"`
+	Job     string `json:"job"`
+}
+
+type digestList struct {
+	Digests   []digest `json:"digests"`
+	NextToken string   `json:"nextToken,omitempty"`
+}
+
+// ListDigests returns a page of the digests which have been stored, optionally filtered to those whose
+// window overlaps with the start/stop query parameters
+func (h *Handler) ListDigests(w http.ResponseWriter, r *http.Request) {
+	logger := h.LogProvider(r.Context())
+	options, err := common.ExtractListOptions(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
+		return
+	}
+	list, err := h.Storage.List(r.Context(), options)
+	if err != nil {
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusInternalServerError, common.CodeInternalError, "", "")
+		return
+	}
+	res := digestList{
+		Digests:   make([]digest, 0, len(list.Digests)),
+		NextToken: list.NextToken,
+	}
+	for _, meta := range list.Digests {
+		res.Digests = append(res.Digests, newDigest(meta))
+	}
+	writeJSON(w, "application/json", http.StatusOK, res)
+}
+
+// GetDigest describes the digest with the given ID. If the Storage cannot read metadata, an existing digest is
+// described by its ID and links alone.
+func (h *Handler) GetDigest(w http.ResponseWriter, r *http.Request) {
+	logger := h.LogProvider(r.Context())
+	id, err := extractID(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
+		return
+	}
+	meta, err := common.Stat(r.Context(), h.Storage, id)
+	if _, ok := err.(types.ErrUnsupported); ok {
+		meta, err = h.exists(r.Context(), id)
+	}
+	if err != nil {
+		h.writeStorageError(w, r, id, err)
+		return
+	}
+	writeJSON(w, "application/json", http.StatusOK, newDigest(meta))
+}
+
+// GetDigestContent serves the content of the digest with the given ID, either directly or as a redirect
+func (h *Handler) GetDigestContent(w http.ResponseWriter, r *http.Request) {
+	logger := h.LogProvider(r.Context())
+	id, err := extractID(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
+		return
+	}
+	started := time.Now()
+	if h.Redirect && h.redirect(w, r, id, started) {
+		return
+	}
+	stat := h.StatProvider(r.Context())
+	body, err := h.Storage.Get(r.Context(), id)
+	metrics.TimeDependency(stat, logs.DependencyStorage, "get", started, err)
+	if err != nil {
+		stat.Count(metrics.DigestServed, 1, metrics.Status(metrics.StorageStatus(err)))
+		h.writeStorageError(w, r, id, err)
+		return
+	}
+	defer body.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	n, _ := io.Copy(w, body)
+	stat.Count(metrics.DigestServed, 1, metrics.Status(metrics.StatusServed))
+	h.logServed(r, id, false, n, started)
+}
+
+// DeleteDigest removes a digest, including any in progress state, so that it may be regenerated
+func (h *Handler) DeleteDigest(w http.ResponseWriter, r *http.Request) {
+	logger := h.LogProvider(r.Context())
+	id, err := extractID(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
+		return
+	}
+	if err := h.Storage.Delete(r.Context(), id); err != nil {
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusInternalServerError, common.CodeInternalError, "", id)
+		return
+	}
+	h.logDeleted(r, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// redirect attempts to respond with a redirect to a pre-signed URL for the digest. If the Storage is not
+// capable of presigning URLs, no response is written and false is returned so that the caller may fall back
+// to proxying the digest.
+func (h *Handler) redirect(w http.ResponseWriter, r *http.Request, id string, started time.Time) bool {
+	presigner, ok := h.Storage.(types.Presigner)
+	if !ok {
+		return false
+	}
+	ttl := h.RedirectTTL
+	if ttl == 0 {
+		ttl = defaultRedirectTTL
+	}
+	location, err := presigner.Presign(r.Context(), id, ttl)
+	if _, ok := err.(types.ErrUnsupported); ok {
+		return false
+	}
+	if err != nil {
+		h.StatProvider(r.Context()).Count(metrics.DigestServed, 1, metrics.Status(metrics.StorageStatus(err)))
+		h.writeStorageError(w, r, id, err)
+		return true
+	}
+	http.Redirect(w, r, location, http.StatusFound)
+	h.StatProvider(r.Context()).Count(metrics.DigestServed, 1, metrics.Status(metrics.StatusRedirected))
+	h.logServed(r, id, true, 0, started)
+	return true
+}
+
+// exists returns metadata holding only the ID of the digest, or an error of type types.ErrNotFound if it does
+// not exist
+func (h *Handler) exists(ctx context.Context, id string) (types.DigestMetadata, error) {
+	exists, err := h.Storage.Exists(ctx, id)
+	if err != nil {
+		return types.DigestMetadata{}, err
+	}
+	if !exists {
+		return types.DigestMetadata{}, types.ErrNotFound{ID: id}
+	}
+	return types.DigestMetadata{ID: id}, nil
+}
+
+// writeStorageError translates an error returned from a Storage lookup into the appropriate response. Digests
+// which are still being created do not exist yet, and their job should be consulted instead.
+func (h *Handler) writeStorageError(w http.ResponseWriter, r *http.Request, id string, err error) {
+	logger := h.LogProvider(r.Context())
+	switch err.(type) {
+	case types.ErrInProgress:
+		logger.Info(logs.NotFound{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusNotFound, common.CodeDigestInProgress, err.Error(), id)
+	case types.ErrNotFound:
+		logger.Info(logs.NotFound{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusNotFound, common.CodeDigestNotFound, err.Error(), id)
+	default:
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusInternalServerError, common.CodeInternalError, "", id)
+	}
+}
+
+func newDigest(meta types.DigestMetadata) digest {
+	return digest{
+		ID:                 meta.ID,
+		Start:              common.FormatTime(meta.Start),
+		Stop:               common.FormatTime(meta.Stop),
+		Scope:              meta.Scope,
+		CreatedAt:          common.FormatTime(meta.CreatedAt),
+		Size:               meta.Size,
+		Records:            meta.Records,
+		SourceObjects:      meta.SourceObjects,
+		SourceLastModified: common.FormatTime(meta.SourceLastModified),
+		Stale:              meta.Stale,
+		Links: digestLinks{
+			Self:    digestLocation(meta.ID),
+			Content: digestLocation(meta.ID) + "/content",
+			Job:     jobLocation(meta.ID),
+		},
+	}
+}
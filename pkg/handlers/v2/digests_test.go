@@ -0,0 +1,268 @@
+package v2
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/rs/xstats"
+	"github.com/stretchr/testify/assert"
+)
+
+type presignStorage struct {
+	*MockStorage
+	err error
+}
+
+func (s *presignStorage) Presign(_ context.Context, key string, _ time.Duration) (string, error) {
+	return "https://bucket.example.com/" + key, s.err
+}
+
+func TestListDigests(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	id := uuid.New().String()
+	start := time.Now().Add(-time.Hour).UTC()
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().List(gomock.Any(), types.ListOptions{Limit: 10, Token: "page"}).Return(types.DigestList{
+		Digests:   []types.DigestMetadata{{ID: id, Start: start, Stale: true}},
+		NextToken: "next",
+	}, nil)
+
+	h := Handler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+	}
+	r, _ := http.NewRequest(http.MethodGet, "/v2/digests?limit=10&token=page", nil)
+	w := httptest.NewRecorder()
+	h.ListDigests(w, r.WithContext(newContext()))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var res digestList
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, "next", res.NextToken)
+	assert.Len(t, res.Digests, 1)
+	assert.Equal(t, start.Format(time.RFC3339Nano), res.Digests[0].Start)
+	assert.Equal(t, "", res.Digests[0].Stop)
+	assert.True(t, res.Digests[0].Stale)
+	assert.Equal(t, digestLinks{Self: "/v2/digests/" + id, Content: "/v2/digests/" + id + "/content", Job: "/v2/jobs/" + id}, res.Digests[0].Links)
+}
+
+func TestListDigestsErrors(t *testing.T) {
+	tc := []struct {
+		Name   string
+		Query  string
+		Err    error
+		Status int
+	}{
+		{Name: "bad_start", Query: "start=yesterday", Status: http.StatusBadRequest},
+		{Name: "bad_range", Query: "start=2019-01-02T00:00:00Z&stop=2019-01-01T00:00:00Z", Status: http.StatusBadRequest},
+		{Name: "limit_too_large", Query: "limit=1001", Status: http.StatusBadRequest},
+		{Name: "storage_error", Err: errors.New("oops"), Status: http.StatusInternalServerError},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			storageMock := NewMockStorage(ctrl)
+			if tt.Err != nil {
+				storageMock.EXPECT().List(gomock.Any(), gomock.Any()).Return(types.DigestList{}, tt.Err)
+			}
+			h := Handler{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+				Storage:      storageMock,
+			}
+			r, _ := http.NewRequest(http.MethodGet, "/v2/digests?"+tt.Query, nil)
+			w := httptest.NewRecorder()
+			h.ListDigests(w, r.WithContext(newContext()))
+			assert.Equal(t, tt.Status, w.Code)
+		})
+	}
+}
+
+func TestGetDigest(t *testing.T) {
+	tc := []struct {
+		Name   string
+		Err    error
+		Status int
+		Code   string
+	}{
+		{Name: "found", Status: http.StatusOK},
+		{Name: "not_found", Err: types.ErrNotFound{}, Status: http.StatusNotFound, Code: common.CodeDigestNotFound},
+		{Name: "in_progress", Err: types.ErrInProgress{}, Status: http.StatusNotFound, Code: common.CodeDigestInProgress},
+		{Name: "storage_error", Err: errors.New("oops"), Status: http.StatusInternalServerError, Code: common.CodeInternalError},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			id := uuid.New().String()
+			storageMock := NewMockStorage(ctrl)
+			storageMock.EXPECT().Stat(gomock.Any(), id).Return(types.DigestMetadata{ID: id, Size: 10}, tt.Err)
+			h := Handler{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+				Storage:      storageMock,
+			}
+			w := httptest.NewRecorder()
+			h.GetDigest(w, newIDRequest(http.MethodGet, digestLocation(id), id))
+			assert.Equal(t, tt.Status, w.Code)
+			if tt.Code == "" {
+				var res digest
+				assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+				assert.Equal(t, id, res.ID)
+				assert.Equal(t, int64(10), res.Size)
+				return
+			}
+			var p common.Problem
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &p))
+			assert.Equal(t, tt.Code, p.Code)
+			assert.Equal(t, id, p.DigestID)
+		})
+	}
+}
+
+func TestGetDigestWithoutStat(t *testing.T) {
+	tc := []struct {
+		Name   string
+		Exists bool
+		Status int
+	}{
+		{Name: "found", Exists: true, Status: http.StatusOK},
+		{Name: "not_found", Status: http.StatusNotFound},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			id := uuid.New().String()
+			storageMock := NewMockStorage(ctrl)
+			storageMock.EXPECT().Exists(gomock.Any(), id).Return(tt.Exists, nil)
+			h := Handler{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+				// only the methods of types.Storage are visible through the embedded interface
+				Storage: struct{ types.Storage }{storageMock},
+			}
+			w := httptest.NewRecorder()
+			h.GetDigest(w, newIDRequest(http.MethodGet, digestLocation(id), id))
+			assert.Equal(t, tt.Status, w.Code)
+			if tt.Exists {
+				var res digest
+				assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+				assert.Equal(t, id, res.ID)
+				assert.Equal(t, digestLocation(id), res.Links.Self)
+			}
+		})
+	}
+}
+
+func TestGetDigestContent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	id := uuid.New().String()
+	data := "this is the digest you're looking for"
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Get(gomock.Any(), id).Return(ioutil.NopCloser(bytes.NewBufferString(data)), nil)
+	storageMock.EXPECT().Stat(gomock.Any(), id).Return(types.DigestMetadata{ID: id}, nil)
+
+	// redirecting falls back to proxying when the storage cannot presign URLs
+	h := Handler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		Redirect:     true,
+	}
+	w := httptest.NewRecorder()
+	h.GetDigestContent(w, newIDRequest(http.MethodGet, digestLocation(id)+"/content", id))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/octet-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, data, w.Body.String())
+}
+
+func TestGetDigestContentRedirect(t *testing.T) {
+	tc := []struct {
+		Name     string
+		Err      error
+		Status   int
+		Location string
+	}{
+		{Name: "redirect", Status: http.StatusFound, Location: "https://bucket.example.com/"},
+		{Name: "in_progress", Err: types.ErrInProgress{}, Status: http.StatusNotFound},
+		{Name: "unsupported", Err: types.ErrUnsupported{}, Status: http.StatusOK},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			id := uuid.New().String()
+			storageMock := NewMockStorage(ctrl)
+			if tt.Status == http.StatusOK {
+				storageMock.EXPECT().Get(gomock.Any(), id).Return(ioutil.NopCloser(bytes.NewBufferString("digest")), nil)
+			}
+			if tt.Err == nil || tt.Status == http.StatusOK {
+				storageMock.EXPECT().Stat(gomock.Any(), id).Return(types.DigestMetadata{ID: id}, nil)
+			}
+			h := Handler{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+				Storage:      &presignStorage{MockStorage: storageMock, err: tt.Err},
+				Redirect:     true,
+			}
+			w := httptest.NewRecorder()
+			h.GetDigestContent(w, newIDRequest(http.MethodGet, digestLocation(id)+"/content", id))
+			assert.Equal(t, tt.Status, w.Code)
+			if tt.Location != "" {
+				assert.Equal(t, tt.Location+id, w.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestDeleteDigest(t *testing.T) {
+	tc := []struct {
+		Name   string
+		Err    error
+		Status int
+	}{
+		{Name: "deleted", Status: http.StatusNoContent},
+		{Name: "storage_error", Err: errors.New("oops"), Status: http.StatusInternalServerError},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			id := uuid.New().String()
+			storageMock := NewMockStorage(ctrl)
+			storageMock.EXPECT().Delete(gomock.Any(), id).Return(tt.Err)
+			h := Handler{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+				Storage:      storageMock,
+			}
+			w := httptest.NewRecorder()
+			h.DeleteDigest(w, newIDRequest(http.MethodDelete, digestLocation(id), id))
+			assert.Equal(t, tt.Status, w.Code)
+		})
+	}
+}
@@ -0,0 +1,7 @@
+// Package v2 contains all handlers used to service the version 2.X.X API.
+//
+// Unlike version 1, the API is resource oriented. A job creates the digest for a window, and shares its ID with
+// the digest. Jobs are created with a JSON body rather than query parameters, and digests are described by
+// JSON metadata, with their content served separately.
+//
+package v2
@@ -0,0 +1,80 @@
+package v2
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/ratelimit"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+const (
+	// Prefix is the path under which the version 2 API is served
+	Prefix = "/v2"
+
+	defaultRedirectTTL = 5 * time.Minute
+)
+
+// Handler handles incoming HTTP requests for the jobs and digests resources
+type Handler struct {
+	LogProvider  types.LogFn
+	StatProvider types.StatFn
+	Storage      types.Storage
+	Marker       types.Marker
+	Queuer       types.Queuer
+	// Redirect, when true, causes GetDigestContent to respond with a redirect to a short-lived pre-signed URL
+	// instead of proxying the digest, provided the Storage implements types.Presigner.
+	Redirect bool
+	// RedirectTTL is the length of time for which pre-signed URLs are valid. Defaults to 5 minutes.
+	RedirectTTL time.Duration
+	// AuthorizeForce, if set, is consulted before honoring a request to regenerate a digest which
+	// already exists. Returning an error rejects the request with a 403. If not set, all requests
+	// to regenerate a digest are allowed.
+	AuthorizeForce func(r *http.Request) error
+	// Policy constrains the windows for which digests may be created. Requests which violate it are
+	// rejected with a 422, or, if the policy allows it, oversized windows are split into multiple jobs.
+	Policy types.WindowPolicy
+	// Scope describes the accounts and regions which digests are created from. It is part of the digest ID.
+	Scope types.Scope
+	// EstimatedDurationPerHour is the estimated time taken to digest an hour of flow logs. It is used to estimate
+	// when queued jobs will be complete. If zero, no estimate is given.
+	EstimatedDurationPerHour time.Duration
+	// ValidateCallback, if set, enables the callback field of a job. It returns an error if the callback URL is
+	// not allowed. The Queuer must implement types.CallbackQueuer.
+	ValidateCallback func(callback string) error
+	// Events, if set, is published to whenever a job is queued
+	Events types.Publisher
+	// Limiter, if set, limits the creation of digests. Clients are charged for each job queued, so a window which
+	// is split is charged for each of its parts.
+	Limiter *ratelimit.Limiter
+}
+
+// extractID extracts the job or digest ID from the request path. An error is returned if the ID is not a valid UUID.
+func extractID(r *http.Request) (string, error) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return "", fmt.Errorf("invalid ID: %s", err.Error())
+	}
+	return id.String(), nil
+}
+
+// jobLocation returns the URL of the job with the given ID
+func jobLocation(id string) string {
+	return Prefix + "/jobs/" + id
+}
+
+// digestLocation returns the URL of the digest with the given ID
+func digestLocation(id string) string {
+	return Prefix + "/digests/" + id
+}
+
+// writeJSON writes a JSON response body with the given content type
+func writeJSON(w http.ResponseWriter, contentType string, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,203 @@
+package v2
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/auth"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/logs"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/ratelimit"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+)
+
+const (
+	// statusComplete is the status of a job whose digest exists
+	statusComplete = "complete"
+	// statusFailed is the status of a job which could not be queued
+	statusFailed = "failed"
+)
+
+// jobRequest is the body of a request to create the digest for a window
+type jobRequest struct {
+	// Start and Stop are parsed as RFC3339Nano timestamps, and truncated to minute precision
+	Start string `json:"start"`
+	Stop  string `json:"stop"`
+	// Force requests that the digest be regenerated if it already exists
+	Force bool `json:"force"`
+	// Callback, if set, is a URL to notify when the digest is complete
+	Callback string `json:"callback"`
+}
+
+// job describes the work of creating a digest. A job shares its ID with the digest it creates.
+type job struct {
+	ID string `json:"id"`
+	// Start and Stop are the window of the digest, in UTC, after truncation. They are only known when the job
+	// is created.
+	Start  string `json:"start,omitempty"`
+	Stop   string `json:"stop,omitempty"`
+	Status string `json:"status"`
+	// EstimatedCompletion is only set for jobs which were queued
+	EstimatedCompletion string   `json:"estimatedCompletion,omitempty"`
+	Links               jobLinks `json:"links"`
+}
+
+type jobLinks struct {
+	Self   string `json:"self"`
+	Digest string `json:"digest"`
+}
+
+// jobList is the response to a request to create jobs. A request creates more than one job if its window was
+// longer than the Policy allows, and the Policy splits such windows.
+type jobList struct {
+	Jobs []job `json:"jobs"`
+}
+
+// CreateJob creates the jobs for the window in the JSON body of the request. Windows whose digest already exists,
+// or is being created, are not queued again unless the request forces regeneration. The response lists every job,
+// and is a 202 if any were queued. Otherwise, it is a 429 if any were not queued because the client or the service
+// is over a limit, a 500 if any could not be queued, so that the request may be retried as a whole, or a 200.
+// Jobs which were not queued because of a limit have the status "rate_limited", and those which could not be
+// queued have the status "failed".
+func (h *Handler) CreateJob(w http.ResponseWriter, r *http.Request) {
+	logger := h.LogProvider(r.Context())
+	var req jobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
+		return
+	}
+	requested, err := types.ParseWindow(req.Start, req.Stop)
+	if err == nil && !requested.Stop.After(requested.Start) {
+		err = types.ErrEmptyWindow
+	}
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
+		return
+	}
+	if req.Force && h.AuthorizeForce != nil {
+		if err = h.AuthorizeForce(r); err != nil {
+			logger.Info(logs.Forbidden{Reason: err.Error()})
+			common.WriteProblem(w, r, http.StatusForbidden, common.CodeForbidden, err.Error(), "")
+			return
+		}
+	}
+	if err = common.CheckCallback(h.Queuer, h.ValidateCallback, req.Callback); err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeCallbackRejected, err.Error(), "")
+		return
+	}
+	now := time.Now()
+	windows, err := h.Policy.Windows(requested.Start, requested.Stop, now)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusUnprocessableEntity, common.CodeWindowRejected, err.Error(), "")
+		return
+	}
+
+	res := jobList{Jobs: make([]job, 0, len(windows))}
+	statusCode := http.StatusOK
+	failed := false
+	opts := common.JobOptions{Force: req.Force, Callback: req.Callback, Client: auth.ClientOf(r)}
+	var limited *ratelimit.ErrLimited
+	for _, window := range windows {
+		id := types.DigestKey{Start: window.Start, Stop: window.Stop, Scope: h.Scope}.ID()
+		status, err := h.jobs().Queue(r.Context(), id, window, opts)
+		if e, ok := err.(ratelimit.ErrLimited); ok {
+			if limited == nil || e.RetryAfter > limited.RetryAfter {
+				limited = &e
+			}
+			res.Jobs = append(res.Jobs, h.newJob(id, window, common.StatusRateLimited, now))
+			continue
+		}
+		if err != nil {
+			failed = true
+			res.Jobs = append(res.Jobs, h.newJob(id, window, statusFailed, now))
+			continue
+		}
+		if status == common.StatusExists {
+			status = statusComplete
+		}
+		h.logRequested(r, id, window, status, opts)
+		if status == common.StatusQueued {
+			statusCode = http.StatusAccepted
+		}
+		res.Jobs = append(res.Jobs, h.newJob(id, window, status, now))
+	}
+	if limited != nil && statusCode != http.StatusAccepted {
+		common.TooManyRequests(w, r, *limited)
+		return
+	}
+	if failed && statusCode != http.StatusAccepted {
+		common.WriteProblem(w, r, http.StatusInternalServerError, common.CodeInternalError, "", "")
+		return
+	}
+	if len(res.Jobs) == 1 {
+		w.Header().Set("Location", jobLocation(res.Jobs[0].ID))
+	}
+	writeJSON(w, "application/json", statusCode, res)
+}
+
+// GetJob reports the status of the job with the given ID
+func (h *Handler) GetJob(w http.ResponseWriter, r *http.Request) {
+	logger := h.LogProvider(r.Context())
+	id, err := extractID(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
+		return
+	}
+	exists, err := h.Storage.Exists(r.Context(), id)
+	switch err.(type) {
+	case nil:
+	case types.ErrInProgress:
+		writeJSON(w, "application/json", http.StatusOK, newJobStatus(id, common.StatusInProgress))
+		return
+	default:
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		common.WriteProblem(w, r, http.StatusInternalServerError, common.CodeInternalError, "", id)
+		return
+	}
+	if !exists {
+		msg := "job " + id + " not found"
+		logger.Info(logs.NotFound{Reason: msg})
+		common.WriteProblem(w, r, http.StatusNotFound, common.CodeJobNotFound, msg, id)
+		return
+	}
+	writeJSON(w, "application/json", http.StatusOK, newJobStatus(id, statusComplete))
+}
+
+// jobs returns the Queue of the jobs which create digests
+func (h *Handler) jobs() *common.Queue {
+	return &common.Queue{
+		LogProvider:  h.LogProvider,
+		StatProvider: h.StatProvider,
+		Storage:      h.Storage,
+		Marker:       h.Marker,
+		Queuer:       h.Queuer,
+		Events:       h.Events,
+		Limiter:      h.Limiter,
+	}
+}
+
+// newJob describes the job with the given status for the digest of the window
+func (h *Handler) newJob(id string, window types.Window, status string, now time.Time) job {
+	j := newJobStatus(id, status)
+	j.Start = common.FormatTime(window.Start)
+	j.Stop = common.FormatTime(window.Stop)
+	if status == common.StatusQueued && h.EstimatedDurationPerHour > 0 {
+		j.EstimatedCompletion = common.FormatTime(common.EstimateCompletion(window, now, h.EstimatedDurationPerHour))
+	}
+	return j
+}
+
+// newJobStatus describes the job with the given ID and status, when its window is not known
+func newJobStatus(id string, status string) job {
+	return job{
+		ID:     id,
+		Status: status,
+		Links:  jobLinks{Self: jobLocation(id), Digest: digestLocation(id)},
+	}
+}
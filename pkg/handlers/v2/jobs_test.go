@@ -0,0 +1,335 @@
+package v2
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/ratelimit"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/go-chi/chi"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/rs/xstats"
+	"github.com/stretchr/testify/assert"
+)
+
+func newContext() context.Context {
+	return logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard}))
+}
+
+func newJobRequest(body string) *http.Request {
+	r, _ := http.NewRequest(http.MethodPost, Prefix+"/jobs", bytes.NewBufferString(body))
+	return r.WithContext(newContext())
+}
+
+func newIDRequest(method string, path string, id string) *http.Request {
+	r, _ := http.NewRequest(method, path, nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	return r.WithContext(context.WithValue(newContext(), chi.RouteCtxKey, rctx))
+}
+
+func windowBody(start, stop time.Time, extra string) string {
+	return `{"start":"` + start.Format(time.RFC3339Nano) + `","stop":"` + stop.Format(time.RFC3339Nano) + `"` + extra + `}`
+}
+
+type callbackQueuer struct {
+	*MockQueuer
+	callback string
+}
+
+func (q *callbackQueuer) QueueWithCallback(ctx context.Context, id string, start, stop time.Time, callback string) error {
+	q.callback = callback
+	return nil
+}
+
+func TestCreateJobBadRequest(t *testing.T) {
+	now := time.Now()
+	tc := []struct {
+		Name   string
+		Body   string
+		Status int
+		Code   string
+	}{
+		{Name: "not_json", Body: "start", Status: http.StatusBadRequest, Code: common.CodeInvalidRequest},
+		{Name: "bad_start", Body: `{"start":"yesterday","stop":"` + now.Format(time.RFC3339) + `"}`, Status: http.StatusBadRequest, Code: common.CodeInvalidRequest},
+		{Name: "bad_range", Body: windowBody(now, now.Add(-time.Hour), ""), Status: http.StatusBadRequest, Code: common.CodeInvalidRequest},
+		{Name: "empty_range", Body: windowBody(now.Add(-time.Hour), now.Add(-time.Hour), ""), Status: http.StatusBadRequest, Code: common.CodeInvalidRequest},
+		{Name: "callbacks_disabled", Body: windowBody(now.Add(-time.Hour), now, `,"callback":"https://example.com"`), Status: http.StatusBadRequest, Code: common.CodeCallbackRejected},
+		{Name: "forbidden", Body: windowBody(now.Add(-time.Hour), now, `,"force":true`), Status: http.StatusForbidden, Code: common.CodeForbidden},
+		{Name: "window_rejected", Body: windowBody(now.Add(-time.Hour), now.Add(time.Hour), ""), Status: http.StatusUnprocessableEntity, Code: common.CodeWindowRejected},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			h := Handler{
+				LogProvider:    logevent.FromContext,
+				StatProvider:   xstats.FromContext,
+				AuthorizeForce: func(*http.Request) error { return errors.New("no") },
+			}
+			w := httptest.NewRecorder()
+			h.CreateJob(w, newJobRequest(tt.Body))
+			assert.Equal(t, tt.Status, w.Code)
+			var p common.Problem
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &p))
+			assert.Equal(t, tt.Code, p.Code)
+		})
+	}
+}
+
+func TestCreateJob(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	start := time.Now().Add(-2 * time.Hour).Truncate(time.Minute)
+	stop := start.Add(time.Hour)
+	id := types.DigestKey{Start: start, Stop: stop}.ID()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), id).Return(false, nil)
+	queuer := &callbackQueuer{MockQueuer: NewMockQueuer(ctrl)}
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), id).Return(nil)
+
+	h := Handler{
+		LogProvider:              logevent.FromContext,
+		StatProvider:             xstats.FromContext,
+		Storage:                  storageMock,
+		Queuer:                   queuer,
+		Marker:                   markerMock,
+		EstimatedDurationPerHour: time.Minute,
+		ValidateCallback:         func(string) error { return nil },
+	}
+	w := httptest.NewRecorder()
+	h.CreateJob(w, newJobRequest(windowBody(start, stop, `,"callback":"https://example.com/hook"`)))
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "/v2/jobs/"+id, w.Header().Get("Location"))
+	assert.Equal(t, "https://example.com/hook", queuer.callback)
+	var res jobList
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Len(t, res.Jobs, 1)
+	assert.Equal(t, id, res.Jobs[0].ID)
+	assert.Equal(t, common.StatusQueued, res.Jobs[0].Status)
+	assert.Equal(t, common.FormatTime(start), res.Jobs[0].Start)
+	assert.NotEmpty(t, res.Jobs[0].EstimatedCompletion)
+	assert.Equal(t, jobLinks{Self: "/v2/jobs/" + id, Digest: "/v2/digests/" + id}, res.Jobs[0].Links)
+}
+
+func TestCreateJobNotQueued(t *testing.T) {
+	tc := []struct {
+		Name       string
+		ExistsErr  error
+		Exists     bool
+		Status     int
+		JobStatus  string
+		ResultCode string
+	}{
+		{Name: "complete", Exists: true, Status: http.StatusOK, JobStatus: statusComplete},
+		{Name: "in_progress", ExistsErr: types.ErrInProgress{}, Status: http.StatusOK, JobStatus: common.StatusInProgress},
+		{Name: "storage_error", ExistsErr: errors.New("oops"), Status: http.StatusInternalServerError},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			storageMock := NewMockStorage(ctrl)
+			storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(tt.Exists, tt.ExistsErr)
+			h := Handler{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+				Storage:      storageMock,
+			}
+			start := time.Now().Add(-2 * time.Hour)
+			w := httptest.NewRecorder()
+			h.CreateJob(w, newJobRequest(windowBody(start, start.Add(time.Hour), "")))
+			assert.Equal(t, tt.Status, w.Code)
+			if tt.JobStatus != "" {
+				var res jobList
+				assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+				assert.Equal(t, tt.JobStatus, res.Jobs[0].Status)
+				assert.Empty(t, res.Jobs[0].EstimatedCompletion)
+			}
+		})
+	}
+}
+
+func TestCreateJobForce(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(true, nil)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(errors.New("oops"))
+
+	h := Handler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		Queuer:       queuerMock,
+		Marker:       markerMock,
+	}
+	start := time.Now().Add(-2 * time.Hour)
+	w := httptest.NewRecorder()
+	h.CreateJob(w, newJobRequest(windowBody(start, start.Add(time.Hour), `,"force":true`)))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+}
+
+func TestCreateJobSplit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, nil).Times(3)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("oops"))
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+
+	h := Handler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		Queuer:       queuerMock,
+		Marker:       markerMock,
+		Policy:       types.WindowPolicy{MaxWindow: time.Hour, Split: true},
+	}
+	start := time.Now().Add(-4 * time.Hour).Truncate(time.Hour)
+	w := httptest.NewRecorder()
+	h.CreateJob(w, newJobRequest(windowBody(start, start.Add(3*time.Hour), "")))
+	// the jobs which were queued are reported along with the one which failed
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var res jobList
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&res))
+	statuses := make([]string, 0, len(res.Jobs))
+	for _, j := range res.Jobs {
+		statuses = append(statuses, j.Status)
+	}
+	assert.Equal(t, []string{common.StatusQueued, common.StatusQueued, statusFailed}, statuses)
+}
+
+func TestCreateJobSplitFailed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, errors.New("oops")).Times(3)
+
+	h := Handler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		Queuer:       NewMockQueuer(ctrl),
+		Marker:       NewMockMarker(ctrl),
+		Policy:       types.WindowPolicy{MaxWindow: time.Hour, Split: true},
+	}
+	start := time.Now().Add(-4 * time.Hour).Truncate(time.Hour)
+	w := httptest.NewRecorder()
+	h.CreateJob(w, newJobRequest(windowBody(start, start.Add(3*time.Hour), "")))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestCreateJobRateLimited(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, nil).Times(4)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+
+	now := time.Unix(1500000000, 0)
+	h := Handler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+		Queuer:       queuerMock,
+		Marker:       markerMock,
+		Policy:       types.WindowPolicy{MaxWindow: time.Hour, Split: true},
+		Limiter: &ratelimit.Limiter{Limits: &ratelimit.Limits{
+			Default: ratelimit.Limit{PerMinute: 2},
+			Now:     func() time.Time { return now },
+		}},
+	}
+	start := time.Now().Add(-4 * time.Hour).Truncate(time.Hour)
+
+	// each job of a split window is charged
+	w := httptest.NewRecorder()
+	h.CreateJob(w, newJobRequest(windowBody(start, start.Add(3*time.Hour), "")))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var res jobList
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&res))
+	statuses := make([]string, 0, len(res.Jobs))
+	for _, j := range res.Jobs {
+		statuses = append(statuses, j.Status)
+	}
+	assert.Equal(t, []string{common.StatusQueued, common.StatusQueued, common.StatusRateLimited}, statuses)
+
+	w = httptest.NewRecorder()
+	h.CreateJob(w, newJobRequest(windowBody(start, start.Add(time.Hour), "")))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+}
+
+func TestGetJob(t *testing.T) {
+	tc := []struct {
+		Name      string
+		Exists    bool
+		ExistsErr error
+		Status    int
+		JobStatus string
+	}{
+		{Name: "complete", Exists: true, Status: http.StatusOK, JobStatus: statusComplete},
+		{Name: "in_progress", ExistsErr: types.ErrInProgress{}, Status: http.StatusOK, JobStatus: common.StatusInProgress},
+		{Name: "not_found", Status: http.StatusNotFound},
+		{Name: "storage_error", ExistsErr: errors.New("oops"), Status: http.StatusInternalServerError},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			id := uuid.New().String()
+			storageMock := NewMockStorage(ctrl)
+			storageMock.EXPECT().Exists(gomock.Any(), id).Return(tt.Exists, tt.ExistsErr)
+			h := Handler{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+				Storage:      storageMock,
+			}
+			w := httptest.NewRecorder()
+			h.GetJob(w, newIDRequest(http.MethodGet, jobLocation(id), id))
+			assert.Equal(t, tt.Status, w.Code)
+			if tt.JobStatus != "" {
+				var res job
+				assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+				assert.Equal(t, newJobStatus(id, tt.JobStatus), res)
+			}
+		})
+	}
+}
+
+func TestGetJobBadID(t *testing.T) {
+	h := Handler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+	}
+	w := httptest.NewRecorder()
+	h.GetJob(w, newIDRequest(http.MethodGet, "/v2/jobs/job", "job"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,130 @@
+package v2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/metrics"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/rs/xstats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordedStat is a stat sent to recordingStats
+type recordedStat struct {
+	Name  string
+	Value float64
+	Tags  []string
+}
+
+// recordingStats is an xstats.XStater which records the stats sent to it
+type recordingStats struct {
+	lock  sync.Mutex
+	stats []recordedStat
+}
+
+func (s *recordingStats) record(name string, value float64, tags []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stats = append(s.stats, recordedStat{Name: name, Value: value, Tags: tags})
+}
+
+func (s *recordingStats) Gauge(stat string, value float64, tags ...string) {
+	s.record(stat, value, tags)
+}
+
+func (s *recordingStats) Count(stat string, count float64, tags ...string) {
+	s.record(stat, count, tags)
+}
+
+func (s *recordingStats) Histogram(stat string, value float64, tags ...string) {
+	s.record(stat, value, tags)
+}
+
+func (s *recordingStats) Timing(stat string, duration time.Duration, tags ...string) {
+	s.record(stat, float64(duration), tags)
+}
+
+func (s *recordingStats) AddTags(tags ...string) {}
+
+func (s *recordingStats) GetTags() []string { return nil }
+
+// find returns the stats with the given name
+func (s *recordingStats) find(name string) []recordedStat {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var found []recordedStat
+	for _, stat := range s.stats {
+		if stat.Name == name {
+			found = append(found, stat)
+		}
+	}
+	return found
+}
+
+func TestMetricsRequested(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, types.ErrInProgress{})
+
+	h := Handler{
+		LogProvider:  logevent.FromContext,
+		StatProvider: xstats.FromContext,
+		Storage:      storageMock,
+	}
+	stats := &recordingStats{}
+	r := newJobRequest(windowBody(start, start.Add(time.Hour), ""))
+	w := httptest.NewRecorder()
+	h.CreateJob(w, r.WithContext(xstats.NewContext(r.Context(), stats)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	requested := stats.find(metrics.DigestRequested)
+	require.Len(t, requested, 1)
+	assert.Equal(t, []string{"status:in_progress"}, requested[0].Tags)
+}
+
+func TestMetricsServed(t *testing.T) {
+	tc := []struct {
+		Name   string
+		Err    error
+		Status string
+	}{
+		{Name: "redirected", Status: "status:redirected"},
+		{Name: "in progress", Err: types.ErrInProgress{}, Status: "status:in_progress"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			id := uuid.New().String()
+			storageMock := NewMockStorage(ctrl)
+			if tt.Err == nil {
+				storageMock.EXPECT().Stat(gomock.Any(), id).Return(types.DigestMetadata{ID: id}, nil)
+			}
+			h := Handler{
+				LogProvider:  logevent.FromContext,
+				StatProvider: xstats.FromContext,
+				Storage:      &presignStorage{MockStorage: storageMock, err: tt.Err},
+				Redirect:     true,
+			}
+			stats := &recordingStats{}
+			r := newIDRequest(http.MethodGet, digestLocation(id)+"/content", id)
+			h.GetDigestContent(httptest.NewRecorder(), r.WithContext(xstats.NewContext(r.Context(), stats)))
+
+			served := stats.find(metrics.DigestServed)
+			require.Len(t, served, 1)
+			assert.Equal(t, []string{tt.Status}, served[0].Tags)
+		})
+	}
+}
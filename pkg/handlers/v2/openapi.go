@@ -0,0 +1,339 @@
+package v2
+
+import (
+	"net/http"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/openapi"
+)
+
+// OpenAPI returns the OpenAPI 3 document describing the routes served by the handlers of this package
+func OpenAPI() *openapi.Document {
+	idParameter := func(description string) []*openapi.Parameter {
+		return []*openapi.Parameter{
+			{
+				Name:        "id",
+				In:          "path",
+				Description: description,
+				Required:    true,
+				Schema:      &openapi.Schema{Type: "string", Format: openapi.FormatUUID},
+			},
+		}
+	}
+	doc := &openapi.Document{
+		OpenAPI: "3.0.2",
+		Info: openapi.Info{
+			Title:       "VPC Digester",
+			Description: "VPC Flow Log Digester API, version 2. A job creates the digest for a window, and shares its ID with the digest.",
+			Version:     "2.0.0",
+		},
+		Paths: map[string]*openapi.PathItem{
+			Prefix + "/jobs": {
+				Post: &openapi.Operation{
+					OperationID: "createJob",
+					Summary:     "Create the digest for a window.",
+					Description: "If the window is longer than the service allows, and the service is configured to split such windows, a job is created for each part of the window. Digests which already exist, or are being created, are not queued again unless force is set.",
+					RequestBody: &openapi.RequestBody{
+						Required: true,
+						Content: map[string]*openapi.MediaType{
+							"application/json": {Schema: openapi.Ref("JobRequest")},
+						},
+					},
+					Responses: map[string]*openapi.Response{
+						"200": jobListResponse("None of the jobs were queued, because their digests already exist or are being created."),
+						"202": jobListResponse("At least one job was queued. Jobs which were not queued because the caller or the service is over a limit have the status rate_limited, and those which could not be queued have the status failed. Either may be requested again."),
+						"400": problemResponse("The body is not valid, or the callback URL is not allowed (code callback_rejected)."),
+						"403": problemResponse("The caller does not hold the required permission, or does not hold digests:regenerate and force is set."),
+						"413": problemResponse("The body is larger than 10 MiB."),
+						"422": problemResponse("The window ends in the future, is too recent for all flow logs to have been delivered, starts too far in the past, or is longer than the service allows."),
+						"429": rateLimitedResponse(),
+						"500": internalErrorResponse(),
+					},
+				},
+			},
+			Prefix + "/jobs/{id}": {
+				Parameters: idParameter("The ID of the job, which is also the ID of its digest."),
+				Get: &openapi.Operation{
+					OperationID: "getJob",
+					Summary:     "Check the status of a job.",
+					Responses: map[string]*openapi.Response{
+						"200": jsonResponse("The job, whose status is either in_progress or complete.", openapi.Ref("Job")),
+						"400": problemResponse("The ID is not valid."),
+						"404": problemResponse("The job does not exist, or its digest was deleted."),
+						"500": internalErrorResponse(),
+					},
+				},
+			},
+			Prefix + "/digests": {
+				Get: &openapi.Operation{
+					OperationID: "listDigests",
+					Summary:     "List stored digests.",
+					Description: "A page contains limit digests unless it is the last, but the last page may be empty. Continue paging until nextToken is absent.",
+					Parameters: []*openapi.Parameter{
+						{
+							Name:        "start",
+							In:          "query",
+							Description: "If set, only digests whose window ends after this time are returned.",
+							Schema:      &openapi.Schema{Type: "string", Format: openapi.FormatDateTime},
+						},
+						{
+							Name:        "stop",
+							In:          "query",
+							Description: "If set, only digests whose window begins before this time are returned.",
+							Schema:      &openapi.Schema{Type: "string", Format: openapi.FormatDateTime},
+						},
+						{
+							Name:        "limit",
+							In:          "query",
+							Description: "The maximum number of digests to return in this page. Only the last page has fewer.",
+							Schema:      &openapi.Schema{Type: "integer", Minimum: float(1), Maximum: float(common.MaxListLimit), Default: common.DefaultListLimit},
+						},
+						{
+							Name:        "token",
+							In:          "query",
+							Description: "The nextToken value from the previous page.",
+							Schema:      &openapi.Schema{Type: "string"},
+						},
+					},
+					Responses: map[string]*openapi.Response{
+						"200": jsonResponse("A page of digests.", openapi.Ref("DigestList")),
+						"400": problemResponse("The query parameters are not valid."),
+						"500": internalErrorResponse(),
+					},
+				},
+			},
+			Prefix + "/digests/{id}": {
+				Parameters: idParameter("The ID of the digest."),
+				Get: &openapi.Operation{
+					OperationID: "getDigest",
+					Summary:     "Describe a digest.",
+					Responses: map[string]*openapi.Response{
+						"200": jsonResponse("The digest.", openapi.Ref("Digest")),
+						"400": problemResponse("The ID is not valid."),
+						"404": problemResponse("The digest does not exist (code digest_not_found), or is still being created (code digest_in_progress)."),
+						"500": internalErrorResponse(),
+					},
+				},
+				Delete: &openapi.Operation{
+					OperationID: "deleteDigest",
+					Summary:     "Delete a digest, along with any prior versions and in progress state, so that it may be regenerated.",
+					Responses: map[string]*openapi.Response{
+						"204": {Description: "The digest was deleted, or did not exist."},
+						"400": problemResponse("The ID is not valid."),
+						"500": internalErrorResponse(),
+					},
+				},
+			},
+			Prefix + "/digests/{id}/content": {
+				Parameters: idParameter("The ID of the digest."),
+				Get: &openapi.Operation{
+					OperationID: "getDigestContent",
+					Summary:     "Fetch the content of a complete digest.",
+					Responses: map[string]*openapi.Response{
+						"200": {
+							Description: "The gzipped digest.",
+							Content:     map[string]*openapi.MediaType{"application/octet-stream": {}},
+						},
+						"302": {
+							Description: "The digest may be downloaded from the short-lived pre-signed URL in the Location header. Only returned if the service is configured to redirect.",
+							Content:     map[string]*openapi.MediaType{"text/html": {}},
+						},
+						"400": problemResponse("The ID is not valid."),
+						"404": problemResponse("The digest does not exist (code digest_not_found), or is still being created (code digest_in_progress)."),
+						"500": internalErrorResponse(),
+					},
+				},
+			},
+			Prefix + "/openapi.json": {
+				Get: &openapi.Operation{
+					OperationID: "getOpenAPI",
+					Summary:     "Fetch this document.",
+					Responses: map[string]*openapi.Response{
+						"200": jsonResponse("The OpenAPI 3 document describing version 2 of the API.", &openapi.Schema{Type: "object"}),
+					},
+				},
+			},
+		},
+		Components: openapi.Components{Schemas: schemas()},
+	}
+	addAuthResponses(doc)
+	return doc
+}
+
+// InvalidRequest writes the problem details response to a request which does not match the OpenAPI document
+func InvalidRequest(w http.ResponseWriter, r *http.Request, err error) {
+	common.WriteProblem(w, r, http.StatusBadRequest, common.CodeInvalidRequest, err.Error(), "")
+}
+
+func schemas() map[string]*openapi.Schema {
+	dateTime := func(description string) *openapi.Schema {
+		return &openapi.Schema{Type: "string", Format: openapi.FormatDateTime, Description: description}
+	}
+	uuid := &openapi.Schema{Type: "string", Format: openapi.FormatUUID}
+	str := func(description string) *openapi.Schema {
+		return &openapi.Schema{Type: "string", Description: description}
+	}
+	return map[string]*openapi.Schema{
+		"Problem": {
+			Type:        "object",
+			Description: "An RFC 7807 problem details object, returned with the application/problem+json content type for all errors.",
+			Required:    []string{"type", "title", "status", "code"},
+			Properties: map[string]*openapi.Schema{
+				"type":   str("A URI identifying the problem type, of the form urn:vpcflow-digesterd:problem:<code>."),
+				"title":  str("The HTTP status text."),
+				"status": {Type: "integer"},
+				"detail": str("A human readable explanation of this occurrence of the problem. Omitted for internal errors."),
+				"code": {
+					Type:        "string",
+					Description: "A machine readable error code.",
+					Enum: []string{common.CodeInvalidRequest, common.CodeWindowRejected, common.CodeCallbackRejected, common.CodeUnauthorized, common.CodeForbidden,
+						common.CodeJobNotFound, common.CodeDigestNotFound, common.CodeDigestInProgress, common.CodeRateLimited, common.CodeInternalError},
+				},
+				"digestId":  str("The ID of the digest concerned, if any."),
+				"requestId": str("The ID of the request, taken from the X-Request-Id header if provided, for correlation with logs."),
+			},
+		},
+		"JobRequest": {
+			Type:     "object",
+			Required: []string{"start", "stop"},
+			Properties: map[string]*openapi.Schema{
+				"start": dateTime("The start of the digest window. Truncated to minute precision."),
+				"stop":  dateTime("The stop of the digest window. Truncated to minute precision."),
+				"force": {
+					Type:        "boolean",
+					Description: "If true, regenerate the digest even if it already exists. The existing digest continues to be served until it is replaced. Requires the digests:regenerate permission.",
+				},
+				"callback": str("An HTTPS URL to which a Notification is POSTed when the digest is complete, or has failed. The host must be allowed by the service."),
+			},
+		},
+		"Job": {
+			Type:     "object",
+			Required: []string{"id", "status", "links"},
+			Properties: map[string]*openapi.Schema{
+				"id":    uuid,
+				"start": dateTime("The start of the digest window, in UTC, after truncation. Only present when the job is created."),
+				"stop":  dateTime("The stop of the digest window, in UTC, after truncation. Only present when the job is created."),
+				"status": {
+					Type:        "string",
+					Description: "Whether the job was queued by this request, is in progress, or its digest is complete. Jobs which were not queued because the caller or the service is over a limit are rate_limited, and those which could not be queued are failed.",
+					Enum:        []string{common.StatusQueued, common.StatusInProgress, statusComplete, common.StatusRateLimited, statusFailed},
+				},
+				"estimatedCompletion": dateTime("An estimate of when the digest will be complete. Only present for queued jobs."),
+				"links": {
+					Type:     "object",
+					Required: []string{"self", "digest"},
+					Properties: map[string]*openapi.Schema{
+						"self":   str(""),
+						"digest": str(""),
+					},
+				},
+			},
+		},
+		"JobList": {
+			Type:     "object",
+			Required: []string{"jobs"},
+			Properties: map[string]*openapi.Schema{
+				"jobs": {Type: "array", Items: openapi.Ref("Job")},
+			},
+		},
+		"Digest": {
+			Type:     "object",
+			Required: []string{"id", "links"},
+			Properties: map[string]*openapi.Schema{
+				"id":                 uuid,
+				"start":              str("The start of the digest window. Empty if unknown."),
+				"stop":               str("The stop of the digest window. Empty if unknown."),
+				"scope":              str("The accounts and regions the digest was created from, as <accounts>/<regions>, where * means all."),
+				"createdAt":          str("When the digest was created. Empty if unknown."),
+				"size":               {Type: "integer", Description: "The size, in bytes, of the stored (gzipped) digest."},
+				"records":            {Type: "integer", Description: "The number of flow log records read to create the digest."},
+				"sourceObjects":      {Type: "integer", Description: "The number of flow log objects read to create the digest."},
+				"sourceLastModified": str("The most recent modification time of the flow log objects read to create the digest. Empty if unknown."),
+				"stale":              {Type: "boolean", Description: "Whether flow logs for the window were delivered after the digest was created, in which case it is being regenerated."},
+				"links": {
+					Type:     "object",
+					Required: []string{"self", "content", "job"},
+					Properties: map[string]*openapi.Schema{
+						"self":    str(""),
+						"content": str(""),
+						"job":     str(""),
+					},
+				},
+			},
+		},
+		"DigestList": {
+			Type:     "object",
+			Required: []string{"digests"},
+			Properties: map[string]*openapi.Schema{
+				"digests":   {Type: "array", Items: openapi.Ref("Digest")},
+				"nextToken": str("Present if there may be more digests."),
+			},
+		},
+	}
+}
+
+func jobListResponse(description string) *openapi.Response {
+	return &openapi.Response{
+		Description: description,
+		Headers: map[string]*openapi.Header{
+			"Location": {
+				Description: "The URL of the job. Only present if a single job was created.",
+				Schema:      &openapi.Schema{Type: "string"},
+			},
+		},
+		Content: map[string]*openapi.MediaType{
+			"application/json": {Schema: openapi.Ref("JobList")},
+		},
+	}
+}
+
+// addAuthResponses documents the responses to unauthenticated and unauthorized requests on every operation except
+// the retrieval of this document, which is public
+func addAuthResponses(doc *openapi.Document) {
+	for path, item := range doc.Paths {
+		if path == Prefix+"/openapi.json" {
+			continue
+		}
+		for _, operation := range item.Operations() {
+			operation.Responses["401"] = problemResponse("The request does not carry valid credentials. Only returned if authentication is enabled.")
+			if _, ok := operation.Responses["403"]; !ok {
+				operation.Responses["403"] = problemResponse("The caller does not hold the required permission.")
+			}
+		}
+	}
+}
+
+func jsonResponse(description string, schema *openapi.Schema) *openapi.Response {
+	return &openapi.Response{
+		Description: description,
+		Content:     map[string]*openapi.MediaType{"application/json": {Schema: schema}},
+	}
+}
+
+func problemResponse(description string) *openapi.Response {
+	return &openapi.Response{
+		Description: description,
+		Content:     map[string]*openapi.MediaType{common.ProblemContentType: {Schema: openapi.Ref("Problem")}},
+	}
+}
+
+func internalErrorResponse() *openapi.Response {
+	return problemResponse("An internal error occurred. The error is logged with the request ID.")
+}
+
+// rateLimitedResponse documents the response to a request for a digest rejected because the caller has requested
+// too many recently, or too many digests are already in progress
+func rateLimitedResponse() *openapi.Response {
+	response := problemResponse("The caller has requested too many digests recently, or too many digests are in progress (code rate_limited), and no jobs were queued. Each job queued counts towards the limits, so a window which is split counts once for each part. Only returned if limits are configured.")
+	response.Headers = map[string]*openapi.Header{
+		"Retry-After": {
+			Description: "The number of seconds after which the request may be retried.",
+			Schema:      &openapi.Schema{Type: "integer"},
+		},
+	}
+	return response
+}
+
+func float(f float64) *float64 {
+	return &f
+}
@@ -0,0 +1,198 @@
+package v2
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/openapi"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/go-chi/chi"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/rs/xstats"
+	"github.com/stretchr/testify/assert"
+)
+
+// newValidatedRouter serves the handler through the OpenAPI validator, failing the test for any response which
+// does not match the document
+func newValidatedRouter(t *testing.T, h *Handler) http.Handler {
+	validator := &openapi.Validator{
+		Document:       OpenAPI(),
+		OnRequestError: InvalidRequest,
+		OnResponseError: func(r *http.Request, err error) {
+			t.Errorf("%s %s: %s", r.Method, r.URL.Path, err.Error())
+		},
+		ValidateResponseBodies: true,
+	}
+	router := chi.NewRouter()
+	router.Route(Prefix, func(r chi.Router) {
+		r.Use(validator.Middleware)
+		r.Post("/jobs", h.CreateJob)
+		r.Get("/jobs/{id}", h.GetJob)
+		r.Get("/digests", h.ListDigests)
+		r.Get("/digests/{id}", h.GetDigest)
+		r.Delete("/digests/{id}", h.DeleteDigest)
+		r.Get("/digests/{id}/content", h.GetDigestContent)
+	})
+	return router
+}
+
+func TestOpenAPIRejectsInvalidRequests(t *testing.T) {
+	tc := []struct {
+		Name   string
+		Method string
+		Target string
+		Body   string
+	}{
+		{Name: "missing_stop", Method: http.MethodPost, Target: "/v2/jobs", Body: `{"start":"2019-01-01T00:00:00Z"}`},
+		{Name: "bad_force", Method: http.MethodPost, Target: "/v2/jobs", Body: `{"start":"2019-01-01T00:00:00Z","stop":"2019-01-01T01:00:00Z","force":"yes"}`},
+		{Name: "bad_job_id", Method: http.MethodGet, Target: "/v2/jobs/job"},
+		{Name: "bad_limit", Method: http.MethodGet, Target: "/v2/digests?limit=0"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			// the handler has no dependencies, so reaching it would panic
+			router := newValidatedRouter(t, &Handler{})
+			r := httptest.NewRequest(tt.Method, tt.Target, bytes.NewBufferString(tt.Body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, common.ProblemContentType, w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestOpenAPIResponsesMatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	id := uuid.New().String()
+	start := time.Now().Add(-2 * time.Hour).Truncate(time.Minute)
+	stop := start.Add(time.Hour)
+
+	storageMock := NewMockStorage(ctrl)
+	queuerMock := NewMockQueuer(ctrl)
+	markerMock := NewMockMarker(ctrl)
+	router := newValidatedRouter(t, &Handler{
+		LogProvider:              logevent.FromContext,
+		StatProvider:             xstats.FromContext,
+		Storage:                  storageMock,
+		Queuer:                   queuerMock,
+		Marker:                   markerMock,
+		EstimatedDurationPerHour: time.Minute,
+	})
+
+	tc := []struct {
+		Name   string
+		Method string
+		Target string
+		Body   string
+		Setup  func()
+		Status int
+	}{
+		{
+			Name:   "create_queued",
+			Method: http.MethodPost,
+			Target: "/v2/jobs",
+			Body:   windowBody(start, stop, ""),
+			Setup: func() {
+				storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, nil)
+				queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				markerMock.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			Status: http.StatusAccepted,
+		},
+		{
+			Name:   "create_complete",
+			Method: http.MethodPost,
+			Target: "/v2/jobs",
+			Body:   windowBody(start, stop, ""),
+			Setup: func() {
+				storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(true, nil)
+			},
+			Status: http.StatusOK,
+		},
+		{
+			Name:   "create_rejected",
+			Method: http.MethodPost,
+			Target: "/v2/jobs",
+			Body:   windowBody(start, time.Now().Add(time.Hour), ""),
+			Setup:  func() {},
+			Status: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:   "get_job",
+			Method: http.MethodGet,
+			Target: "/v2/jobs/" + id,
+			Setup: func() {
+				storageMock.EXPECT().Exists(gomock.Any(), id).Return(false, types.ErrInProgress{})
+			},
+			Status: http.StatusOK,
+		},
+		{
+			Name:   "get_job_not_found",
+			Method: http.MethodGet,
+			Target: "/v2/jobs/" + id,
+			Setup: func() {
+				storageMock.EXPECT().Exists(gomock.Any(), id).Return(false, nil)
+			},
+			Status: http.StatusNotFound,
+		},
+		{
+			Name:   "list",
+			Method: http.MethodGet,
+			Target: "/v2/digests",
+			Setup: func() {
+				storageMock.EXPECT().List(gomock.Any(), gomock.Any()).Return(types.DigestList{
+					Digests: []types.DigestMetadata{{ID: id, Start: start, Stop: stop}},
+				}, nil)
+			},
+			Status: http.StatusOK,
+		},
+		{
+			Name:   "get_digest",
+			Method: http.MethodGet,
+			Target: "/v2/digests/" + id,
+			Setup: func() {
+				storageMock.EXPECT().Stat(gomock.Any(), id).Return(types.DigestMetadata{ID: id}, nil)
+			},
+			Status: http.StatusOK,
+		},
+		{
+			Name:   "get_content_in_progress",
+			Method: http.MethodGet,
+			Target: "/v2/digests/" + id + "/content",
+			Setup: func() {
+				storageMock.EXPECT().Get(gomock.Any(), id).Return(nil, types.ErrInProgress{Key: id})
+			},
+			Status: http.StatusNotFound,
+		},
+		{
+			Name:   "delete",
+			Method: http.MethodDelete,
+			Target: "/v2/digests/" + id,
+			Setup: func() {
+				storageMock.EXPECT().Delete(gomock.Any(), id).Return(nil)
+			},
+			Status: http.StatusNoContent,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			tt.Setup()
+			r := httptest.NewRequest(tt.Method, tt.Target, bytes.NewBufferString(tt.Body))
+			if tt.Body != "" {
+				r.Header.Set("Content-Type", "application/json")
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r.WithContext(newContext()))
+			assert.Equal(t, tt.Status, w.Code, w.Body.String())
+		})
+	}
+}
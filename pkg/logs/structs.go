@@ -12,6 +12,9 @@ const (
 
 	// DependencyDigester identifies a digester failure
 	DependencyDigester = "digester"
+
+	// DependencyWatermarker identifies a failure to determine the current state of the source flow logs
+	DependencyWatermarker = "watermarker"
 )
 
 // DependencyFailure is logged when a downstream dependency fails
@@ -33,14 +36,117 @@ type InvalidInput struct {
 	Message string `logevent:"message,default=invalid-input"`
 }
 
+// InvalidResponse is logged when a response does not match the API specification
+type InvalidResponse struct {
+	Reason  string `logevent:"reason"`
+	Message string `logevent:"message,default=invalid-response"`
+}
+
 // NotFound is logged when the requested resource is not found
 type NotFound struct {
 	Reason  string `logevent:"reason"`
 	Message string `logevent:"message,default=not-found"`
 }
 
+// Unauthorized is logged when a request does not carry valid credentials
+type Unauthorized struct {
+	Reason  string `logevent:"reason"`
+	Message string `logevent:"message,default=unauthorized"`
+}
+
+// Forbidden is logged when the caller is not permitted to perform the requested operation
+type Forbidden struct {
+	Reason  string `logevent:"reason"`
+	Message string `logevent:"message,default=forbidden"`
+}
+
+// RateLimited is logged when a request is rejected because the client, or the service, is over a limit
+type RateLimited struct {
+	Client  string `logevent:"client"`
+	Reason  string `logevent:"reason"`
+	Message string `logevent:"message,default=rate-limited"`
+}
+
 // Conflict is logged when the input provided is not valid
 type Conflict struct {
 	Reason  string `logevent:"reason"`
 	Message string `logevent:"message,default=conflict"`
 }
+
+// StaleDigest is logged when the source data for a digest has changed since it was created
+type StaleDigest struct {
+	ID                 string `logevent:"id"`
+	SourceObjects      int64  `logevent:"source_objects"`
+	CurrentObjects     int64  `logevent:"current_objects"`
+	SourceLastModified string `logevent:"source_last_modified"`
+	LastModified       string `logevent:"last_modified"`
+	Message            string `logevent:"message,default=stale-digest"`
+}
+
+// RegenerationAbandoned is logged when the reconciler stops requeuing a stale digest which has failed to regenerate
+// after every attempt. The stale digest continues to be served until it is regenerated by other means.
+type RegenerationAbandoned struct {
+	ID       string `logevent:"id"`
+	Attempts int    `logevent:"attempts"`
+	Message  string `logevent:"message,default=regeneration-abandoned"`
+}
+
+// CallbackDeadLetter is logged when a notification could not be delivered to a callback URL, after any retries.
+// It records enough to redeliver the notification manually.
+type CallbackDeadLetter struct {
+	ID       string `logevent:"id"`
+	Callback string `logevent:"callback"`
+	Status   string `logevent:"status"`
+	Reason   string `logevent:"reason"`
+	Message  string `logevent:"message,default=callback-dead-letter"`
+}
+
+// DigestRequested is an audit event logged for each digest a client requests. Status is the outcome of the
+// request: queued, or, if the digest was not queued again, exists, complete, or in_progress.
+type DigestRequested struct {
+	Client  string `logevent:"client"`
+	ID      string `logevent:"id"`
+	Start   string `logevent:"start"`
+	Stop    string `logevent:"stop"`
+	Scope   string `logevent:"scope"`
+	Force   bool   `logevent:"force"`
+	Status  string `logevent:"status"`
+	Message string `logevent:"message,default=digest-requested"`
+}
+
+// DigestServed is an audit event logged when the content of a digest is served to a client. Redirected digests
+// are downloaded from storage directly, so their size is not known.
+type DigestServed struct {
+	Client     string `logevent:"client"`
+	ID         string `logevent:"id"`
+	Start      string `logevent:"start"`
+	Stop       string `logevent:"stop"`
+	Scope      string `logevent:"scope"`
+	Redirect   bool   `logevent:"redirect"`
+	Bytes      int64  `logevent:"bytes"`
+	DurationMS int64  `logevent:"duration_ms"`
+	Message    string `logevent:"message,default=digest-served"`
+}
+
+// DigestProduced is an audit event logged when a digest has been created and stored. The client is the one
+// which requested the digest, as carried with the job, rather than the caller of the worker endpoint, and is
+// empty for digests regenerated by the service itself. Its size is that of the digest before it was compressed.
+type DigestProduced struct {
+	Client            string `logevent:"client"`
+	ID                string `logevent:"id"`
+	Start             string `logevent:"start"`
+	Stop              string `logevent:"stop"`
+	Scope             string `logevent:"scope"`
+	Records           int64  `logevent:"records"`
+	UncompressedBytes int64  `logevent:"uncompressed_bytes"`
+	DurationMS        int64  `logevent:"duration_ms"`
+	Message           string `logevent:"message,default=digest-produced"`
+}
+
+// DigestDeleted is an audit event logged when a client deletes a digest
+type DigestDeleted struct {
+	Client  string `logevent:"client"`
+	ID      string `logevent:"id"`
+	Scope   string `logevent:"scope"`
+	Message string `logevent:"message,default=digest-deleted"`
+}
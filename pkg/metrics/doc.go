@@ -0,0 +1,3 @@
+// Package metrics is a container of all the stats that the service
+// will emit, and of the tags which qualify them.
+package metrics
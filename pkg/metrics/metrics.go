@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+)
+
+const (
+	// DigestRequested counts the digests requested, tagged by the status of each: queued, exists, in_progress,
+	// rate_limited, or error
+	DigestRequested = "digest.requested"
+
+	// DigestServed counts requests for the content of a digest, tagged by status: served, redirected,
+	// in_progress, not_found, or error
+	DigestServed = "digest.served"
+
+	// DigestDuration times the creation and storage of a digest, tagged by status: success or error
+	DigestDuration = "digest.duration"
+
+	// DigestSourceObjects is the number of flow log objects read to create a digest
+	DigestSourceObjects = "digest.source_objects"
+
+	// DigestSourceBytes is the size of the flow log objects read to create a digest
+	DigestSourceBytes = "digest.source_bytes"
+
+	// DigestRecords is the number of flow log records read to create a digest
+	DigestRecords = "digest.records"
+
+	// DigestUncompressedSize is the size of a digest before it is compressed and stored
+	DigestUncompressedSize = "digest.uncompressed_size"
+
+	// DigestSize is the size of a digest as stored, after it is compressed or encrypted
+	DigestSize = "digest.size"
+
+	// QueueLatency times the wait of a digest job between being queued and its creation starting
+	QueueLatency = "digest.queue_latency"
+
+	// DependencyDuration times each call to a dependency, tagged by dependency, operation, and status: success
+	// or error. Dependencies are named as in the logs package.
+	DependencyDuration = "dependency.duration"
+)
+
+const (
+	// StatusSuccess tags a stat whose operation succeeded
+	StatusSuccess = "success"
+	// StatusError tags a stat whose operation failed
+	StatusError = "error"
+
+	// StatusServed tags a digest whose content was served
+	StatusServed = "served"
+	// StatusRedirected tags a digest which was served as a redirect to storage
+	StatusRedirected = "redirected"
+	// StatusInProgress tags a digest which is still being created
+	StatusInProgress = "in_progress"
+	// StatusNotFound tags a digest which does not exist
+	StatusNotFound = "not_found"
+	// StatusRateLimited tags a digest which was not queued because the client, or the service, is over a limit
+	StatusRateLimited = "rate_limited"
+)
+
+// Status returns the tag of the status of an operation
+func Status(status string) string {
+	return "status:" + status
+}
+
+// Dependency returns the tag of a dependency
+func Dependency(dependency string) string {
+	return "dependency:" + dependency
+}
+
+// Operation returns the tag of an operation of a dependency
+func Operation(operation string) string {
+	return "operation:" + operation
+}
+
+// ErrorStatus returns StatusError if err is not nil, or otherwise StatusSuccess
+func ErrorStatus(err error) string {
+	if err != nil {
+		return StatusError
+	}
+	return StatusSuccess
+}
+
+// TimeDependency records the time taken by a call to a dependency which started at the given time, and its outcome.
+// A digest which is not found, or is in progress, is an answer from the dependency rather than a failure.
+func TimeDependency(stat types.Stat, dependency string, operation string, started time.Time, err error) {
+	switch err.(type) {
+	case types.ErrNotFound, types.ErrInProgress:
+		err = nil
+	}
+	stat.Timing(DependencyDuration, time.Since(started), Dependency(dependency), Operation(operation), Status(ErrorStatus(err)))
+}
+
+// StorageStatus returns the status of a digest which could not be served because of the given Storage error
+func StorageStatus(err error) string {
+	switch err.(type) {
+	case types.ErrInProgress:
+		return StatusInProgress
+	case types.ErrNotFound:
+		return StatusNotFound
+	default:
+		return StatusError
+	}
+}
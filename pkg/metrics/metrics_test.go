@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordedStat is a stat sent to recordingStats
+type recordedStat struct {
+	Name  string
+	Value float64
+	Tags  []string
+}
+
+// recordingStats is an xstats.XStater which records the stats sent to it
+type recordingStats struct {
+	lock  sync.Mutex
+	stats []recordedStat
+}
+
+func (s *recordingStats) record(name string, value float64, tags []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stats = append(s.stats, recordedStat{Name: name, Value: value, Tags: tags})
+}
+
+func (s *recordingStats) Gauge(stat string, value float64, tags ...string) {
+	s.record(stat, value, tags)
+}
+
+func (s *recordingStats) Count(stat string, count float64, tags ...string) {
+	s.record(stat, count, tags)
+}
+
+func (s *recordingStats) Histogram(stat string, value float64, tags ...string) {
+	s.record(stat, value, tags)
+}
+
+func (s *recordingStats) Timing(stat string, duration time.Duration, tags ...string) {
+	s.record(stat, float64(duration), tags)
+}
+
+func (s *recordingStats) AddTags(tags ...string) {}
+
+func (s *recordingStats) GetTags() []string { return nil }
+
+// find returns the stats with the given name
+func (s *recordingStats) find(name string) []recordedStat {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var found []recordedStat
+	for _, stat := range s.stats {
+		if stat.Name == name {
+			found = append(found, stat)
+		}
+	}
+	return found
+}
+
+func TestTimeDependency(t *testing.T) {
+	tc := []struct {
+		Name   string
+		Err    error
+		Status string
+	}{
+		{Name: "success", Status: "status:success"},
+		{Name: "error", Err: errors.New("oops"), Status: "status:error"},
+		{Name: "not found", Err: types.ErrNotFound{}, Status: "status:success"},
+		{Name: "in progress", Err: types.ErrInProgress{}, Status: "status:success"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			stats := &recordingStats{}
+			TimeDependency(stats, "storage", "get", time.Now().Add(-time.Second), tt.Err)
+			found := stats.find(DependencyDuration)
+			require.Len(t, found, 1)
+			assert.True(t, found[0].Value >= float64(time.Second))
+			assert.Equal(t, []string{"dependency:storage", "operation:get", tt.Status}, found[0].Tags)
+		})
+	}
+}
+
+func TestStorageStatus(t *testing.T) {
+	assert.Equal(t, StatusInProgress, StorageStatus(types.ErrInProgress{}))
+	assert.Equal(t, StatusNotFound, StorageStatus(types.ErrNotFound{}))
+	assert.Equal(t, StatusError, StorageStatus(errors.New("oops")))
+}
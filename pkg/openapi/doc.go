@@ -0,0 +1,3 @@
+// Package openapi contains a model of the subset of OpenAPI 3 used to describe this service, along with
+// middleware which validates requests and responses against it.
+package openapi
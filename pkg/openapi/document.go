@@ -0,0 +1,167 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Document is an OpenAPI 3 document
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Components holds the schemas referred to elsewhere in the document
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas,omitempty"`
+}
+
+// PathItem describes the operations available on a path
+type PathItem struct {
+	Parameters []*Parameter `json:"parameters,omitempty"`
+	Get        *Operation   `json:"get,omitempty"`
+	Head       *Operation   `json:"head,omitempty"`
+	Post       *Operation   `json:"post,omitempty"`
+	Delete     *Operation   `json:"delete,omitempty"`
+}
+
+// Operations returns the operations of the path, keyed by HTTP method
+func (p *PathItem) Operations() map[string]*Operation {
+	operations := make(map[string]*Operation)
+	for method, operation := range map[string]*Operation{
+		http.MethodGet:    p.Get,
+		http.MethodHead:   p.Head,
+		http.MethodPost:   p.Post,
+		http.MethodDelete: p.Delete,
+	} {
+		if operation != nil {
+			operations[method] = operation
+		}
+	}
+	return operations
+}
+
+// Operation describes a single API operation on a path
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary"`
+	Description string               `json:"description,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter describes a single operation parameter. Only the form style, with explode, is supported for arrays.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes a request body
+type RequestBody struct {
+	Description string                `json:"description,omitempty"`
+	Required    bool                  `json:"required,omitempty"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+// Response describes a single response from an operation
+type Response struct {
+	Description string                `json:"description"`
+	Headers     map[string]*Header    `json:"headers,omitempty"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// Header describes a response header
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// MediaType describes the body of a request or response of a particular content type
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+// JSON renders the document as indented JSON
+func (d *Document) JSON() ([]byte, error) {
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// Handler returns a handler which serves the document as JSON
+func Handler(d *Document) http.HandlerFunc {
+	b, err := d.JSON()
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(b)
+	}
+}
+
+// Find returns the template and item of the path which matches the request, along with the values of its path
+// parameters. Only paths with an operation for the method are considered, and where more than one path matches,
+// the one with the most literal segments is preferred. Nil is returned if no path matches.
+func (d *Document) Find(method string, path string) (string, *PathItem, map[string]string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	var (
+		found     string
+		foundItem *PathItem
+		params    map[string]string
+		literals  = -1
+	)
+	for template, item := range d.Paths {
+		if item.Operations()[method] == nil {
+			continue
+		}
+		matchedParams, matchedLiterals, ok := match(template, segments)
+		if ok && matchedLiterals > literals {
+			found, foundItem, params, literals = template, item, matchedParams, matchedLiterals
+		}
+	}
+	return found, foundItem, params
+}
+
+// match matches the segments of a request path against a path template, returning the values of the path
+// parameters and the number of literal segments matched
+func match(template string, segments []string) (map[string]string, int, bool) {
+	templateSegments := strings.Split(strings.Trim(template, "/"), "/")
+	if len(templateSegments) != len(segments) {
+		return nil, 0, false
+	}
+	literals := 0
+	params := make(map[string]string)
+	for i, segment := range templateSegments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if segments[i] == "" {
+				return nil, 0, false
+			}
+			params[segment[1:len(segment)-1]] = segments[i]
+			continue
+		}
+		if segment != segments[i] {
+			return nil, 0, false
+		}
+		literals++
+	}
+	return params, literals, true
+}
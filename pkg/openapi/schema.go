@@ -0,0 +1,221 @@
+package openapi
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const refPrefix = "#/components/schemas/"
+
+// Formats of string values which are validated. Any other format is descriptive only.
+const (
+	FormatDateTime = "date-time"
+	FormatUUID     = "uuid"
+	FormatURI      = "uri"
+	// FormatDuration is not defined by OpenAPI. It is a duration as accepted by time.ParseDuration, such as "30s".
+	FormatDuration = "duration"
+)
+
+// Schema is the subset of the OpenAPI 3 schema object used by this service
+type Schema struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Minimum     *float64           `json:"minimum,omitempty"`
+	Maximum     *float64           `json:"maximum,omitempty"`
+	Default     interface{}        `json:"default,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	// OneOf, if set, requires a value to match exactly one of the schemas
+	OneOf []*Schema `json:"oneOf,omitempty"`
+}
+
+// Ref returns a schema which refers to the named schema in the components of the document
+func Ref(name string) *Schema {
+	return &Schema{Ref: refPrefix + name}
+}
+
+// ValidationError describes a value which does not match its schema
+type ValidationError struct {
+	// Field is the location of the value, such as a parameter name or a path within a JSON body
+	Field  string
+	Reason string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s %s", e.Field, e.Reason)
+}
+
+// resolve follows a reference to a schema in the components of the document
+func (d *Document) resolve(s *Schema) (*Schema, error) {
+	if s.Ref == "" {
+		return s, nil
+	}
+	resolved, ok := d.Components.Schemas[strings.TrimPrefix(s.Ref, refPrefix)]
+	if !ok || !strings.HasPrefix(s.Ref, refPrefix) {
+		return nil, fmt.Errorf("unresolvable schema reference %s", s.Ref)
+	}
+	return resolved, nil
+}
+
+// ValidateJSON validates a value decoded from JSON, as by encoding/json into an interface{}, against the schema
+func (d *Document) ValidateJSON(s *Schema, field string, value interface{}) error {
+	s, err := d.resolve(s)
+	if err != nil {
+		return err
+	}
+	if len(s.OneOf) > 0 {
+		matched := 0
+		for _, option := range s.OneOf {
+			if d.ValidateJSON(option, field, value) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return ValidationError{Field: field, Reason: fmt.Sprintf("should match exactly one of %d schemas", len(s.OneOf))}
+		}
+	}
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return ValidationError{Field: field, Reason: "should be an object"}
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				return ValidationError{Field: joinField(field, name), Reason: "is required"}
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, ok := s.Properties[name]; ok {
+				if err := d.ValidateJSON(property, joinField(field, name), object[name]); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return ValidationError{Field: field, Reason: "should be an array"}
+		}
+		for i, item := range array {
+			if err := d.ValidateJSON(s.Items, fmt.Sprintf("%s[%d]", field, i), item); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return ValidationError{Field: field, Reason: "should be a string"}
+		}
+		return validateString(s, field, str)
+	case "integer", "number":
+		number, ok := value.(float64)
+		if !ok || (s.Type == "integer" && number != math.Trunc(number)) {
+			return ValidationError{Field: field, Reason: "should be an " + s.Type}
+		}
+		return validateRange(s, field, number)
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return ValidationError{Field: field, Reason: "should be a boolean"}
+		}
+	}
+	return nil
+}
+
+// ValidateString validates a parameter or header value against the schema. Arrays are validated item by item.
+func (d *Document) ValidateString(s *Schema, field string, values []string) error {
+	s, err := d.resolve(s)
+	if err != nil {
+		return err
+	}
+	if s.Type == "array" {
+		for _, value := range values {
+			if err := d.ValidateString(s.Items, field, []string{value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(values) > 1 {
+		return ValidationError{Field: field, Reason: "should not be repeated"}
+	}
+	value := values[0]
+	switch s.Type {
+	case "string":
+		return validateString(s, field, value)
+	case "integer", "number":
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || (s.Type == "integer" && number != math.Trunc(number)) {
+			return ValidationError{Field: field, Reason: "should be an " + s.Type}
+		}
+		return validateRange(s, field, number)
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return ValidationError{Field: field, Reason: "should be a boolean"}
+		}
+	}
+	return nil
+}
+
+func validateString(s *Schema, field string, value string) error {
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			found = found || allowed == value
+		}
+		if !found {
+			return ValidationError{Field: field, Reason: "should be one of " + strings.Join(s.Enum, ", ")}
+		}
+	}
+	var err error
+	switch s.Format {
+	case FormatDateTime:
+		_, err = time.Parse(time.RFC3339Nano, value)
+	case FormatUUID:
+		_, err = uuid.Parse(value)
+	case FormatURI:
+		var u *url.URL
+		if u, err = url.Parse(value); err == nil && !u.IsAbs() {
+			err = fmt.Errorf("should be an absolute URI")
+		}
+	case FormatDuration:
+		_, err = time.ParseDuration(value)
+	}
+	if err != nil {
+		return ValidationError{Field: field, Reason: "should be a valid " + s.Format}
+	}
+	return nil
+}
+
+func validateRange(s *Schema, field string, number float64) error {
+	if s.Minimum != nil && number < *s.Minimum {
+		return ValidationError{Field: field, Reason: fmt.Sprintf("should be at least %v", *s.Minimum)}
+	}
+	if s.Maximum != nil && number > *s.Maximum {
+		return ValidationError{Field: field, Reason: fmt.Sprintf("should be at most %v", *s.Maximum)}
+	}
+	return nil
+}
+
+func joinField(parent string, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
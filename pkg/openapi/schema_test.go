@@ -0,0 +1,97 @@
+package openapi
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testDocument() *Document {
+	return &Document{
+		Components: Components{Schemas: map[string]*Schema{
+			"Window": {
+				Type:     "object",
+				Required: []string{"start"},
+				Properties: map[string]*Schema{
+					"start": {Type: "string", Format: FormatDateTime},
+					"count": {Type: "integer", Minimum: float(1)},
+					"state": {Type: "string", Enum: []string{"queued", "exists"}},
+				},
+			},
+			"List": {
+				Type:     "object",
+				Required: []string{"items"},
+				Properties: map[string]*Schema{
+					"items": {Type: "array", Items: Ref("Window")},
+				},
+			},
+		}},
+	}
+}
+
+func float(f float64) *float64 {
+	return &f
+}
+
+func TestValidateJSON(t *testing.T) {
+	tc := []struct {
+		Name   string
+		Schema *Schema
+		JSON   string
+		Error  string
+	}{
+		{Name: "valid", Schema: Ref("Window"), JSON: `{"start":"2019-01-01T00:00:00Z","count":2,"state":"queued","other":1}`},
+		{Name: "missing", Schema: Ref("Window"), JSON: `{}`, Error: "body.start is required"},
+		{Name: "format", Schema: Ref("Window"), JSON: `{"start":"yesterday"}`, Error: "body.start should be a valid date-time"},
+		{Name: "integer", Schema: Ref("Window"), JSON: `{"start":"2019-01-01T00:00:00Z","count":1.5}`, Error: "body.count should be an integer"},
+		{Name: "minimum", Schema: Ref("Window"), JSON: `{"start":"2019-01-01T00:00:00Z","count":0}`, Error: "body.count should be at least 1"},
+		{Name: "enum", Schema: Ref("Window"), JSON: `{"start":"2019-01-01T00:00:00Z","state":"gone"}`, Error: "body.state should be one of queued, exists"},
+		{Name: "not_object", Schema: Ref("Window"), JSON: `[]`, Error: "body should be an object"},
+		{Name: "nested", Schema: Ref("List"), JSON: `{"items":[{"start":"2019-01-01T00:00:00Z"},{}]}`, Error: "body.items[1].start is required"},
+		{Name: "one_of", Schema: &Schema{OneOf: []*Schema{Ref("Window"), Ref("List")}}, JSON: `{"items":[]}`},
+		{Name: "none_of", Schema: &Schema{OneOf: []*Schema{Ref("Window"), Ref("List")}}, JSON: `{}`, Error: "body should match exactly one of 2 schemas"},
+		{Name: "bad_ref", Schema: Ref("Missing"), JSON: `{}`, Error: "unresolvable schema reference #/components/schemas/Missing"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			var value interface{}
+			assert.Nil(t, json.Unmarshal([]byte(tt.JSON), &value))
+			err := testDocument().ValidateJSON(tt.Schema, "body", value)
+			if tt.Error == "" {
+				assert.Nil(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.Error)
+		})
+	}
+}
+
+func TestValidateString(t *testing.T) {
+	tc := []struct {
+		Name   string
+		Schema *Schema
+		Values []string
+		Valid  bool
+	}{
+		{Name: "boolean", Schema: &Schema{Type: "boolean"}, Values: []string{"true"}, Valid: true},
+		{Name: "not_boolean", Schema: &Schema{Type: "boolean"}, Values: []string{"yes"}},
+		{Name: "integer", Schema: &Schema{Type: "integer", Maximum: float(10)}, Values: []string{"10"}, Valid: true},
+		{Name: "too_large", Schema: &Schema{Type: "integer", Maximum: float(10)}, Values: []string{"11"}},
+		{Name: "uuid", Schema: &Schema{Type: "string", Format: FormatUUID}, Values: []string{"03b3871b-9540-5db7-abcc-d44f2d5965dc"}, Valid: true},
+		{Name: "not_uuid", Schema: &Schema{Type: "string", Format: FormatUUID}, Values: []string{"digest"}},
+		{Name: "duration", Schema: &Schema{Type: "string", Format: FormatDuration}, Values: []string{"30s"}, Valid: true},
+		{Name: "not_duration", Schema: &Schema{Type: "string", Format: FormatDuration}, Values: []string{"30"}},
+		{Name: "uri", Schema: &Schema{Type: "string", Format: FormatURI}, Values: []string{"https://example.com/hook"}, Valid: true},
+		{Name: "relative_uri", Schema: &Schema{Type: "string", Format: FormatURI}, Values: []string{"/hook"}},
+		{Name: "repeated", Schema: &Schema{Type: "string"}, Values: []string{"a", "b"}},
+		{Name: "array", Schema: &Schema{Type: "array", Items: &Schema{Type: "integer"}}, Values: []string{"1", "2"}, Valid: true},
+		{Name: "bad_array", Schema: &Schema{Type: "array", Items: &Schema{Type: "integer"}}, Values: []string{"1", "b"}},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := testDocument().ValidateString(tt.Schema, "param", tt.Values)
+			assert.Equal(t, tt.Valid, err == nil, "%v", err)
+		})
+	}
+}
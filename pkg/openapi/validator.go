@@ -0,0 +1,210 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxBodySize = 10 << 20
+
+// BodyTooLargeError indicates that the body of a request is larger than the validator will read
+type BodyTooLargeError struct {
+	Limit int64
+}
+
+func (e BodyTooLargeError) Error() string {
+	return fmt.Sprintf("body should be no larger than %d bytes", e.Limit)
+}
+
+// Validator is middleware which validates requests and responses against a Document. Requests which do not
+// match the document, or whose JSON bodies are too large to validate, are rejected before reaching the handler. Responses are never altered, but those which do
+// not match the document are reported.
+type Validator struct {
+	Document *Document
+	// OnRequestError writes the response to a request which does not match the document. The error is a
+	// BodyTooLargeError if the body was too large to validate.
+	OnRequestError func(w http.ResponseWriter, r *http.Request, err error)
+	// OnResponseError, if set, is called with each response which does not match the document
+	OnResponseError func(r *http.Request, err error)
+	// ValidateResponseBodies enables validation of JSON response bodies, which requires them to be copied in
+	// memory. The status code and content type of responses are always validated.
+	ValidateResponseBodies bool
+}
+
+// Middleware wraps the handler with validation. Requests for paths which are not in the document are passed
+// through unvalidated.
+func (v *Validator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, item, params := v.Document.Find(r.Method, r.URL.Path)
+		if item == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		operation := item.Operations()[r.Method]
+		if err := v.validateRequest(r, item, operation, params); err != nil {
+			v.OnRequestError(w, r, err)
+			return
+		}
+		if v.OnResponseError == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		recorder := &responseRecorder{ResponseWriter: w}
+		if v.ValidateResponseBodies {
+			recorder.body = &bytes.Buffer{}
+		}
+		next.ServeHTTP(recorder, r)
+		if r.Context().Err() != nil {
+			// the client has gone away, and any response is incomplete
+			return
+		}
+		if err := v.validateResponse(r, operation, recorder); err != nil {
+			v.OnResponseError(r, err)
+		}
+	})
+}
+
+func (v *Validator) validateRequest(r *http.Request, item *PathItem, operation *Operation, params map[string]string) error {
+	query := r.URL.Query()
+	for _, parameter := range append(append([]*Parameter(nil), item.Parameters...), operation.Parameters...) {
+		var values []string
+		switch parameter.In {
+		case "query":
+			values = query[parameter.Name]
+		case "path":
+			if value, ok := params[parameter.Name]; ok {
+				values = []string{value}
+			}
+		case "header":
+			values = r.Header[http.CanonicalHeaderKey(parameter.Name)]
+		}
+		if len(values) == 0 {
+			if parameter.Required {
+				return ValidationError{Field: parameter.Name, Reason: "is required"}
+			}
+			continue
+		}
+		if err := v.Document.ValidateString(parameter.Schema, parameter.Name, values); err != nil {
+			return err
+		}
+	}
+	if operation.RequestBody == nil {
+		return nil
+	}
+	media, ok := operation.RequestBody.Content["application/json"]
+	if !ok || media.Schema == nil {
+		return nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxBodySize {
+		return BodyTooLargeError{Limit: maxBodySize}
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if len(bytes.TrimSpace(body)) == 0 {
+		if operation.RequestBody.Required {
+			return ValidationError{Field: "body", Reason: "is required"}
+		}
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return ValidationError{Field: "body", Reason: "should be JSON: " + err.Error()}
+	}
+	return v.Document.ValidateJSON(media.Schema, "body", value)
+}
+
+func (v *Validator) validateResponse(r *http.Request, operation *Operation, recorder *responseRecorder) error {
+	status := recorder.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	response, ok := operation.Responses[strconv.Itoa(status)]
+	if !ok {
+		response, ok = operation.Responses["default"]
+	}
+	if !ok {
+		return fmt.Errorf("%s %s responded with undocumented status %d", r.Method, r.URL.Path, status)
+	}
+	if len(response.Content) == 0 {
+		if recorder.written > 0 {
+			return fmt.Errorf("%s %s responded with status %d, which should have no body", r.Method, r.URL.Path, status)
+		}
+		return nil
+	}
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	contentType, _, _ := mime.ParseMediaType(recorder.Header().Get("Content-Type"))
+	media, ok := response.Content[contentType]
+	if !ok {
+		return fmt.Errorf("%s %s responded with status %d and undocumented content type %q", r.Method, r.URL.Path, status, contentType)
+	}
+	if recorder.body == nil || media.Schema == nil || !isJSON(contentType) {
+		return nil
+	}
+	if recorder.truncated {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(recorder.body.Bytes(), &value); err != nil {
+		return fmt.Errorf("%s %s responded with invalid JSON: %s", r.Method, r.URL.Path, err.Error())
+	}
+	if err := v.Document.ValidateJSON(media.Schema, "body", value); err != nil {
+		return fmt.Errorf("%s %s responded with status %d and %s", r.Method, r.URL.Path, status, err.Error())
+	}
+	return nil
+}
+
+func isJSON(contentType string) bool {
+	return contentType == "application/json" || strings.HasSuffix(contentType, "+json")
+}
+
+// responseRecorder records the status code and size of a response, and optionally a copy of its body, as it
+// is written
+type responseRecorder struct {
+	http.ResponseWriter
+	status    int
+	written   int
+	body      *bytes.Buffer
+	truncated bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.written += len(b)
+	if r.body != nil && !r.truncated {
+		if r.body.Len()+len(b) > maxBodySize {
+			r.truncated = true
+		} else {
+			r.body.Write(b)
+		}
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush flushes the response, if the underlying ResponseWriter supports it, so that streaming responses are
+// not buffered by the validator
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
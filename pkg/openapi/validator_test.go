@@ -0,0 +1,178 @@
+package openapi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testValidator(responseErrors *[]error) *Validator {
+	doc := testDocument()
+	doc.Paths = map[string]*PathItem{
+		"/windows": {
+			Post: &Operation{
+				Parameters: []*Parameter{{Name: "force", In: "query", Schema: &Schema{Type: "boolean"}}},
+				RequestBody: &RequestBody{
+					Required: true,
+					Content:  map[string]*MediaType{"application/json": {Schema: Ref("Window")}},
+				},
+				Responses: map[string]*Response{
+					"200": {Content: map[string]*MediaType{"application/json": {Schema: Ref("Window")}}},
+					"204": {},
+				},
+			},
+		},
+		"/windows/{id}": {
+			Parameters: []*Parameter{{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "string", Format: FormatUUID}}},
+			Get:        &Operation{Responses: map[string]*Response{"204": {}}},
+		},
+		"/{topic}/{event}": {
+			Post: &Operation{Responses: map[string]*Response{"204": {}}},
+		},
+	}
+	return &Validator{
+		Document: doc,
+		OnRequestError: func(w http.ResponseWriter, r *http.Request, err error) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+		},
+		OnResponseError: func(r *http.Request, err error) {
+			*responseErrors = append(*responseErrors, err)
+		},
+		ValidateResponseBodies: true,
+	}
+}
+
+func TestFind(t *testing.T) {
+	v := testValidator(nil)
+	template, _, params := v.Document.Find(http.MethodGet, "/windows/abc")
+	assert.Equal(t, "/windows/{id}", template)
+	assert.Equal(t, map[string]string{"id": "abc"}, params)
+
+	template, _, params = v.Document.Find(http.MethodPost, "/windows/abc")
+	assert.Equal(t, "/{topic}/{event}", template)
+	assert.Equal(t, map[string]string{"topic": "windows", "event": "abc"}, params)
+
+	_, item, _ := v.Document.Find(http.MethodGet, "/other")
+	assert.Nil(t, item)
+}
+
+func TestValidatorRequests(t *testing.T) {
+	tc := []struct {
+		Name   string
+		Method string
+		URL    string
+		Body   string
+		Status int
+	}{
+		{Name: "valid", Method: http.MethodPost, URL: "/windows?force=true", Body: `{"start":"2019-01-01T00:00:00Z"}`, Status: http.StatusNoContent},
+		{Name: "bad_query", Method: http.MethodPost, URL: "/windows?force=maybe", Body: `{"start":"2019-01-01T00:00:00Z"}`, Status: http.StatusBadRequest},
+		{Name: "missing_body", Method: http.MethodPost, URL: "/windows", Status: http.StatusBadRequest},
+		{Name: "bad_body", Method: http.MethodPost, URL: "/windows", Body: `{"start":1}`, Status: http.StatusBadRequest},
+		{Name: "not_json", Method: http.MethodPost, URL: "/windows", Body: `start`, Status: http.StatusBadRequest},
+		{Name: "bad_path", Method: http.MethodGet, URL: "/windows/abc", Status: http.StatusBadRequest},
+		{Name: "undocumented", Method: http.MethodGet, URL: "/other", Status: http.StatusNoContent},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			var responseErrors []error
+			var body []byte
+			handler := testValidator(&responseErrors).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ = ioutil.ReadAll(r.Body)
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			r, _ := http.NewRequest(tt.Method, tt.URL, bytes.NewBufferString(tt.Body))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.Status, w.Code, w.Body.String())
+			assert.Empty(t, responseErrors)
+			if tt.Status == http.StatusNoContent {
+				assert.Equal(t, tt.Body, string(body))
+			}
+		})
+	}
+}
+
+func TestValidatorBodyTooLarge(t *testing.T) {
+	var requestErr error
+	v := testValidator(nil)
+	v.OnRequestError = func(w http.ResponseWriter, r *http.Request, err error) {
+		requestErr = err
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	}
+	handler := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	body := `{"start":"2019-01-01T00:00:00Z"}` + strings.Repeat(" ", maxBodySize)
+	r, _ := http.NewRequest(http.MethodPost, "/windows", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, BodyTooLargeError{Limit: maxBodySize}, requestErr)
+}
+
+func TestValidatorResponses(t *testing.T) {
+	tc := []struct {
+		Name        string
+		Status      int
+		ContentType string
+		Body        string
+		Valid       bool
+	}{
+		{Name: "valid", Status: http.StatusOK, ContentType: "application/json; charset=utf-8", Body: `{"start":"2019-01-01T00:00:00Z"}`, Valid: true},
+		{Name: "no_content", Status: http.StatusNoContent, Valid: true},
+		{Name: "undocumented_status", Status: http.StatusConflict},
+		{Name: "undocumented_content_type", Status: http.StatusOK, ContentType: "text/plain", Body: "ok"},
+		{Name: "invalid_body", Status: http.StatusOK, ContentType: "application/json", Body: `{}`},
+		{Name: "unexpected_body", Status: http.StatusNoContent, ContentType: "application/json", Body: `{}`},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			var responseErrors []error
+			handler := testValidator(&responseErrors).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.ContentType != "" {
+					w.Header().Set("Content-Type", tt.ContentType)
+				}
+				w.WriteHeader(tt.Status)
+				_, _ = w.Write([]byte(tt.Body))
+			}))
+			r, _ := http.NewRequest(http.MethodPost, "/windows", bytes.NewBufferString(`{"start":"2019-01-01T00:00:00Z"}`))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.Status, w.Code)
+			assert.Equal(t, tt.Body, w.Body.String())
+			assert.Equal(t, tt.Valid, len(responseErrors) == 0, "%v", responseErrors)
+		})
+	}
+}
+
+func TestValidatorFlushes(t *testing.T) {
+	var responseErrors []error
+	flushed := false
+	handler := testValidator(&responseErrors).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+		flusher, ok := w.(http.Flusher)
+		flushed = ok
+		if ok {
+			flusher.Flush()
+		}
+	}))
+	r, _ := http.NewRequest(http.MethodPost, "/windows", bytes.NewBufferString(`{"start":"2019-01-01T00:00:00Z"}`))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.True(t, flushed)
+	assert.True(t, w.Flushed)
+}
+
+func TestHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	Handler(testDocument())(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `"Window"`)
+}
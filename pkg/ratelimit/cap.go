@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+)
+
+const (
+	defaultCountTTL   = 5 * time.Second
+	defaultRetryAfter = time.Minute
+)
+
+// InProgressCap caps the number of digests in progress at once, as counted by the Marker. Counting may require
+// listing every marker, so the count is cached for CountTTL.
+type InProgressCap struct {
+	Counter types.MarkerCounter
+	// Max is the number of digests which may be in progress at once. If zero, there is no cap.
+	Max int
+	// CountTTL is the time for which the count of digests in progress is reused. It defaults to 5s.
+	CountTTL time.Duration
+	// RetryAfter is the time after which clients are asked to retry when the cap is reached. It defaults to 1m.
+	RetryAfter time.Duration
+	// Now returns the current time. If nil, time.Now is used.
+	Now func() time.Time
+
+	lock      sync.Mutex
+	count     int
+	countedAt time.Time
+}
+
+// Allow reports whether another digest may be started. If not, it returns the time after which the client should
+// retry. Each digest allowed is added to the cached count, so that a burst of requests cannot exceed the cap
+// before the count is refreshed.
+func (c *InProgressCap) Allow(ctx context.Context) (bool, time.Duration, error) {
+	if c.Max <= 0 {
+		return true, 0, nil
+	}
+	now := c.now()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.countedAt.IsZero() || now.Sub(c.countedAt) >= c.countTTL() {
+		count, err := c.Counter.CountMarked(ctx)
+		if err != nil {
+			return false, 0, err
+		}
+		c.count = count
+		c.countedAt = now
+	}
+	if c.count >= c.Max {
+		return false, c.retryAfter(), nil
+	}
+	c.count++
+	return true, 0, nil
+}
+
+// Release removes a digest which was allowed, but not started after all, from the cached count
+func (c *InProgressCap) Release() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.count > 0 {
+		c.count--
+	}
+}
+
+func (c *InProgressCap) countTTL() time.Duration {
+	if c.CountTTL > 0 {
+		return c.CountTTL
+	}
+	return defaultCountTTL
+}
+
+func (c *InProgressCap) retryAfter() time.Duration {
+	if c.RetryAfter > 0 {
+		return c.RetryAfter
+	}
+	return defaultRetryAfter
+}
+
+func (c *InProgressCap) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
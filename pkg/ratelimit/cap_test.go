@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInProgressCap(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	now := time.Unix(1500000000, 0)
+	counter := NewMockMarkerCounter(ctrl)
+	c := &InProgressCap{
+		Counter: counter,
+		Max:     2,
+		Now:     func() time.Time { return now },
+	}
+
+	counter.EXPECT().CountMarked(gomock.Any()).Return(1, nil)
+	ok, _, err := c.Allow(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	// the digest allowed is counted until the count is refreshed
+	ok, retryAfter, err := c.Allow(context.Background())
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, defaultRetryAfter, retryAfter)
+
+	now = now.Add(defaultCountTTL)
+	counter.EXPECT().CountMarked(gomock.Any()).Return(0, nil)
+	ok, _, err = c.Allow(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	now = now.Add(defaultCountTTL)
+	counter.EXPECT().CountMarked(gomock.Any()).Return(0, errors.New("oops"))
+	_, _, err = c.Allow(context.Background())
+	assert.NotNil(t, err)
+}
+
+func TestInProgressCapRelease(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	counter := NewMockMarkerCounter(ctrl)
+	c := &InProgressCap{Counter: counter, Max: 1, CountTTL: time.Hour}
+
+	counter.EXPECT().CountMarked(gomock.Any()).Return(0, nil)
+	ok, _, err := c.Allow(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	c.Release()
+	ok, _, err = c.Allow(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, ok, "the released digest should no longer be counted")
+}
+
+func TestInProgressCapDisabled(t *testing.T) {
+	c := &InProgressCap{}
+	ok, _, err := c.Allow(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
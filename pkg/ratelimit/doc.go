@@ -0,0 +1,3 @@
+// Package ratelimit contains the limits on the creation of digests: a token bucket for each client, and a cap on
+// the number of digests in progress at once.
+package ratelimit
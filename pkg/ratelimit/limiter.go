@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ErrLimited indicates that a digest may not be queued because the client, or the service as a whole, is over a
+// limit on the creation of digests
+type ErrLimited struct {
+	Reason string
+	// RetryAfter is the time after which the client may try again
+	RetryAfter time.Duration
+}
+
+func (e ErrLimited) Error() string {
+	return fmt.Sprintf("digest not queued: %s", e.Reason)
+}
+
+// Limiter limits the rate at which each client may queue digests, and the number of digests in progress at once.
+// Each digest is charged for separately, so a request which queues many digests is charged for each of them,
+// and requests which queue none are not charged at all.
+type Limiter struct {
+	// Limits, if set, limits the rate of each client
+	Limits *Limits
+	// Cap, if set, caps the number of digests in progress
+	Cap *InProgressCap
+}
+
+// Take charges the client for a digest which is about to be queued. If the client, or the service, is over a
+// limit, ErrLimited is returned, and nothing is charged. Otherwise, the returned function refunds the charge, and
+// should be called if the digest is not queued after all. Clients are identified as by auth.ClientOf.
+func (l *Limiter) Take(ctx context.Context, client string) (func(), error) {
+	if l.Limits != nil {
+		if ok, retryAfter := l.Limits.Allow(client); !ok {
+			return nil, ErrLimited{Reason: fmt.Sprintf("%s has exceeded its rate limit", client), RetryAfter: retryAfter}
+		}
+	}
+	refund := func() {
+		if l.Limits != nil {
+			l.Limits.Return(client)
+		}
+	}
+	if l.Cap != nil {
+		ok, retryAfter, err := l.Cap.Allow(ctx)
+		if err != nil {
+			refund()
+			return nil, err
+		}
+		if !ok {
+			refund()
+			return nil, ErrLimited{Reason: fmt.Sprintf("%d digests are already in progress", l.Cap.Max), RetryAfter: retryAfter}
+		}
+	}
+	return func() {
+		refund()
+		if l.Cap != nil {
+			l.Cap.Release()
+		}
+	}, nil
+}
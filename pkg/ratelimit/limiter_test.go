@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiterRateLimit(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	l := &Limiter{Limits: &Limits{
+		Default: Limit{PerMinute: 1},
+		Now:     func() time.Time { return now },
+	}}
+
+	refund, err := l.Take(context.Background(), "alice")
+	assert.Nil(t, err)
+	assert.NotNil(t, refund)
+	_, err = l.Take(context.Background(), "alice")
+	assert.Equal(t, ErrLimited{Reason: "alice has exceeded its rate limit", RetryAfter: time.Minute}, err)
+	_, err = l.Take(context.Background(), "bob")
+	assert.Nil(t, err, "each client should have its own bucket")
+
+	refund()
+	_, err = l.Take(context.Background(), "alice")
+	assert.Nil(t, err, "a refunded charge should be available again")
+}
+
+func TestLimiterInProgressCap(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	now := time.Unix(1500000000, 0)
+	counter := NewMockMarkerCounter(ctrl)
+	l := &Limiter{
+		Limits: &Limits{Default: Limit{PerMinute: 2}, Now: func() time.Time { return now }},
+		Cap:    &InProgressCap{Counter: counter, Max: 1, CountTTL: time.Hour},
+	}
+
+	counter.EXPECT().CountMarked(gomock.Any()).Return(0, nil)
+	refund, err := l.Take(context.Background(), "alice")
+	assert.Nil(t, err)
+	_, err = l.Take(context.Background(), "alice")
+	assert.Equal(t, ErrLimited{Reason: "1 digests are already in progress", RetryAfter: defaultRetryAfter}, err)
+
+	// the rate is not charged for digests rejected by the cap, and refunds release the cap
+	refund()
+	_, err = l.Take(context.Background(), "alice")
+	assert.Nil(t, err)
+	_, err = l.Take(context.Background(), "alice")
+	assert.Equal(t, ErrLimited{Reason: "1 digests are already in progress", RetryAfter: defaultRetryAfter}, err)
+	refund()
+	_, err = l.Take(context.Background(), "alice")
+	assert.Nil(t, err)
+}
+
+func TestLimiterCountFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	now := time.Unix(1500000000, 0)
+	counter := NewMockMarkerCounter(ctrl)
+	l := &Limiter{
+		Limits: &Limits{Default: Limit{PerMinute: 1}, Now: func() time.Time { return now }},
+		Cap:    &InProgressCap{Counter: counter, Max: 1},
+	}
+
+	counter.EXPECT().CountMarked(gomock.Any()).Return(0, errors.New("oops"))
+	_, err := l.Take(context.Background(), "alice")
+	assert.NotNil(t, err)
+	_, ok := err.(ErrLimited)
+	assert.False(t, ok)
+	ok, _ = l.Limits.Allow("alice")
+	assert.True(t, ok, "the rate should not be charged when the cap cannot be checked")
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	l := &Limiter{}
+	for i := 0; i < 100; i++ {
+		refund, err := l.Take(context.Background(), "alice")
+		assert.Nil(t, err)
+		refund()
+	}
+}
@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is the time between sweeps of the buckets which have refilled
+const sweepInterval = time.Minute
+
+// Limit is the rate at which a client may create digests. Requests are allowed as long as tokens remain in the
+// client's bucket, which holds up to Burst tokens, and is refilled at PerMinute tokens a minute.
+type Limit struct {
+	PerMinute int
+	// Burst is the size of the bucket. If zero, it is the same as PerMinute.
+	Burst int
+}
+
+// unlimited reports whether the Limit allows any number of requests
+func (l Limit) unlimited() bool {
+	return l.PerMinute <= 0
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.PerMinute)
+}
+
+// perSecond returns the rate at which the bucket is refilled
+func (l Limit) perSecond() float64 {
+	return float64(l.PerMinute) / 60
+}
+
+// Limits holds a token bucket for each client. Buckets which have refilled are the same as new buckets, and are
+// removed periodically, so that only the clients which have made requests recently are held in memory.
+type Limits struct {
+	// Default is the Limit of clients with no Limit of their own. If its rate is zero, they are not limited.
+	Default Limit
+	// Clients are the Limits of individual clients, by the subject they authenticate as
+	Clients map[string]Limit
+	// Now returns the current time. If nil, time.Now is used.
+	Now func() time.Time
+
+	lock    sync.Mutex
+	buckets map[string]*bucket
+	sweptAt time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Allow takes a token from the bucket of the client, reporting whether one was available. If not, it returns
+// the time after which one will be.
+func (l *Limits) Allow(client string) (bool, time.Duration) {
+	limit := l.limit(client)
+	if limit.unlimited() {
+		return true, 0
+	}
+	now := l.now()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.buckets == nil {
+		l.buckets = make(map[string]*bucket)
+	}
+	if now.Sub(l.sweptAt) >= sweepInterval {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: limit.burst(), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(limit.burst(), b.tokens+now.Sub(b.last).Seconds()*limit.perSecond())
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := (1 - b.tokens) / limit.perSecond()
+	return false, time.Duration(math.Ceil(wait * float64(time.Second)))
+}
+
+// Return gives back a token taken from the bucket of the client, for a digest which was not queued after all
+func (l *Limits) Return(client string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if b, ok := l.buckets[client]; ok {
+		b.tokens = math.Min(l.limit(client).burst(), b.tokens+1)
+	}
+}
+
+// limit returns the Limit of the client
+func (l *Limits) limit(client string) Limit {
+	if limit, ok := l.Clients[client]; ok {
+		return limit
+	}
+	return l.Default
+}
+
+// sweep removes the buckets which would have refilled by now. It is called with the lock held.
+func (l *Limits) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		limit := l.limit(client)
+		if limit.unlimited() || b.tokens+now.Sub(b.last).Seconds()*limit.perSecond() >= limit.burst() {
+			delete(l.buckets, client)
+		}
+	}
+	l.sweptAt = now
+}
+
+func (l *Limits) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitsAllow(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	l := &Limits{
+		Default: Limit{PerMinute: 2},
+		Clients: map[string]Limit{
+			"batch":     {PerMinute: 60, Burst: 3},
+			"unlimited": {},
+		},
+		Now: func() time.Time { return now },
+	}
+
+	// the default bucket holds a minute of requests
+	for i := 0; i < 2; i++ {
+		ok, _ := l.Allow("alice")
+		assert.True(t, ok)
+	}
+	ok, retryAfter := l.Allow("alice")
+	assert.False(t, ok)
+	assert.Equal(t, 30*time.Second, retryAfter)
+
+	// each client has its own bucket
+	ok, _ = l.Allow("bob")
+	assert.True(t, ok)
+
+	for i := 0; i < 3; i++ {
+		ok, _ = l.Allow("batch")
+		assert.True(t, ok)
+	}
+	ok, retryAfter = l.Allow("batch")
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, retryAfter)
+
+	for i := 0; i < 100; i++ {
+		ok, _ = l.Allow("unlimited")
+		assert.True(t, ok)
+	}
+
+	// buckets are refilled over time, up to their burst
+	now = now.Add(time.Hour)
+	ok, _ = l.Allow("alice")
+	assert.True(t, ok)
+	ok, _ = l.Allow("alice")
+	assert.True(t, ok)
+	ok, _ = l.Allow("alice")
+	assert.False(t, ok)
+}
+
+func TestLimitsUnlimitedByDefault(t *testing.T) {
+	l := &Limits{}
+	for i := 0; i < 100; i++ {
+		ok, _ := l.Allow("alice")
+		assert.True(t, ok)
+	}
+}
+
+func TestLimitsReturn(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	l := &Limits{
+		Default: Limit{PerMinute: 1},
+		Now:     func() time.Time { return now },
+	}
+
+	ok, _ := l.Allow("alice")
+	assert.True(t, ok)
+	l.Return("alice")
+	ok, _ = l.Allow("alice")
+	assert.True(t, ok, "the returned token should be available again")
+	ok, _ = l.Allow("alice")
+	assert.False(t, ok)
+
+	// tokens are never returned beyond the burst
+	l.Return("alice")
+	l.Return("alice")
+	ok, _ = l.Allow("alice")
+	assert.True(t, ok)
+	ok, _ = l.Allow("alice")
+	assert.False(t, ok)
+}
+
+func TestLimitsSweep(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	l := &Limits{
+		Default: Limit{PerMinute: 1},
+		Clients: map[string]Limit{"batch": {PerMinute: 1, Burst: 10}},
+		Now:     func() time.Time { return now },
+	}
+
+	for _, client := range []string{"10.0.0.1", "10.0.0.2", "batch", "batch", "batch"} {
+		ok, _ := l.Allow(client)
+		assert.True(t, ok)
+	}
+	assert.Len(t, l.buckets, 3)
+
+	// after a minute, the default buckets have refilled, but the bucket of batch takes longer to refill
+	now = now.Add(time.Minute)
+	ok, _ := l.Allow("10.0.0.3")
+	assert.True(t, ok)
+	assert.Len(t, l.buckets, 2)
+	assert.Contains(t, l.buckets, "batch")
+	assert.Contains(t, l.buckets, "10.0.0.3")
+
+	// a client whose bucket was removed starts with a full bucket
+	ok, _ = l.Allow("10.0.0.1")
+	assert.True(t, ok)
+	ok, _ = l.Allow("10.0.0.1")
+	assert.False(t, ok)
+}
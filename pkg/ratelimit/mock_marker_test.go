@@ -0,0 +1,41 @@
+// Automatically generated by MockGen. DO NOT EDIT!
+// Source: ./pkg/types/storage.go
+
+package ratelimit
+
+import (
+	context "context"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// Mock of MarkerCounter interface
+type MockMarkerCounter struct {
+	ctrl     *gomock.Controller
+	recorder *_MockMarkerCounterRecorder
+}
+
+// Recorder for MockMarkerCounter (not exported)
+type _MockMarkerCounterRecorder struct {
+	mock *MockMarkerCounter
+}
+
+func NewMockMarkerCounter(ctrl *gomock.Controller) *MockMarkerCounter {
+	mock := &MockMarkerCounter{ctrl: ctrl}
+	mock.recorder = &_MockMarkerCounterRecorder{mock}
+	return mock
+}
+
+func (_m *MockMarkerCounter) EXPECT() *_MockMarkerCounterRecorder {
+	return _m.recorder
+}
+
+func (_m *MockMarkerCounter) CountMarked(ctx context.Context) (int, error) {
+	ret := _m.ctrl.Call(_m, "CountMarked", ctx)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockMarkerCounterRecorder) CountMarked(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CountMarked", arg0)
+}
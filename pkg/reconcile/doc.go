@@ -0,0 +1,3 @@
+// Package reconcile contains the background process which detects digests that were created before
+// all of their source flow logs had been delivered, and requeues them so that they are regenerated.
+package reconcile
@@ -0,0 +1,173 @@
+package reconcile
+
+import (
+	"context"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/logs"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+)
+
+const (
+	pageSize           = 100
+	defaultMaxAttempts = 5
+)
+
+// Storage is the Storage of digests which the reconciler checks. It must be able to flag digests as stale.
+type Storage interface {
+	types.Storage
+	types.StaleMarker
+}
+
+// Reconciler compares the source data recorded in the metadata of recently created digests with the
+// flow log objects that are currently available. VPC flow logs are delivered with a lag, so a digest
+// for a recent window may have been created before all of its source data existed. Such digests are
+// flagged as stale and requeued, and the stale digest continues to be served until it is replaced.
+// A Reconciler must not run more than one pass at a time.
+type Reconciler struct {
+	LogProvider types.LogFn
+	Storage     Storage
+	Marker      types.Marker
+	Queuer      types.Queuer
+	Watermarker types.WatermarkProvider
+	// Horizon bounds how far back, from now, the reconciler looks for digests to check. Late-arriving
+	// data is only expected for recent windows, so this should be a small multiple of the delivery lag.
+	Horizon time.Duration
+	// Interval is the time between reconciliation passes when running in the background.
+	Interval time.Duration
+	// MaxAttempts is the number of times a stale digest is requeued before the reconciler gives up on it, if
+	// each attempt fails to regenerate it. Attempts are spaced by Interval, doubling after each one. Defaults
+	// to 5.
+	MaxAttempts int
+	// Policy is the policy enforced by the worker endpoint. Digests whose window it would reject, such as
+	// those which have aged past its MaxLookback, can no longer be regenerated and are not checked.
+	Policy types.WindowPolicy
+
+	now func() time.Time
+	// attempts records the regenerations requeued for each stale digest seen in the last pass
+	attempts map[string]attempt
+}
+
+// attempt records the regenerations requeued for a stale digest
+type attempt struct {
+	count int
+	next  time.Time
+}
+
+// Run reconciles digests every Interval until the context is cancelled
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Reconcile(ctx); err != nil {
+				r.LogProvider(ctx).Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+			}
+		}
+	}
+}
+
+// Reconcile performs a single pass over all digests whose window overlaps with the Horizon. Failures
+// to reconcile individual digests are logged and do not stop the pass. An error is returned only if
+// the digests could not be listed.
+func (r *Reconciler) Reconcile(ctx context.Context) error {
+	now := r.now
+	if now == nil {
+		now = time.Now
+	}
+	options := types.ListOptions{
+		Start: now().Add(-r.Horizon),
+		Limit: pageSize,
+	}
+	// attempts are only kept for the stale digests seen in this pass, so that those which have been
+	// regenerated, deleted or aged out of the Horizon are forgotten
+	attempts := make(map[string]attempt)
+	for {
+		list, err := r.Storage.List(ctx, options)
+		if err != nil {
+			return err
+		}
+		for _, meta := range list.Digests {
+			if r.Policy.Check(meta.Start, meta.Stop, now()) != nil {
+				continue
+			}
+			if a, ok := r.reconcile(ctx, meta, now()); ok {
+				attempts[meta.ID] = a
+			}
+		}
+		if list.NextToken == "" {
+			r.attempts = attempts
+			return nil
+		}
+		options.Token = list.NextToken
+	}
+}
+
+// reconcile checks a single digest, requeuing it if it is stale. The attempts made to regenerate the digest are
+// returned if it is stale.
+func (r *Reconciler) reconcile(ctx context.Context, meta types.DigestMetadata, now time.Time) (attempt, bool) {
+	logger := r.LogProvider(ctx)
+	previous := r.attempts[meta.ID]
+	// a digest which is already being created or regenerated does not need to be checked
+	exists, err := r.Storage.Exists(ctx, meta.ID)
+	if err != nil {
+		if _, ok := err.(types.ErrInProgress); !ok {
+			logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		}
+		return previous, meta.Stale
+	}
+	if !exists {
+		return attempt{}, false
+	}
+	// a digest which was already flagged as stale, but is no longer in progress, failed to regenerate
+	// and should be requeued, unless it has failed too many times or is waiting to be retried
+	if meta.Stale {
+		if previous.count == r.maxAttempts() {
+			logger.Warn(logs.RegenerationAbandoned{ID: meta.ID, Attempts: previous.count})
+			// the count is moved past the maximum so that the digest is only reported once
+			previous.count++
+		}
+		if previous.count > r.maxAttempts() || now.Before(previous.next) {
+			return previous, true
+		}
+	} else {
+		watermark, err := r.Watermarker(ctx, meta.Start, meta.Stop)
+		if err != nil {
+			logger.Error(logs.DependencyFailure{Dependency: logs.DependencyWatermarker, Reason: err.Error()})
+			return attempt{}, false
+		}
+		if !watermark.Changed(meta) {
+			return attempt{}, false
+		}
+		if err := r.Storage.MarkStale(ctx, meta.ID); err != nil {
+			logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+			return attempt{}, false
+		}
+		previous = attempt{}
+		logger.Info(logs.StaleDigest{
+			ID:                 meta.ID,
+			SourceObjects:      meta.SourceObjects,
+			CurrentObjects:     watermark.Objects,
+			SourceLastModified: meta.SourceLastModified.Format(time.RFC3339Nano),
+			LastModified:       watermark.LastModified.Format(time.RFC3339Nano),
+		})
+	}
+	if err := r.Queuer.Queue(ctx, meta.ID, meta.Start, meta.Stop); err != nil {
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyQueuer, Reason: err.Error()})
+		return previous, true
+	}
+	if err := r.Marker.Mark(ctx, meta.ID); err != nil {
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
+	}
+	return attempt{count: previous.count + 1, next: now.Add(r.Interval << uint(previous.count))}, true
+}
+
+func (r *Reconciler) maxAttempts() int {
+	if r.MaxAttempts > 0 {
+		return r.MaxAttempts
+	}
+	return defaultMaxAttempts
+}
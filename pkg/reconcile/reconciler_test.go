@@ -0,0 +1,273 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	now   = time.Date(2019, time.January, 1, 12, 0, 0, 0, time.UTC)
+	start = now.Add(-2 * time.Hour)
+	stop  = now.Add(-time.Hour)
+)
+
+func newReconciler(storage Storage, marker types.Marker, queuer types.Queuer, watermark types.Watermark, err error) *Reconciler {
+	return &Reconciler{
+		LogProvider: func(context.Context) types.Logger {
+			return logevent.New(logevent.Config{Output: ioutil.Discard})
+		},
+		Storage: storage,
+		Marker:  marker,
+		Queuer:  queuer,
+		Watermarker: func(context.Context, time.Time, time.Time) (types.Watermark, error) {
+			return watermark, err
+		},
+		Horizon: 6 * time.Hour,
+		now:     func() time.Time { return now },
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	meta := types.DigestMetadata{
+		ID:                 "id",
+		Start:              start,
+		Stop:               stop,
+		SourceObjects:      2,
+		SourceLastModified: stop,
+	}
+	tc := []struct {
+		Name         string
+		Meta         types.DigestMetadata
+		ExistsErr    error
+		Exists       bool
+		Watermark    types.Watermark
+		WatermarkErr error
+		Stale        bool
+		Requeued     bool
+	}{
+		{
+			Name:      "in_progress",
+			Meta:      meta,
+			ExistsErr: types.ErrInProgress{Key: "id"},
+		},
+		{
+			Name:      "exists_error",
+			Meta:      meta,
+			ExistsErr: errors.New("oops"),
+		},
+		{
+			Name: "deleted",
+			Meta: meta,
+		},
+		{
+			Name:      "unchanged",
+			Meta:      meta,
+			Exists:    true,
+			Watermark: types.Watermark{Objects: 2, LastModified: stop},
+		},
+		{
+			Name:         "watermark_error",
+			Meta:         meta,
+			Exists:       true,
+			WatermarkErr: errors.New("oops"),
+		},
+		{
+			Name:      "new_objects",
+			Meta:      meta,
+			Exists:    true,
+			Watermark: types.Watermark{Objects: 3, LastModified: stop},
+			Stale:     true,
+			Requeued:  true,
+		},
+		{
+			Name:      "modified_objects",
+			Meta:      meta,
+			Exists:    true,
+			Watermark: types.Watermark{Objects: 2, LastModified: stop.Add(time.Minute)},
+			Stale:     true,
+			Requeued:  true,
+		},
+		{
+			Name: "already_stale",
+			Meta: types.DigestMetadata{
+				ID:    "id",
+				Start: start,
+				Stop:  stop,
+				Stale: true,
+			},
+			Exists:       true,
+			WatermarkErr: errors.New("watermark should not be checked"),
+			Requeued:     true,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockStorage := NewMockStorage(ctrl)
+			mockMarker := NewMockMarker(ctrl)
+			mockQueuer := NewMockQueuer(ctrl)
+
+			mockStorage.EXPECT().List(gomock.Any(), types.ListOptions{Start: now.Add(-6 * time.Hour), Limit: pageSize}).Return(types.DigestList{
+				Digests: []types.DigestMetadata{tt.Meta},
+			}, nil)
+			mockStorage.EXPECT().Exists(gomock.Any(), "id").Return(tt.Exists, tt.ExistsErr)
+			if tt.Stale {
+				mockStorage.EXPECT().MarkStale(gomock.Any(), "id").Return(nil)
+			}
+			if tt.Requeued {
+				mockQueuer.EXPECT().Queue(gomock.Any(), "id", start, stop).Return(nil)
+				mockMarker.EXPECT().Mark(gomock.Any(), "id").Return(nil)
+			}
+
+			r := newReconciler(mockStorage, mockMarker, mockQueuer, tt.Watermark, tt.WatermarkErr)
+			assert.Nil(t, r.Reconcile(context.Background()))
+		})
+	}
+}
+
+func TestReconcilePages(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := NewMockStorage(ctrl)
+	first := types.ListOptions{Start: now.Add(-6 * time.Hour), Limit: pageSize}
+	second := first
+	second.Token = "next"
+	gomock.InOrder(
+		mockStorage.EXPECT().List(gomock.Any(), first).Return(types.DigestList{
+			Digests:   []types.DigestMetadata{{ID: "one"}},
+			NextToken: "next",
+		}, nil),
+		mockStorage.EXPECT().Exists(gomock.Any(), "one").Return(false, nil),
+		mockStorage.EXPECT().List(gomock.Any(), second).Return(types.DigestList{
+			Digests: []types.DigestMetadata{{ID: "two"}},
+		}, nil),
+		mockStorage.EXPECT().Exists(gomock.Any(), "two").Return(false, nil),
+	)
+
+	r := newReconciler(mockStorage, nil, nil, types.Watermark{}, nil)
+	assert.Nil(t, r.Reconcile(context.Background()))
+}
+
+func TestReconcileListError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().List(gomock.Any(), gomock.Any()).Return(types.DigestList{}, errors.New("oops"))
+
+	r := newReconciler(mockStorage, nil, nil, types.Watermark{}, nil)
+	assert.NotNil(t, r.Reconcile(context.Background()))
+}
+
+func TestReconcileQueueError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := NewMockStorage(ctrl)
+	mockQueuer := NewMockQueuer(ctrl)
+	mockStorage.EXPECT().List(gomock.Any(), gomock.Any()).Return(types.DigestList{
+		Digests: []types.DigestMetadata{{ID: "id", Start: start, Stop: stop, Stale: true}},
+	}, nil)
+	mockStorage.EXPECT().Exists(gomock.Any(), "id").Return(true, nil)
+	mockQueuer.EXPECT().Queue(gomock.Any(), "id", start, stop).Return(errors.New("oops"))
+
+	r := newReconciler(mockStorage, nil, mockQueuer, types.Watermark{}, nil)
+	assert.Nil(t, r.Reconcile(context.Background()))
+}
+
+func TestReconcileSkipsRejectedWindows(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().List(gomock.Any(), gomock.Any()).Return(types.DigestList{
+		Digests: []types.DigestMetadata{
+			{ID: "old", Start: start, Stop: stop, Stale: true},
+			{ID: "recent", Start: stop, Stop: now.Add(-30 * time.Minute)},
+		},
+	}, nil)
+	mockStorage.EXPECT().Exists(gomock.Any(), "recent").Return(false, nil)
+
+	r := newReconciler(mockStorage, nil, nil, types.Watermark{}, nil)
+	r.Policy = types.WindowPolicy{MaxLookback: 90 * time.Minute}
+	assert.Nil(t, r.Reconcile(context.Background()))
+}
+
+func TestReconcileRetryBackoff(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stale := types.DigestMetadata{ID: "id", Start: start, Stop: stop, Stale: true}
+	mockStorage := NewMockStorage(ctrl)
+	mockMarker := NewMockMarker(ctrl)
+	mockQueuer := NewMockQueuer(ctrl)
+	mockStorage.EXPECT().List(gomock.Any(), gomock.Any()).Return(types.DigestList{
+		Digests: []types.DigestMetadata{stale},
+	}, nil).Times(5)
+	mockStorage.EXPECT().Exists(gomock.Any(), "id").Return(true, nil).Times(5)
+	mockQueuer.EXPECT().Queue(gomock.Any(), "id", start, stop).Return(nil).Times(2)
+	mockMarker.EXPECT().Mark(gomock.Any(), "id").Return(nil).Times(2)
+
+	current := now
+	r := newReconciler(mockStorage, mockMarker, mockQueuer, types.Watermark{}, nil)
+	r.now = func() time.Time { return current }
+	r.Interval = 10 * time.Minute
+	r.MaxAttempts = 2
+
+	// requeued, then retried after one interval
+	assert.Nil(t, r.Reconcile(context.Background()))
+	current = now.Add(5 * time.Minute)
+	assert.Nil(t, r.Reconcile(context.Background()))
+	current = now.Add(10 * time.Minute)
+	assert.Nil(t, r.Reconcile(context.Background()))
+	// given up on once the attempts are exhausted
+	current = now.Add(30 * time.Minute)
+	assert.Nil(t, r.Reconcile(context.Background()))
+	current = now.Add(time.Hour)
+	assert.Nil(t, r.Reconcile(context.Background()))
+	assert.Len(t, r.attempts, 1)
+}
+
+func TestReconcileForgetsAttempts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := NewMockStorage(ctrl)
+	mockMarker := NewMockMarker(ctrl)
+	mockQueuer := NewMockQueuer(ctrl)
+	gomock.InOrder(
+		mockStorage.EXPECT().List(gomock.Any(), gomock.Any()).Return(types.DigestList{
+			Digests: []types.DigestMetadata{{ID: "id", Start: start, Stop: stop, Stale: true}},
+		}, nil),
+		mockStorage.EXPECT().Exists(gomock.Any(), "id").Return(true, nil),
+		mockStorage.EXPECT().List(gomock.Any(), gomock.Any()).Return(types.DigestList{}, nil),
+	)
+	mockQueuer.EXPECT().Queue(gomock.Any(), "id", start, stop).Return(nil)
+	mockMarker.EXPECT().Mark(gomock.Any(), "id").Return(nil)
+
+	r := newReconciler(mockStorage, mockMarker, mockQueuer, types.Watermark{}, nil)
+	assert.Nil(t, r.Reconcile(context.Background()))
+	assert.Len(t, r.attempts, 1)
+	assert.Nil(t, r.Reconcile(context.Background()))
+	assert.Len(t, r.attempts, 0)
+}
+
+func TestRunCancelled(t *testing.T) {
+	r := newReconciler(nil, nil, nil, types.Watermark{}, nil)
+	r.Interval = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.Run(ctx)
+}
@@ -1,17 +1,28 @@
 package digesterd
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/asecurityteam/go-vpcflow"
 	"github.com/asecurityteam/transport"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/auth"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/callback"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/events"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/common"
 	v1 "github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/v1"
+	v2 "github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/v2"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/logs"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/openapi"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/ratelimit"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/reconcile"
 	"github.com/asecurityteam/vpcflow-digesterd/pkg/storage"
 	"github.com/asecurityteam/vpcflow-digesterd/pkg/stream"
 	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
@@ -19,9 +30,18 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+const (
+	defaultReconcileHorizon         = time.Hour
+	defaultEstimatedDurationPerHour = time.Minute
+	// workerSubject identifies the stream appliance when it authenticates with DIGEST_WORKER_SECRET
+	workerSubject = "stream-appliance"
 )
 
 // Service is a container for all of the pluggable modules used by the service
@@ -44,22 +64,50 @@ type Service struct {
 	// Marker is responsible for marking which digests jobs are inprogress. The built in
 	// Marker uses S3 to hold this state.
 	Marker types.Marker
-}
 
-func (s *Service) init() error {
-	var err error
-	storageClient, err := createS3Client(mustEnv("DIGEST_STORAGE_BUCKET_REGION"), os.Getenv("DIGEST_STORAGE_BUCKET_ROLE"))
-	if err != nil {
-		return err
-	}
-	progressClient, err := createS3Client(mustEnv("DIGEST_PROGRESS_BUCKET_REGION"), os.Getenv("DIGEST_PROGRESS_BUCKET_ROLE"))
-	if err != nil {
-		return err
-	}
+	// Notifier delivers notifications to the callback URLs given when digests are requested.
+	// Callbacks are only accepted if DIGEST_CALLBACK_ALLOWLIST is set. The built in Notifier
+	// POSTs JSON notifications signed with DIGEST_CALLBACK_SECRET.
+	Notifier types.Notifier
+
+	// DigesterProvider creates the digests of flow logs. The built in DigesterProvider reads
+	// flow logs from the S3 bucket named by VPC_FLOW_LOGS_BUCKET. When DIGEST_RECONCILE_INTERVAL
+	// is set, the digesters it creates must implement types.StatsReporter.
+	DigesterProvider types.DigesterProvider
+
+	// DigestersReportStats declares that the digesters created by the DigesterProvider implement
+	// types.StatsReporter, which is required when DIGEST_RECONCILE_INTERVAL is set. It is set for
+	// the built in DigesterProvider.
+	DigestersReportStats bool
+
+	// WatermarkProvider describes the flow logs a digest would be created from, and is used to
+	// reconcile late data when DIGEST_RECONCILE_INTERVAL is set. The built in WatermarkProvider
+	// lists the flow logs in the S3 bucket named by VPC_FLOW_LOGS_BUCKET.
+	WatermarkProvider types.WatermarkProvider
+
+	// Authenticator identifies the callers of the API, whose permissions are checked by each
+	// route. The built in Authenticator accepts the bearer tokens, HMAC signing keys and JWT
+	// signing keys given by the DIGEST_AUTH_* settings. If none are given, and no Authenticator
+	// is provided, the API does not require authentication.
+	Authenticator auth.Authenticator
+
+	// Config holds the settings of the built in modules. If no Config is provided, it is loaded
+	// from the environment, or the file named by DIGEST_CONFIG_FILE.
+	Config *Config
+
+	// workerAddress and worker are the listening address and handler of the worker endpoint, when
+	// it is served apart from the API
+	workerAddress string
+	worker        http.Handler
+	// reconciler is run by Reconcile, if DIGEST_RECONCILE_INTERVAL is set
+	reconciler *reconcile.Reconciler
+}
 
+// init builds each of the built in modules which was not provided. S3 clients are only created for the
+// buckets used by those modules, so a Service given all of its modules needs no AWS configuration.
+func (s *Service) init(cfg *Config) error {
 	if s.Queuer == nil {
-		streamApplianceEndpoint := mustEnv("STREAM_APPLIANCE_ENDPOINT")
-		streamApplianceURL, err := url.Parse(streamApplianceEndpoint)
+		streamApplianceURL, err := url.Parse(cfg.StreamApplianceEndpoint)
 		if err != nil {
 			return err
 		}
@@ -85,125 +133,510 @@ func (s *Service) init() error {
 		s.Queuer = &stream.DigestQueuer{
 			Client:   s.HTTPClient,
 			Endpoint: streamApplianceURL,
+			Token:    cfg.StreamApplianceToken,
 		}
 	}
-	if s.Storage == nil {
-		progressTimeoutStr := mustEnv("DIGEST_PROGRESS_TIMEOUT")
-		progressTimeoutInt, err := strconv.Atoi(progressTimeoutStr)
+	if s.Storage == nil || s.Marker == nil {
+		progressClient, err := createS3Client(cfg, cfg.progressBucket())
 		if err != nil {
 			return err
 		}
-		s.Storage = &storage.InProgress{
-			Bucket: mustEnv("DIGEST_PROGRESS_BUCKET"),
-			Client: progressClient,
-			Storage: &storage.S3{
-				Bucket: mustEnv("DIGEST_STORAGE_BUCKET"),
-				Client: storageClient,
-			},
-			Timeout: time.Millisecond * time.Duration(progressTimeoutInt),
+		if s.Storage == nil {
+			storageClient, err := createS3Client(cfg, cfg.storageBucket())
+			if err != nil {
+				return err
+			}
+			var digests types.Storage = &storage.S3{
+				Bucket:  cfg.StorageBucket,
+				Client:  storageClient,
+				Options: cfg.storageObjects(),
+			}
+			keys, err := newKeyProvider(cfg)
+			if err != nil {
+				return err
+			}
+			if keys != nil {
+				digests = &storage.Encrypted{Keys: keys, Storage: digests}
+			}
+			s.Storage = &storage.InProgress{
+				Bucket:  cfg.ProgressBucket,
+				Client:  progressClient,
+				Storage: digests,
+				Timeout: cfg.ProgressTimeout,
+			}
+		}
+		if s.Marker == nil {
+			s.Marker = &storage.ProgressMarker{
+				Bucket:  cfg.ProgressBucket,
+				Client:  progressClient,
+				Timeout: cfg.ProgressTimeout,
+				Options: cfg.progressObjects(),
+			}
+		}
+	}
+	if s.DigesterProvider == nil || s.needsWatermarker(cfg) {
+		flowLogsClient, err := createS3Client(cfg, cfg.flowLogsBucket())
+		if err != nil {
+			return err
+		}
+		if s.DigesterProvider == nil {
+			s.DigesterProvider = newDigester(cfg.VPCFlowLogsBucket, flowLogsClient, cfg.MaxBytesPrefetch, cfg.MaxConcurrentPrefetch, cfg.ScanRegions, cfg.ScanAccounts)
+			s.DigestersReportStats = true
+		}
+		if s.WatermarkProvider == nil {
+			s.WatermarkProvider = newWatermarker(cfg.VPCFlowLogsBucket, flowLogsClient, cfg.ScanRegions, cfg.ScanAccounts)
 		}
 	}
-	if s.Marker == nil {
-		s.Marker = &storage.ProgressMarker{
-			Bucket: mustEnv("DIGEST_PROGRESS_BUCKET"),
-			Client: progressClient,
+	if s.Authenticator == nil && cfg.authEnabled() {
+		authenticator, err := newAuthenticator(cfg)
+		if err != nil {
+			return err
 		}
+		s.Authenticator = authenticator
 	}
 	return nil
 }
 
-// BindRoutes binds the service handlers to the provided router
-func (s *Service) BindRoutes(router chi.Router) error {
-	if err := s.init(); err != nil {
-		return err
+// needsWatermarker reports whether the built in WatermarkProvider is used, which is only the case if late data
+// is reconciled and no WatermarkProvider was provided
+func (s *Service) needsWatermarker(cfg *Config) bool {
+	return s.WatermarkProvider == nil && cfg.ReconcileInterval > 0
+}
+
+// config returns the Config of the service, loading it from the environment if none was given, and checks that
+// it holds every setting required by the built in modules the service will use. All of the problems found are
+// reported in a single ConfigError.
+func (s *Service) config(ctx context.Context) (*Config, error) {
+	cfg := s.Config
+	var problems []string
+	if cfg == nil {
+		source, err := NewConfigSource(os.Environ())
+		if err != nil {
+			return nil, err
+		}
+		cfg = NewConfig()
+		problems = cfg.load(ctx, source)
 	}
-	vpcflowBucket := mustEnv("VPC_FLOW_LOGS_BUCKET")
-	vpcflowRegion := mustEnv("VPC_FLOW_LOGS_BUCKET_REGION")
-	maxBytesPrefetch := mustEnv("VPC_MAX_BYTES_PREFETCH")
-	maxConcurrentPrefetch := mustEnv("VPC_MAX_CONCURRENT_PREFETCH")
-	maxBytes, err := strconv.ParseInt(maxBytesPrefetch, 10, 64)
-	if err != nil {
-		return err
+	problems = append(problems, cfg.validate(s)...)
+	if len(problems) > 0 {
+		return nil, ConfigError{Problems: problems}
 	}
-	maxConcurrent, err := strconv.Atoi(maxConcurrentPrefetch)
+	return cfg, nil
+}
+
+// BindRoutes binds the service handlers to the provided router. An error is returned, rather than a panic, if the
+// configuration is missing or invalid.
+func (s *Service) BindRoutes(router chi.Router) error {
+	cfg, err := s.config(context.Background())
 	if err != nil {
 		return err
 	}
-	s3Client, err := createS3Client(vpcflowRegion, os.Getenv("VPC_FLOW_LOGS_BUCKET_ROLE"))
-	if err != nil {
+	if err := s.init(cfg); err != nil {
 		return err
 	}
+	policy := cfg.windowPolicy()
+	scope := makeScope(cfg.ScanRegions, cfg.ScanAccounts)
+	bus := &events.Bus{}
+	// the marker is wrapped for this router only, so that binding routes again does not publish each event twice
+	marker := &events.Marker{Marker: s.Marker, Publisher: bus}
 	digesterHandler := &v1.DigesterHandler{
 		LogProvider:  types.LoggerFromContext,
 		StatProvider: types.StatFromContext,
 		Queuer:       s.Queuer,
 		Storage:      s.Storage,
-		Marker:       s.Marker,
+		Marker:       marker,
+		Redirect:     cfg.DownloadRedirect,
+		RedirectTTL:  cfg.DownloadRedirectTTL,
+		Policy:       policy,
+		Scope:        scope,
+		LegacyLookup: cfg.LegacyIDLookup,
+
+		EstimatedDurationPerHour: cfg.EstimatePerHour,
+		MaxWait:                  cfg.MaxWait,
+		Events:                   bus,
+		MaxBatch:                 cfg.BatchMax,
+		BatchConcurrency:         cfg.BatchConcurrency,
 	}
-	regions := strings.Split(os.Getenv("VPC_FLOW_LOGS_SCAN_REGIONS"), ",")
-	accounts := strings.Split(os.Getenv("VPC_FLOW_LOGS_SCAN_ACCOUNTS"), ",")
 	produceHandler := &v1.Produce{
 		LogProvider:      types.LoggerFromContext,
 		StatProvider:     types.StatFromContext,
 		Storage:          s.Storage,
-		Marker:           s.Marker,
-		DigesterProvider: newDigester(vpcflowBucket, s3Client, maxBytes, maxConcurrent, filterSlice(regions), filterSlice(accounts)),
+		Marker:           marker,
+		DigesterProvider: s.DigesterProvider,
+		Scope:            scope,
+		Policy:           policy,
+		Events:           bus,
+		Topic:            cfg.StreamApplianceTopic,
+	}
+	eventsHandler := &v1.Events{
+		Subscriber: bus,
+	}
+	v2Handler := &v2.Handler{
+		LogProvider:  types.LoggerFromContext,
+		StatProvider: types.StatFromContext,
+		Queuer:       s.Queuer,
+		Storage:      s.Storage,
+		Marker:       marker,
+		Redirect:     cfg.DownloadRedirect,
+		RedirectTTL:  cfg.DownloadRedirectTTL,
+		Policy:       policy,
+		Scope:        scope,
+		Events:       bus,
+
+		EstimatedDurationPerHour: cfg.EstimatePerHour,
+	}
+	if allowlist := cfg.CallbackAllowlist; len(allowlist) > 0 {
+		if s.Notifier == nil {
+			s.Notifier = &callback.HTTPNotifier{
+				Client: newCallbackClient(),
+				Secret: []byte(cfg.CallbackSecret),
+			}
+		}
+		digesterHandler.ValidateCallback = callback.Allowlist(allowlist).Validate
+		v2Handler.ValidateCallback = callback.Allowlist(allowlist).Validate
+		produceHandler.Notifier = s.Notifier
 	}
+	s.reconciler = s.newReconciler(cfg, marker)
+	limits, err := cfg.rateLimits()
+	if err != nil {
+		return err
+	}
+	var inProgressCap *ratelimit.InProgressCap
+	// digests are counted by the Marker itself, as counting publishes no events
+	if counter, ok := s.Marker.(types.MarkerCounter); ok && cfg.MaxInProgress > 0 {
+		inProgressCap = &ratelimit.InProgressCap{Counter: counter, Max: cfg.MaxInProgress}
+	}
+	if limits != nil || inProgressCap != nil {
+		// the limits are shared by both versions of the API
+		limiter := &ratelimit.Limiter{Limits: limits, Cap: inProgressCap}
+		digesterHandler.Limiter = limiter
+		v2Handler.Limiter = limiter
+	}
+	v1Spec := v1.OpenAPI()
+	v2Spec := v2.OpenAPI()
+	router.Use(middleware.RequestID)
 	router.Use(s.Middleware...)
-	router.Post("/", digesterHandler.Post)
-	router.Get("/", digesterHandler.Get)
-	router.Post("/{topic}/{event}", produceHandler.ServeHTTP)
+	require := newRequire(s.Authenticator)
+	if s.Authenticator != nil {
+		digesterHandler.AuthorizeForce = auth.Authorize(auth.PermissionRegenerate)
+		v2Handler.AuthorizeForce = auth.Authorize(auth.PermissionRegenerate)
+	}
+	workerRequire := newRequire(workerAuthenticator(cfg, s.Authenticator))
+	v1Validator := newValidator(v1Spec)
+	router.Group(func(r chi.Router) {
+		bindRoutes(r, digesterHandler, eventsHandler, openapi.Handler(v1Spec), validated(require, v1Validator))
+		if cfg.WorkerAddress == "" {
+			bindWorkerRoutes(r, produceHandler, validated(workerRequire, v1Validator))
+		}
+	})
+	router.Route(v2.Prefix, func(r chi.Router) {
+		bindV2Routes(r, v2Handler, openapi.Handler(v2Spec), validated(require, newValidator(v2Spec)))
+	})
+	if cfg.WorkerAddress != "" {
+		worker := chi.NewRouter()
+		worker.Use(middleware.RequestID)
+		worker.Use(s.Middleware...)
+		bindWorkerRoutes(worker, produceHandler, validated(workerRequire, v1Validator))
+		s.workerAddress = cfg.WorkerAddress
+		s.worker = worker
+	}
 	return nil
 }
 
-func mustEnv(key string) string {
-	val := os.Getenv(key)
-	if val == "" {
-		panic(fmt.Sprintf("%s is required", key))
+// Worker returns the listening address and handler of the worker endpoint, if DIGEST_WORKER_ADDRESS is set, in
+// which case the endpoint is not bound to the router of the API and should be served on its own listener. The
+// handler is nil if the endpoint is bound to the router of the API, or BindRoutes has not been called.
+func (s *Service) Worker() (string, http.Handler) {
+	return s.workerAddress, s.worker
+}
+
+// newValidator returns middleware which validates requests and responses against the OpenAPI document of an
+// API version. Invalid requests are rejected, while invalid responses are only logged.
+func newValidator(spec *openapi.Document) *openapi.Validator {
+	return &openapi.Validator{
+		Document: spec,
+		OnRequestError: func(w http.ResponseWriter, r *http.Request, err error) {
+			types.LoggerFromContext(r.Context()).Info(logs.InvalidInput{Reason: err.Error()})
+			if _, ok := err.(openapi.BodyTooLargeError); ok {
+				common.WriteProblem(w, r, http.StatusRequestEntityTooLarge, common.CodeInvalidRequest, err.Error(), "")
+				return
+			}
+			common.InvalidRequest(w, r, err)
+		},
+		OnResponseError: func(r *http.Request, err error) {
+			types.LoggerFromContext(r.Context()).Error(logs.InvalidResponse{Reason: err.Error()})
+		},
+	}
+}
+
+// validated returns middleware which requires a permission, and then validates the request against the OpenAPI
+// document, so that the content of a request is only read once its caller is authorized
+func validated(require func(auth.Permission) func(http.Handler) http.Handler, validator *openapi.Validator) func(auth.Permission) func(http.Handler) http.Handler {
+	return func(permission auth.Permission) func(http.Handler) http.Handler {
+		authorize := require(permission)
+		return func(next http.Handler) http.Handler {
+			return authorize(validator.Middleware(next))
+		}
 	}
-	return val
 }
 
-func createS3Client(region, assumedRole string) (*s3.S3, error) {
-	useIAM := mustEnv("USE_IAM")
-	useIAMFlag, err := strconv.ParseBool(useIAM)
+// newRequire returns the middleware which checks that the caller of a route holds a permission. If there is no
+// Authenticator, every request is permitted.
+func newRequire(authenticator auth.Authenticator) func(auth.Permission) func(http.Handler) http.Handler {
+	if authenticator == nil {
+		return func(auth.Permission) func(http.Handler) http.Handler {
+			return func(next http.Handler) http.Handler { return next }
+		}
+	}
+	authorizer := &auth.Authorizer{
+		LogProvider:   types.LoggerFromContext,
+		Authenticator: authenticator,
+		Unauthorized:  common.Unauthorized,
+		Forbidden:     common.Forbidden,
+	}
+	return authorizer.Require
+}
+
+// workerAuthenticator returns the Authenticator of the worker endpoint. If DIGEST_WORKER_SECRET is set, the stream
+// appliance may present it as a bearer token, or sign requests with it under the key ID stream-appliance, in
+// addition to any credentials accepted by the API.
+func workerAuthenticator(cfg *Config, api auth.Authenticator) auth.Authenticator {
+	if cfg.WorkerSecret == "" {
+		return api
+	}
+	appliance := []auth.Credential{
+		{Subject: workerSubject, Secret: cfg.WorkerSecret, Permissions: []auth.Permission{auth.PermissionProduce}},
+	}
+	chain := auth.Chain{
+		auth.StaticTokens(appliance),
+		&auth.HMACAuthenticator{Keys: appliance, MaxSkew: cfg.AuthHMACMaxSkew},
+	}
+	if api != nil {
+		chain = append(chain, api)
+	}
+	return chain
+}
+
+// bindRoutes binds each of the routes described by v1.OpenAPI, except the worker endpoint, to its handler, each
+// requiring the permission of its operation. The OpenAPI document is public.
+func bindRoutes(router chi.Router, digesterHandler *v1.DigesterHandler, eventsHandler *v1.Events, specHandler http.HandlerFunc, require func(auth.Permission) func(http.Handler) http.Handler) {
+	router.With(require(auth.PermissionCreate)).Post("/", digesterHandler.Post)
+	router.With(require(auth.PermissionCreate)).Post("/batch", digesterHandler.Batch)
+	router.With(require(auth.PermissionRead)).Get("/", digesterHandler.Get)
+	router.With(require(auth.PermissionRead)).Get("/digests", digesterHandler.List)
+	router.With(require(auth.PermissionRead)).Get("/digests/{id}", digesterHandler.GetByID)
+	router.With(require(auth.PermissionRead)).Head("/digests/{id}", digesterHandler.HeadByID)
+	router.With(require(auth.PermissionDelete)).Delete("/digests/{id}", digesterHandler.DeleteByID)
+	router.With(require(auth.PermissionRead)).Get("/events", eventsHandler.ServeHTTP)
+	router.Get("/openapi.json", specHandler)
+}
+
+// bindWorkerRoutes binds the worker endpoint, to which the stream appliance delivers digest jobs
+func bindWorkerRoutes(router chi.Router, produceHandler *v1.Produce, require func(auth.Permission) func(http.Handler) http.Handler) {
+	router.With(require(auth.PermissionProduce)).Post("/{topic}/{event}", produceHandler.ServeHTTP)
+}
+
+// bindV2Routes binds each of the routes described by v2.OpenAPI to its handler. The router is mounted at
+// v2.Prefix, which takes precedence over the v1 produce route for the topic "v2".
+func bindV2Routes(router chi.Router, handler *v2.Handler, specHandler http.HandlerFunc, require func(auth.Permission) func(http.Handler) http.Handler) {
+	router.With(require(auth.PermissionCreate)).Post("/jobs", handler.CreateJob)
+	router.With(require(auth.PermissionRead)).Get("/jobs/{id}", handler.GetJob)
+	router.With(require(auth.PermissionRead)).Get("/digests", handler.ListDigests)
+	router.With(require(auth.PermissionRead)).Get("/digests/{id}", handler.GetDigest)
+	router.With(require(auth.PermissionDelete)).Delete("/digests/{id}", handler.DeleteDigest)
+	router.With(require(auth.PermissionRead)).Get("/digests/{id}/content", handler.GetDigestContent)
+	router.Get("/openapi.json", specHandler)
+}
+
+// newReconciler returns the reconciler which requeues digests whose source data has changed since they were
+// created, or nil if DIGEST_RECONCILE_INTERVAL is not set or the Storage cannot flag digests as stale
+func (s *Service) newReconciler(cfg *Config, marker types.Marker) *reconcile.Reconciler {
+	storage, ok := s.Storage.(reconcile.Storage)
+	if cfg.ReconcileInterval == 0 || !ok {
+		return nil
+	}
+	return &reconcile.Reconciler{
+		LogProvider: types.LoggerFromContext,
+		Storage:     storage,
+		Marker:      marker,
+		Queuer:      s.Queuer,
+		Watermarker: s.WatermarkProvider,
+		Horizon:     cfg.ReconcileHorizon,
+		Interval:    cfg.ReconcileInterval,
+		MaxAttempts: cfg.ReconcileMaxAttempts,
+		Policy:      cfg.windowPolicy(),
+	}
+}
+
+// Reconcile runs the reconciler, which requeues digests whose source data has changed since they were created,
+// until the context is cancelled. The reconciler logs to the logger of the context. Reconcile returns at once
+// if DIGEST_RECONCILE_INTERVAL is not set, or BindRoutes has not been called.
+func (s *Service) Reconcile(ctx context.Context) {
+	if s.reconciler == nil {
+		return
+	}
+	s.reconciler.Run(ctx)
+}
+
+// newCallbackClient returns the client used to deliver notifications to callback URLs. Deliveries are retried
+// on server errors, throttling and timeouts, and redirects are not followed so that notifications can only be
+// delivered to allowed hosts.
+func newCallbackClient() *http.Client {
+	retrier := transport.NewRetrier(
+		transport.NewPercentJitteredBackoffPolicy(transport.NewFixedBackoffPolicy(500*time.Millisecond), .2),
+		transport.NewLimitedRetryPolicy(5,
+			transport.NewStatusCodeRetryPolicy(429, 500, 502, 503, 504),
+			transport.NewTimeoutRetryPolicy(5*time.Second),
+		),
+	)
+	base := transport.NewFactory(
+		transport.OptionDefaultTransport,
+		transport.OptionTLSHandshakeTimeout(time.Second),
+	)
+	return &http.Client{
+		Transport: transport.Chain{retrier}.ApplyFactory(base)(),
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// createS3Client returns a client for the bucket. Buckets may be served by any S3 compatible endpoint, such as
+// MinIO or LocalStack, optionally addressed by path and with its own certificate authorities.
+func createS3Client(cfg *Config, bucket s3Bucket) (*s3.S3, error) {
+	awsSession, err := createSession(cfg, bucket.Region)
 	if err != nil {
 		return nil, err
 	}
-	cfg := aws.NewConfig()
-	cfg.Region = aws.String(region)
-	if !useIAMFlag {
-		cfg.Credentials = credentials.NewChainCredentials([]credentials.Provider{
+	clientCfg := aws.NewConfig()
+	if bucket.Endpoint != "" {
+		clientCfg.Endpoint = aws.String(bucket.Endpoint)
+	}
+	clientCfg.S3ForcePathStyle = aws.Bool(bucket.PathStyle)
+	if bucket.CAFile != "" || bucket.InsecureSkipVerify {
+		tlsConfig, err := newTLSConfig(bucket.CAFile, bucket.InsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		base := transport.NewFactory(
+			transport.OptionDefaultTransport,
+			transport.OptionTLSClientConfig(tlsConfig),
+		)
+		clientCfg.HTTPClient = &http.Client{Transport: base()}
+	}
+	if bucket.Role != "" {
+		clientCfg.Credentials = stscreds.NewCredentials(awsSession, bucket.Role)
+	}
+	return s3.New(awsSession, clientCfg), nil
+}
+
+// createSession returns an AWS session for the region, using the credentials of the instance if USE_IAM is set
+func createSession(cfg *Config, region string) (*session.Session, error) {
+	awsCfg := aws.NewConfig()
+	awsCfg.Region = aws.String(region)
+	if !cfg.useIAM() {
+		awsCfg.Credentials = credentials.NewChainCredentials([]credentials.Provider{
 			&credentials.EnvProvider{},
 			&credentials.SharedCredentialsProvider{
-				Filename: os.Getenv("AWS_CREDENTIALS_FILE"),
-				Profile:  os.Getenv("AWS_CREDENTIALS_PROFILE"),
+				Filename: cfg.AWSCredentialsFile,
+				Profile:  cfg.AWSCredentialsProfile,
 			},
 		})
 	}
-	awsSession, err := session.NewSession(cfg)
+	return session.NewSession(awsCfg)
+}
+
+// newKeyProvider returns the KeyProvider with which digests are encrypted, or nil if digests are not encrypted.
+// KMS is used in the region of the digest storage bucket, with its role.
+func newKeyProvider(cfg *Config) (types.KeyProvider, error) {
+	if cfg.EncryptionKeyFile != "" {
+		return storage.NewStaticKeyProvider(cfg.EncryptionKeyFile)
+	}
+	if cfg.EncryptionKMSKeyID == "" {
+		return nil, nil
+	}
+	awsSession, err := createSession(cfg, cfg.StorageBucketRegion)
 	if err != nil {
 		return nil, err
 	}
-	if assumedRole != "" {
-		creds := stscreds.NewCredentials(awsSession, assumedRole)
-		return s3.New(awsSession, &aws.Config{Credentials: creds}), nil
+	kmsCfg := aws.NewConfig()
+	if cfg.StorageBucketRole != "" {
+		kmsCfg.Credentials = stscreds.NewCredentials(awsSession, cfg.StorageBucketRole)
+	}
+	return &storage.KMSKeyProvider{Client: kms.New(awsSession, kmsCfg), KeyID: cfg.EncryptionKMSKeyID}, nil
+}
+
+// newAuthenticator returns the built in Authenticator, which accepts each of the kinds of credentials configured
+func newAuthenticator(cfg *Config) (auth.Authenticator, error) {
+	var chain auth.Chain
+	if cfg.AuthTokensFile != "" {
+		tokens, err := auth.LoadCredentials(cfg.AuthTokensFile)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, auth.StaticTokens(tokens))
+	}
+	if cfg.AuthHMACKeysFile != "" {
+		keys, err := auth.LoadCredentials(cfg.AuthHMACKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, &auth.HMACAuthenticator{Keys: keys, MaxSkew: cfg.AuthHMACMaxSkew})
+	}
+	if cfg.AuthJWKSFile != "" {
+		keys, err := auth.LoadKeySet(cfg.AuthJWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, &auth.JWTAuthenticator{Keys: keys, Issuer: cfg.AuthJWTIssuer, Audience: cfg.AuthJWTAudience})
 	}
-	return s3.New(awsSession), nil
+	return chain, nil
+}
+
+// newTLSConfig returns the TLS configuration of a client which trusts the certificate authorities in the PEM
+// file, if given, in addition to those of the system
+func newTLSConfig(caFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify} // nolint: gosec
+	if caFile == "" {
+		return tlsConfig, nil
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates were found in %s", caFile)
+	}
+	tlsConfig.RootCAs = pool
+	return tlsConfig, nil
 }
 
 func newDigester(bucket string, client s3iface.S3API, maxBytes int64, concurrency int, regions []string, accounts []string) types.DigesterProvider {
 	return func(start, stop time.Time) vpcflow.Digester {
-		bucketIter := &vpcflow.BucketStateIterator{
-			Bucket: bucket,
-			Queue:  client,
-			Prefix: makePrefix(regions, accounts, start),
+		listClient := &watermarkClient{S3API: client}
+		bucketIter := &countingIterator{
+			BucketIterator: &vpcflow.BucketStateIterator{
+				Bucket: bucket,
+				Queue:  listClient,
+				Prefix: makePrefix(regions, accounts, start),
+			},
+		}
+		readerIter := &countingReader{
+			ReadCloser: &vpcflow.BucketIteratorReader{
+				BucketIterator: bucketIter,
+				FetchPolicy:    vpcflow.NewPrefetchPolicy(client, maxBytes, concurrency),
+			},
 		}
-		readerIter := &vpcflow.BucketIteratorReader{
-			BucketIterator: bucketIter,
-			FetchPolicy:    vpcflow.NewPrefetchPolicy(client, maxBytes, concurrency),
+		return &statsDigester{
+			Digester: &vpcflow.ReaderDigester{Reader: readerIter},
+			client:   listClient,
+			iterator: bucketIter,
+			reader:   readerIter,
 		}
-		return &vpcflow.ReaderDigester{Reader: readerIter}
 	}
 }
 
@@ -224,6 +657,14 @@ func makePrefix(regions, accounts []string, date time.Time) string {
 	return fmt.Sprintf("AWSLogs/%s/vpcflowlogs/%s/%d/%s/%s", accounts[0], regions[0], date.Year(), month, day) // For now, we are focusing on one day for one region/account combination
 }
 
+// makeScope describes the accounts and regions that are actually scanned, mirroring makePrefix
+func makeScope(regions, accounts []string) types.Scope {
+	if len(regions) == 0 || len(accounts) == 0 {
+		return types.Scope{}
+	}
+	return types.Scope{Accounts: accounts[:1], Regions: regions[:1]}
+}
+
 // because splitting on an empty string will result in a slice with one element, [""],
 // we filter out invalid empty strings
 func filterSlice(slice []string) []string {
@@ -1,17 +1,40 @@
 package digesterd
 
 import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/auth"
+	v1 "github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/v1"
+	v2 "github.com/asecurityteam/vpcflow-digesterd/pkg/handlers/v2"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/openapi"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/ratelimit"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/storage"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/stream"
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/go-chi/chi"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+var updateOpenAPI = flag.Bool("update-openapi", false, "rewrite openapi.json and openapi.v2.json from the OpenAPI documents")
+
 func TestFilterSlice(t *testing.T) {
 	tc := []struct {
 		Name   string
@@ -82,6 +105,12 @@ func TestMakePrefix(t *testing.T) {
 	}
 }
 
+func TestMakeScope(t *testing.T) {
+	assert.Equal(t, "*/*", makeScope([]string{}, []string{"a"}).String())
+	assert.Equal(t, "*/*", makeScope([]string{"r"}, []string{}).String())
+	assert.Equal(t, "a/r", makeScope([]string{"r", "r2"}, []string{"a", "a2"}).String())
+}
+
 func TestNewDigesterSuccess(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -92,47 +121,6 @@ func TestNewDigesterSuccess(t *testing.T) {
 	require.NotNil(t, digester)
 }
 
-func TestMustEnv(t *testing.T) {
-	tc := []struct {
-		Name  string
-		Key   string
-		Value string
-		Set   bool
-	}{
-		{
-			Name:  "var unset",
-			Key:   "key",
-			Value: "",
-			Set:   false,
-		},
-		{
-			Name:  "var set",
-			Key:   "key",
-			Value: "value",
-			Set:   true,
-		},
-	}
-
-	for _, tt := range tc {
-		t.Run(tt.Name, func(t *testing.T) {
-			originalValue, existing := os.LookupEnv(tt.Key)
-			if existing {
-				defer os.Setenv(tt.Key, originalValue)
-			}
-			os.Unsetenv(tt.Key)
-			if tt.Set {
-				os.Setenv(tt.Key, tt.Value)
-				require.Equal(t, tt.Value, mustEnv(tt.Key))
-				return
-			}
-			require.Panics(t, func() {
-				mustEnv(tt.Key)
-			})
-
-		})
-	}
-}
-
 func TestServiceInitSuccess(t *testing.T) {
 	// save current environment variables, and restore them
 	// after the test ends
@@ -153,8 +141,12 @@ func TestServiceInitSuccess(t *testing.T) {
 	os.Setenv("DIGEST_PROGRESS_TIMEOUT", "1")
 	os.Setenv("DIGEST_PROGRESS_BUCKET", "n/a")
 	os.Setenv("DIGEST_STORAGE_BUCKET", "n/a")
+	source, err := NewConfigSource(os.Environ())
+	require.Nil(t, err)
+	cfg, err := LoadConfig(context.Background(), source)
+	require.Nil(t, err)
 	s := &Service{}
-	require.Nil(t, s.init())
+	require.Nil(t, s.init(cfg))
 }
 
 func TestServiceBindRoutesSuccess(t *testing.T) {
@@ -186,3 +178,495 @@ func TestServiceBindRoutesSuccess(t *testing.T) {
 	s := &Service{}
 	require.Nil(t, s.BindRoutes(router))
 }
+
+func TestServiceBindRoutesReportsAllProblems(t *testing.T) {
+	environ := os.Environ()
+	os.Clearenv()
+	defer func() {
+		for _, e := range environ {
+			envPair := strings.Split(e, "=")
+			os.Setenv(envPair[0], envPair[1])
+		}
+	}()
+
+	os.Setenv("DIGEST_STORAGE_BUCKET_REGION", "n/a")
+	os.Setenv("DIGEST_PROGRESS_TIMEOUT", "soon")
+	os.Setenv("VPC_MAX_BYTES_PREFETCH", "-1")
+
+	s := &Service{}
+	err := s.BindRoutes(chi.NewMux())
+	require.NotNil(t, err)
+	configErr, ok := err.(ConfigError)
+	require.True(t, ok)
+	assert.Contains(t, configErr.Problems, "DIGEST_PROGRESS_TIMEOUT is not valid: time: invalid duration \"soon\"")
+	assert.Contains(t, configErr.Problems, "DIGEST_PROGRESS_BUCKET_REGION is required")
+	assert.Contains(t, configErr.Problems, "STREAM_APPLIANCE_ENDPOINT is required")
+	assert.Contains(t, configErr.Problems, "VPC_MAX_BYTES_PREFETCH should be greater than zero")
+	assert.NotContains(t, configErr.Problems, "DIGEST_STORAGE_BUCKET_REGION is required")
+}
+
+func TestServiceBindRoutesWithoutAWS(t *testing.T) {
+	environ := os.Environ()
+	os.Clearenv()
+	defer func() {
+		for _, e := range environ {
+			envPair := strings.Split(e, "=")
+			os.Setenv(envPair[0], envPair[1])
+		}
+	}()
+
+	s := &Service{
+		Queuer:           &stream.DigestQueuer{},
+		Storage:          &storage.S3{},
+		Marker:           &storage.ProgressMarker{},
+		DigesterProvider: newDigester("", nil, 0, 0, nil, nil),
+	}
+	require.Nil(t, s.BindRoutes(chi.NewMux()))
+	assert.Nil(t, s.WatermarkProvider, "no S3 client should be created for the flow logs bucket")
+}
+
+func TestServiceBindRoutesTwice(t *testing.T) {
+	environ := os.Environ()
+	os.Clearenv()
+	defer func() {
+		for _, e := range environ {
+			envPair := strings.Split(e, "=")
+			os.Setenv(envPair[0], envPair[1])
+		}
+	}()
+
+	marker := &storage.ProgressMarker{}
+	s := &Service{
+		Queuer:           &stream.DigestQueuer{},
+		Storage:          &storage.S3{},
+		Marker:           marker,
+		DigesterProvider: newDigester("", nil, 0, 0, nil, nil),
+	}
+	require.Nil(t, s.BindRoutes(chi.NewMux()))
+	require.Nil(t, s.BindRoutes(chi.NewMux()))
+	assert.Equal(t, marker, s.Marker, "the provided Marker should not be wrapped more than once")
+}
+
+func TestServiceBindRoutesAuth(t *testing.T) {
+	environ := os.Environ()
+	os.Clearenv()
+	defer func() {
+		for _, e := range environ {
+			envPair := strings.Split(e, "=")
+			os.Setenv(envPair[0], envPair[1])
+		}
+	}()
+
+	s := &Service{
+		Queuer:           &stream.DigestQueuer{},
+		Storage:          &storage.S3{},
+		Marker:           &storage.ProgressMarker{},
+		DigesterProvider: newDigester("", nil, 0, 0, nil, nil),
+		Authenticator: auth.StaticTokens{
+			{Subject: "reader", Secret: "read-token", Permissions: []auth.Permission{auth.PermissionRead}},
+			{Subject: "creator", Secret: "create-token", Permissions: []auth.Permission{auth.PermissionCreate}},
+		},
+	}
+	router := chi.NewMux()
+	require.Nil(t, s.BindRoutes(router))
+
+	tc := []struct {
+		Name   string
+		Method string
+		Path   string
+		Body   string
+		Token  string
+		Status int
+	}{
+		{Name: "v1 unauthenticated", Method: http.MethodGet, Path: "/digests", Status: http.StatusUnauthorized},
+		{Name: "v1 invalid token", Method: http.MethodGet, Path: "/digests", Token: "other-token", Status: http.StatusUnauthorized},
+		{Name: "v1 forbidden", Method: http.MethodDelete, Path: "/digests/6a6b27b2-4b94-4b8c-a5a1-2ad5c7ea4b3e", Token: "read-token", Status: http.StatusForbidden},
+		{Name: "v1 worker forbidden", Method: http.MethodPost, Path: "/topic/event", Body: `{"id":"id","start":"2019-01-01T00:00:00Z","stop":"2019-01-01T01:00:00Z"}`, Token: "read-token", Status: http.StatusForbidden},
+		{Name: "v1 delete forbidden", Method: http.MethodDelete, Path: "/digests/6a6b27b2-4b94-4b8c-a5a1-2ad5c7ea4b3e", Token: "create-token", Status: http.StatusForbidden},
+		{Name: "v1 force forbidden", Method: http.MethodPost, Path: "/?start=2019-01-01T00:00:00Z&stop=2019-01-01T01:00:00Z&force=true", Token: "create-token", Status: http.StatusForbidden},
+		{Name: "v1 spec public", Method: http.MethodGet, Path: "/openapi.json", Status: http.StatusOK},
+		{Name: "v1 invalid unauthenticated", Method: http.MethodGet, Path: "/digests/abc", Status: http.StatusUnauthorized},
+		{Name: "v1 invalid", Method: http.MethodGet, Path: "/digests/abc", Token: "read-token", Status: http.StatusBadRequest},
+		{Name: "v1 too large unauthenticated", Method: http.MethodPost, Path: "/batch", Body: strings.Repeat(" ", 10<<20+1), Status: http.StatusUnauthorized},
+		{Name: "v1 too large", Method: http.MethodPost, Path: "/batch", Body: strings.Repeat(" ", 10<<20+1), Token: "create-token", Status: http.StatusRequestEntityTooLarge},
+		{Name: "v2 unauthenticated", Method: http.MethodGet, Path: v2.Prefix + "/digests", Status: http.StatusUnauthorized},
+		{Name: "v2 forbidden", Method: http.MethodDelete, Path: v2.Prefix + "/digests/6a6b27b2-4b94-4b8c-a5a1-2ad5c7ea4b3e", Token: "read-token", Status: http.StatusForbidden},
+		{Name: "v2 delete forbidden", Method: http.MethodDelete, Path: v2.Prefix + "/digests/6a6b27b2-4b94-4b8c-a5a1-2ad5c7ea4b3e", Token: "create-token", Status: http.StatusForbidden},
+		{Name: "v2 force forbidden", Method: http.MethodPost, Path: v2.Prefix + "/jobs", Body: `{"start":"2019-01-01T00:00:00Z","stop":"2019-01-01T01:00:00Z","force":true}`, Token: "create-token", Status: http.StatusForbidden},
+		{Name: "v2 spec public", Method: http.MethodGet, Path: v2.Prefix + "/openapi.json", Status: http.StatusOK},
+		{Name: "v2 invalid unauthenticated", Method: http.MethodPost, Path: v2.Prefix + "/jobs", Body: `{"start":"yesterday"}`, Status: http.StatusUnauthorized},
+		{Name: "v2 invalid", Method: http.MethodPost, Path: v2.Prefix + "/jobs", Body: `{"start":"yesterday"}`, Token: "create-token", Status: http.StatusBadRequest},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.Method, tt.Path, strings.NewReader(tt.Body))
+			r.Header.Set("Content-Type", "application/json")
+			r = r.WithContext(logevent.NewContext(r.Context(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+			if tt.Token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.Token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			assert.Equal(t, tt.Status, w.Code)
+		})
+	}
+}
+
+func TestServiceBindRoutesWorker(t *testing.T) {
+	const job = `{"id":"id","start":"2019-01-01T00:00:00Z","stop":"2019-01-01T01:00:00Z"}`
+	cfg := NewConfig()
+	cfg.WorkerAddress = ":8081"
+	cfg.WorkerSecret = "s3cret"
+	cfg.StreamApplianceTopic = "digest-queue"
+	s := &Service{
+		Queuer:           &stream.DigestQueuer{},
+		Storage:          &storage.S3{},
+		Marker:           &storage.ProgressMarker{},
+		DigesterProvider: newDigester("", nil, 0, 0, nil, nil),
+		Config:           cfg,
+	}
+	router := chi.NewMux()
+	require.Nil(t, s.BindRoutes(router))
+	address, worker := s.Worker()
+	assert.Equal(t, ":8081", address)
+	require.NotNil(t, worker)
+
+	serve := func(handler http.Handler, path string, sign func(*http.Request)) int {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(job))
+		r.Header.Set("Content-Type", "application/json")
+		r = r.WithContext(logevent.NewContext(r.Context(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+		sign(r)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	assert.Equal(t, http.StatusNotFound, serve(router, "/digest-queue/event", bearer("s3cret")), "the worker endpoint should not be served by the API")
+	assert.Equal(t, http.StatusUnauthorized, serve(worker, "/digest-queue/event", func(*http.Request) {}))
+	assert.Equal(t, http.StatusUnauthorized, serve(worker, "/digest-queue/event", bearer("guess")))
+	assert.Equal(t, http.StatusNotFound, serve(worker, "/other-queue/event", bearer("s3cret")))
+	assert.Equal(t, http.StatusNotFound, serve(worker, "/other-queue/event", func(r *http.Request) {
+		require.Nil(t, auth.SignRequest(r, workerSubject, "s3cret", time.Now()))
+	}))
+}
+
+func TestServiceBindRoutesRateLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// no digests exist, and every job is queued
+	s3Mock := NewMockS3API(ctrl)
+	s3Mock.EXPECT().HeadObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, awserr.New("NotFound", "", nil)).AnyTimes()
+	appliance := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer appliance.Close()
+	endpoint, _ := url.Parse(appliance.URL)
+
+	stop := time.Now().Add(-time.Hour).Truncate(time.Hour)
+	start := stop.Add(-2 * time.Hour)
+	cfg := NewConfig()
+	cfg.RateLimit = 3
+	cfg.WindowMax = time.Hour
+	cfg.WindowSplit = true
+	s := &Service{
+		Queuer:           &stream.DigestQueuer{Client: appliance.Client(), Endpoint: endpoint},
+		Storage:          &storage.S3{Client: s3Mock},
+		Marker:           noopMarker{},
+		DigesterProvider: newDigester("", nil, 0, 0, nil, nil),
+		Config:           cfg,
+	}
+	router := chi.NewMux()
+	require.Nil(t, s.BindRoutes(router))
+
+	serve := func(method string, path string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r = r.WithContext(logevent.NewContext(r.Context(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// the window is split into two jobs, each of which is charged
+	job := `{"start":"` + start.Format(time.RFC3339) + `","stop":"` + stop.Format(time.RFC3339) + `"}`
+	assert.Equal(t, http.StatusAccepted, serve(http.MethodPost, v2.Prefix+"/jobs", job).Code)
+	// the limit is shared by both versions of the API, and only one more job may be queued
+	query := "/?start=" + start.Format(time.RFC3339) + "&stop=" + stop.Format(time.RFC3339)
+	w := serve(http.MethodPost, query, "")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"queued"`)
+	assert.Contains(t, w.Body.String(), `"status":"rate_limited"`)
+	w = serve(http.MethodPost, query, "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), `"code":"rate_limited"`)
+	// requests which queue nothing are not charged
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, v2.Prefix+"/jobs", `{"start":"yesterday"}`).Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/openapi.json", "").Code, "other routes should not be limited")
+}
+
+// noopMarker is a Marker which marks nothing
+type noopMarker struct{}
+
+func (noopMarker) Mark(ctx context.Context, key string) error   { return nil }
+func (noopMarker) Unmark(ctx context.Context, key string) error { return nil }
+
+func TestConfigRateLimits(t *testing.T) {
+	cfg := NewConfig()
+	limits, err := cfg.rateLimits()
+	require.Nil(t, err)
+	assert.Nil(t, limits)
+
+	cfg.RateLimit = 10
+	cfg.RateLimitClients = map[string]string{"batch": "60:120", "ops": "0"}
+	limits, err = cfg.rateLimits()
+	require.Nil(t, err)
+	assert.Equal(t, ratelimit.Limit{PerMinute: 10}, limits.Default)
+	assert.Equal(t, map[string]ratelimit.Limit{"batch": {PerMinute: 60, Burst: 120}, "ops": {}}, limits.Clients)
+
+	cfg.RateLimitClients = map[string]string{"batch": "fast"}
+	_, err = cfg.rateLimits()
+	assert.NotNil(t, err)
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	tokensFile := filepath.Join(dir, "tokens.json")
+	require.Nil(t, ioutil.WriteFile(tokensFile, []byte(`[{"subject":"worker","secret":"worker-token","permissions":["digests:produce"]}]`), 0600))
+
+	cfg := NewConfig()
+	assert.False(t, cfg.authEnabled())
+	assert.Empty(t, cfg.validateAuth())
+
+	cfg.AuthTokensFile = tokensFile
+	cfg.AuthHMACKeysFile = tokensFile
+	assert.True(t, cfg.authEnabled())
+	assert.Empty(t, cfg.validateAuth())
+	authenticator, err := newAuthenticator(cfg)
+	require.Nil(t, err)
+	r := httptest.NewRequest(http.MethodPost, "/topic/event", nil)
+	r.Header.Set("Authorization", "Bearer worker-token")
+	principal, err := authenticator.Authenticate(r)
+	require.Nil(t, err)
+	assert.True(t, principal.Has(auth.PermissionProduce))
+
+	cfg.AuthJWKSFile = filepath.Join(dir, "missing.json")
+	assert.Len(t, cfg.validateAuth(), 1)
+	_, err = newAuthenticator(cfg)
+	assert.NotNil(t, err)
+
+	cfg.AuthJWKSFile = ""
+	cfg.AuthJWTIssuer = "https://issuer.example.com"
+	assert.Len(t, cfg.validateAuth(), 1)
+}
+
+func TestCreateS3Client(t *testing.T) {
+	cfg := NewConfig()
+	cfg.UseIAM = aws.Bool(true)
+	client, err := createS3Client(cfg, s3Bucket{Region: "us-west-2"})
+	require.Nil(t, err)
+	assert.Equal(t, "https://s3.us-west-2.amazonaws.com", client.Endpoint)
+	assert.False(t, aws.BoolValue(client.Config.S3ForcePathStyle))
+
+	client, err = createS3Client(cfg, s3Bucket{Region: "us-west-2", Endpoint: "http://localhost:9000", PathStyle: true})
+	require.Nil(t, err)
+	assert.Equal(t, "http://localhost:9000", client.Endpoint)
+	assert.True(t, aws.BoolValue(client.Config.S3ForcePathStyle))
+
+	client, err = createS3Client(cfg, s3Bucket{Region: "us-west-2", InsecureSkipVerify: true})
+	require.Nil(t, err)
+	tr, ok := client.Config.HTTPClient.Transport.(*http.Transport)
+	require.True(t, ok)
+	assert.True(t, tr.TLSClientConfig.InsecureSkipVerify)
+
+	_, err = createS3Client(cfg, s3Bucket{Region: "us-west-2", CAFile: "missing.pem"})
+	assert.NotNil(t, err)
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "tls")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.Nil(t, ioutil.WriteFile(caFile, certificate, 0600))
+	tlsConfig, err := newTLSConfig(caFile, false)
+	require.Nil(t, err)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	resp, err := client.Get(server.URL)
+	require.Nil(t, err)
+	resp.Body.Close()
+
+	invalidFile := filepath.Join(dir, "invalid.pem")
+	require.Nil(t, ioutil.WriteFile(invalidFile, []byte("not a certificate"), 0600))
+	_, err = newTLSConfig(invalidFile, false)
+	assert.NotNil(t, err)
+}
+
+func TestNewKeyProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "key")
+	require.Nil(t, ioutil.WriteFile(keyFile, bytes.Repeat([]byte{1}, 32), 0600))
+
+	cfg := NewConfig()
+	cfg.UseIAM = aws.Bool(true)
+	cfg.StorageBucketRegion = "us-west-2"
+	keys, err := newKeyProvider(cfg)
+	require.Nil(t, err)
+	assert.Nil(t, keys)
+
+	cfg.EncryptionKMSKeyID = "alias/digests"
+	keys, err = newKeyProvider(cfg)
+	require.Nil(t, err)
+	kmsKeys, ok := keys.(*storage.KMSKeyProvider)
+	require.True(t, ok)
+	assert.Equal(t, "alias/digests", kmsKeys.KeyID)
+
+	cfg.EncryptionKMSKeyID = ""
+	cfg.EncryptionKeyFile = keyFile
+	keys, err = newKeyProvider(cfg)
+	require.Nil(t, err)
+	assert.IsType(t, &storage.StaticKeyProvider{}, keys)
+}
+
+func TestServiceInitEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "key")
+	require.Nil(t, ioutil.WriteFile(keyFile, bytes.Repeat([]byte{1}, 32), 0600))
+
+	cfg := NewConfig()
+	cfg.UseIAM = aws.Bool(true)
+	cfg.StorageBucketRegion = "n/a"
+	cfg.ProgressBucketRegion = "n/a"
+	cfg.EncryptionKeyFile = keyFile
+	s := &Service{Queuer: &stream.DigestQueuer{}, DigesterProvider: newDigester("", nil, 0, 0, nil, nil)}
+	require.Nil(t, s.init(cfg))
+	inProgress, ok := s.Storage.(*storage.InProgress)
+	require.True(t, ok)
+	assert.IsType(t, &storage.Encrypted{}, inProgress.Storage)
+}
+
+func TestNewReconciler(t *testing.T) {
+	cfg := NewConfig()
+	cfg.ReconcileInterval = time.Minute
+	s := &Service{Storage: &storage.S3{}}
+	assert.NotNil(t, s.newReconciler(cfg, nil))
+
+	// only the methods of types.Storage are visible through the embedded interface
+	s = &Service{Storage: struct{ types.Storage }{&storage.S3{}}}
+	assert.Nil(t, s.newReconciler(cfg, nil), "the reconciler requires a Storage which can flag digests as stale")
+
+	cfg.ReconcileInterval = 0
+	s = &Service{Storage: &storage.S3{}}
+	assert.Nil(t, s.newReconciler(cfg, nil))
+}
+
+func TestServiceBindRoutesConfig(t *testing.T) {
+	environ := os.Environ()
+	os.Clearenv()
+	defer func() {
+		for _, e := range environ {
+			envPair := strings.Split(e, "=")
+			os.Setenv(envPair[0], envPair[1])
+		}
+	}()
+
+	cfg := NewConfig()
+	cfg.UseIAM = aws.Bool(true)
+	cfg.StorageBucket = "n/a"
+	cfg.StorageBucketRegion = "n/a"
+	cfg.ProgressBucket = "n/a"
+	cfg.ProgressBucketRegion = "n/a"
+	cfg.ProgressTimeout = time.Millisecond
+	cfg.StreamApplianceEndpoint = "n/a"
+	cfg.VPCFlowLogsBucket = "n/a"
+	cfg.VPCFlowLogsBucketRegion = "n/a"
+	cfg.MaxBytesPrefetch = 1
+	cfg.MaxConcurrentPrefetch = 1
+	s := &Service{Config: cfg}
+	require.Nil(t, s.BindRoutes(chi.NewMux()))
+}
+
+func TestRoutesMatchOpenAPI(t *testing.T) {
+	noop := func(http.ResponseWriter, *http.Request) {}
+	allow := newRequire(nil)
+	tc := []struct {
+		Name string
+		Bind func(chi.Router)
+		Spec *openapi.Document
+	}{
+		{
+			Name: "v1",
+			Bind: func(router chi.Router) {
+				bindRoutes(router, &v1.DigesterHandler{}, &v1.Events{}, noop, allow)
+				bindWorkerRoutes(router, &v1.Produce{}, allow)
+			},
+			Spec: v1.OpenAPI(),
+		},
+		{
+			Name: "v2",
+			Bind: func(router chi.Router) {
+				router.Route(v2.Prefix, func(r chi.Router) {
+					bindV2Routes(r, &v2.Handler{}, noop, allow)
+				})
+			},
+			Spec: v2.OpenAPI(),
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			router := chi.NewRouter()
+			tt.Bind(router)
+			var routes []string
+			err := chi.Walk(router, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+				// chi reports the routes of a mounted router beneath its wildcard, as in /v2/*/jobs
+				routes = append(routes, method+" "+strings.Replace(route, "/*/", "/", -1))
+				return nil
+			})
+			require.Nil(t, err)
+
+			var documented []string
+			for path, item := range tt.Spec.Paths {
+				for method := range item.Operations() {
+					documented = append(documented, method+" "+path)
+				}
+			}
+			sort.Strings(routes)
+			sort.Strings(documented)
+			assert.Equal(t, documented, routes, "the routes bound do not match those in the OpenAPI document")
+		})
+	}
+}
+
+func TestOpenAPIFile(t *testing.T) {
+	tc := []struct {
+		File string
+		Spec *openapi.Document
+	}{
+		{File: "openapi.json", Spec: v1.OpenAPI()},
+		{File: "openapi.v2.json", Spec: v2.OpenAPI()},
+	}
+	for _, tt := range tc {
+		t.Run(tt.File, func(t *testing.T) {
+			generated, err := tt.Spec.JSON()
+			require.Nil(t, err)
+			if *updateOpenAPI {
+				require.Nil(t, ioutil.WriteFile("../"+tt.File, generated, 0644))
+			}
+			committed, err := ioutil.ReadFile("../" + tt.File)
+			require.Nil(t, err)
+			assert.Equal(t, string(generated), string(committed), tt.File+" is out of date; run go test ./pkg -run TestOpenAPIFile -update-openapi")
+		})
+	}
+}
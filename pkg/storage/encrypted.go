@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+)
+
+// envelopeMagic begins every encrypted digest, and identifies the version of the envelope format
+var envelopeMagic = []byte("VFDE\x01")
+
+// Encrypted is an implementation of Storage which decorates another Storage, such as S3, encrypting digests
+// before they are stored.
+//
+// Each digest is encrypted with AES-GCM under its own data key, which is obtained from the KeyProvider and stored,
+// encrypted, in an envelope alongside the digest. The digest is gzipped before it is encrypted, and the envelope is
+// stored with the Encrypted flag set in its metadata, so that the decorated Storage stores it as it is, rather than
+// gzipping it again. Get returns digests gzipped, as the decorated Storage does. Digests stored before encryption
+// was enabled, which have no envelope, are streamed from the decorated Storage as they are.
+//
+// Encrypted digests cannot be downloaded directly from the decorated Storage, so Encrypted is not a Presigner.
+type Encrypted struct {
+	Keys types.KeyProvider
+	types.Storage
+}
+
+// Get returns the decrypted digest for the given key, gzipped
+func (s *Encrypted) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	res, err := s.Storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	stored := bufio.NewReader(res)
+	magic, err := stored.Peek(len(envelopeMagic))
+	if err != nil && err != io.EOF {
+		res.Close()
+		return nil, err
+	}
+	if !bytes.Equal(magic, envelopeMagic) {
+		return struct {
+			io.Reader
+			io.Closer
+		}{Reader: stored, Closer: res}, nil
+	}
+	defer res.Close()
+	envelope, err := ioutil.ReadAll(stored)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := s.open(ctx, key, envelope[len(envelopeMagic):])
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(digest)), nil
+}
+
+// Store encrypts the digest under a new data key, and stores it, along with its metadata, in the decorated
+// Storage. It is the caller's responsibility to call Close on the Reader when done.
+func (s *Encrypted) Store(ctx context.Context, key string, data io.ReadCloser, meta types.DigestMetadata) error {
+	compressed := &bytes.Buffer{}
+	gw := gzip.NewWriter(compressed)
+	if _, err := io.Copy(gw, data); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	envelope, err := s.seal(ctx, key, compressed.Bytes())
+	if err != nil {
+		return err
+	}
+	meta.Encrypted = true
+	return s.Storage.Store(ctx, key, ioutil.NopCloser(bytes.NewReader(envelope)), meta)
+}
+
+// Stat returns the metadata for the digest from the decorated Storage. If the decorated Storage cannot read
+// metadata, an error will be returned of type types.ErrUnsupported.
+func (s *Encrypted) Stat(ctx context.Context, key string) (types.DigestMetadata, error) {
+	return stat(ctx, s.Storage, key)
+}
+
+// MarkStale flags the digest as stale in the decorated Storage. If the decorated Storage cannot flag digests as
+// stale, an error will be returned of type types.ErrUnsupported.
+func (s *Encrypted) MarkStale(ctx context.Context, key string) error {
+	return markStale(ctx, s.Storage, key)
+}
+
+// seal encrypts the compressed digest, returning the envelope: the magic, the length of the encrypted data key
+// as a big endian uint16, the encrypted data key, and the sealed digest. The digest is bound to its key, so that
+// it cannot be substituted for another digest.
+func (s *Encrypted) seal(ctx context.Context, key string, compressed []byte) ([]byte, error) {
+	dataKey, encryptedKey, err := s.Keys.GenerateDataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := seal(dataKey, compressed, []byte(key))
+	if err != nil {
+		return nil, err
+	}
+	envelope := make([]byte, 0, len(envelopeMagic)+2+len(encryptedKey)+len(sealed))
+	envelope = append(envelope, envelopeMagic...)
+	envelope = append(envelope, 0, 0)
+	binary.BigEndian.PutUint16(envelope[len(envelopeMagic):], uint16(len(encryptedKey)))
+	envelope = append(envelope, encryptedKey...)
+	return append(envelope, sealed...), nil
+}
+
+// open decrypts the body of an envelope, following the magic, returning the compressed digest
+func (s *Encrypted) open(ctx context.Context, key string, body []byte) ([]byte, error) {
+	if len(body) < 2 {
+		return nil, errors.New("the digest envelope is truncated")
+	}
+	keyLen := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+keyLen {
+		return nil, errors.New("the digest envelope is truncated")
+	}
+	dataKey, err := s.Keys.DecryptDataKey(ctx, body[2:2+keyLen])
+	if err != nil {
+		return nil, err
+	}
+	return open(dataKey, body[2+keyLen:], []byte(key))
+}
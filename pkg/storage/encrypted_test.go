@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storeRaw returns a function which records what is stored as the decorated Storage would for an encrypted digest
+func storeRaw(stored *bytes.Buffer) func(context.Context, string, io.ReadCloser, types.DigestMetadata) error {
+	return func(_ context.Context, _ string, data io.ReadCloser, _ types.DigestMetadata) error {
+		_, err := io.Copy(stored, data)
+		return err
+	}
+}
+
+func gunzip(t *testing.T, r io.Reader) string {
+	gr, err := gzip.NewReader(r)
+	require.Nil(t, err)
+	defer gr.Close()
+	b, err := ioutil.ReadAll(gr)
+	require.Nil(t, err)
+	return string(b)
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	digest := "10.0.0.1 10.0.0.2 443 tcp"
+	stored := &bytes.Buffer{}
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Store(gomock.Any(), key, gomock.Any(), types.DigestMetadata{Records: 1, Encrypted: true}).DoAndReturn(storeRaw(stored))
+
+	s := &Encrypted{
+		Keys:    &StaticKeyProvider{Key: bytes.Repeat([]byte{1}, 32)},
+		Storage: mockStorage,
+	}
+	err := s.Store(context.Background(), key, ioutil.NopCloser(bytes.NewReader([]byte(digest))), types.DigestMetadata{Records: 1})
+	require.Nil(t, err)
+	assert.True(t, bytes.HasPrefix(stored.Bytes(), envelopeMagic))
+	assert.NotContains(t, stored.String(), digest)
+
+	mockStorage.EXPECT().Get(gomock.Any(), key).Return(ioutil.NopCloser(bytes.NewReader(stored.Bytes())), nil)
+	res, err := s.Get(context.Background(), key)
+	require.Nil(t, err)
+	defer res.Close()
+	assert.Equal(t, digest, gunzip(t, res))
+
+	// a digest cannot be served under another key
+	mockStorage.EXPECT().Get(gomock.Any(), "other").Return(ioutil.NopCloser(bytes.NewReader(stored.Bytes())), nil)
+	_, err = s.Get(context.Background(), "other")
+	assert.NotNil(t, err)
+}
+
+func TestEncryptedGetUnencrypted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stored := &bytes.Buffer{}
+	gw := gzip.NewWriter(stored)
+	_, err := gw.Write([]byte("digest"))
+	require.Nil(t, err)
+	require.Nil(t, gw.Close())
+
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Get(gomock.Any(), key).Return(ioutil.NopCloser(bytes.NewReader(stored.Bytes())), nil)
+
+	s := &Encrypted{Keys: NewMockKeyProvider(ctrl), Storage: mockStorage}
+	res, err := s.Get(context.Background(), key)
+	require.Nil(t, err)
+	assert.Equal(t, "digest", gunzip(t, res))
+}
+
+func TestEncryptedGetError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Get(gomock.Any(), key).Return(nil, types.ErrNotFound{ID: key})
+
+	s := &Encrypted{Keys: NewMockKeyProvider(ctrl), Storage: mockStorage}
+	_, err := s.Get(context.Background(), key)
+	assert.Equal(t, types.ErrNotFound{ID: key}, err)
+}
+
+func TestEncryptedDecryptDataKeyError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dataKey := bytes.Repeat([]byte{2}, 32)
+	stored := &bytes.Buffer{}
+	mockKeys := NewMockKeyProvider(ctrl)
+	mockKeys.EXPECT().GenerateDataKey(gomock.Any()).Return(dataKey, []byte("encrypted key"), nil)
+	mockKeys.EXPECT().DecryptDataKey(gomock.Any(), []byte("encrypted key")).Return(nil, errors.New("access denied"))
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Store(gomock.Any(), key, gomock.Any(), gomock.Any()).DoAndReturn(storeRaw(stored))
+	mockStorage.EXPECT().Get(gomock.Any(), key).DoAndReturn(func(context.Context, string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(stored.Bytes())), nil
+	})
+
+	s := &Encrypted{Keys: mockKeys, Storage: mockStorage}
+	require.Nil(t, s.Store(context.Background(), key, ioutil.NopCloser(bytes.NewReader([]byte("digest"))), types.DigestMetadata{}))
+	_, err := s.Get(context.Background(), key)
+	assert.EqualError(t, err, "access denied")
+}
+
+func TestEncryptedStoreGenerateDataKeyError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockKeys := NewMockKeyProvider(ctrl)
+	mockKeys.EXPECT().GenerateDataKey(gomock.Any()).Return(nil, nil, errors.New("throttled"))
+
+	s := &Encrypted{Keys: mockKeys, Storage: NewMockStorage(ctrl)}
+	err := s.Store(context.Background(), key, ioutil.NopCloser(bytes.NewReader([]byte("digest"))), types.DigestMetadata{})
+	assert.EqualError(t, err, "throttled")
+}
@@ -27,16 +27,18 @@ type InProgress struct {
 
 // Get returns the digest for the given key.
 //
-// If the digest is in the process of being created, an error will be returned of type types.ErrInProgress
+// If the digest is in the process of being created, an error will be returned of type types.ErrInProgress.
+// If an existing digest is in the process of being regenerated, the existing digest is returned until it is replaced.
 func (s *InProgress) Get(ctx context.Context, key string) (io.ReadCloser, error) {
 	inProgress, err := s.isInProgress(ctx, key)
 	if err != nil {
 		return nil, err
 	}
-	if inProgress {
+	res, err := s.Storage.Get(ctx, key)
+	if _, ok := err.(types.ErrNotFound); ok && inProgress {
 		return nil, types.ErrInProgress{Key: key}
 	}
-	return s.Storage.Get(ctx, key)
+	return res, err
 }
 
 // Exists returns true if the digest exists, but does not download the digest body.
@@ -53,10 +55,81 @@ func (s *InProgress) Exists(ctx context.Context, key string) (bool, error) {
 	return s.Storage.Exists(ctx, key)
 }
 
-func (s *InProgress) isInProgress(ctx context.Context, key string) (bool, error) {
-	res, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+// Delete removes the digest along with any "in progress" marker for it, so that a subsequent request
+// may regenerate the digest.
+func (s *InProgress) Delete(ctx context.Context, key string) error {
+	if _, err := s.Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s.Bucket),
 		Key:    aws.String(key + inProgressSuffix),
+	}); err != nil {
+		return err
+	}
+	return s.Storage.Delete(ctx, key)
+}
+
+// Presign returns a URL from which the digest for the given key can be downloaded directly.
+//
+// If the digest is in the process of being created, an error will be returned of type types.ErrInProgress.
+// If an existing digest is in the process of being regenerated, a URL for the existing digest is returned.
+// If the decorated Storage cannot presign URLs, an error will be returned of type types.ErrUnsupported.
+func (s *InProgress) Presign(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	presigner, ok := s.Storage.(types.Presigner)
+	if !ok {
+		return "", types.ErrUnsupported{Operation: "presign"}
+	}
+	inProgress, err := s.isInProgress(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	location, err := presigner.Presign(ctx, key, ttl)
+	if _, ok := err.(types.ErrNotFound); ok && inProgress {
+		return "", types.ErrInProgress{Key: key}
+	}
+	return location, err
+}
+
+// Stat returns the metadata for the digest from the decorated Storage.
+//
+// If the decorated Storage cannot read metadata, an error will be returned of type types.ErrUnsupported.
+func (s *InProgress) Stat(ctx context.Context, key string) (types.DigestMetadata, error) {
+	return stat(ctx, s.Storage, key)
+}
+
+// MarkStale flags the digest as stale in the decorated Storage.
+//
+// If the decorated Storage cannot flag digests as stale, an error will be returned of type types.ErrUnsupported.
+func (s *InProgress) MarkStale(ctx context.Context, key string) error {
+	return markStale(ctx, s.Storage, key)
+}
+
+func (s *InProgress) isInProgress(ctx context.Context, key string) (bool, error) {
+	return isMarked(ctx, s.Client, s.Bucket, key, s.Timeout)
+}
+
+// stat returns the metadata for the digest from a decorated Storage, if it can read metadata
+func stat(ctx context.Context, storage types.Storage, key string) (types.DigestMetadata, error) {
+	stater, ok := storage.(types.Stater)
+	if !ok {
+		return types.DigestMetadata{}, types.ErrUnsupported{Operation: "stat"}
+	}
+	return stater.Stat(ctx, key)
+}
+
+// markStale flags the digest as stale in a decorated Storage, if it can flag digests as stale
+func markStale(ctx context.Context, storage types.Storage, key string) error {
+	marker, ok := storage.(types.StaleMarker)
+	if !ok {
+		return types.ErrUnsupported{Operation: "mark stale"}
+	}
+	return marker.MarkStale(ctx, key)
+}
+
+// isMarked reports whether the digest identified by key has an "in progress" marker in the bucket which was
+// set less than timeout ago
+func isMarked(ctx context.Context, client s3iface.S3API, bucket string, key string, timeout time.Duration) (bool, error) {
+	res, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key + inProgressSuffix),
 	})
 	if err != nil && isNotFound(err) {
 		return false, nil
@@ -70,5 +143,5 @@ func (s *InProgress) isInProgress(ctx context.Context, key string) (bool, error)
 	}
 	ts, _ := time.Parse(time.RFC3339Nano, string(b))
 	now := time.Now()
-	return now.Before(ts.Add(s.Timeout)), nil
+	return now.Before(ts.Add(timeout)), nil
 }
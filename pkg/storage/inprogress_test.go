@@ -78,12 +78,15 @@ func TestGetInProgressBeforeTimeout(t *testing.T) {
 	}
 
 	mockClient := NewMockS3API(ctrl)
+	mockStorage := NewMockStorage(ctrl)
 	mockClient.EXPECT().GetObjectWithContext(gomock.Any(), expectedInput).Return(getOutput, nil)
+	mockStorage.EXPECT().Get(gomock.Any(), key).Return(nil, types.ErrNotFound{ID: key})
 
 	ip := &InProgress{
 		Timeout: time.Hour,
 		Bucket:  bucket,
 		Client:  mockClient,
+		Storage: mockStorage,
 	}
 	_, err := ip.Get(context.Background(), key)
 	assert.NotNil(t, err)
@@ -91,6 +94,33 @@ func TestGetInProgressBeforeTimeout(t *testing.T) {
 	assert.True(t, ok)
 }
 
+func TestGetRegenerating(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	getOutput := &s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewBufferString(time.Now().Format(time.RFC3339))),
+	}
+	output := []byte("existing digest")
+
+	mockClient := NewMockS3API(ctrl)
+	mockStorage := NewMockStorage(ctrl)
+	mockClient.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(getOutput, nil)
+	mockStorage.EXPECT().Get(gomock.Any(), key).Return(ioutil.NopCloser(bytes.NewReader(output)), nil)
+
+	ip := &InProgress{
+		Timeout: time.Hour,
+		Bucket:  bucket,
+		Client:  mockClient,
+		Storage: mockStorage,
+	}
+	res, err := ip.Get(context.Background(), key)
+	assert.Nil(t, err)
+	defer res.Close()
+	data, _ := ioutil.ReadAll(res)
+	assert.Equal(t, string(output), string(data))
+}
+
 func TestGetInProgressAfterTimeout(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -221,3 +251,145 @@ func TestExistsInProgressAfterTimeout(t *testing.T) {
 	assert.Nil(t, err)
 	assert.True(t, exists)
 }
+
+func TestStatAndMarkStale(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Stat(gomock.Any(), key).Return(types.DigestMetadata{ID: key}, nil)
+	mockStorage.EXPECT().MarkStale(gomock.Any(), key).Return(nil)
+
+	ip := &InProgress{
+		Bucket:  bucket,
+		Client:  NewMockS3API(ctrl),
+		Storage: mockStorage,
+	}
+	meta, err := ip.Stat(context.Background(), key)
+	assert.Nil(t, err)
+	assert.Equal(t, key, meta.ID)
+	assert.Nil(t, ip.MarkStale(context.Background(), key))
+}
+
+func TestStatAndMarkStaleUnsupported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// only the methods of types.Storage are visible through the embedded interface
+	ip := &InProgress{
+		Bucket:  bucket,
+		Client:  NewMockS3API(ctrl),
+		Storage: struct{ types.Storage }{NewMockStorage(ctrl)},
+	}
+	_, err := ip.Stat(context.Background(), key)
+	_, ok := err.(types.ErrUnsupported)
+	assert.True(t, ok)
+	err = ip.MarkStale(context.Background(), key)
+	_, ok = err.(types.ErrUnsupported)
+	assert.True(t, ok)
+}
+
+type presignStorage struct {
+	*MockStorage
+	Location string
+	Err      error
+}
+
+func (s *presignStorage) Presign(_ context.Context, _ string, _ time.Duration) (string, error) {
+	return s.Location, s.Err
+}
+
+func TestPresignUnsupported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ip := &InProgress{
+		Bucket:  bucket,
+		Client:  NewMockS3API(ctrl),
+		Storage: NewMockStorage(ctrl),
+	}
+	_, err := ip.Presign(context.Background(), key, time.Minute)
+	assert.NotNil(t, err)
+	_, ok := err.(types.ErrUnsupported)
+	assert.True(t, ok)
+}
+
+func TestPresignNotInProgress(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	aErr := awserr.New(s3.ErrCodeNoSuchKey, "", errors.New(""))
+	location := "https://example.com/digest"
+
+	mockClient := NewMockS3API(ctrl)
+	mockClient.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, aErr)
+
+	ip := &InProgress{
+		Bucket:  bucket,
+		Client:  mockClient,
+		Storage: &presignStorage{MockStorage: NewMockStorage(ctrl), Location: location},
+	}
+	res, err := ip.Presign(context.Background(), key, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, location, res)
+}
+
+func TestPresignInProgress(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	getOutput := &s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewBufferString(time.Now().Format(time.RFC3339))),
+	}
+
+	mockClient := NewMockS3API(ctrl)
+	mockClient.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(getOutput, nil)
+
+	ip := &InProgress{
+		Timeout: time.Hour,
+		Bucket:  bucket,
+		Client:  mockClient,
+		Storage: &presignStorage{MockStorage: NewMockStorage(ctrl), Err: types.ErrNotFound{ID: key}},
+	}
+	_, err := ip.Presign(context.Background(), key, time.Minute)
+	assert.NotNil(t, err)
+	_, ok := err.(types.ErrInProgress)
+	assert.True(t, ok)
+}
+
+func TestDeleteClearsMarker(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	expectedInput := &s3.DeleteObjectInput{
+		Key:    aws.String(key + "_in_progress"),
+		Bucket: aws.String(bucket),
+	}
+
+	mockClient := NewMockS3API(ctrl)
+	mockStorage := NewMockStorage(ctrl)
+	mockClient.EXPECT().DeleteObjectWithContext(gomock.Any(), expectedInput).Return(&s3.DeleteObjectOutput{}, nil)
+	mockStorage.EXPECT().Delete(gomock.Any(), key).Return(nil)
+
+	ip := &InProgress{
+		Bucket:  bucket,
+		Client:  mockClient,
+		Storage: mockStorage,
+	}
+	assert.Nil(t, ip.Delete(context.Background(), key))
+}
+
+func TestDeleteMarkerError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := NewMockS3API(ctrl)
+	mockClient.EXPECT().DeleteObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("oops"))
+
+	ip := &InProgress{
+		Bucket:  bucket,
+		Client:  mockClient,
+		Storage: NewMockStorage(ctrl),
+	}
+	assert.NotNil(t, ip.Delete(context.Background(), key))
+}
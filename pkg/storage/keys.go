@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+)
+
+const dataKeySize = 32
+
+// StaticKeyProvider is an implementation of KeyProvider which encrypts data keys with a single AES-256 master
+// key. It is intended for local development and testing, where KMS is not available.
+type StaticKeyProvider struct {
+	Key []byte
+}
+
+// NewStaticKeyProvider loads the master key from a file, which holds either the 32 bytes of the key, or their
+// base64 encoding
+func NewStaticKeyProvider(path string) (*StaticKeyProvider, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == dataKeySize {
+		return &StaticKeyProvider{Key: b}, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != dataKeySize {
+		return nil, fmt.Errorf("%s should hold a %d byte key, or its base64 encoding", path, dataKeySize)
+	}
+	return &StaticKeyProvider{Key: key}, nil
+}
+
+// GenerateDataKey returns a new random data key, and the key sealed with the master key
+func (p *StaticKeyProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	plaintext := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, plaintext); err != nil {
+		return nil, nil, err
+	}
+	encrypted, err := seal(p.Key, plaintext, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plaintext, encrypted, nil
+}
+
+// DecryptDataKey opens a data key sealed with the master key
+func (p *StaticKeyProvider) DecryptDataKey(ctx context.Context, encrypted []byte) ([]byte, error) {
+	return open(p.Key, encrypted, nil)
+}
+
+// KMSKeyProvider is an implementation of KeyProvider which generates data keys with AWS KMS, so that the
+// master key never leaves KMS
+type KMSKeyProvider struct {
+	Client kmsiface.KMSAPI
+	// KeyID is the ID, ARN or alias of the KMS key under which data keys are generated
+	KeyID string
+}
+
+// GenerateDataKey returns a new AES-256 data key generated by KMS
+func (p *KMSKeyProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	res, err := p.Client.GenerateDataKeyWithContext(ctx, &kms.GenerateDataKeyInput{
+		KeyId:   aws.String(p.KeyID),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return res.Plaintext, res.CiphertextBlob, nil
+}
+
+// DecryptDataKey asks KMS to decrypt a data key it generated
+func (p *KMSKeyProvider) DecryptDataKey(ctx context.Context, encrypted []byte) ([]byte, error) {
+	res, err := p.Client.DecryptWithContext(ctx, &kms.DecryptInput{
+		CiphertextBlob: encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Plaintext, nil
+}
+
+// seal encrypts plaintext with AES-GCM under key, returning a random nonce followed by the ciphertext.
+// additionalData is authenticated, but not encrypted.
+func seal(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts the output of seal
+func open(key []byte, sealed []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("the ciphertext is too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStaticKeyProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	key := bytes.Repeat([]byte{7}, 32)
+
+	tc := []struct {
+		Name     string
+		Contents []byte
+		Valid    bool
+	}{
+		{Name: "raw", Contents: key, Valid: true},
+		{Name: "base64", Contents: []byte(base64.StdEncoding.EncodeToString(key) + "\n"), Valid: true},
+		{Name: "short", Contents: []byte(base64.StdEncoding.EncodeToString(key[:16])), Valid: false},
+		{Name: "garbage", Contents: []byte("not a key"), Valid: false},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.Name)
+			require.Nil(t, ioutil.WriteFile(path, tt.Contents, 0600))
+			provider, err := NewStaticKeyProvider(path)
+			if !tt.Valid {
+				assert.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, key, provider.Key)
+		})
+	}
+
+	_, err = NewStaticKeyProvider(filepath.Join(dir, "missing"))
+	assert.NotNil(t, err)
+}
+
+func TestStaticKeyProvider(t *testing.T) {
+	provider := &StaticKeyProvider{Key: bytes.Repeat([]byte{7}, 32)}
+	plaintext, encrypted, err := provider.GenerateDataKey(context.Background())
+	require.Nil(t, err)
+	assert.Len(t, plaintext, 32)
+	assert.NotContains(t, string(encrypted), string(plaintext))
+
+	decrypted, err := provider.DecryptDataKey(context.Background(), encrypted)
+	require.Nil(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	other := &StaticKeyProvider{Key: bytes.Repeat([]byte{8}, 32)}
+	_, err = other.DecryptDataKey(context.Background(), encrypted)
+	assert.NotNil(t, err)
+	_, err = provider.DecryptDataKey(context.Background(), []byte("short"))
+	assert.NotNil(t, err)
+}
+
+// fakeKMS implements the data key operations of KMS, wrapping keys with a StaticKeyProvider
+type fakeKMS struct {
+	kmsiface.KMSAPI
+	keys  *StaticKeyProvider
+	keyID string
+}
+
+func (f *fakeKMS) GenerateDataKeyWithContext(ctx aws.Context, input *kms.GenerateDataKeyInput, _ ...request.Option) (*kms.GenerateDataKeyOutput, error) {
+	f.keyID = aws.StringValue(input.KeyId)
+	plaintext, encrypted, err := f.keys.GenerateDataKey(ctx)
+	return &kms.GenerateDataKeyOutput{Plaintext: plaintext, CiphertextBlob: encrypted}, err
+}
+
+func (f *fakeKMS) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, _ ...request.Option) (*kms.DecryptOutput, error) {
+	plaintext, err := f.keys.DecryptDataKey(ctx, input.CiphertextBlob)
+	return &kms.DecryptOutput{Plaintext: plaintext}, err
+}
+
+func TestKMSKeyProvider(t *testing.T) {
+	client := &fakeKMS{keys: &StaticKeyProvider{Key: bytes.Repeat([]byte{7}, 32)}}
+	provider := &KMSKeyProvider{Client: client, KeyID: "alias/digests"}
+	plaintext, encrypted, err := provider.GenerateDataKey(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, "alias/digests", client.keyID)
+
+	decrypted, err := provider.DecryptDataKey(context.Background(), encrypted)
+	require.Nil(t, err)
+	assert.Equal(t, plaintext, decrypted)
+}
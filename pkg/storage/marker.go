@@ -3,6 +3,8 @@ package storage
 import (
 	"bytes"
 	"context"
+	"math"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,13 +15,27 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
 )
 
+const (
+	defaultPollInterval    = 250 * time.Millisecond
+	defaultMaxPollInterval = 5 * time.Second
+)
+
 // ProgressMarker is an implementation of Marker which allows for marking/unmarking of digests in progress
 type ProgressMarker struct {
-	Bucket   string
-	Client   s3iface.S3API
-	uploader s3manageriface.UploaderAPI
-	lock     sync.Mutex
-	now      func() time.Time
+	Bucket string
+	Client s3iface.S3API
+	// Timeout is the time after which a marker is considered invalid, as for the InProgress Storage. Zero
+	// means markers are never considered invalid when waiting.
+	Timeout time.Duration
+	// Options are applied to every marker written to the bucket
+	Options ObjectOptions
+	// PollInterval and MaxPollInterval bound the backoff between checks of the marker while waiting for it to
+	// be removed. They default to 250ms and 5s.
+	PollInterval    time.Duration
+	MaxPollInterval time.Duration
+	uploader        s3manageriface.UploaderAPI
+	lock            sync.Mutex
+	now             func() time.Time
 }
 
 // Mark flags the digest identified by key as being "in progress"
@@ -29,11 +45,13 @@ func (m *ProgressMarker) Mark(ctx context.Context, key string) error {
 	if now == nil {
 		now = time.Now
 	}
-	_, err := m.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+	input := &s3manager.UploadInput{
 		Bucket: aws.String(m.Bucket),
 		Key:    aws.String(key + inProgressSuffix),
 		Body:   bytes.NewReader([]byte(now().Format(time.RFC3339Nano))),
-	})
+	}
+	m.Options.applyUpload(input)
+	_, err := m.uploader.UploadWithContext(ctx, input)
 	return err
 }
 
@@ -46,6 +64,75 @@ func (m *ProgressMarker) Unmark(ctx context.Context, key string) error {
 	return err
 }
 
+// WaitUnmarked blocks until the digest identified by key is not "in progress". S3 offers no notification of
+// changes to the marker, so it is polled, doubling the interval between checks up to MaxPollInterval.
+func (m *ProgressMarker) WaitUnmarked(ctx context.Context, key string) error {
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = time.Duration(math.MaxInt64)
+	}
+	interval := m.PollInterval
+	if interval == 0 {
+		interval = defaultPollInterval
+	}
+	maxInterval := m.MaxPollInterval
+	if maxInterval == 0 {
+		maxInterval = defaultMaxPollInterval
+	}
+	for {
+		marked, err := isMarked(ctx, m.Client, m.Bucket, key, timeout)
+		if err != nil {
+			return err
+		}
+		if !marked {
+			return nil
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		interval *= 2
+		if interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
+
+// CountMarked returns the number of digests which are "in progress", by listing the markers in the bucket. Markers
+// older than the Timeout are not counted. Every object in the bucket is listed, a thousand per request, so the cost
+// of each call grows with the number of markers, including expired ones which have not been deleted. Callers
+// should reuse the count, as ratelimit.InProgressCap does.
+func (m *ProgressMarker) CountMarked(ctx context.Context) (int, error) {
+	now := m.now
+	if now == nil {
+		now = time.Now
+	}
+	cutoff := time.Time{}
+	if m.Timeout > 0 {
+		cutoff = now().Add(-m.Timeout)
+	}
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(m.Bucket)}
+	count := 0
+	for {
+		res, err := m.Client.ListObjectsV2WithContext(ctx, input)
+		if err != nil {
+			return 0, err
+		}
+		for _, object := range res.Contents {
+			if strings.HasSuffix(aws.StringValue(object.Key), inProgressSuffix) && aws.TimeValue(object.LastModified).After(cutoff) {
+				count++
+			}
+		}
+		if !aws.BoolValue(res.IsTruncated) {
+			return count, nil
+		}
+		input.ContinuationToken = res.NextContinuationToken
+	}
+}
+
 func (m *ProgressMarker) initUploader() {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"io/ioutil"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/golang/mock/gomock"
@@ -38,6 +40,34 @@ func TestMarkInProgress(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestMarkInProgressWithOptions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	date := time.Date(1999, time.January, 1, 1, 0, 0, 0, time.UTC)
+	expectedInput := &s3manager.UploadInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key + "_in_progress"),
+		Body:                 bytes.NewReader([]byte(date.Format(time.RFC3339Nano))),
+		ServerSideEncryption: aws.String("aws:kms"),
+		SSEKMSKeyId:          aws.String("alias/progress"),
+		Tagging:              aws.String("team=security"),
+	}
+
+	mockUploader := NewMockUploaderAPI(ctrl)
+	mockUploader.EXPECT().UploadWithContext(gomock.Any(), expectedInput).Return(nil, nil)
+
+	m := &ProgressMarker{
+		Bucket:   bucket,
+		Options:  ObjectOptions{KMSKeyID: "alias/progress", Tags: map[string]string{"team": "security"}},
+		uploader: mockUploader,
+		now:      func() time.Time { return date },
+	}
+
+	err := m.Mark(context.Background(), key)
+	assert.Nil(t, err)
+}
+
 func TestMarkInProgressError(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -103,3 +133,145 @@ func TestUnmarkInProgressError(t *testing.T) {
 	err := m.Unmark(context.Background(), key)
 	assert.NotNil(t, err)
 }
+
+func TestWaitUnmarked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	expectedInput := &s3.GetObjectInput{
+		Key:    aws.String(key + "_in_progress"),
+		Bucket: aws.String(bucket),
+	}
+	marked := func() *s3.GetObjectOutput {
+		return &s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewBufferString(time.Now().Format(time.RFC3339Nano))),
+		}
+	}
+	aErr := awserr.New(s3.ErrCodeNoSuchKey, "", errors.New(""))
+
+	mockClient := NewMockS3API(ctrl)
+	gomock.InOrder(
+		mockClient.EXPECT().GetObjectWithContext(gomock.Any(), expectedInput).Return(marked(), nil),
+		mockClient.EXPECT().GetObjectWithContext(gomock.Any(), expectedInput).Return(marked(), nil),
+		mockClient.EXPECT().GetObjectWithContext(gomock.Any(), expectedInput).Return(nil, aErr),
+	)
+
+	m := &ProgressMarker{
+		Bucket:       bucket,
+		Client:       mockClient,
+		Timeout:      time.Hour,
+		PollInterval: time.Millisecond,
+	}
+	err := m.WaitUnmarked(context.Background(), key)
+	assert.Nil(t, err)
+}
+
+func TestWaitUnmarkedExpiredMarker(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	output := &s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewBufferString(time.Now().Add(-2 * time.Hour).Format(time.RFC3339Nano))),
+	}
+	mockClient := NewMockS3API(ctrl)
+	mockClient.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(output, nil)
+
+	m := &ProgressMarker{
+		Bucket:  bucket,
+		Client:  mockClient,
+		Timeout: time.Hour,
+	}
+	err := m.WaitUnmarked(context.Background(), key)
+	assert.Nil(t, err)
+}
+
+func TestWaitUnmarkedCancelled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := NewMockS3API(ctrl)
+	mockClient.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(context.Context, *s3.GetObjectInput, ...interface{}) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{
+				Body: ioutil.NopCloser(bytes.NewBufferString(time.Now().Format(time.RFC3339Nano))),
+			}, nil
+		},
+	).AnyTimes()
+
+	m := &ProgressMarker{
+		Bucket:       bucket,
+		Client:       mockClient,
+		Timeout:      time.Hour,
+		PollInterval: time.Millisecond,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := m.WaitUnmarked(ctx, key)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestWaitUnmarkedError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := NewMockS3API(ctrl)
+	mockClient.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("oops"))
+
+	m := &ProgressMarker{
+		Bucket: bucket,
+		Client: mockClient,
+	}
+	err := m.WaitUnmarked(context.Background(), key)
+	assert.NotNil(t, err)
+}
+
+func TestCountMarked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	date := time.Date(1999, time.January, 1, 1, 0, 0, 0, time.UTC)
+	mockS3 := NewMockS3API(ctrl)
+	gomock.InOrder(
+		mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), &s3.ListObjectsV2Input{
+			Bucket: aws.String(bucket),
+		}).Return(&s3.ListObjectsV2Output{
+			Contents: []*s3.Object{
+				{Key: aws.String("a_in_progress"), LastModified: aws.Time(date.Add(-time.Minute))},
+				{Key: aws.String("b_in_progress"), LastModified: aws.Time(date.Add(-time.Hour))},
+				{Key: aws.String("c"), LastModified: aws.Time(date)},
+			},
+			IsTruncated:           aws.Bool(true),
+			NextContinuationToken: aws.String("token"),
+		}, nil),
+		mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), &s3.ListObjectsV2Input{
+			Bucket:            aws.String(bucket),
+			ContinuationToken: aws.String("token"),
+		}).Return(&s3.ListObjectsV2Output{
+			Contents: []*s3.Object{
+				{Key: aws.String("d_in_progress"), LastModified: aws.Time(date)},
+			},
+		}, nil),
+	)
+
+	m := &ProgressMarker{
+		Bucket:  bucket,
+		Client:  mockS3,
+		Timeout: 10 * time.Minute,
+		now:     func() time.Time { return date },
+	}
+	count, err := m.CountMarked(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count, "expired markers and other objects should not be counted")
+}
+
+func TestCountMarkedError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("oops"))
+
+	m := &ProgressMarker{Bucket: bucket, Client: mockS3}
+	_, err := m.CountMarked(context.Background())
+	assert.NotNil(t, err)
+}
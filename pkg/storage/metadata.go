@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// S3 user metadata keys under which digest metadata is stored
+const (
+	metaStart         = "start"
+	metaStop          = "stop"
+	metaScope         = "scope"
+	metaCreatedAt     = "created-at"
+	metaSize          = "size"
+	metaRecords       = "records"
+	metaSourceObjects = "source-objects"
+	metaSourceLastMod = "source-last-modified"
+	metaStale         = "stale"
+	metaEncrypted     = "encrypted"
+)
+
+// encodeMetadata converts digest metadata to S3 user metadata. The ID is omitted since it is
+// already encoded in the object key.
+func encodeMetadata(meta types.DigestMetadata) map[string]*string {
+	return map[string]*string{
+		metaStart:         aws.String(meta.Start.UTC().Format(time.RFC3339Nano)),
+		metaStop:          aws.String(meta.Stop.UTC().Format(time.RFC3339Nano)),
+		metaScope:         aws.String(meta.Scope),
+		metaCreatedAt:     aws.String(meta.CreatedAt.UTC().Format(time.RFC3339Nano)),
+		metaSize:          aws.String(strconv.FormatInt(meta.Size, 10)),
+		metaRecords:       aws.String(strconv.FormatInt(meta.Records, 10)),
+		metaSourceObjects: aws.String(strconv.FormatInt(meta.SourceObjects, 10)),
+		metaSourceLastMod: aws.String(meta.SourceLastModified.UTC().Format(time.RFC3339Nano)),
+		metaStale:         aws.String(strconv.FormatBool(meta.Stale)),
+		metaEncrypted:     aws.String(strconv.FormatBool(meta.Encrypted)),
+	}
+}
+
+// decodeMetadata converts S3 user metadata to digest metadata. Values which are missing or
+// malformed, as is the case for digests stored before metadata was recorded, are left as zero values.
+func decodeMetadata(key string, m map[string]*string) types.DigestMetadata {
+	// S3 returns user metadata keys in canonical header form, e.g. "Created-At", so normalize them
+	normalized := make(map[string]string, len(m))
+	for k, v := range m {
+		normalized[strings.ToLower(k)] = aws.StringValue(v)
+	}
+	meta := types.DigestMetadata{
+		ID:    key,
+		Scope: normalized[metaScope],
+	}
+	meta.Start, _ = time.Parse(time.RFC3339Nano, normalized[metaStart])
+	meta.Stop, _ = time.Parse(time.RFC3339Nano, normalized[metaStop])
+	meta.CreatedAt, _ = time.Parse(time.RFC3339Nano, normalized[metaCreatedAt])
+	meta.Size, _ = strconv.ParseInt(normalized[metaSize], 10, 64)
+	meta.Records, _ = strconv.ParseInt(normalized[metaRecords], 10, 64)
+	meta.SourceObjects, _ = strconv.ParseInt(normalized[metaSourceObjects], 10, 64)
+	meta.SourceLastModified, _ = time.Parse(time.RFC3339Nano, normalized[metaSourceLastMod])
+	meta.Stale, _ = strconv.ParseBool(normalized[metaStale])
+	meta.Encrypted, _ = strconv.ParseBool(normalized[metaEncrypted])
+	return meta
+}
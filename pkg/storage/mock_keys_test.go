@@ -0,0 +1,53 @@
+// Automatically generated by MockGen. DO NOT EDIT!
+// Source: ./pkg/types/keys.go
+
+package storage
+
+import (
+	context "context"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// Mock of KeyProvider interface
+type MockKeyProvider struct {
+	ctrl     *gomock.Controller
+	recorder *_MockKeyProviderRecorder
+}
+
+// Recorder for MockKeyProvider (not exported)
+type _MockKeyProviderRecorder struct {
+	mock *MockKeyProvider
+}
+
+func NewMockKeyProvider(ctrl *gomock.Controller) *MockKeyProvider {
+	mock := &MockKeyProvider{ctrl: ctrl}
+	mock.recorder = &_MockKeyProviderRecorder{mock}
+	return mock
+}
+
+func (_m *MockKeyProvider) EXPECT() *_MockKeyProviderRecorder {
+	return _m.recorder
+}
+
+func (_m *MockKeyProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	ret := _m.ctrl.Call(_m, "GenerateDataKey", ctx)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].([]byte)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+func (_mr *_MockKeyProviderRecorder) GenerateDataKey(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GenerateDataKey", arg0)
+}
+
+func (_m *MockKeyProvider) DecryptDataKey(ctx context.Context, encrypted []byte) ([]byte, error) {
+	ret := _m.ctrl.Call(_m, "DecryptDataKey", ctx, encrypted)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockKeyProviderRecorder) DecryptDataKey(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DecryptDataKey", arg0, arg1)
+}
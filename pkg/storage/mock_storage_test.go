@@ -5,6 +5,7 @@ package storage
 
 import (
 	context "context"
+	types "github.com/asecurityteam/vpcflow-digesterd/pkg/types"
 	gomock "github.com/golang/mock/gomock"
 	io "io"
 )
@@ -52,12 +53,54 @@ func (_mr *_MockStorageRecorder) Exists(arg0, arg1 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Exists", arg0, arg1)
 }
 
-func (_m *MockStorage) Store(ctx context.Context, key string, data io.ReadCloser) error {
-	ret := _m.ctrl.Call(_m, "Store", ctx, key, data)
+func (_m *MockStorage) Store(ctx context.Context, key string, data io.ReadCloser, meta types.DigestMetadata) error {
+	ret := _m.ctrl.Call(_m, "Store", ctx, key, data, meta)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-func (_mr *_MockStorageRecorder) Store(arg0, arg1, arg2 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "Store", arg0, arg1, arg2)
+func (_mr *_MockStorageRecorder) Store(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Store", arg0, arg1, arg2, arg3)
+}
+
+func (_m *MockStorage) Delete(ctx context.Context, key string) error {
+	ret := _m.ctrl.Call(_m, "Delete", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockStorageRecorder) Delete(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Delete", arg0, arg1)
+}
+
+func (_m *MockStorage) List(ctx context.Context, options types.ListOptions) (types.DigestList, error) {
+	ret := _m.ctrl.Call(_m, "List", ctx, options)
+	ret0, _ := ret[0].(types.DigestList)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockStorageRecorder) List(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "List", arg0, arg1)
+}
+
+func (_m *MockStorage) Stat(ctx context.Context, key string) (types.DigestMetadata, error) {
+	ret := _m.ctrl.Call(_m, "Stat", ctx, key)
+	ret0, _ := ret[0].(types.DigestMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockStorageRecorder) Stat(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Stat", arg0, arg1)
+}
+
+func (_m *MockStorage) MarkStale(ctx context.Context, key string) error {
+	ret := _m.ctrl.Call(_m, "MarkStale", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockStorageRecorder) MarkStale(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "MarkStale", arg0, arg1)
 }
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// ObjectOptions are applied to every object written to a bucket, including the copies made when a digest is
+// archived or marked as stale, as S3 does not carry the encryption or storage class of an object over to its copies
+type ObjectOptions struct {
+	// ServerSideEncryption is the algorithm used to encrypt objects at rest, either "AES256" or "aws:kms". If empty,
+	// the default encryption of the bucket applies, unless KMSKeyID is set, in which case "aws:kms" is used.
+	ServerSideEncryption string
+	// KMSKeyID is the ID or ARN of the KMS key used to encrypt objects
+	KMSKeyID string
+	// StorageClass is the S3 storage class of objects, such as "STANDARD_IA". If empty, objects are stored in the
+	// standard class.
+	StorageClass string
+	// Tags is the tag set of every object, such as cost allocation tags
+	Tags map[string]string
+}
+
+// serverSideEncryption returns the algorithm used to encrypt objects, if any
+func (o ObjectOptions) serverSideEncryption() string {
+	if o.ServerSideEncryption == "" && o.KMSKeyID != "" {
+		return s3.ServerSideEncryptionAwsKms
+	}
+	return o.ServerSideEncryption
+}
+
+// tagging returns the tag set encoded as URL query parameters, as S3 expects
+func (o ObjectOptions) tagging() string {
+	values := url.Values{}
+	for k, v := range o.Tags {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+func (o ObjectOptions) applyUpload(input *s3manager.UploadInput) {
+	if sse := o.serverSideEncryption(); sse != "" {
+		input.ServerSideEncryption = aws.String(sse)
+	}
+	if o.KMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(o.KMSKeyID)
+	}
+	if o.StorageClass != "" {
+		input.StorageClass = aws.String(o.StorageClass)
+	}
+	if len(o.Tags) > 0 {
+		input.Tagging = aws.String(o.tagging())
+	}
+}
+
+func (o ObjectOptions) applyCopy(input *s3.CopyObjectInput) {
+	if sse := o.serverSideEncryption(); sse != "" {
+		input.ServerSideEncryption = aws.String(sse)
+	}
+	if o.KMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(o.KMSKeyID)
+	}
+	if o.StorageClass != "" {
+		input.StorageClass = aws.String(o.StorageClass)
+	}
+	if len(o.Tags) > 0 {
+		input.Tagging = aws.String(o.tagging())
+		input.TaggingDirective = aws.String(s3.TaggingDirectiveReplace)
+	}
+}
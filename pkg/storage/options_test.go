@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObjectOptionsApplyUpload(t *testing.T) {
+	tc := []struct {
+		Name     string
+		Options  ObjectOptions
+		Expected s3manager.UploadInput
+	}{
+		{
+			Name: "none",
+		},
+		{
+			Name:    "AES256",
+			Options: ObjectOptions{ServerSideEncryption: "AES256", StorageClass: "STANDARD_IA"},
+			Expected: s3manager.UploadInput{
+				ServerSideEncryption: aws.String("AES256"),
+				StorageClass:         aws.String("STANDARD_IA"),
+			},
+		},
+		{
+			Name:    "KMS key",
+			Options: ObjectOptions{KMSKeyID: "alias/digests", Tags: map[string]string{"team": "security", "cost center": "42"}},
+			Expected: s3manager.UploadInput{
+				ServerSideEncryption: aws.String("aws:kms"),
+				SSEKMSKeyId:          aws.String("alias/digests"),
+				Tagging:              aws.String("cost+center=42&team=security"),
+			},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			var input s3manager.UploadInput
+			tt.Options.applyUpload(&input)
+			assert.Equal(t, tt.Expected, input)
+		})
+	}
+}
+
+func TestObjectOptionsApplyCopy(t *testing.T) {
+	var input s3.CopyObjectInput
+	ObjectOptions{}.applyCopy(&input)
+	assert.Equal(t, s3.CopyObjectInput{}, input)
+
+	options := ObjectOptions{
+		ServerSideEncryption: "aws:kms",
+		KMSKeyID:             "alias/digests",
+		StorageClass:         "GLACIER",
+		Tags:                 map[string]string{"team": "security"},
+	}
+	options.applyCopy(&input)
+	assert.Equal(t, s3.CopyObjectInput{
+		ServerSideEncryption: aws.String("aws:kms"),
+		SSEKMSKeyId:          aws.String("alias/digests"),
+		StorageClass:         aws.String("GLACIER"),
+		Tagging:              aws.String("team=security"),
+		TaggingDirective:     aws.String("REPLACE"),
+	}, input)
+}
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"fmt"
 	"io"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
 	"github.com/aws/aws-sdk-go/aws"
@@ -16,17 +19,29 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
 )
 
-const keySuffix = ".log.gz"
+const (
+	keySuffix = ".log.gz"
+	// versionsPrefix is the prefix under which digests are preserved when they are replaced
+	versionsPrefix = "versions/"
+	versionFormat  = "20060102T150405.000000000Z"
+	// defaultListLimit is the size of a page of digests if the caller does not give one
+	defaultListLimit = 1000
+	// listConcurrency is the number of HEAD requests made at once to fetch the metadata of a page of digests
+	listConcurrency = 8
+)
 
 // S3 implements the Storage interface and uses S3 as the backing store for digests
 type S3 struct {
-	Bucket   string
-	Client   s3iface.S3API
+	Bucket string
+	Client s3iface.S3API
+	// Options are applied to every digest written to the bucket
+	Options  ObjectOptions
 	uploader s3manageriface.UploaderAPI
 	lock     sync.Mutex
 }
 
-// Get returns the digest for the given key. The digest is returned as a gzipped payload.
+// Get returns the digest for the given key. The digest is returned as it was stored: gzipped, or as an
+// encrypted envelope if its metadata marked it as encrypted.
 // It is the caller's responsibility to call Close on the Reader when done.
 func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
 	input := &s3.GetObjectInput{
@@ -56,27 +71,266 @@ func (s *S3) Exists(ctx context.Context, key string) (bool, error) {
 	return false, err
 }
 
-// Store stores the digest. It is the caller's responsibility to call Close on the Reader when done.
-func (s *S3) Store(ctx context.Context, key string, data io.ReadCloser) error {
-	// gzip the digest
+// Presign returns a URL from which the digest for the given key can be downloaded directly from S3
+// until ttl elapses. If the digest does not exist, an error of type types.ErrNotFound is returned.
+func (s *S3) Presign(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	if _, err := s.Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key + keySuffix),
+	}); err != nil {
+		return "", parseNotFound(err, key)
+	}
+	req, _ := s.Client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key + keySuffix),
+	})
+	return req.Presign(ttl)
+}
+
+// Store stores the digest, along with its metadata. The digest is gzipped unless its metadata marks it as
+// encrypted, in which case it is stored as it is. The Size recorded in the metadata is that of the stored object.
+// If a digest already exists for the key, it is preserved as a version before being replaced. It is the
+// caller's responsibility to call Close on the Reader when done.
+func (s *S3) Store(ctx context.Context, key string, data io.ReadCloser, meta types.DigestMetadata) error {
 	buff := &bytes.Buffer{}
-	gw := gzip.NewWriter(buff)
-	if _, err := io.Copy(gw, data); err != nil {
+	if meta.Encrypted {
+		if _, err := io.Copy(buff, data); err != nil {
+			return err
+		}
+	} else {
+		gw := gzip.NewWriter(buff)
+		if _, err := io.Copy(gw, data); err != nil {
+			return err
+		}
+		gw.Close()
+	}
+	meta.Size = int64(buff.Len())
+
+	if err := s.archive(ctx, key); err != nil {
 		return err
 	}
-	gw.Close()
 
 	// lazily initialize uploader with the s3 client
 	s.initUploader()
 
-	_, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+	input := &s3manager.UploadInput{
+		Bucket:   aws.String(s.Bucket),
+		Key:      aws.String(key + keySuffix),
+		Body:     buff,
+		Metadata: encodeMetadata(meta),
+	}
+	s.Options.applyUpload(input)
+	_, err := s.uploader.UploadWithContext(ctx, input)
+	return err
+}
+
+// Delete removes the digest, along with any versions preserved when it was replaced. Deleting a digest
+// which does not exist is not an error.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	_, err := s.Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s.Bucket),
 		Key:    aws.String(key + keySuffix),
-		Body:   buff,
 	})
+	if err != nil {
+		return err
+	}
+	return s.deleteVersions(ctx, key)
+}
+
+// deleteVersions removes the versions preserved for the digest, a page of them at a time
+func (s *S3) deleteVersions(ctx context.Context, key string) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(versionsPrefix + key + "/"),
+	}
+	for {
+		res, err := s.Client.ListObjectsV2WithContext(ctx, input)
+		if err != nil {
+			return err
+		}
+		if len(res.Contents) > 0 {
+			objects := make([]*s3.ObjectIdentifier, 0, len(res.Contents))
+			for _, object := range res.Contents {
+				objects = append(objects, &s3.ObjectIdentifier{Key: object.Key})
+			}
+			out, err := s.Client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+				Bucket: aws.String(s.Bucket),
+				Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+			})
+			if err != nil {
+				return err
+			}
+			if len(out.Errors) > 0 {
+				return fmt.Errorf("could not delete %s: %s", aws.StringValue(out.Errors[0].Key), aws.StringValue(out.Errors[0].Message))
+			}
+		}
+		if !aws.BoolValue(res.IsTruncated) {
+			return nil
+		}
+		input.ContinuationToken = res.NextContinuationToken
+	}
+}
+
+// List returns a page of metadata for stored digests. Pages of objects are fetched from the bucket until the
+// page of digests is full, or there are no more objects, so a page is only short if it is the last. Each digest
+// requires an additional HEAD request in order to retrieve its metadata, of which up to listConcurrency are made
+// at once.
+func (s *S3) List(ctx context.Context, options types.ListOptions) (types.DigestList, error) {
+	limit := options.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		// exclude replaced versions, which are nested under versionsPrefix
+		Delimiter: aws.String("/"),
+	}
+	if options.Token != "" {
+		input.ContinuationToken = aws.String(options.Token)
+	}
+	list := types.DigestList{
+		Digests: []types.DigestMetadata{},
+	}
+	for {
+		// never fetch more objects than there is room for, so that the continuation token of the last page
+		// fetched is the start of the next page of digests
+		input.MaxKeys = aws.Int64(int64(limit - len(list.Digests)))
+		res, err := s.Client.ListObjectsV2WithContext(ctx, input)
+		if err != nil {
+			return types.DigestList{}, err
+		}
+		digests, err := s.statAll(ctx, res.Contents)
+		if err != nil {
+			return types.DigestList{}, err
+		}
+		for _, meta := range digests {
+			if overlaps(meta, options.Start, options.Stop) {
+				list.Digests = append(list.Digests, meta)
+			}
+		}
+		if !aws.BoolValue(res.IsTruncated) {
+			return list, nil
+		}
+		if len(list.Digests) >= limit {
+			list.NextToken = aws.StringValue(res.NextContinuationToken)
+			return list, nil
+		}
+		input.ContinuationToken = res.NextContinuationToken
+	}
+}
+
+// statAll returns the metadata of each digest among the listed objects, in the order they were listed. Objects
+// which are not digests, or which were deleted after they were listed, are skipped.
+func (s *S3) statAll(ctx context.Context, objects []*s3.Object) ([]types.DigestMetadata, error) {
+	var (
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, listConcurrency)
+		digests = make([]types.DigestMetadata, len(objects))
+		found   = make([]bool, len(objects))
+		errs    = make([]error, len(objects))
+	)
+	for i, object := range objects {
+		objectKey := aws.StringValue(object.Key)
+		if !strings.HasSuffix(objectKey, keySuffix) {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, key string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			meta, err := s.Stat(ctx, key)
+			if _, ok := err.(types.ErrNotFound); ok {
+				// the digest was deleted between listing and fetching its metadata
+				return
+			}
+			digests[i], found[i], errs[i] = meta, err == nil, err
+		}(i, strings.TrimSuffix(objectKey, keySuffix))
+	}
+	wg.Wait()
+	result := make([]types.DigestMetadata, 0, len(objects))
+	for i := range objects {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if found[i] {
+			result = append(result, digests[i])
+		}
+	}
+	return result, nil
+}
+
+// MarkStale flags the stored digest as having been created from source data which has since changed.
+// S3 metadata cannot be modified in place, so the digest is copied onto itself with the updated metadata.
+func (s *S3) MarkStale(ctx context.Context, key string) error {
+	meta, err := s.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+	meta.Stale = true
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(s.Bucket),
+		CopySource:        aws.String(s.Bucket + "/" + key + keySuffix),
+		Key:               aws.String(key + keySuffix),
+		Metadata:          encodeMetadata(meta),
+		MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+	}
+	s.Options.applyCopy(input)
+	_, err = s.Client.CopyObjectWithContext(ctx, input)
 	return err
 }
 
+// archive copies an existing digest for key to a versioned key, named for when the digest was created,
+// so that it is not lost when the digest is regenerated. It is a no-op if the digest does not exist.
+func (s *S3) archive(ctx context.Context, key string) error {
+	meta, err := s.Stat(ctx, key)
+	if _, ok := err.(types.ErrNotFound); ok {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(s.Bucket),
+		CopySource: aws.String(s.Bucket + "/" + key + keySuffix),
+		Key:        aws.String(versionsPrefix + key + "/" + meta.CreatedAt.UTC().Format(versionFormat) + keySuffix),
+	}
+	s.Options.applyCopy(input)
+	_, err = s.Client.CopyObjectWithContext(ctx, input)
+	return err
+}
+
+// Stat returns the metadata for the digest identified by key, but does not download the digest body.
+func (s *S3) Stat(ctx context.Context, key string) (types.DigestMetadata, error) {
+	res, err := s.Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key + keySuffix),
+	})
+	if err != nil {
+		return types.DigestMetadata{}, parseNotFound(err, key)
+	}
+	meta := decodeMetadata(key, res.Metadata)
+	if meta.Size == 0 {
+		meta.Size = aws.Int64Value(res.ContentLength)
+	}
+	if meta.CreatedAt.IsZero() {
+		meta.CreatedAt = aws.TimeValue(res.LastModified)
+	}
+	return meta, nil
+}
+
+// overlaps reports whether the digest's window overlaps with [start, stop). A zero start or stop
+// leaves that side of the range unbounded.
+func overlaps(meta types.DigestMetadata, start, stop time.Time) bool {
+	if !start.IsZero() && !meta.Stop.After(start) {
+		return false
+	}
+	if !stop.IsZero() && !meta.Start.Before(stop) {
+		return false
+	}
+	return true
+}
+
 func (s *S3) initUploader() {
 	s.lock.Lock()
 	defer s.lock.Unlock()
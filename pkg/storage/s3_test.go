@@ -6,11 +6,17 @@ import (
 	"context"
 	"errors"
 	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/golang/mock/gomock"
@@ -183,19 +189,163 @@ func TestStore(t *testing.T) {
 			return nil, err
 		}
 		assert.Equal(t, value, string(data))
+		assert.Equal(t, "2019-01-01T00:00:00Z", aws.StringValue(input.Metadata["start"]))
+		assert.Equal(t, "2019-01-01T01:00:00Z", aws.StringValue(input.Metadata["stop"]))
+		assert.Equal(t, "a/r", aws.StringValue(input.Metadata["scope"]))
+		assert.Equal(t, "10", aws.StringValue(input.Metadata["records"]))
+		assert.Equal(t, "2", aws.StringValue(input.Metadata["source-objects"]))
+		assert.NotEqual(t, "0", aws.StringValue(input.Metadata["size"]))
 		return &s3manager.UploadOutput{}, nil
 	})
 
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().HeadObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, awserr.New("NotFound", "", errors.New("")))
+
 	storage := &S3{
 		Bucket:   bucket,
+		Client:   mockS3,
 		uploader: mockUploader,
 	}
 
+	meta := types.DigestMetadata{
+		Start:         time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Stop:          time.Date(2019, time.January, 1, 1, 0, 0, 0, time.UTC),
+		Scope:         "a/r",
+		CreatedAt:     time.Now(),
+		Records:       10,
+		SourceObjects: 2,
+	}
 	input := ioutil.NopCloser(bytes.NewReader([]byte(value)))
-	err := storage.Store(context.Background(), key, input)
+	err := storage.Store(context.Background(), key, input, meta)
 	assert.Nil(t, err)
 }
 
+func TestStoreEncrypted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	value := "VFDE\x01sealed digest"
+
+	mockUploader := NewMockUploaderAPI(ctrl)
+	mockUploader.EXPECT().UploadWithContext(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, input *s3manager.UploadInput) (interface{}, error) {
+		data, err := ioutil.ReadAll(input.Body)
+		if err != nil {
+			return nil, err
+		}
+		assert.Equal(t, value, string(data))
+		assert.Equal(t, "true", aws.StringValue(input.Metadata["encrypted"]))
+		assert.Equal(t, strconv.Itoa(len(value)), aws.StringValue(input.Metadata["size"]))
+		return &s3manager.UploadOutput{}, nil
+	})
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().HeadObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, awserr.New("NotFound", "", errors.New("")))
+
+	storage := &S3{
+		Bucket:   bucket,
+		Client:   mockS3,
+		uploader: mockUploader,
+	}
+
+	input := ioutil.NopCloser(bytes.NewReader([]byte(value)))
+	assert.Nil(t, storage.Store(context.Background(), key, input, types.DigestMetadata{Encrypted: true}))
+}
+
+func TestStoreVersionsExisting(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	headOutput := &s3.HeadObjectOutput{
+		Metadata: map[string]*string{
+			"Created-At": aws.String("2019-01-02T03:04:05Z"),
+		},
+	}
+	expectedCopy := &s3.CopyObjectInput{
+		Bucket:     aws.String(bucket),
+		CopySource: aws.String(bucket + "/" + key + ".log.gz"),
+		Key:        aws.String("versions/" + key + "/20190102T030405.000000000Z.log.gz"),
+	}
+
+	mockS3 := NewMockS3API(ctrl)
+	mockUploader := NewMockUploaderAPI(ctrl)
+	gomock.InOrder(
+		mockS3.EXPECT().HeadObjectWithContext(gomock.Any(), gomock.Any()).Return(headOutput, nil),
+		mockS3.EXPECT().CopyObjectWithContext(gomock.Any(), expectedCopy).Return(&s3.CopyObjectOutput{}, nil),
+		mockUploader.EXPECT().UploadWithContext(gomock.Any(), gomock.Any()).Return(&s3manager.UploadOutput{}, nil),
+	)
+
+	storage := &S3{
+		Bucket:   bucket,
+		Client:   mockS3,
+		uploader: mockUploader,
+	}
+
+	input := ioutil.NopCloser(bytes.NewReader([]byte("regenerated digest")))
+	assert.Nil(t, storage.Store(context.Background(), key, input, types.DigestMetadata{}))
+}
+
+func TestStoreWithOptions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	options := ObjectOptions{ServerSideEncryption: "AES256", StorageClass: "STANDARD_IA", Tags: map[string]string{"team": "security"}}
+	headOutput := &s3.HeadObjectOutput{
+		Metadata: map[string]*string{
+			"Created-At": aws.String("2019-01-02T03:04:05Z"),
+		},
+	}
+	expectedCopy := &s3.CopyObjectInput{
+		Bucket:               aws.String(bucket),
+		CopySource:           aws.String(bucket + "/" + key + ".log.gz"),
+		Key:                  aws.String("versions/" + key + "/20190102T030405.000000000Z.log.gz"),
+		ServerSideEncryption: aws.String("AES256"),
+		StorageClass:         aws.String("STANDARD_IA"),
+		Tagging:              aws.String("team=security"),
+		TaggingDirective:     aws.String("REPLACE"),
+	}
+
+	mockS3 := NewMockS3API(ctrl)
+	mockUploader := NewMockUploaderAPI(ctrl)
+	gomock.InOrder(
+		mockS3.EXPECT().HeadObjectWithContext(gomock.Any(), gomock.Any()).Return(headOutput, nil),
+		mockS3.EXPECT().CopyObjectWithContext(gomock.Any(), expectedCopy).Return(&s3.CopyObjectOutput{}, nil),
+		mockUploader.EXPECT().UploadWithContext(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, input *s3manager.UploadInput) (interface{}, error) {
+			assert.Equal(t, "AES256", aws.StringValue(input.ServerSideEncryption))
+			assert.Equal(t, "STANDARD_IA", aws.StringValue(input.StorageClass))
+			assert.Equal(t, "team=security", aws.StringValue(input.Tagging))
+			return &s3manager.UploadOutput{}, nil
+		}),
+	)
+
+	storage := &S3{
+		Bucket:   bucket,
+		Client:   mockS3,
+		Options:  options,
+		uploader: mockUploader,
+	}
+
+	input := ioutil.NopCloser(bytes.NewReader([]byte("regenerated digest")))
+	assert.Nil(t, storage.Store(context.Background(), key, input, types.DigestMetadata{}))
+}
+
+func TestStoreVersionError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().HeadObjectWithContext(gomock.Any(), gomock.Any()).Return(&s3.HeadObjectOutput{}, nil)
+	mockS3.EXPECT().CopyObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("oops"))
+
+	storage := &S3{
+		Bucket:   bucket,
+		Client:   mockS3,
+		uploader: NewMockUploaderAPI(ctrl),
+	}
+
+	input := ioutil.NopCloser(bytes.NewReader([]byte("regenerated digest")))
+	assert.NotNil(t, storage.Store(context.Background(), key, input, types.DigestMetadata{}))
+}
+
 func TestStoreError(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -205,12 +355,427 @@ func TestStoreError(t *testing.T) {
 	mockUploader := NewMockUploaderAPI(ctrl)
 	mockUploader.EXPECT().UploadWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("oops"))
 
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().HeadObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, awserr.New("NotFound", "", errors.New("")))
+
 	storage := &S3{
 		Bucket:   bucket,
+		Client:   mockS3,
 		uploader: mockUploader,
 	}
 
 	input := ioutil.NopCloser(bytes.NewReader([]byte(value)))
-	err := storage.Store(context.Background(), key, input)
+	err := storage.Store(context.Background(), key, input, types.DigestMetadata{})
 	assert.NotNil(t, err)
 }
+
+func TestPresign(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	headInput := &s3.HeadObjectInput{
+		Key:    aws.String(key + ".log.gz"),
+		Bucket: aws.String(bucket),
+	}
+	getInput := &s3.GetObjectInput{
+		Key:    aws.String(key + ".log.gz"),
+		Bucket: aws.String(bucket),
+	}
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-west-2"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	req, _ := s3.New(sess).GetObjectRequest(getInput)
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().HeadObjectWithContext(gomock.Any(), headInput).Return(&s3.HeadObjectOutput{}, nil)
+	mockS3.EXPECT().GetObjectRequest(getInput).Return(req, &s3.GetObjectOutput{})
+
+	storage := &S3{
+		Bucket: bucket,
+		Client: mockS3,
+	}
+
+	location, err := storage.Presign(context.Background(), key, time.Minute)
+	assert.Nil(t, err)
+	u, err := url.Parse(location)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(u.Path, "/"+key+".log.gz"))
+	assert.Equal(t, "60", u.Query().Get("X-Amz-Expires"))
+	assert.NotEmpty(t, u.Query().Get("X-Amz-Signature"))
+}
+
+func TestPresignNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	aErr := awserr.New("NotFound", "", errors.New(""))
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().HeadObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, aErr)
+
+	storage := &S3{
+		Bucket: bucket,
+		Client: mockS3,
+	}
+
+	_, err := storage.Presign(context.Background(), key, time.Minute)
+	assert.NotNil(t, err)
+	_, ok := err.(types.ErrNotFound)
+	assert.True(t, ok)
+}
+
+func TestDelete(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	expectedInput := &s3.DeleteObjectInput{
+		Key:    aws.String(key + ".log.gz"),
+		Bucket: aws.String(bucket),
+	}
+
+	versions := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String("versions/" + key + "/"),
+	}
+	next := &s3.ListObjectsV2Input{
+		Bucket:            aws.String(bucket),
+		Prefix:            aws.String("versions/" + key + "/"),
+		ContinuationToken: aws.String("next"),
+	}
+	first := "versions/" + key + "/20190101T000000.000000000Z.log.gz"
+	second := "versions/" + key + "/20190102T000000.000000000Z.log.gz"
+
+	mockS3 := NewMockS3API(ctrl)
+	gomock.InOrder(
+		mockS3.EXPECT().DeleteObjectWithContext(gomock.Any(), expectedInput).Return(&s3.DeleteObjectOutput{}, nil),
+		mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), versions).Return(&s3.ListObjectsV2Output{
+			Contents:              []*s3.Object{{Key: aws.String(first)}},
+			IsTruncated:           aws.Bool(true),
+			NextContinuationToken: aws.String("next"),
+		}, nil),
+		mockS3.EXPECT().DeleteObjectsWithContext(gomock.Any(), &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &s3.Delete{Objects: []*s3.ObjectIdentifier{{Key: aws.String(first)}}, Quiet: aws.Bool(true)},
+		}).Return(&s3.DeleteObjectsOutput{}, nil),
+		mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), next).Return(&s3.ListObjectsV2Output{
+			Contents: []*s3.Object{{Key: aws.String(second)}},
+		}, nil),
+		mockS3.EXPECT().DeleteObjectsWithContext(gomock.Any(), &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &s3.Delete{Objects: []*s3.ObjectIdentifier{{Key: aws.String(second)}}, Quiet: aws.Bool(true)},
+		}).Return(&s3.DeleteObjectsOutput{}, nil),
+	)
+
+	storage := &S3{
+		Bucket: bucket,
+		Client: mockS3,
+	}
+
+	assert.Nil(t, storage.Delete(context.Background(), key))
+}
+
+func TestDeleteNoVersions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().DeleteObjectWithContext(gomock.Any(), gomock.Any()).Return(&s3.DeleteObjectOutput{}, nil)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), gomock.Any()).Return(&s3.ListObjectsV2Output{}, nil)
+
+	storage := &S3{
+		Bucket: bucket,
+		Client: mockS3,
+	}
+
+	assert.Nil(t, storage.Delete(context.Background(), key))
+}
+
+func TestDeleteVersionsError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().DeleteObjectWithContext(gomock.Any(), gomock.Any()).Return(&s3.DeleteObjectOutput{}, nil)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), gomock.Any()).Return(&s3.ListObjectsV2Output{
+		Contents: []*s3.Object{{Key: aws.String("versions/" + key + "/20190101T000000.000000000Z.log.gz")}},
+	}, nil)
+	mockS3.EXPECT().DeleteObjectsWithContext(gomock.Any(), gomock.Any()).Return(&s3.DeleteObjectsOutput{
+		Errors: []*s3.Error{{Key: aws.String("versions/" + key + "/20190101T000000.000000000Z.log.gz"), Message: aws.String("Access Denied")}},
+	}, nil)
+
+	storage := &S3{
+		Bucket: bucket,
+		Client: mockS3,
+	}
+
+	assert.NotNil(t, storage.Delete(context.Background(), key))
+}
+
+func TestDeleteError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().DeleteObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("oops"))
+
+	storage := &S3{
+		Bucket: bucket,
+		Client: mockS3,
+	}
+
+	assert.NotNil(t, storage.Delete(context.Background(), key))
+}
+
+func TestList(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	listOutput := &s3.ListObjectsV2Output{
+		Contents: []*s3.Object{
+			{Key: aws.String("early.log.gz")},
+			{Key: aws.String("late.log.gz")},
+			{Key: aws.String("not-a-digest")},
+		},
+		IsTruncated:           aws.Bool(true),
+		NextContinuationToken: aws.String("next"),
+	}
+	early := &s3.HeadObjectOutput{
+		Metadata: map[string]*string{
+			"Start":          aws.String("2019-01-01T00:00:00Z"),
+			"Stop":           aws.String("2019-01-01T01:00:00Z"),
+			"Scope":          aws.String("a/r"),
+			"Created-At":     aws.String("2019-01-02T00:00:00Z"),
+			"Size":           aws.String("100"),
+			"Records":        aws.String("10"),
+			"Source-Objects": aws.String("2"),
+		},
+	}
+	late := &s3.HeadObjectOutput{
+		Metadata: map[string]*string{
+			"Start": aws.String("2019-02-01T00:00:00Z"),
+			"Stop":  aws.String("2019-02-01T01:00:00Z"),
+		},
+	}
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), &s3.ListObjectsV2Input{
+		Bucket:            aws.String(bucket),
+		Delimiter:         aws.String("/"),
+		MaxKeys:           aws.Int64(10),
+		ContinuationToken: aws.String("token"),
+	}).Return(listOutput, nil)
+	mockS3.EXPECT().HeadObjectWithContext(gomock.Any(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("early.log.gz"),
+	}).Return(early, nil)
+	mockS3.EXPECT().HeadObjectWithContext(gomock.Any(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("late.log.gz"),
+	}).Return(late, nil)
+	// the page is not full, so the next page of objects is fetched
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), &s3.ListObjectsV2Input{
+		Bucket:            aws.String(bucket),
+		Delimiter:         aws.String("/"),
+		MaxKeys:           aws.Int64(9),
+		ContinuationToken: aws.String("next"),
+	}).Return(&s3.ListObjectsV2Output{
+		Contents: []*s3.Object{{Key: aws.String("gone.log.gz")}},
+	}, nil)
+	mockS3.EXPECT().HeadObjectWithContext(gomock.Any(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("gone.log.gz"),
+	}).Return(nil, awserr.New("NotFound", "", nil))
+
+	storage := &S3{
+		Bucket: bucket,
+		Client: mockS3,
+	}
+
+	list, err := storage.List(context.Background(), types.ListOptions{
+		Start: time.Date(2019, time.January, 1, 0, 30, 0, 0, time.UTC),
+		Stop:  time.Date(2019, time.January, 2, 0, 0, 0, 0, time.UTC),
+		Limit: 10,
+		Token: "token",
+	})
+	assert.Nil(t, err)
+	assert.Empty(t, list.NextToken)
+	assert.Equal(t, []types.DigestMetadata{
+		{
+			ID:            "early",
+			Start:         time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC),
+			Stop:          time.Date(2019, time.January, 1, 1, 0, 0, 0, time.UTC),
+			Scope:         "a/r",
+			CreatedAt:     time.Date(2019, time.January, 2, 0, 0, 0, 0, time.UTC),
+			Size:          100,
+			Records:       10,
+			SourceObjects: 2,
+		},
+	}, list.Digests)
+}
+
+func TestListFullPage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), gomock.Any()).Return(&s3.ListObjectsV2Output{
+		Contents:              []*s3.Object{{Key: aws.String("first.log.gz")}, {Key: aws.String("second.log.gz")}},
+		IsTruncated:           aws.Bool(true),
+		NextContinuationToken: aws.String("next"),
+	}, nil)
+	mockS3.EXPECT().HeadObjectWithContext(gomock.Any(), gomock.Any()).Return(&s3.HeadObjectOutput{}, nil).Times(2)
+
+	storage := &S3{
+		Bucket: bucket,
+		Client: mockS3,
+	}
+	list, err := storage.List(context.Background(), types.ListOptions{Limit: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, "next", list.NextToken)
+	assert.Len(t, list.Digests, 2)
+	assert.Equal(t, "first", list.Digests[0].ID)
+	assert.Equal(t, "second", list.Digests[1].ID)
+}
+
+func TestListError(t *testing.T) {
+	tc := []struct {
+		Name      string
+		ListError error
+		HeadError error
+	}{
+		{
+			Name:      "list",
+			ListError: errors.New("oops"),
+		},
+		{
+			Name:      "head",
+			HeadError: errors.New("oops"),
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockS3 := NewMockS3API(ctrl)
+			if tt.ListError != nil {
+				mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), gomock.Any()).Return(nil, tt.ListError)
+			} else {
+				mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), gomock.Any()).Return(&s3.ListObjectsV2Output{
+					Contents: []*s3.Object{{Key: aws.String(key + ".log.gz")}},
+				}, nil)
+				mockS3.EXPECT().HeadObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, tt.HeadError)
+			}
+
+			storage := &S3{
+				Bucket: bucket,
+				Client: mockS3,
+			}
+			_, err := storage.List(context.Background(), types.ListOptions{})
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestStat(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lastModified := time.Date(2019, time.January, 2, 0, 0, 0, 0, time.UTC)
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().HeadObjectWithContext(gomock.Any(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key + ".log.gz"),
+	}).Return(&s3.HeadObjectOutput{
+		ContentLength: aws.Int64(100),
+		LastModified:  aws.Time(lastModified),
+		Metadata: map[string]*string{
+			"Start":                aws.String("2019-01-01T00:00:00Z"),
+			"Stop":                 aws.String("2019-01-01T01:00:00Z"),
+			"Source-Last-Modified": aws.String("2019-01-01T01:05:00Z"),
+			"Stale":                aws.String("true"),
+			"Encrypted":            aws.String("true"),
+		},
+	}, nil)
+
+	storage := &S3{
+		Bucket: bucket,
+		Client: mockS3,
+	}
+
+	meta, err := storage.Stat(context.Background(), key)
+	assert.Nil(t, err)
+	assert.Equal(t, types.DigestMetadata{
+		ID:                 key,
+		Start:              time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Stop:               time.Date(2019, time.January, 1, 1, 0, 0, 0, time.UTC),
+		CreatedAt:          lastModified,
+		Size:               100,
+		SourceLastModified: time.Date(2019, time.January, 1, 1, 5, 0, 0, time.UTC),
+		Stale:              true,
+		Encrypted:          true,
+	}, meta)
+}
+
+func TestStatNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().HeadObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, awserr.New("NotFound", "", nil))
+
+	storage := &S3{
+		Bucket: bucket,
+		Client: mockS3,
+	}
+
+	_, err := storage.Stat(context.Background(), key)
+	assert.IsType(t, types.ErrNotFound{}, err)
+}
+
+func TestMarkStale(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().HeadObjectWithContext(gomock.Any(), gomock.Any()).Return(&s3.HeadObjectOutput{
+		Metadata: map[string]*string{
+			"Start": aws.String("2019-01-01T00:00:00Z"),
+			"Stop":  aws.String("2019-01-01T01:00:00Z"),
+		},
+	}, nil)
+	mockS3.EXPECT().CopyObjectWithContext(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, input *s3.CopyObjectInput, _ ...interface{}) (*s3.CopyObjectOutput, error) {
+			assert.Equal(t, bucket+"/"+key+".log.gz", aws.StringValue(input.CopySource))
+			assert.Equal(t, key+".log.gz", aws.StringValue(input.Key))
+			assert.Equal(t, s3.MetadataDirectiveReplace, aws.StringValue(input.MetadataDirective))
+			meta := decodeMetadata(key, input.Metadata)
+			assert.True(t, meta.Stale)
+			assert.Equal(t, time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC), meta.Start)
+			return &s3.CopyObjectOutput{}, nil
+		})
+
+	storage := &S3{
+		Bucket: bucket,
+		Client: mockS3,
+	}
+
+	assert.Nil(t, storage.MarkStale(context.Background(), key))
+}
+
+func TestMarkStaleNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().HeadObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, awserr.New("NotFound", "", nil))
+
+	storage := &S3{
+		Bucket: bucket,
+		Client: mockS3,
+	}
+
+	assert.IsType(t, types.ErrNotFound{}, storage.MarkStale(context.Background(), key))
+}
@@ -8,26 +8,45 @@ import (
 	"net/http"
 	"net/url"
 	"time"
+
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
 )
 
 type payload struct {
 	ID    string `json:"id"`
 	Start string `json:"start"`
 	Stop  string `json:"stop"`
+	// Callback is the URL to notify when the job is complete, if any
+	Callback string `json:"callback,omitempty"`
+	// QueuedAt is the time at which the job was queued, from which the worker measures the latency of the queue
+	QueuedAt string `json:"queuedAt"`
+	// Client identifies the client which requested the job, if any
+	Client string `json:"client,omitempty"`
 }
 
 // DigestQueuer is a Queuer implementation which queues digest jobs onto a streaming appliance
 type DigestQueuer struct {
 	Endpoint *url.URL
 	Client   *http.Client
+	// Token, if set, is sent as a bearer token with each job, for streaming appliances, or a worker endpoint, which
+	// require one
+	Token string
 }
 
 // Queue enqueues a digest job onto a streaming appliance
 func (q *DigestQueuer) Queue(ctx context.Context, id string, start, stop time.Time) error {
+	return q.QueueWithCallback(ctx, id, start, stop, "")
+}
+
+// QueueWithCallback enqueues a digest job onto a streaming appliance, along with a URL to notify when it is complete
+func (q *DigestQueuer) QueueWithCallback(ctx context.Context, id string, start, stop time.Time, callback string) error {
 	body := payload{
-		ID:    id,
-		Start: start.Format(time.RFC3339Nano),
-		Stop:  stop.Format(time.RFC3339Nano),
+		ID:       id,
+		Start:    start.Format(time.RFC3339Nano),
+		Stop:     stop.Format(time.RFC3339Nano),
+		Callback: callback,
+		QueuedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		Client:   types.ClientFromContext(ctx),
 	}
 	rawBody, _ := json.Marshal(body)
 	req, err := http.NewRequest(http.MethodPost, q.Endpoint.String(), bytes.NewReader(rawBody))
@@ -35,6 +54,9 @@ func (q *DigestQueuer) Queue(ctx context.Context, id string, start, stop time.Ti
 		return err
 	}
 	req.Header.Set("Content-Type", "application/octet-stream")
+	if q.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+q.Token)
+	}
 	res, err := q.Client.Do(req.WithContext(ctx))
 	if err != nil {
 		return err
@@ -3,6 +3,7 @@ package stream
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -11,6 +12,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/asecurityteam/vpcflow-digesterd/pkg/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -23,6 +25,8 @@ type testRoundTripper struct {
 	StatusCode  int
 	Error       error
 	ExpectedURL string
+	Body        []byte
+	Header      http.Header
 }
 
 func (rt *testRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
@@ -35,6 +39,8 @@ func (rt *testRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
 	if rt.Error != nil {
 		return nil, rt.Error
 	}
+	rt.Body, _ = ioutil.ReadAll(r.Body)
+	rt.Header = r.Header
 	res := &http.Response{
 		StatusCode: rt.StatusCode,
 		Body:       ioutil.NopCloser(bytes.NewReader([]byte(""))),
@@ -90,3 +96,54 @@ func TestRTError(t *testing.T) {
 	err := dq.Queue(context.Background(), "digestId", time.Now(), time.Now())
 	assert.NotNil(t, err)
 }
+
+func TestQueueWithCallback(t *testing.T) {
+	endpoint, _ := url.Parse(baseURL)
+	rt := &testRoundTripper{
+		StatusCode:  200,
+		ExpectedURL: endpoint.String(),
+	}
+	dq := DigestQueuer{
+		Client:   &http.Client{Transport: rt},
+		Endpoint: endpoint,
+	}
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := types.NewClientContext(context.Background(), "alice")
+	err := dq.QueueWithCallback(ctx, "digestId", start, start.Add(time.Hour), "https://hooks.example.com")
+	assert.Nil(t, err)
+
+	var body payload
+	assert.Nil(t, json.Unmarshal(rt.Body, &body))
+	queuedAt, err := time.Parse(time.RFC3339Nano, body.QueuedAt)
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now(), queuedAt, time.Minute)
+	body.QueuedAt = ""
+	assert.Equal(t, payload{
+		ID:       "digestId",
+		Start:    "2019-01-01T00:00:00Z",
+		Stop:     "2019-01-01T01:00:00Z",
+		Callback: "https://hooks.example.com",
+		Client:   "alice",
+	}, body)
+}
+
+func TestQueueWithToken(t *testing.T) {
+	endpoint, _ := url.Parse(baseURL)
+	rt := &testRoundTripper{
+		StatusCode:  200,
+		ExpectedURL: endpoint.String(),
+	}
+	dq := DigestQueuer{
+		Client:   &http.Client{Transport: rt},
+		Endpoint: endpoint,
+		Token:    "s3cret",
+	}
+	err := dq.Queue(context.Background(), "digestId", time.Now(), time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer s3cret", rt.Header.Get("Authorization"))
+
+	dq.Token = ""
+	err = dq.Queue(context.Background(), "digestId", time.Now(), time.Now())
+	assert.Nil(t, err)
+	assert.Empty(t, rt.Header.Get("Authorization"))
+}
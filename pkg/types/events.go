@@ -0,0 +1,49 @@
+package types
+
+import (
+	"context"
+	"time"
+)
+
+// Event types describing changes to the state of a digest job
+const (
+	// EventQueued is published when a digest job is queued
+	EventQueued = "queued"
+	// EventStarted is published when a worker begins creating a digest
+	EventStarted = "started"
+	// EventCompleted is published when a digest has been created and stored
+	EventCompleted = "completed"
+	// EventFailed is published when a digest could not be created
+	EventFailed = "failed"
+	// EventMarked is published when a digest is marked as "in progress"
+	EventMarked = "marked"
+	// EventUnmarked is published when a digest is no longer marked as "in progress"
+	EventUnmarked = "unmarked"
+)
+
+// Event describes a change to the state of a digest job
+type Event struct {
+	// ID identifies the event, and is assigned when it is published
+	ID       string
+	Type     string
+	DigestID string
+	// Start and Stop are the window of the digest. They are zero for events which do not know the window.
+	Start time.Time
+	Stop  time.Time
+	// Error describes why the job failed. Only set for EventFailed.
+	Error string
+	Time  time.Time
+}
+
+// Publisher publishes events to any subscribers. Publishing never blocks on, or fails because of, subscribers.
+type Publisher interface {
+	Publish(ctx context.Context, e Event)
+}
+
+// Subscriber provides a stream of published events
+type Subscriber interface {
+	// Subscribe returns the retained events published after the event with lastEventID, followed by a channel
+	// of events published from now on. If lastEventID is empty, no events are replayed. The channel is closed
+	// if the subscriber falls too far behind, and cancel must be called when the subscriber is done.
+	Subscribe(lastEventID string) (replay []Event, events <-chan Event, cancel func())
+}
@@ -0,0 +1,65 @@
+package types
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// idTimeFormat is a fixed width format, so that the name hashed for an ID does not depend on how the
+// window's times were expressed by the caller
+const idTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+var (
+	// idNamespace is the namespace for canonical digest IDs. The version must be incremented whenever the
+	// name hashed by DigestKey.ID changes, so that IDs computed under different schemes never collide.
+	idNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://github.com/asecurityteam/vpcflow-digesterd/digest/v2"))
+	// legacyIDNamespace is the namespace used for digest IDs before canonical IDs were introduced
+	legacyIDNamespace = uuid.NewSHA1(uuid.Nil, []byte("digest"))
+)
+
+// DigestKey describes everything which determines the content of a digest, and therefore its ID
+type DigestKey struct {
+	Start time.Time
+	Stop  time.Time
+	Scope Scope
+	// Options are the settings of the digester, by name, which change the content of a digest. Digests created
+	// with different options are stored under different IDs.
+	Options map[string]string
+}
+
+// ID returns the canonical ID of the digest. The ID is the same for equal instants regardless of their
+// time zone, and for scopes and options which differ only in order. Options are only hashed when there are
+// some, so that the IDs of digests created without options are unchanged.
+func (k DigestKey) ID() string {
+	accounts := sortedCopy(k.Scope.Accounts)
+	regions := sortedCopy(k.Scope.Regions)
+	parts := []string{
+		k.Start.UTC().Format(idTimeFormat),
+		k.Stop.UTC().Format(idTimeFormat),
+		Scope{Accounts: accounts, Regions: regions}.String(),
+	}
+	if len(k.Options) > 0 {
+		options := make([]string, 0, len(k.Options))
+		for name, value := range k.Options {
+			options = append(options, name+"="+value)
+		}
+		sort.Strings(options)
+		parts = append(parts, strings.Join(options, ","))
+	}
+	return uuid.NewSHA1(idNamespace, []byte(strings.Join(parts, "|"))).String()
+}
+
+// LegacyID returns the ID under which the digest would have been stored before canonical IDs were introduced.
+// Legacy IDs depend on the time zone in which the window was expressed, and do not account for scope.
+func (k DigestKey) LegacyID() string {
+	return uuid.NewSHA1(legacyIDNamespace, []byte(k.Start.String()+k.Stop.String())).String()
+}
+
+func sortedCopy(values []string) []string {
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,66 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDigestKeyIDTimeZoneIndependent(t *testing.T) {
+	utc := DigestKey{
+		Start: time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Stop:  time.Date(2019, time.January, 1, 1, 0, 0, 0, time.UTC),
+	}
+	zone := time.FixedZone("", 60*60)
+	offset := DigestKey{
+		Start: time.Date(2019, time.January, 1, 1, 0, 0, 0, zone),
+		Stop:  time.Date(2019, time.January, 1, 2, 0, 0, 0, zone),
+	}
+	assert.Equal(t, utc.ID(), offset.ID())
+	assert.NotEqual(t, utc.LegacyID(), offset.LegacyID())
+}
+
+func TestDigestKeyIDStable(t *testing.T) {
+	// canonical IDs must never change for a given scheme version, since they are used to look up stored digests
+	key := DigestKey{
+		Start: time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Stop:  time.Date(2019, time.January, 1, 1, 0, 0, 0, time.UTC),
+	}
+	assert.Equal(t, key.ID(), DigestKey{Start: key.Start, Stop: key.Stop, Scope: Scope{}}.ID())
+	assert.Equal(t, "adc6fa16-acf7-5dce-82d0-fe81dccfb4ec", key.ID())
+}
+
+func TestDigestKeyIDScope(t *testing.T) {
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	stop := start.Add(time.Hour)
+	base := DigestKey{Start: start, Stop: stop}
+
+	scoped := DigestKey{Start: start, Stop: stop, Scope: Scope{Accounts: []string{"1", "2"}, Regions: []string{"us-west-2"}}}
+	reordered := DigestKey{Start: start, Stop: stop, Scope: Scope{Accounts: []string{"2", "1"}, Regions: []string{"us-west-2"}}}
+	assert.NotEqual(t, base.ID(), scoped.ID())
+	assert.Equal(t, scoped.ID(), reordered.ID())
+}
+
+func TestDigestKeyIDOptions(t *testing.T) {
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	stop := start.Add(time.Hour)
+	base := DigestKey{Start: start, Stop: stop}
+
+	assert.Equal(t, base.ID(), DigestKey{Start: start, Stop: stop, Options: map[string]string{}}.ID())
+	a := DigestKey{Start: start, Stop: stop, Options: map[string]string{"granularity": "hour", "ports": "true"}}
+	b := DigestKey{Start: start, Stop: stop, Options: map[string]string{"granularity": "minute", "ports": "true"}}
+	assert.NotEqual(t, base.ID(), a.ID())
+	assert.NotEqual(t, a.ID(), b.ID())
+	assert.Equal(t, a.ID(), DigestKey{Start: start, Stop: stop, Options: map[string]string{"ports": "true", "granularity": "hour"}}.ID())
+}
+
+func TestDigestKeyLegacyID(t *testing.T) {
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	stop := start.Add(time.Hour)
+	namespace := uuid.NewSHA1(uuid.Nil, []byte("digest"))
+	expected := uuid.NewSHA1(namespace, []byte(start.String()+stop.String())).String()
+	assert.Equal(t, expected, DigestKey{Start: start, Stop: stop, Scope: Scope{Accounts: []string{"1"}}}.LegacyID())
+	assert.NotEqual(t, expected, DigestKey{Start: start, Stop: stop}.ID())
+}
@@ -0,0 +1,13 @@
+package types
+
+import "context"
+
+// KeyProvider supplies the data keys with which digests are encrypted before they are stored
+type KeyProvider interface {
+	// GenerateDataKey returns a new 256 bit data key, both in plaintext and encrypted such that only the
+	// KeyProvider can recover it. The encrypted key is stored alongside the digest it protects.
+	GenerateDataKey(ctx context.Context) (plaintext []byte, encrypted []byte, err error)
+
+	// DecryptDataKey returns the plaintext of a data key which was encrypted by GenerateDataKey
+	DecryptDataKey(ctx context.Context, encrypted []byte) ([]byte, error)
+}
@@ -0,0 +1,105 @@
+package types
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Scope identifies the set of AWS accounts and regions from which a digest's flow logs are read.
+// An empty list of accounts or regions means that all of them are included.
+type Scope struct {
+	Accounts []string
+	Regions  []string
+}
+
+// String renders the scope as "<accounts>/<regions>", where each element is a comma separated
+// list, or "*" if empty.
+func (s Scope) String() string {
+	return joinOrWildcard(s.Accounts) + "/" + joinOrWildcard(s.Regions)
+}
+
+// Equal reports whether the scopes include the same accounts and regions, regardless of order
+func (s Scope) Equal(other Scope) bool {
+	return Scope{Accounts: sortedCopy(s.Accounts), Regions: sortedCopy(s.Regions)}.String() ==
+		Scope{Accounts: sortedCopy(other.Accounts), Regions: sortedCopy(other.Regions)}.String()
+}
+
+func joinOrWildcard(values []string) string {
+	if len(values) == 0 {
+		return "*"
+	}
+	return strings.Join(values, ",")
+}
+
+// DigestStats describes the source data which was consumed in order to create a digest
+type DigestStats struct {
+	// SourceObjects is the number of flow log objects read
+	SourceObjects int64
+	// SourceBytes is the total size of the flow log objects read
+	SourceBytes int64
+	// Records is the number of flow log records read
+	Records int64
+	// LastModified is the most recent modification time of the flow log objects read
+	LastModified time.Time
+}
+
+// StatsReporter is an optional interface for digesters which can report on the source data they consumed.
+// Stats is only meaningful after Digest has returned.
+type StatsReporter interface {
+	Stats() DigestStats
+}
+
+// DigestMetadata describes a stored digest
+type DigestMetadata struct {
+	ID            string
+	Start         time.Time
+	Stop          time.Time
+	Scope         string
+	CreatedAt     time.Time
+	Size          int64
+	Records       int64
+	SourceObjects int64
+	// SourceLastModified is the most recent modification time of the source objects the digest was
+	// created from. Along with SourceObjects, it is the watermark used to detect late-arriving data.
+	SourceLastModified time.Time
+	// Stale is set when the source data for the digest has changed since it was created
+	Stale bool
+	// Encrypted is set when the digest is stored in an encrypted envelope, which Storage should store as it is
+	// rather than compressing it
+	Encrypted bool
+}
+
+// Watermark summarizes the set of flow log objects available for a window of time
+type Watermark struct {
+	Objects      int64
+	LastModified time.Time
+}
+
+// Changed reports whether the source data described by the digest metadata differs from the watermark
+func (w Watermark) Changed(meta DigestMetadata) bool {
+	return w.Objects != meta.SourceObjects || w.LastModified.After(meta.SourceLastModified)
+}
+
+// WatermarkProvider returns the current Watermark of the flow log objects from which a digest for the
+// given window would be created
+type WatermarkProvider func(ctx context.Context, start, stop time.Time) (Watermark, error)
+
+// ListOptions filters and paginates the digests returned by Storage.List
+type ListOptions struct {
+	// Start and Stop, if set, restrict results to digests whose window overlaps with [Start, Stop)
+	Start time.Time
+	Stop  time.Time
+	// Limit is the maximum number of digests to return in a single page. Pages are filled with digests
+	// which match the filters, so fewer than Limit digests are only returned in the last page.
+	Limit int
+	// Token is the NextToken value returned by a previous call, used to fetch the next page
+	Token string
+}
+
+// DigestList is a single page of digests returned by Storage.List
+type DigestList struct {
+	Digests []DigestMetadata
+	// NextToken is empty when there are no more pages
+	NextToken string
+}
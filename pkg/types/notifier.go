@@ -0,0 +1,37 @@
+package types
+
+import (
+	"context"
+	"time"
+)
+
+// Notification statuses
+const (
+	NotificationCompleted = "completed"
+	NotificationFailed    = "failed"
+)
+
+// Notification describes the outcome of a digest job, and is delivered to the callback URL given when the
+// job was requested
+type Notification struct {
+	ID     string    `json:"id"`
+	Start  time.Time `json:"start"`
+	Stop   time.Time `json:"stop"`
+	Status string    `json:"status"`
+	// Location is the URL from which the digest may be fetched. Only set when the job completed.
+	Location string `json:"location,omitempty"`
+	// Error describes why the job failed. Only set when the job failed.
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// Notifier delivers notifications to callback URLs
+type Notifier interface {
+	Notify(ctx context.Context, callback string, n Notification) error
+}
+
+// CallbackQueuer is an optional interface for Queuers which can carry a callback URL with a digest job, so that
+// a Notification may be delivered when it completes
+type CallbackQueuer interface {
+	QueueWithCallback(ctx context.Context, id string, start, stop time.Time, callback string) error
+}
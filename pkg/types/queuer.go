@@ -9,3 +9,18 @@ import (
 type Queuer interface {
 	Queue(ctx context.Context, id string, start, stop time.Time) error
 }
+
+type clientKey struct{}
+
+// NewClientContext returns a copy of the context which carries the client which requested a digest job, so that a
+// Queuer may pass it on to the worker which creates the digest
+func NewClientContext(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// ClientFromContext returns the client which requested a digest job, or an empty string if there is none, as is
+// the case for jobs queued by the service itself
+func ClientFromContext(ctx context.Context) string {
+	client, _ := ctx.Value(clientKey{}).(string)
+	return client
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"time"
 )
 
 // ErrInProgress indicates that a digest is in the process of being created
@@ -24,6 +25,15 @@ func (e ErrNotFound) Error() string {
 	return fmt.Sprintf("digest %s was not found", e.ID)
 }
 
+// ErrUnsupported indicates that a Storage implementation does not support the requested operation
+type ErrUnsupported struct {
+	Operation string
+}
+
+func (e ErrUnsupported) Error() string {
+	return fmt.Sprintf("storage does not support %s", e.Operation)
+}
+
 // Storage is an interface for accessing created digests. It is the caller's responsibility to call Close on the Reader when done.
 type Storage interface {
 	// Get returns the digest for the given key.
@@ -32,8 +42,26 @@ type Storage interface {
 	// Exists returns true if the digest exists, but does not download the digest body.
 	Exists(ctx context.Context, key string) (bool, error)
 
-	// Store stores the digest
-	Store(ctx context.Context, key string, data io.ReadCloser) error
+	// Store stores the digest, along with metadata describing it
+	Store(ctx context.Context, key string, data io.ReadCloser, meta DigestMetadata) error
+
+	// Delete removes the digest. Deleting a digest which does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+
+	// List returns a page of metadata for stored digests
+	List(ctx context.Context, options ListOptions) (DigestList, error)
+}
+
+// Stater is an optional interface for Storage implementations which can read the metadata of a digest
+type Stater interface {
+	// Stat returns the metadata for the digest, but does not download the digest body.
+	Stat(ctx context.Context, key string) (DigestMetadata, error)
+}
+
+// StaleMarker is an optional interface for Storage implementations which can record that a digest is stale
+type StaleMarker interface {
+	// MarkStale flags the stored digest as having been created from source data which has since changed
+	MarkStale(ctx context.Context, key string) error
 }
 
 // Marker is an interface for indicating that a digest is in progress of being created
@@ -44,3 +72,23 @@ type Marker interface {
 	// Unmark flags the digest identified by key as not being "in progress"
 	Unmark(ctx context.Context, key string) error
 }
+
+// MarkerWatcher is an optional interface for Markers which can wait for a digest to stop being "in progress"
+type MarkerWatcher interface {
+	// WaitUnmarked blocks until the digest identified by key is not "in progress", returning immediately if it
+	// is not marked. If the context is done first, the context's error is returned.
+	WaitUnmarked(ctx context.Context, key string) error
+}
+
+// MarkerCounter is an optional interface for Markers which can count the digests "in progress"
+type MarkerCounter interface {
+	// CountMarked returns the number of digests which are "in progress"
+	CountMarked(ctx context.Context) (int, error)
+}
+
+// Presigner is an optional interface for Storage implementations which can hand out short-lived URLs
+// from which a digest can be downloaded directly, rather than being proxied through the service.
+type Presigner interface {
+	// Presign returns a URL from which the digest for the given key can be fetched until ttl elapses.
+	Presign(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
@@ -0,0 +1,127 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const defaultMaxSplit = 100
+
+// ErrEmptyWindow indicates that a window, once truncated to minute precision, does not span any time. The worker
+// endpoint rejects such windows, so they must not be queued.
+var ErrEmptyWindow = errors.New("the window should span at least one minute")
+
+// ErrWindowRejected indicates that a window is well formed, but may not be digested under the WindowPolicy
+type ErrWindowRejected struct {
+	Reason string
+}
+
+func (e ErrWindowRejected) Error() string {
+	return fmt.Sprintf("window rejected: %s", e.Reason)
+}
+
+// ErrWindowTooLarge indicates that a window is longer than the WindowPolicy allows
+type ErrWindowTooLarge struct {
+	Length time.Duration
+	Max    time.Duration
+}
+
+func (e ErrWindowTooLarge) Error() string {
+	return fmt.Sprintf("window rejected: window of %s exceeds the maximum of %s", e.Length, e.Max)
+}
+
+// Window is the time range covered by a digest
+type Window struct {
+	Start time.Time
+	Stop  time.Time
+}
+
+// ParseWindow parses the start and stop of a window as RFC3339Nano timestamps. The times are truncated to the
+// nearest minute, since anything with more precision doesn't really fit the digest filter use case. An error is
+// returned if either is not a valid timestamp, or if start is after stop. Windows which truncate to no time at all
+// are accepted, as they always have been by version 1 of the API; callers which queue jobs directly should
+// reject them with ErrEmptyWindow.
+func ParseWindow(start, stop string) (Window, error) {
+	startTime, err := time.Parse(time.RFC3339Nano, start)
+	if err != nil {
+		return Window{}, err
+	}
+	stopTime, err := time.Parse(time.RFC3339Nano, stop)
+	if err != nil {
+		return Window{}, err
+	}
+	if startTime.After(stopTime) {
+		return Window{}, errors.New("start should be before stop")
+	}
+	return Window{Start: startTime.Truncate(time.Minute), Stop: stopTime.Truncate(time.Minute)}, nil
+}
+
+// WindowPolicy constrains the windows for which digests may be created. A zero value for any limit disables it.
+// Windows which end in the future are always rejected, since the digest would be incomplete.
+type WindowPolicy struct {
+	// MaxWindow is the maximum length of a window
+	MaxWindow time.Duration
+	// MaxLookback is the maximum age of the start of a window
+	MaxLookback time.Duration
+	// SettleLag is the minimum age of the end of a window. VPC flow logs are delivered with a lag, so windows
+	// which end more recently than this are likely to be missing data.
+	SettleLag time.Duration
+	// Split, when true, causes windows larger than MaxWindow to be split into consecutive windows of at most
+	// MaxWindow, rather than rejected.
+	Split bool
+	// MaxSplit is the maximum number of windows that a window may be split into. Defaults to 100.
+	MaxSplit int
+}
+
+// Check returns an error if the window violates the policy at the given time. ErrWindowTooLarge is returned if
+// the window is only rejected because of its length.
+func (p WindowPolicy) Check(start, stop, now time.Time) error {
+	settled := now.Add(-p.SettleLag)
+	if stop.After(settled) {
+		if p.SettleLag == 0 {
+			return ErrWindowRejected{Reason: "stop must not be in the future"}
+		}
+		return ErrWindowRejected{Reason: fmt.Sprintf("stop must be at least %s in the past, so that all flow logs have been delivered", p.SettleLag)}
+	}
+	if p.MaxLookback > 0 && start.Before(now.Add(-p.MaxLookback)) {
+		return ErrWindowRejected{Reason: fmt.Sprintf("start must be no more than %s in the past", p.MaxLookback)}
+	}
+	if length := stop.Sub(start); p.MaxWindow > 0 && length > p.MaxWindow {
+		return ErrWindowTooLarge{Length: length, Max: p.MaxWindow}
+	}
+	return nil
+}
+
+// Windows checks the window against the policy and returns the windows which should be digested. This is the
+// window itself unless it is larger than MaxWindow and the policy allows it to be split.
+func (p WindowPolicy) Windows(start, stop, now time.Time) ([]Window, error) {
+	err := p.Check(start, stop, now)
+	switch err.(type) {
+	case nil:
+		return []Window{{Start: start, Stop: stop}}, nil
+	case ErrWindowTooLarge:
+		if !p.Split {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	maxSplit := p.MaxSplit
+	if maxSplit == 0 {
+		maxSplit = defaultMaxSplit
+	}
+	count := int((stop.Sub(start) + p.MaxWindow - 1) / p.MaxWindow)
+	if count > maxSplit {
+		return nil, ErrWindowRejected{Reason: fmt.Sprintf("window would be split into %d windows, exceeding the maximum of %d", count, maxSplit)}
+	}
+	windows := make([]Window, 0, count)
+	for s := start; s.Before(stop); s = s.Add(p.MaxWindow) {
+		e := s.Add(p.MaxWindow)
+		if e.After(stop) {
+			e = stop
+		}
+		windows = append(windows, Window{Start: s, Stop: e})
+	}
+	return windows, nil
+}
@@ -0,0 +1,158 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindowPolicyWindows(t *testing.T) {
+	now := time.Date(2019, time.January, 2, 0, 0, 0, 0, time.UTC)
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	tc := []struct {
+		Name     string
+		Policy   WindowPolicy
+		Start    time.Time
+		Stop     time.Time
+		Expected []Window
+		Err      error
+	}{
+		{
+			Name:     "unlimited",
+			Start:    start,
+			Stop:     start.Add(12 * time.Hour),
+			Expected: []Window{{Start: start, Stop: start.Add(12 * time.Hour)}},
+		},
+		{
+			Name:  "future",
+			Start: start,
+			Stop:  now.Add(time.Minute),
+			Err:   ErrWindowRejected{Reason: "stop must not be in the future"},
+		},
+		{
+			Name:   "unsettled",
+			Policy: WindowPolicy{SettleLag: time.Hour},
+			Start:  start,
+			Stop:   now.Add(-time.Minute),
+			Err:    ErrWindowRejected{Reason: "stop must be at least 1h0m0s in the past, so that all flow logs have been delivered"},
+		},
+		{
+			Name:   "lookback",
+			Policy: WindowPolicy{MaxLookback: time.Hour},
+			Start:  start,
+			Stop:   now,
+			Err:    ErrWindowRejected{Reason: "start must be no more than 1h0m0s in the past"},
+		},
+		{
+			Name:   "too_large",
+			Policy: WindowPolicy{MaxWindow: time.Hour},
+			Start:  start,
+			Stop:   start.Add(90 * time.Minute),
+			Err:    ErrWindowTooLarge{Length: 90 * time.Minute, Max: time.Hour},
+		},
+		{
+			Name:   "split",
+			Policy: WindowPolicy{MaxWindow: time.Hour, Split: true},
+			Start:  start,
+			Stop:   start.Add(150 * time.Minute),
+			Expected: []Window{
+				{Start: start, Stop: start.Add(time.Hour)},
+				{Start: start.Add(time.Hour), Stop: start.Add(2 * time.Hour)},
+				{Start: start.Add(2 * time.Hour), Stop: start.Add(150 * time.Minute)},
+			},
+		},
+		{
+			Name:   "split_exact",
+			Policy: WindowPolicy{MaxWindow: time.Hour, Split: true, MaxSplit: 2},
+			Start:  start,
+			Stop:   start.Add(2 * time.Hour),
+			Expected: []Window{
+				{Start: start, Stop: start.Add(time.Hour)},
+				{Start: start.Add(time.Hour), Stop: start.Add(2 * time.Hour)},
+			},
+		},
+		{
+			Name:   "too_many_splits",
+			Policy: WindowPolicy{MaxWindow: time.Hour, Split: true, MaxSplit: 2},
+			Start:  start,
+			Stop:   start.Add(150 * time.Minute),
+			Err:    ErrWindowRejected{Reason: "window would be split into 3 windows, exceeding the maximum of 2"},
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			windows, err := tt.Policy.Windows(tt.Start, tt.Stop, now)
+			assert.Equal(t, tt.Err, err)
+			assert.Equal(t, tt.Expected, windows)
+		})
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	tc := []struct {
+		Name     string
+		Start    string
+		Stop     string
+		Expected Window
+		Err      bool
+	}{
+		{
+			Name:     "valid",
+			Start:    "2019-01-01T00:00:00Z",
+			Stop:     "2019-01-01T01:00:00Z",
+			Expected: Window{Start: start, Stop: start.Add(time.Hour)},
+		},
+		{
+			Name:     "truncated",
+			Start:    "2019-01-01T00:00:59.999Z",
+			Stop:     "2019-01-01T01:00:30Z",
+			Expected: Window{Start: start, Stop: start.Add(time.Hour)},
+		},
+		{
+			Name:  "invalid start",
+			Start: "yesterday",
+			Stop:  "2019-01-01T01:00:00Z",
+			Err:   true,
+		},
+		{
+			Name:  "invalid stop",
+			Start: "2019-01-01T00:00:00Z",
+			Err:   true,
+		},
+		{
+			Name:     "empty",
+			Start:    "2019-01-01T00:00:00Z",
+			Stop:     "2019-01-01T00:00:00Z",
+			Expected: Window{Start: start, Stop: start},
+		},
+		{
+			Name:     "empty once truncated",
+			Start:    "2019-01-01T00:00:10Z",
+			Stop:     "2019-01-01T00:00:50Z",
+			Expected: Window{Start: start, Stop: start},
+		},
+		{
+			Name:  "reversed",
+			Start: "2019-01-01T01:00:00Z",
+			Stop:  "2019-01-01T00:00:00Z",
+			Err:   true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			window, err := ParseWindow(tt.Start, tt.Stop)
+			if tt.Err {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.True(t, tt.Expected.Start.Equal(window.Start))
+			assert.True(t, tt.Expected.Stop.Equal(window.Stop))
+		})
+	}
+}